@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/proxati/llm_proxy/v2/internal/ca"
+)
+
+var (
+	caKeyType     string
+	caValidity    time.Duration
+	caCommonName  string
+	caForce       bool
+	caGracePeriod time.Duration
+	caFormat      string
+	caOut         string
+	caPassword    string
+	caBundleWith  string
+)
+
+// caCmd manages the certificate authority for TLS interception
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage the certificate authority that signs the intercepted TLS connections.",
+	Long: `The proxy signs a certificate for each HTTPS host with its own certificate authority
+(CA), so clients must trust this CA. The CA is saved in --ca_dir (default: ~/.mitmproxy),
+and the proxy creates one on the first run when there isn't one.
+
+These commands create the CA with a chosen key size and validity, show its fingerprint,
+export it for the trust stores, and rotate it. After a rotation, the old CA is still
+exported until the end of its grace period, so clients can trust both while the proxies
+are restarted with the new CA.
+
+## Example Usage
+
+# Create a CA that's valid for one year
+./llm_proxy ca init --key-type rsa-4096 --validity 8760h
+
+# Show the CA, and its SHA-256 fingerprint
+./llm_proxy ca show
+
+# Export a bundle for Python, with the certifi CAs and this proxy's CA
+./llm_proxy ca export --bundle-with $(python -m certifi) --out bundle.pem
+export REQUESTS_CA_BUNDLE=$PWD/bundle.pem SSL_CERT_FILE=$PWD/bundle.pem
+
+# Export a PKCS#12 trust store for Java or Windows
+./llm_proxy ca export --format pkcs12 --password changeit --out llm_proxy-ca.p12
+
+# Replace the CA, and keep trusting the old one for 7 days
+./llm_proxy ca rotate --grace-period 168h
+`,
+}
+
+var caInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a new CA.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return initCA(cmd.OutOrStdout(), cfg.HTTPBehavior.CertDir, caOptions(), caForce)
+	},
+}
+
+var caShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the CA certificate and its fingerprint, and the rotated CAs that are still trusted.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return showCA(cmd.OutOrStdout(), cfg.HTTPBehavior.CertDir)
+	},
+}
+
+var caExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the CA certificates for a trust store, as PEM, DER, or PKCS#12.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if caOut == "" || caOut == "-" {
+			return exportCA(cmd.OutOrStdout(), cfg.HTTPBehavior.CertDir, caFormat, caPassword, caBundleWith)
+		}
+
+		file, err := os.Create(caOut)
+		if err != nil {
+			return fmt.Errorf("unable to create the output file: %w", err)
+		}
+		if err := exportCA(file, cfg.HTTPBehavior.CertDir, caFormat, caPassword, caBundleWith); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "Exported the CA to %s\n", caOut)
+		return nil
+	},
+}
+
+var caRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the CA with a new one, and keep trusting the old one for a grace period.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rotateCA(cmd.OutOrStdout(), cfg.HTTPBehavior.CertDir, caOptions(), caGracePeriod)
+	},
+}
+
+// caOptions returns the options for a new CA from the flags
+func caOptions() ca.Options {
+	return ca.Options{
+		KeyType:    ca.KeyType(caKeyType),
+		Validity:   caValidity,
+		CommonName: caCommonName,
+	}
+}
+
+// initCA creates a new CA, and prints it
+func initCA(w io.Writer, dir string, opts ca.Options, force bool) error {
+	store, err := ca.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	cert, err := store.Init(opts, time.Now(), force)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Created a new CA in %s\n\n", store.Dir())
+	printCert(w, cert)
+	return nil
+}
+
+// showCA prints the active CA, and the retired CAs that are still trusted
+func showCA(w io.Writer, dir string) error {
+	store, err := ca.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	cert, err := store.Load()
+	if err != nil {
+		if errors.Is(err, ca.ErrNotFound) {
+			return fmt.Errorf("no CA in %s, create one with: llm_proxy ca init", store.Dir())
+		}
+		return err
+	}
+	fmt.Fprintf(w, "CA directory:  %s\n", store.Dir())
+	printCert(w, cert)
+
+	retired, err := store.Retired()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, r := range retired {
+		if !now.Before(r.TrustedUntil) {
+			continue
+		}
+		fmt.Fprintf(w, "\nRotated CA, trusted until %s\n", r.TrustedUntil.Local().Format(time.DateTime))
+		printCert(w, r.Cert)
+	}
+	return nil
+}
+
+// exportCA writes the trusted CA certificates in the format. DER only has the active CA, because
+// it can only hold one certificate. The bundleWith file is a PEM bundle, like Python's certifi,
+// that's written before the CA certificates.
+func exportCA(w io.Writer, dir, format, password, bundleWith string) error {
+	if bundleWith != "" && format != "pem" {
+		return errors.New("--bundle-with is only supported for the pem format")
+	}
+
+	store, err := ca.Open(dir)
+	if err != nil {
+		return err
+	}
+	certs, err := store.TrustedCerts(time.Now())
+	if err != nil {
+		if errors.Is(err, ca.ErrNotFound) {
+			return fmt.Errorf("no CA in %s, create one with: llm_proxy ca init", store.Dir())
+		}
+		return err
+	}
+
+	var data []byte
+	switch format {
+	case "pem":
+		if bundleWith != "" {
+			bundle, err := os.ReadFile(bundleWith)
+			if err != nil {
+				return fmt.Errorf("unable to read the CA bundle: %w", err)
+			}
+			if len(bundle) > 0 && !strings.HasSuffix(string(bundle), "\n") {
+				bundle = append(bundle, '\n')
+			}
+			data = append(data, bundle...)
+		}
+		data = append(data, ca.EncodePEM(certs)...)
+	case "der":
+		data = certs[0].Raw
+	case "pkcs12", "p12":
+		data, err = ca.EncodePKCS12TrustStore(certs, password)
+		if err != nil {
+			return fmt.Errorf("unable to create the PKCS#12 file: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported format %q, must be pem, der, or pkcs12", format)
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// rotateCA replaces the CA, and prints the new and old CAs
+func rotateCA(w io.Writer, dir string, opts ca.Options, gracePeriod time.Duration) error {
+	if gracePeriod < 0 {
+		return errors.New("the grace period can't be negative")
+	}
+	store, err := ca.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	newCert, oldCert, err := store.Rotate(opts, gracePeriod, time.Now())
+	if err != nil {
+		if errors.Is(err, ca.ErrNotFound) {
+			return fmt.Errorf("no CA in %s to rotate, create one with: llm_proxy ca init", store.Dir())
+		}
+		return err
+	}
+
+	fmt.Fprintf(w, "Created a new CA in %s\n\n", store.Dir())
+	printCert(w, newCert)
+	if gracePeriod > 0 {
+		fmt.Fprintf(w, "\nThe old CA %s is still exported until %s.\n",
+			ca.Fingerprint(oldCert), time.Now().Add(gracePeriod).Format(time.DateTime))
+	}
+	fmt.Fprintln(w, "Export the new CA to the trust stores, and restart the proxies to use it.")
+	return nil
+}
+
+// printCert prints the details of a CA certificate
+func printCert(w io.Writer, cert *x509.Certificate) {
+	fmt.Fprintf(w, "Subject:       %s\n", cert.Subject)
+	fmt.Fprintf(w, "Key:           %s\n", ca.KeyDescription(cert))
+	fmt.Fprintf(w, "Serial:        %x\n", cert.SerialNumber)
+	fmt.Fprintf(w, "Valid from:    %s\n", cert.NotBefore.Local().Format(time.DateTime))
+	fmt.Fprintf(w, "Valid until:   %s\n", cert.NotAfter.Local().Format(time.DateTime))
+	fmt.Fprintf(w, "SHA-256:       %s\n", ca.Fingerprint(cert))
+}
+
+func init() {
+	rootCmd.AddCommand(caCmd)
+	caCmd.AddCommand(caInitCmd, caShowCmd, caExportCmd, caRotateCmd)
+
+	keyTypes := make([]string, 0, len(ca.KeyTypes))
+	for _, kt := range ca.KeyTypes {
+		keyTypes = append(keyTypes, string(kt))
+	}
+	for _, c := range []*cobra.Command{caInitCmd, caRotateCmd} {
+		c.Flags().StringVar(&caKeyType, "key-type", string(ca.KeyTypeRSA2048),
+			"Private key type of the new CA: "+strings.Join(keyTypes, ", "))
+		c.Flags().DurationVar(&caValidity, "validity", ca.DefaultValidity, "How long the new CA is valid")
+		c.Flags().StringVar(&caCommonName, "common-name", ca.DefaultCommonName, "Common name of the new CA")
+	}
+	caInitCmd.Flags().BoolVar(&caForce, "force", false, "Replace an existing CA, without keeping it trusted")
+	caRotateCmd.Flags().DurationVar(&caGracePeriod, "grace-period", ca.DefaultGracePeriod,
+		"How long the old CA is still exported for the trust stores")
+
+	caExportCmd.Flags().StringVar(&caFormat, "format", "pem", "Export format: pem, der, or pkcs12")
+	caExportCmd.Flags().StringVarP(&caOut, "out", "o", "", "Output file, stdout when empty")
+	caExportCmd.Flags().StringVar(&caPassword, "password", "", "Password of the PKCS#12 file")
+	caExportCmd.Flags().StringVar(&caBundleWith, "bundle-with", "",
+		"A PEM bundle to include before the CA, e.g. the output of: python -m certifi")
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/internal/ca"
+)
+
+func TestCACommands(t *testing.T) {
+	t.Parallel()
+	dir := filepath.Join(t.TempDir(), "ca")
+	out := &bytes.Buffer{}
+
+	require.Error(t, showCA(out, dir), "there is no CA yet")
+	require.Error(t, rotateCA(out, dir, ca.Options{}, time.Hour), "there is no CA to rotate")
+
+	require.NoError(t, initCA(out, dir, ca.Options{CommonName: "test CA"}, false))
+	assert.Contains(t, out.String(), "CN=test CA")
+	assert.Contains(t, out.String(), "RSA 2048")
+	require.Error(t, initCA(out, dir, ca.Options{}, false), "the CA exists")
+
+	out.Reset()
+	require.NoError(t, showCA(out, dir))
+	assert.Contains(t, out.String(), "SHA-256:")
+	assert.NotContains(t, out.String(), "Rotated CA")
+
+	t.Run("export", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, exportCA(out, dir, "pem", "", ""))
+		block, _ := pem.Decode(out.Bytes())
+		require.NotNil(t, block)
+		assert.Equal(t, "CERTIFICATE", block.Type)
+
+		der := &bytes.Buffer{}
+		require.NoError(t, exportCA(der, dir, "der", "", ""))
+		assert.Equal(t, block.Bytes, der.Bytes())
+
+		p12 := &bytes.Buffer{}
+		require.NoError(t, exportCA(p12, dir, "pkcs12", "changeit", ""))
+		assert.NotEmpty(t, p12.Bytes())
+
+		bundleFile := filepath.Join(t.TempDir(), "cacert.pem")
+		require.NoError(t, os.WriteFile(bundleFile, []byte("# existing bundle"), 0o600))
+		bundle := &bytes.Buffer{}
+		require.NoError(t, exportCA(bundle, dir, "pem", "", bundleFile))
+		assert.True(t, strings.HasPrefix(bundle.String(), "# existing bundle\n-----BEGIN CERTIFICATE-----"))
+
+		assert.Error(t, exportCA(out, dir, "der", "", bundleFile))
+		assert.Error(t, exportCA(out, dir, "jks", "", ""))
+	})
+
+	out.Reset()
+	require.NoError(t, rotateCA(out, dir, ca.Options{}, time.Hour))
+	assert.Contains(t, out.String(), "is still exported until")
+	assert.Error(t, rotateCA(out, dir, ca.Options{}, -time.Hour))
+
+	out.Reset()
+	require.NoError(t, showCA(out, dir))
+	assert.Contains(t, out.String(), "Rotated CA, trusted until")
+	assert.Contains(t, out.String(), "CN=test CA", "the old CA is shown")
+
+	pemOut := &bytes.Buffer{}
+	require.NoError(t, exportCA(pemOut, dir, "pem", "", ""))
+	assert.Equal(t, 2, strings.Count(pemOut.String(), "BEGIN CERTIFICATE"), "both CAs are trusted during the grace period")
+}
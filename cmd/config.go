@@ -17,3 +17,7 @@ var cacheSuggestions = []string{
 var proxyRunSuggestions = []string{
 	"proxy", "simple-proxy", "simpleproxy",
 }
+
+var tuiSuggestions = []string{
+	"console", "ui", "live", "watch",
+}
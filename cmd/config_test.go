@@ -21,6 +21,10 @@ func TestNoDuplicateSuggestions(t *testing.T) {
 			name:        "simple_suggestions",
 			suggestions: proxyRunSuggestions,
 		},
+		{
+			name:        "tui_suggestions",
+			suggestions: tuiSuggestions,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/virtualkeys"
+)
+
+var (
+	keyName   string
+	keySecret string
+)
+
+// keysCmd manages the virtual API keys
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the virtual API keys issued by this proxy.",
+	Long: `Virtual API keys are issued by this proxy, and are sent by clients instead of a real
+provider key. The proxy checks the virtual key, and swaps in the real key from the
+secret store before sending the request upstream. The usage and cost of each virtual key
+is saved in the keys database, and a key can be revoked without rotating the real key.
+
+The keys database is set with --virtual-keys-db, and the proxy reloads it every few
+seconds, so keys can be created and revoked while the proxy is running.
+
+## Example Usage
+
+# Create a key for a team, using the "openai-prod" key from the secret store
+./llm_proxy keys create --virtual-keys-db /var/lib/llm_proxy/keys.db --name team-a --secret openai-prod
+
+# List the keys, with their usage and cost
+./llm_proxy keys list --virtual-keys-db /var/lib/llm_proxy/keys.db
+
+# Revoke a key by ID or name
+./llm_proxy keys revoke --virtual-keys-db /var/lib/llm_proxy/keys.db team-a
+`,
+}
+
+var keysCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a virtual API key, and print it. The key is only shown once.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return createKey(cmd.OutOrStdout(), cfg.HTTPBehavior.VirtualKeysDB, cfg.HTTPBehavior.SecretStoreFile, keyName, keySecret)
+	},
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the virtual API keys, with their usage and cost.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listKeys(cmd.OutOrStdout(), cfg.HTTPBehavior.VirtualKeysDB)
+	},
+}
+
+var keysRevokeCmd = &cobra.Command{
+	Use:   "revoke <id or name>",
+	Short: "Revoke a virtual API key, by ID or name.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return revokeKey(cmd.OutOrStdout(), cfg.HTTPBehavior.VirtualKeysDB, args[0])
+	},
+}
+
+// openKeyStore opens the virtual keys database from the --virtual-keys-db flag
+func openKeyStore(dbFile string) (*virtualkeys.Store, error) {
+	if dbFile == "" {
+		return nil, errors.New("the --virtual-keys-db flag is required")
+	}
+	return virtualkeys.Open(dbFile, virtualkeys.DefaultOpenTimeout)
+}
+
+// createKey creates a key, and prints it. When a secret store file is set, the secret must be in it.
+func createKey(w io.Writer, dbFile, secretStoreFile, name, secret string) error {
+	if secretStoreFile != "" {
+		secrets, err := config.LoadSecretStore(secretStoreFile)
+		if err != nil {
+			return err
+		}
+		if _, ok := secrets.Get(secret); !ok {
+			return fmt.Errorf("secret %q is not in the secret store %s", secret, secretStoreFile)
+		}
+	}
+
+	store, err := openKeyStore(dbFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	plaintext, key, err := store.Create(name, secret, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Created virtual API key %s (%s) for secret %q:\n\n", key.ID, key.Name, key.Secret)
+	fmt.Fprintf(w, "    %s\n\n", plaintext)
+	fmt.Fprintln(w, "Save this key now, it can't be shown again.")
+	return nil
+}
+
+// listKeys prints a table of the keys
+func listKeys(w io.Writer, dbFile string) error {
+	store, err := openKeyStore(dbFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	keys, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tKEY\tSECRET\tSTATUS\tCREATED\tLAST USED\tREQUESTS\tINPUT TOKENS\tOUTPUT TOKENS\tCOST (USD)")
+	for _, key := range keys {
+		status := "active"
+		if key.IsRevoked() {
+			status = "revoked " + key.RevokedAt.Format(time.DateOnly)
+		}
+		lastUsed := "-"
+		if key.Usage.LastUsedAt != nil {
+			lastUsed = key.Usage.LastUsedAt.Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%.4f\n",
+			key.ID, key.Name, key.Hint, key.Secret, status, key.CreatedAt.Format(time.DateOnly), lastUsed,
+			key.Usage.Requests, key.Usage.InputTokens, key.Usage.OutputTokens, key.Usage.Cost)
+	}
+	return tw.Flush()
+}
+
+// revokeKey revokes a key by ID or name
+func revokeKey(w io.Writer, dbFile, idOrName string) error {
+	store, err := openKeyStore(dbFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	key, err := store.Revoke(idOrName, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Revoked virtual API key %s (%s)\n", key.ID, key.Name)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(keysCmd)
+	keysCmd.AddCommand(keysCreateCmd, keysListCmd, keysRevokeCmd)
+
+	keysCreateCmd.Flags().StringVar(&keyName, "name", "", "Name of the key, e.g. the team or service that uses it")
+	keysCreateCmd.Flags().StringVar(&keySecret, "secret", "", "Name of the real provider key in the secret store")
+	_ = keysCreateCmd.MarkFlagRequired("name")
+	_ = keysCreateCmd.MarkFlagRequired("secret")
+}
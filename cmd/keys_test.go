@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeysCommands(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "keys.db")
+	secretStore := filepath.Join(dir, "secrets.json")
+	require.NoError(t, os.WriteFile(secretStore, []byte(`{"secrets": {"openai-prod": "sk-real"}}`), 0o600))
+
+	out := &bytes.Buffer{}
+	require.NoError(t, createKey(out, dbFile, secretStore, "team-a", "openai-prod"))
+	assert.Regexp(t, regexp.MustCompile(`llmp-[0-9a-f]{48}`), out.String())
+	assert.Error(t, createKey(out, dbFile, secretStore, "team-b", "missing"), "the secret must be in the store")
+	assert.Error(t, createKey(out, "", "", "team-b", "openai-prod"), "the db file is required")
+
+	out.Reset()
+	require.NoError(t, revokeKey(out, dbFile, "team-a"))
+	assert.Contains(t, out.String(), "Revoked virtual API key")
+	assert.Error(t, revokeKey(out, dbFile, "team-b"))
+
+	out.Reset()
+	require.NoError(t, listKeys(out, dbFile))
+	assert.Contains(t, out.String(), "team-a")
+	assert.Contains(t, out.String(), "revoked")
+	assert.NotContains(t, out.String(), "sk-real")
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/proxy"
+)
+
+var tuiLogFile string
+
+// tuiCmd represents the live traffic terminal UI
+var tuiCmd = &cobra.Command{
+	Use:   "tui",
+	Short: "Run the LLM proxy server with an interactive view of the live traffic.",
+	Long: `Starts llm_proxy in normal Man-in-the-Middle (MiTM) mode, with an interactive terminal UI
+that lists each request as it completes, similar to the mitmproxy console.
+
+## Features
+- Live Traffic: Shows the status, model, tokens, latency, cache status, and cost of each request.
+- Details: Select a request to inspect the decoded request and response, with JSON pretty-printed.
+- Filtering: Press '/' to filter by host or workflow name (from the X-Llm_workflow-name header),
+  e.g. "host:api.openai.com workflow:summarize".
+
+## Key Bindings
+- up/down, j/k, pgup/pgdown, g/G: move the cursor
+- enter: show the request and response details, esc to go back
+- /: edit the filter, esc to clear the filter
+- c: clear the list
+- q: quit
+
+The TUI takes over the terminal, so the proxy's own logs are discarded unless --log-file is set.
+Traffic logs are still written when --output is set.
+
+## Example Usage
+
+# Start the proxy server with the TUI
+./llm_proxy tui
+
+# Start the TUI, and write debug logs to a file
+./llm_proxy tui --debug --log-file /tmp/llm_proxy.log
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg.AppMode = config.TUIMode
+
+		var logOutput io.Writer = io.Discard
+		if tuiLogFile != "" {
+			f, err := os.OpenFile(tuiLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+			if err != nil {
+				return fmt.Errorf("unable to open log file: %w", err)
+			}
+			defer f.Close()
+			logOutput = f
+		}
+		cfg.SetTerminalOutput(logOutput)
+
+		return proxy.Run(cfg)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tuiCmd)
+	tuiCmd.SuggestFor = tuiSuggestions
+
+	tuiCmd.Flags().StringVar(
+		&tuiLogFile, "log-file", tuiLogFile,
+		"Write the proxy's runtime logs to this file, because the TUI uses the terminal",
+	)
+}
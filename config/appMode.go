@@ -12,6 +12,9 @@ const (
 
 	// APIAuditMode runs the proxy with the API audit feature enabled, which shows the real-time cost for each API call
 	APIAuditMode
+
+	// TUIMode runs the proxy with an interactive terminal UI that shows the live traffic
+	TUIMode
 )
 
 func (a AppMode) String() string {
@@ -22,7 +25,14 @@ func (a AppMode) String() string {
 		return "CacheMode"
 	case APIAuditMode:
 		return "APIAuditMode"
+	case TUIMode:
+		return "TUIMode"
 	default:
 		return "Unknown"
 	}
 }
+
+// MarshalText returns the name of the app mode, for the JSON representation of the config
+func (a AppMode) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
@@ -21,6 +21,11 @@ func (c CacheEngine) String() string {
 	}
 }
 
+// MarshalText returns the name of the cache engine, for the JSON representation of the config
+func (c CacheEngine) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
 const (
 	// CacheEngineMemory is an in-memory cache engine
 	CacheEngineMemory CacheEngine = iota
@@ -1,10 +1,17 @@
 package config
 
-import "log/slog"
+import (
+	"io"
+	"log/slog"
+	"time"
+)
 
 const (
-	defaultListenAddr = "127.0.0.1:8080"
-	defaultCacheDir   = "/tmp/llm_proxy"
+	defaultListenAddr     = "127.0.0.1:8080"
+	defaultCacheDir       = "/tmp/llm_proxy"
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay  = 30 * time.Second
+	defaultRetryBudget    = 2 * time.Minute
 )
 
 // Config is the main config mega-struct
@@ -50,6 +57,13 @@ func (cfg *Config) IsVerboseOrHigher() bool {
 	}
 }
 
+// SetTerminalOutput changes where the terminal log is written (default: stderr), and rebuilds the
+// logger. This is used by the TUI, which owns the terminal while it's running.
+func (cfg *Config) SetTerminalOutput(w io.Writer) {
+	cfg.getTerminalLogger().output = w
+	cfg.SetLoggerLevel()
+}
+
 // GetLogger returns the slogger
 func (cfg *Config) GetLogger() *slog.Logger {
 	if cfg.getTerminalLogger().logger == nil {
@@ -125,9 +139,17 @@ func NewDefaultConfig() *Config {
 	return &Config{
 		HTTPBehavior: &httpBehavior{
 			Listen:                defaultListenAddr,
+			AdminListen:           "",
+			ReverseProxyListen:    "",
+			ReverseProxyRoutes:    DefaultReverseProxyRoutes,
+			InterceptHosts:        DefaultInterceptHosts(),
 			CertDir:               "",
 			InsecureSkipVerifyTLS: false,
 			NoHTTPUpgrader:        false,
+			RetryMaxAttempts:      0,
+			RetryBaseDelay:        defaultRetryBaseDelay,
+			RetryMaxDelay:         defaultRetryMaxDelay,
+			RetryBudget:           defaultRetryBudget,
 		},
 		terminalLogger: &terminalLogger{
 			Verbose:               false,
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DLPAction is what the DLP addon does with the requests that contain secrets
+type DLPAction string
+
+const (
+	DLPActionOff    DLPAction = ""       // DLP is disabled
+	DLPActionBlock  DLPAction = "block"  // reject the request with an error response
+	DLPActionRedact DLPAction = "redact" // mask the secrets, and send the request
+)
+
+// ParseDLPAction turns user input into a DLPAction, an empty string or "off" disables DLP
+func ParseDLPAction(s string) (DLPAction, error) {
+	switch action := DLPAction(strings.ToLower(strings.TrimSpace(s))); action {
+	case DLPActionOff, "off":
+		return DLPActionOff, nil
+	case DLPActionBlock, DLPActionRedact:
+		return action, nil
+	default:
+		return DLPActionOff, fmt.Errorf("invalid DLP action %q, must be block, redact, or off", s)
+	}
+}
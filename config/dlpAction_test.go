@@ -0,0 +1,34 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDLPAction(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input    string
+		expected DLPAction
+		err      bool
+	}{
+		{"", DLPActionOff, false},
+		{"off", DLPActionOff, false},
+		{"block", DLPActionBlock, false},
+		{" Redact ", DLPActionRedact, false},
+		{"enforce", DLPActionOff, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			action, err := ParseDLPAction(tt.input)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, action)
+		})
+	}
+}
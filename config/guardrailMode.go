@@ -0,0 +1,28 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GuardrailMode is what a guardrail addon does with the requests it flags
+type GuardrailMode string
+
+const (
+	GuardrailModeOff     GuardrailMode = ""        // the guardrail is disabled
+	GuardrailModeMonitor GuardrailMode = "monitor" // tag the traffic log and the response, and send the request
+	GuardrailModeEnforce GuardrailMode = "enforce" // block the request with an error response
+)
+
+// ParseGuardrailMode turns user input into a GuardrailMode, an empty string or "off" disables the
+// guardrail
+func ParseGuardrailMode(s string) (GuardrailMode, error) {
+	switch mode := GuardrailMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case GuardrailModeOff, "off":
+		return GuardrailModeOff, nil
+	case GuardrailModeMonitor, GuardrailModeEnforce:
+		return mode, nil
+	default:
+		return GuardrailModeOff, fmt.Errorf("invalid guardrail mode %q, must be monitor, enforce, or off", s)
+	}
+}
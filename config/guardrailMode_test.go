@@ -0,0 +1,34 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGuardrailMode(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input    string
+		expected GuardrailMode
+		err      bool
+	}{
+		{"", GuardrailModeOff, false},
+		{"off", GuardrailModeOff, false},
+		{"monitor", GuardrailModeMonitor, false},
+		{" Enforce ", GuardrailModeEnforce, false},
+		{"block", GuardrailModeOff, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			mode, err := ParseGuardrailMode(tt.input)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, mode)
+		})
+	}
+}
@@ -2,7 +2,11 @@ package config
 
 import (
 	"net/http"
+	"path"
+	"strings"
 	"sync"
+
+	"github.com/proxati/llm_proxy/v2/schema/headers"
 )
 
 // persistentFiltersRequests are headers that are always filtered from all request logs
@@ -50,6 +54,14 @@ var defaultFiltersResponses = []string{
 	"WWW-Authenticate",
 }
 
+// persistentFiltersUpstream are internal metadata headers that are logged, but always filtered
+// from requests sent upstream, so they never leak to a 3rd party API
+var persistentFiltersUpstream = []string{
+	headers.WorkflowName,
+	"X-Llm_proxy-*",
+	"X-Forwarded-For", // the client IPs of the reverse proxy listener
+}
+
 const (
 	flagTitleFilterResponseHeadersToCache   = "filter-response-headers-to-cache"
 	flagTitleFilterRequestHeadersToLogs     = "filter-request-headers-to-logs"
@@ -63,10 +75,15 @@ type headerIndex map[string]any
 // HeaderFilterGroup is an object used to filter headers for a specific purpose, such as when
 // reading existing logs (remove content-type), writing new logs (remove auth), or when sending
 // requests to upstream.
+//
+// Headers are matched case-insensitively, and each entry is either an exact header name, a prefix
+// ending with "*" (e.g. "X-Internal-*"), or a glob pattern (e.g. "X-*-Token").
 type HeaderFilterGroup struct {
 	Headers           []string    // user-editable list of headers to filter
 	additionalHeaders []string    // headers that are always filtered, not user editable
-	index             headerIndex // map of headers to filter
+	index             headerIndex // map of exact (lowercase) headers to filter
+	prefixes          []string    // lowercase header prefixes to filter
+	globs             []string    // lowercase glob patterns to filter
 	name              string      // human-readable name of this filter group
 }
 
@@ -92,19 +109,62 @@ func (hfg *HeaderFilterGroup) String() string {
 
 func (hfg *HeaderFilterGroup) buildIndex() {
 	index := make(headerIndex)
-	for _, header := range hfg.Headers {
-		index[header] = nil
-	}
-	for _, header := range hfg.additionalHeaders {
-		index[header] = nil
+	var prefixes, globs []string
+
+	for _, group := range [][]string{hfg.Headers, hfg.additionalHeaders} {
+		for _, header := range group {
+			header = strings.ToLower(strings.TrimSpace(header))
+			if header == "" {
+				continue
+			}
+
+			prefix, isPrefix := strings.CutSuffix(header, "*")
+			switch {
+			case isPrefix && !strings.ContainsAny(prefix, "*?["):
+				prefixes = append(prefixes, prefix)
+			case strings.ContainsAny(header, "*?["):
+				if _, err := path.Match(header, ""); err != nil {
+					// an invalid pattern can only match itself
+					index[header] = nil
+					continue
+				}
+				globs = append(globs, header)
+			default:
+				index[header] = nil
+			}
+		}
 	}
+
 	hfg.index = index
+	hfg.prefixes = prefixes
+	hfg.globs = globs
 }
 
 // IsHeaderInGroup returns true if the header should be filtered by this group
 func (hfg *HeaderFilterGroup) IsHeaderInGroup(header string) bool {
-	_, exists := hfg.index[header]
-	return exists
+	header = strings.ToLower(header)
+	if _, exists := hfg.index[header]; exists {
+		return true
+	}
+
+	for _, prefix := range hfg.prefixes {
+		if strings.HasPrefix(header, prefix) {
+			return true
+		}
+	}
+
+	for _, glob := range hfg.globs {
+		if matched, _ := path.Match(glob, header); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsEmpty returns true if this group doesn't filter any headers
+func (hfg *HeaderFilterGroup) IsEmpty() bool {
+	return len(hfg.index) == 0 && len(hfg.prefixes) == 0 && len(hfg.globs) == 0
 }
 
 // FilterHeaders makes a shallow copy of the headers map and removes any headers that are in the
@@ -163,7 +223,7 @@ func NewHeaderFiltersContainer() *HeaderFiltersContainer {
 			persistentFiltersResponses,
 		),
 		RequestToUpstream: NewHeaderFilterGroup(
-			flagTitleFilterRequestHeadersToUpstream, []string{}, []string{}),
+			flagTitleFilterRequestHeadersToUpstream, []string{}, persistentFiltersUpstream),
 		ResponseToClient: NewHeaderFilterGroup(
 			flagTitleFilterResponseHeadersToClient, []string{}, []string{}),
 	}
@@ -96,8 +96,43 @@ func TestEmptyFull(t *testing.T) {
 	assert.NotEmpty(t, hfc.ResponseToLogs.index, "ResponseToLogs index should have values")
 
 	assert.NotNil(t, hfc.RequestToUpstream.index, "RequestToUpstream index should be initialized")
-	assert.Empty(t, hfc.RequestToUpstream.index, "RequestToUpstream index should be be empty")
+	assert.Empty(t, hfc.RequestToUpstream.Headers, "RequestToUpstream should have no user headers")
+	assert.True(t, hfc.RequestToUpstream.IsHeaderInGroup("X-Llm_workflow-name"), "internal headers are always filtered upstream")
 
 	assert.NotNil(t, hfc.ResponseToClient.index, "ResponseToClient index should be initialized")
 	assert.Empty(t, hfc.ResponseToClient.index, "ResponseToClient index should be empty")
 }
+
+func TestHeaderFilterGroupPatterns(t *testing.T) {
+	t.Parallel()
+	hfg := NewHeaderFilterGroup(t.Name(), []string{"x-internal-*", "X-*-Token", "Cookie", "X-Bad-[", " "})
+
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"Cookie", true},
+		{"cookie", true},
+		{"Set-Cookie", false},
+		{"X-Internal-Team", true},
+		{"X-INTERNAL-", true},
+		{"X-Internals", false},
+		{"X-Auth-Token", true},
+		{"X-Token", false},
+		{"X-Bad-[", true},
+		{"X-Bad-A", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, hfg.IsHeaderInGroup(tt.header), tt.header)
+	}
+	assert.False(t, hfg.IsEmpty())
+	assert.True(t, NewHeaderFilterGroup(t.Name(), nil).IsEmpty())
+
+	filtered := hfg.FilterHeaders(http.Header{
+		"X-Internal-Team": {"ml"},
+		"X-Refresh-Token": {"secret"},
+		"Content-Type":    {"application/json"},
+	})
+	assert.Equal(t, http.Header{"Content-Type": {"application/json"}}, filtered)
+}
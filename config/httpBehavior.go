@@ -1,9 +1,43 @@
 package config
 
+import "time"
+
 // httpBehavior is the configuration for how and what the proxy does with HTTP traffic
 type httpBehavior struct {
-	Listen                string // Local address the proxy should listen on
-	CertDir               string // Dir to the certificate, for TLS MITM
-	InsecureSkipVerifyTLS bool   // if true, MITM will not verify the TLS certificate of the target server
-	NoHTTPUpgrader        bool   // if true, the proxy will NOT upgrade http requests to https
+	Listen                string        // Local address the proxy should listen on
+	AdminListen           string        // Local address for the admin server (metrics, health, drain), disabled when empty
+	ReverseProxyListen    string        // Local address for the reverse proxy (base URL) listener, disabled when empty
+	ReverseProxyRoutes    []string      // "prefix=upstream URL" routes for the reverse proxy listener
+	ProxyAuthFile         string        // htpasswd file with the users for Proxy-Authorization Basic auth
+	AllowedClients        []string      // client IP addresses and CIDR networks allowed to use the proxy
+	InterceptHosts        []string      // host globs whose TLS connections are decrypted, others are tunneled
+	CertDir               string        // Dir to the certificate, for TLS MITM
+	InsecureSkipVerifyTLS bool          // if true, MITM will not verify the TLS certificate of the target server
+	UpstreamTLSFile       string        // optional JSON file with the per-host client certificates and root CAs
+	UpstreamProxy         string        // corporate proxy URL that the upstream connections are chained through
+	UpstreamNoProxy       []string      // hosts that are dialed directly, defaults to the NO_PROXY env var
+	NoHTTPUpgrader        bool          // if true, the proxy will NOT upgrade http requests to https
+	ModifyRulesFile       string        // optional JSON file with rules to modify requests and responses
+	ModelAliasesFile      string        // optional JSON file with the model alias table
+	UpstreamPoolsFile     string        // optional JSON file with the upstream pools for the router
+	RateLimitsFile        string        // optional JSON file with the client-side rate limits
+	RetryMaxAttempts      int           // upstream attempts per request, retries are disabled when < 2
+	RetryBaseDelay        time.Duration // backoff before the first retry, doubled for each retry
+	RetryMaxDelay         time.Duration // longest backoff between retries
+	RetryBudget           time.Duration // longest total time spent retrying one request
+	VirtualKeysDB         string        // bolt database file with the virtual API keys, disabled when empty
+	SecretStoreFile       string        // JSON file with the real provider API keys for the virtual keys
+	RequireVirtualKeys    bool          // if true, requests without a virtual API key are rejected
+	PromptGuard           string        // prompt injection guardrail mode: monitor, enforce, or off
+	PromptGuardRulesFile  string        // optional JSON file with the prompt guard heuristics and classifier
+	ModerationFile        string        // optional JSON file with the moderation backend and category thresholds
+	SchemaValidation      string        // response schema validation mode: monitor, enforce, or off
+	SchemaRetries         int           // upstream retries for the responses that don't match the requested schema
+	PolicyFile            string        // optional JSON file with the allow and deny rules for the requests
+	DLP                   string        // action for the requests that contain secrets: block, redact, or off
+	DLPRulesFile          string        // optional JSON file with the DLP detectors and custom rules
+	MaxRequestBodySize    string        // largest request body, like "10MB", unlimited when empty
+	MaxResponseBodySize   string        // largest response body, like "50MB", unlimited when empty
+	MaxMessages           int           // most messages in a chat request, unlimited when 0
+	MaxRequestTokens      int           // most estimated tokens in a request, unlimited when 0
 }
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/proxati/llm_proxy/v2/schema/providers"
+)
+
+// InterceptAllHosts is the intercept host pattern that decrypts every TLS connection
+const InterceptAllHosts = "*"
+
+// DefaultInterceptHosts returns the hosts whose TLS connections are intercepted when none are
+// configured: the LLM API hosts that this proxy knows about
+func DefaultInterceptHosts() []string {
+	hosts := make([]string, 0, len(providers.APIHostnames))
+	for host := range providers.APIHostnames {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// InterceptHosts is the list of host globs, like "*.openai.azure.com", whose TLS connections are
+// decrypted and sent through the addons. The TLS connections to other hosts are tunneled without
+// decryption.
+type InterceptHosts []string
+
+// ParseInterceptHosts validates a list of host globs. Ports are ignored.
+func ParseInterceptHosts(patterns []string) (InterceptHosts, error) {
+	hosts := make(InterceptHosts, 0, len(patterns))
+	errs := make([]error, 0)
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid intercept host %q: %w", pattern, err))
+			continue
+		}
+		hosts = append(hosts, pattern)
+	}
+	if len(hosts) == 0 && len(errs) == 0 {
+		errs = append(errs, fmt.Errorf("at least one intercept host is required, use %q to intercept every host", InterceptAllHosts))
+	}
+	return hosts, errors.Join(errs...)
+}
+
+// All returns true when every host is intercepted
+func (ih InterceptHosts) All() bool {
+	for _, pattern := range ih {
+		if pattern == InterceptAllHosts {
+			return true
+		}
+	}
+	return false
+}
+
+// Intercepts returns true when the host, with or without a port, matches one of the globs
+func (ih InterceptHosts) Intercepts(host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return globMatchAny(ih, host)
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInterceptHosts(t *testing.T) {
+	t.Parallel()
+
+	hosts, err := ParseInterceptHosts([]string{" API.openai.com ", "*.openai.azure.com", ""})
+	require.NoError(t, err)
+	assert.False(t, hosts.All())
+
+	testCases := map[string]bool{
+		"api.openai.com":             true,
+		"api.openai.com:443":         true,
+		"east.openai.azure.com:443":  true,
+		"openai.azure.com":           false,
+		"example.com:443":            false,
+		"api.openai.com.example.com": false,
+		"[2001:db8::1]:443":          false,
+	}
+	for host, expected := range testCases {
+		assert.Equal(t, expected, hosts.Intercepts(host), host)
+	}
+
+	all, err := ParseInterceptHosts([]string{InterceptAllHosts})
+	require.NoError(t, err)
+	assert.True(t, all.All())
+	assert.True(t, all.Intercepts("example.com:443"))
+
+	defaults, err := ParseInterceptHosts(DefaultInterceptHosts())
+	require.NoError(t, err)
+	assert.True(t, defaults.Intercepts("api.openai.com:443"))
+
+	_, err = ParseInterceptHosts([]string{"[invalid"})
+	assert.Error(t, err)
+	_, err = ParseInterceptHosts([]string{" "})
+	assert.Error(t, err, "at least one host is required")
+}
@@ -27,6 +27,11 @@ func (f LogFormat) String() string {
 	}
 }
 
+// MarshalText returns the name of the log format, for the JSON representation of the config
+func (f LogFormat) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
 // StringToLogFormat converts a string to a LogLevel enum value.
 func StringToLogFormat(logFormat string) (LogFormat, error) {
 	logFormat = strings.TrimSpace(logFormat)
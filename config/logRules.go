@@ -0,0 +1,250 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// valid values for the LogRule "omit" list
+const (
+	LogOmitConnectionStats = "connection_stats"
+	LogOmitRequestHeaders  = "request_headers"
+	LogOmitRequestBody     = "request_body"
+	LogOmitResponseHeaders = "response_headers"
+	LogOmitResponseBody    = "response_body"
+)
+
+var validLogOmitValues = map[string]struct{}{
+	LogOmitConnectionStats: {},
+	LogOmitRequestHeaders:  {},
+	LogOmitRequestBody:     {},
+	LogOmitResponseHeaders: {},
+	LogOmitResponseBody:    {},
+}
+
+// LogRuleMatch holds the conditions for a LogRule. Every field that is set must match the flow,
+// and an empty match block matches every flow.
+type LogRuleMatch struct {
+	Host       string   `json:"host,omitempty"`        // exact hostname, or a glob like "*.openai.com"
+	Path       string   `json:"path,omitempty"`        // URL path prefix, like "/v1/embeddings"
+	Model      string   `json:"model,omitempty"`       // exact model name, or a glob like "text-embedding-*"
+	Workflow   string   `json:"workflow,omitempty"`    // exact value of the X-Llm_workflow-name request header
+	Status     []string `json:"status,omitempty"`      // response status codes, like "429", or classes, like "5xx"
+	MinLatency string   `json:"min_latency,omitempty"` // matches flows that took at least this long, like "2s"
+	minLatency time.Duration
+}
+
+// LogRule decides if, and what, to log for the flows that match. When several rules match a
+// flow, the sample rate comes from the first matching rule that sets one, and the omitted
+// fields from all matching rules are combined.
+type LogRule struct {
+	Name          string       `json:"name,omitempty"`
+	Match         LogRuleMatch `json:"match"`
+	SamplePercent *float64     `json:"sample_percent,omitempty"` // 0-100, the percentage of matching flows to log
+	Omit          []string     `json:"omit,omitempty"`           // parts of the log record to drop for matching flows
+}
+
+// LogRuleInput is the data from a completed flow that is checked against the rules
+type LogRuleInput struct {
+	Host       string
+	Path       string
+	Model      string
+	Workflow   string
+	StatusCode int
+	Latency    time.Duration
+}
+
+// LogRuleDecision is the result of evaluating the rules for a single flow
+type LogRuleDecision struct {
+	Log       bool                // if false, don't write a log record for this flow
+	SampledBy string              // name of the rule that provided the sample rate, empty for the default
+	Omit      map[string]struct{} // parts of the log record to drop
+}
+
+// Omits returns true when the part of the log record should be dropped
+func (d LogRuleDecision) Omits(part string) bool {
+	_, ok := d.Omit[part]
+	return ok
+}
+
+// LogRules is the rule set for conditional logging and sampling, usually loaded from a JSON file
+type LogRules struct {
+	Rules []LogRule `json:"rules"`
+	// random returns a number in [0, 100), replaceable for testing
+	random func() float64
+}
+
+// LoadLogRules reads and validates a JSON rules file
+func LoadLogRules(fileName string) (*LogRules, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read log rules file: %w", err)
+	}
+
+	lr, err := NewLogRulesFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log rules file %s: %w", fileName, err)
+	}
+	return lr, nil
+}
+
+// NewLogRulesFromJSON parses and validates the JSON rule set
+func NewLogRulesFromJSON(data []byte) (*LogRules, error) {
+	lr := &LogRules{}
+	if err := json.Unmarshal(data, lr); err != nil {
+		return nil, fmt.Errorf("unable to parse log rules: %w", err)
+	}
+
+	if err := lr.validate(); err != nil {
+		return nil, err
+	}
+	return lr, nil
+}
+
+// validate checks each rule, and parses the fields that need conversion
+func (lr *LogRules) validate() error {
+	errs := make([]error, 0)
+	for i := range lr.Rules {
+		rule := &lr.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+
+		if rule.SamplePercent != nil && (*rule.SamplePercent < 0 || *rule.SamplePercent > 100) {
+			errs = append(errs, fmt.Errorf("%s: sample_percent must be between 0 and 100", rule.Name))
+		}
+
+		for _, omit := range rule.Omit {
+			if _, ok := validLogOmitValues[omit]; !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown omit value: %s", rule.Name, omit))
+			}
+		}
+
+		for _, status := range rule.Match.Status {
+			if _, _, err := parseStatusMatch(status); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", rule.Name, err))
+			}
+		}
+
+		for _, pattern := range []string{rule.Match.Host, rule.Match.Model} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid pattern %q: %w", rule.Name, pattern, err))
+			}
+		}
+
+		if rule.Match.MinLatency != "" {
+			d, err := time.ParseDuration(rule.Match.MinLatency)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid min_latency: %w", rule.Name, err))
+			}
+			rule.Match.minLatency = d
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Decide evaluates all rules for a flow. Flows that don't match any rule with a sample rate are
+// always logged.
+func (lr *LogRules) Decide(in LogRuleInput) LogRuleDecision {
+	decision := LogRuleDecision{Log: true, Omit: make(map[string]struct{})}
+	sampled := false
+
+	for i := range lr.Rules {
+		rule := &lr.Rules[i]
+		if !rule.Match.matches(in) {
+			continue
+		}
+
+		for _, omit := range rule.Omit {
+			decision.Omit[omit] = struct{}{}
+		}
+
+		if rule.SamplePercent != nil && !sampled {
+			sampled = true
+			decision.SampledBy = rule.Name
+			decision.Log = lr.sample(*rule.SamplePercent)
+		}
+	}
+	return decision
+}
+
+// sample returns true for the given percentage of calls
+func (lr *LogRules) sample(percent float64) bool {
+	switch {
+	case percent >= 100:
+		return true
+	case percent <= 0:
+		return false
+	}
+
+	random := lr.random
+	if random == nil {
+		random = func() float64 { return rand.Float64() * 100 }
+	}
+	return random() < percent
+}
+
+// matches returns true when all of the conditions that are set match the flow
+func (m *LogRuleMatch) matches(in LogRuleInput) bool {
+	if m.Host != "" && !globMatch(m.Host, in.Host) {
+		return false
+	}
+	if m.Path != "" && !strings.HasPrefix(in.Path, m.Path) {
+		return false
+	}
+	if m.Model != "" && !globMatch(m.Model, in.Model) {
+		return false
+	}
+	if m.Workflow != "" && m.Workflow != in.Workflow {
+		return false
+	}
+	if m.minLatency > 0 && in.Latency < m.minLatency {
+		return false
+	}
+	if len(m.Status) > 0 && !statusMatches(m.Status, in.StatusCode) {
+		return false
+	}
+	return true
+}
+
+// globMatch is a case-insensitive path.Match, invalid patterns are rejected when loading the rules
+func globMatch(pattern, value string) bool {
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return err == nil && ok
+}
+
+// statusMatches returns true if the status code matches any of the status patterns
+func statusMatches(patterns []string, statusCode int) bool {
+	for _, p := range patterns {
+		low, high, err := parseStatusMatch(p)
+		if err != nil {
+			continue
+		}
+		if statusCode >= low && statusCode <= high {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatusMatch converts "404" to the range 404-404, and "4xx" to 400-499
+func parseStatusMatch(s string) (int, int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+		class := int(s[0]-'0') * 100
+		return class, class + 99, nil
+	}
+
+	code, err := strconv.Atoi(s)
+	if err != nil || code < 100 || code > 599 {
+		return 0, 0, fmt.Errorf("invalid status match: %q", s)
+	}
+	return code, code, nil
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogRulesFromJSON(t *testing.T) {
+	t.Parallel()
+
+	t.Run("valid", func(t *testing.T) {
+		lr, err := NewLogRulesFromJSON([]byte(`{"rules": [
+			{"name": "slow", "match": {"min_latency": "2s", "status": ["2xx", "429"]}, "sample_percent": 50},
+			{"match": {"host": "*.openai.com"}, "omit": ["request_body"]}
+		]}`))
+		require.NoError(t, err)
+		require.Len(t, lr.Rules, 2)
+		assert.Equal(t, 2*time.Second, lr.Rules[0].Match.minLatency)
+		assert.Equal(t, "rule-1", lr.Rules[1].Name, "unnamed rules get a default name")
+	})
+
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"bad json", `{"rules": [`},
+		{"sample too high", `{"rules": [{"sample_percent": 101}]}`},
+		{"sample negative", `{"rules": [{"sample_percent": -1}]}`},
+		{"unknown omit", `{"rules": [{"omit": ["everything"]}]}`},
+		{"bad status", `{"rules": [{"match": {"status": ["6xx"]}}]}`},
+		{"bad status code", `{"rules": [{"match": {"status": ["abc"]}}]}`},
+		{"bad latency", `{"rules": [{"match": {"min_latency": "fast"}}]}`},
+		{"bad glob", `{"rules": [{"match": {"host": "[abc"}}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLogRulesFromJSON([]byte(tt.json))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadLogRules(t *testing.T) {
+	t.Parallel()
+	fileName := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`{"rules": [{"sample_percent": 10}]}`), 0o600))
+
+	lr, err := LoadLogRules(fileName)
+	require.NoError(t, err)
+	assert.Len(t, lr.Rules, 1)
+
+	_, err = LoadLogRules(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+
+	// the example rules file in the repo must stay valid
+	lr, err = LoadLogRules(filepath.Join("..", "examples", "config", "log-rules.json"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, lr.Rules)
+}
+
+func TestLogRuleMatch(t *testing.T) {
+	t.Parallel()
+	in := LogRuleInput{
+		Host:       "api.openai.com",
+		Path:       "/v1/embeddings",
+		Model:      "text-embedding-3-small",
+		Workflow:   "indexer",
+		StatusCode: 429,
+		Latency:    3 * time.Second,
+	}
+
+	tests := []struct {
+		name     string
+		match    string
+		expected bool
+	}{
+		{"empty", `{}`, true},
+		{"host exact", `{"host": "api.openai.com"}`, true},
+		{"host glob", `{"host": "*.OpenAI.com"}`, true},
+		{"host mismatch", `{"host": "example.com"}`, false},
+		{"path prefix", `{"path": "/v1/embed"}`, true},
+		{"path mismatch", `{"path": "/v1/chat"}`, false},
+		{"model glob", `{"model": "text-embedding-*"}`, true},
+		{"model mismatch", `{"model": "gpt-4*"}`, false},
+		{"workflow", `{"workflow": "indexer"}`, true},
+		{"workflow mismatch", `{"workflow": "chat"}`, false},
+		{"status code", `{"status": ["200", "429"]}`, true},
+		{"status class", `{"status": ["4xx"]}`, true},
+		{"status mismatch", `{"status": ["5xx"]}`, false},
+		{"latency", `{"min_latency": "2s"}`, true},
+		{"latency mismatch", `{"min_latency": "5s"}`, false},
+		{"all", `{"host": "api.openai.com", "path": "/v1/", "status": ["4xx"], "min_latency": "1s"}`, true},
+		{"all but one", `{"host": "api.openai.com", "path": "/v1/", "status": ["4xx"], "min_latency": "10s"}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lr, err := NewLogRulesFromJSON([]byte(`{"rules": [{"match": ` + tt.match + `}]}`))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, lr.Rules[0].Match.matches(in))
+		})
+	}
+}
+
+func TestLogRulesDecide(t *testing.T) {
+	t.Parallel()
+
+	// log 100% of errors, 5% of successes, and never log embeddings bodies
+	lr, err := NewLogRulesFromJSON([]byte(`{"rules": [
+		{"name": "errors", "match": {"status": ["4xx", "5xx"]}, "sample_percent": 100},
+		{"name": "successes", "sample_percent": 5},
+		{"name": "embeddings", "match": {"path": "/v1/embeddings"}, "omit": ["request_body", "response_body"]}
+	]}`))
+	require.NoError(t, err)
+
+	var roll float64
+	lr.random = func() float64 { return roll }
+
+	t.Run("error is always logged", func(t *testing.T) {
+		roll = 99
+		d := lr.Decide(LogRuleInput{Path: "/v1/chat/completions", StatusCode: 500})
+		assert.True(t, d.Log)
+		assert.Equal(t, "errors", d.SampledBy)
+		assert.Empty(t, d.Omit)
+	})
+
+	t.Run("success is sampled", func(t *testing.T) {
+		roll = 4.9
+		d := lr.Decide(LogRuleInput{Path: "/v1/chat/completions", StatusCode: 200})
+		assert.True(t, d.Log)
+		assert.Equal(t, "successes", d.SampledBy)
+
+		roll = 5
+		d = lr.Decide(LogRuleInput{Path: "/v1/chat/completions", StatusCode: 200})
+		assert.False(t, d.Log)
+	})
+
+	t.Run("embeddings error without bodies", func(t *testing.T) {
+		roll = 99
+		d := lr.Decide(LogRuleInput{Path: "/v1/embeddings", StatusCode: 400})
+		assert.True(t, d.Log)
+		assert.True(t, d.Omits(LogOmitRequestBody))
+		assert.True(t, d.Omits(LogOmitResponseBody))
+		assert.False(t, d.Omits(LogOmitRequestHeaders))
+	})
+
+	t.Run("no matching sample rule", func(t *testing.T) {
+		empty := &LogRules{}
+		d := empty.Decide(LogRuleInput{StatusCode: 200})
+		assert.True(t, d.Log)
+		assert.Empty(t, d.SampledBy)
+	})
+}
+
+func TestLogRulesSample(t *testing.T) {
+	t.Parallel()
+	lr := &LogRules{random: func() float64 { return 50 }}
+	assert.True(t, lr.sample(100))
+	assert.False(t, lr.sample(0))
+	assert.True(t, lr.sample(50.1))
+	assert.False(t, lr.sample(50))
+
+	// the default random source is used when none is set
+	assert.True(t, (&LogRules{}).sample(100))
+}
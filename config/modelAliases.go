@@ -0,0 +1,83 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ModelAliases is the alias table for the model field in request bodies, usually loaded from a
+// JSON file, like: {"aliases": {"gpt-4": "gpt-4o"}}
+type ModelAliases struct {
+	Aliases map[string]string `json:"aliases"` // requested model -> model sent upstream
+}
+
+// LoadModelAliases reads and validates a JSON model alias file
+func LoadModelAliases(fileName string) (*ModelAliases, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read model aliases file: %w", err)
+	}
+
+	ma, err := NewModelAliasesFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid model aliases file %s: %w", fileName, err)
+	}
+	return ma, nil
+}
+
+// NewModelAliasesFromJSON parses and validates the JSON alias table
+func NewModelAliasesFromJSON(data []byte) (*ModelAliases, error) {
+	ma := &ModelAliases{}
+	if err := json.Unmarshal(data, ma); err != nil {
+		return nil, fmt.Errorf("unable to parse model aliases: %w", err)
+	}
+
+	if err := ma.validate(); err != nil {
+		return nil, err
+	}
+	return ma, nil
+}
+
+// validate rejects empty names and chained aliases, so each model is rewritten at most once
+func (ma *ModelAliases) validate() error {
+	errs := make([]error, 0)
+
+	// sorted, for stable error messages
+	names := make([]string, 0, len(ma.Aliases))
+	for name := range ma.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		target := ma.Aliases[name]
+		switch {
+		case name == "":
+			errs = append(errs, errors.New("alias name is required"))
+		case target == "":
+			errs = append(errs, fmt.Errorf("%s: target model is required", name))
+		case name == target:
+			errs = append(errs, fmt.Errorf("%s: alias points to itself", name))
+		default:
+			if _, chained := ma.Aliases[target]; chained {
+				errs = append(errs, fmt.Errorf("%s: target model %q is also an alias", name, target))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Lookup returns the model that should be sent upstream, and true when the model has an alias
+func (ma *ModelAliases) Lookup(model string) (string, bool) {
+	if ma == nil {
+		return model, false
+	}
+	target, ok := ma.Aliases[model]
+	if !ok {
+		return model, false
+	}
+	return target, true
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewModelAliasesFromJSON(t *testing.T) {
+	t.Parallel()
+
+	ma, err := NewModelAliasesFromJSON([]byte(`{"aliases": {"gpt-4": "gpt-4o", "gpt-3.5-turbo": "gpt-4o-mini"}}`))
+	require.NoError(t, err)
+
+	model, ok := ma.Lookup("gpt-4")
+	assert.True(t, ok)
+	assert.Equal(t, "gpt-4o", model)
+
+	model, ok = ma.Lookup("gpt-4o")
+	assert.False(t, ok)
+	assert.Equal(t, "gpt-4o", model)
+
+	var nilAliases *ModelAliases
+	model, ok = nilAliases.Lookup("gpt-4")
+	assert.False(t, ok)
+	assert.Equal(t, "gpt-4", model)
+
+	invalid := map[string]string{
+		"bad json":     `{"aliases": `,
+		"empty name":   `{"aliases": {"": "gpt-4o"}}`,
+		"empty target": `{"aliases": {"gpt-4": ""}}`,
+		"self":         `{"aliases": {"gpt-4": "gpt-4"}}`,
+		"chained":      `{"aliases": {"gpt-4": "gpt-4-turbo", "gpt-4-turbo": "gpt-4o"}}`,
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewModelAliasesFromJSON([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadModelAliasesExample(t *testing.T) {
+	t.Parallel()
+
+	ma, err := LoadModelAliases(filepath.Join("..", "examples", "config", "model-aliases.json"))
+	require.NoError(t, err)
+	assert.Len(t, ma.Aliases, 3)
+
+	_, err = LoadModelAliases("does-not-exist.json")
+	assert.Error(t, err)
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/proxati/llm_proxy/v2/internal/jsonpatch"
+)
+
+// ModifyRuleMatch holds the request conditions for a ModifyRule. Every field that is set must
+// match the request, and an empty match block matches every request.
+type ModifyRuleMatch struct {
+	Host    string            `json:"host,omitempty"`    // exact hostname, or a glob like "*.openai.com"
+	Path    string            `json:"path,omitempty"`    // URL path prefix, like "/v1/chat/completions"
+	Methods []string          `json:"methods,omitempty"` // HTTP methods, like ["POST"]
+	Headers map[string]string `json:"headers,omitempty"` // request header name -> exact value, or a glob like "ml-*"
+}
+
+// HeaderModifications are applied in order: remove, set, then add
+type HeaderModifications struct {
+	Remove []string          `json:"remove,omitempty"`
+	Set    map[string]string `json:"set,omitempty"`
+	Add    map[string]string `json:"add,omitempty"`
+}
+
+// BodyReplacement rewrites every match of the regex in a body. The replacement may use $1 etc.
+type BodyReplacement struct {
+	Regex       string `json:"regex"`
+	Replacement string `json:"replacement"`
+	pattern     *regexp.Regexp
+}
+
+// RequestModifications are applied to the request, before it's sent upstream
+type RequestModifications struct {
+	Headers   HeaderModifications `json:"headers"`
+	JSONPatch jsonpatch.Patch     `json:"json_patch,omitempty"`
+}
+
+// ResponseModifications are applied to the response, before it's sent to the client. The JSON
+// patch runs first, then the regex replacements.
+type ResponseModifications struct {
+	Headers   HeaderModifications `json:"headers"`
+	JSONPatch jsonpatch.Patch     `json:"json_patch,omitempty"`
+	Replace   []BodyReplacement   `json:"replace,omitempty"`
+}
+
+// ModifyRule changes the requests that match, and/or their responses. When several rules match a
+// request, they are all applied in the order they're listed.
+type ModifyRule struct {
+	Name     string                 `json:"name,omitempty"`
+	Match    ModifyRuleMatch        `json:"match"`
+	Request  *RequestModifications  `json:"request,omitempty"`
+	Response *ResponseModifications `json:"response,omitempty"`
+}
+
+// ModifyRuleInput is the request data that is checked against the rules
+type ModifyRuleInput struct {
+	Host   string
+	Path   string
+	Method string
+	Header http.Header
+}
+
+// ModifyRules is the rule set for the request and response modification addon, usually loaded
+// from a JSON file
+type ModifyRules struct {
+	Rules []ModifyRule `json:"rules"`
+}
+
+// LoadModifyRules reads and validates a JSON rules file
+func LoadModifyRules(fileName string) (*ModifyRules, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read modification rules file: %w", err)
+	}
+
+	mr, err := NewModifyRulesFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modification rules file %s: %w", fileName, err)
+	}
+	return mr, nil
+}
+
+// NewModifyRulesFromJSON parses and validates the JSON rule set
+func NewModifyRulesFromJSON(data []byte) (*ModifyRules, error) {
+	mr := &ModifyRules{}
+	if err := json.Unmarshal(data, mr); err != nil {
+		return nil, fmt.Errorf("unable to parse modification rules: %w", err)
+	}
+
+	if err := mr.validate(); err != nil {
+		return nil, err
+	}
+	return mr, nil
+}
+
+// validate checks each rule, and compiles the regexes
+func (mr *ModifyRules) validate() error {
+	errs := make([]error, 0)
+	for i := range mr.Rules {
+		rule := &mr.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+
+		if rule.Request == nil && rule.Response == nil {
+			errs = append(errs, fmt.Errorf("%s: request or response modifications are required", rule.Name))
+		}
+
+		patterns := []string{rule.Match.Host}
+		for _, value := range rule.Match.Headers {
+			patterns = append(patterns, value)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid pattern %q: %w", rule.Name, pattern, err))
+			}
+		}
+
+		if rule.Request != nil {
+			if err := rule.Request.JSONPatch.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid request json_patch: %w", rule.Name, err))
+			}
+		}
+
+		if rule.Response != nil {
+			if err := rule.Response.JSONPatch.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid response json_patch: %w", rule.Name, err))
+			}
+			for j := range rule.Response.Replace {
+				replace := &rule.Response.Replace[j]
+				pattern, err := regexp.Compile(replace.Regex)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: invalid replace regex: %w", rule.Name, err))
+					continue
+				}
+				replace.pattern = pattern
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Matching returns the rules that match the request, in order
+func (mr *ModifyRules) Matching(in ModifyRuleInput) []*ModifyRule {
+	var matched []*ModifyRule
+	for i := range mr.Rules {
+		if mr.Rules[i].Match.matches(in) {
+			matched = append(matched, &mr.Rules[i])
+		}
+	}
+	return matched
+}
+
+// matches returns true when all of the conditions that are set match the request
+func (m *ModifyRuleMatch) matches(in ModifyRuleInput) bool {
+	if m.Host != "" && !globMatch(m.Host, in.Host) {
+		return false
+	}
+	if m.Path != "" && !strings.HasPrefix(in.Path, m.Path) {
+		return false
+	}
+	if len(m.Methods) > 0 && !containsFold(m.Methods, in.Method) {
+		return false
+	}
+	for name, pattern := range m.Headers {
+		values := in.Header.Values(name)
+		if len(values) == 0 || !globMatch(pattern, values[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply changes the headers in place
+func (h *HeaderModifications) Apply(header http.Header) {
+	for _, name := range h.Remove {
+		header.Del(name)
+	}
+	for name, value := range h.Set {
+		header.Set(name, value)
+	}
+	for name, value := range h.Add {
+		header.Add(name, value)
+	}
+}
+
+// IsEmpty returns true when there are no header changes
+func (h *HeaderModifications) IsEmpty() bool {
+	return len(h.Remove) == 0 && len(h.Set) == 0 && len(h.Add) == 0
+}
+
+// Apply rewrites every match in the body
+func (r *BodyReplacement) Apply(body []byte) []byte {
+	if r.pattern == nil {
+		return body
+	}
+	return r.pattern.ReplaceAll(body, []byte(r.Replacement))
+}
+
+// containsFold returns true when the slice has the string, ignoring case
+func containsFold(slice []string, s string) bool {
+	for _, item := range slice {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewModifyRulesFromJSON(t *testing.T) {
+	t.Parallel()
+
+	mr, err := NewModifyRulesFromJSON([]byte(`{"rules": [
+		{"match": {"host": "*.openai.com"}, "request": {"headers": {"set": {"X-A": "1"}}}},
+		{"name": "chat", "match": {"path": "/v1/chat"}, "response": {"replace": [{"regex": "a(b)", "replacement": "$1"}]}}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, mr.Rules, 2)
+	assert.Equal(t, "rule-0", mr.Rules[0].Name)
+	assert.Equal(t, []byte("xbx"), mr.Rules[1].Response.Replace[0].Apply([]byte("xabx")))
+
+	invalid := map[string]string{
+		"bad json":         `{"rules": [`,
+		"no modifications": `{"rules": [{"match": {"host": "a"}}]}`,
+		"bad host glob":    `{"rules": [{"match": {"host": "["}, "request": {}}]}`,
+		"bad header glob":  `{"rules": [{"match": {"headers": {"X-A": "["}}, "request": {}}]}`,
+		"bad patch":        `{"rules": [{"request": {"json_patch": [{"op": "merge", "path": "/a"}]}}]}`,
+		"bad resp patch":   `{"rules": [{"response": {"json_patch": [{"op": "add", "path": "/a"}]}}]}`,
+		"bad regex":        `{"rules": [{"response": {"replace": [{"regex": "("}]}}]}`,
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewModifyRulesFromJSON([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestModifyRulesMatching(t *testing.T) {
+	t.Parallel()
+
+	mr, err := NewModifyRulesFromJSON([]byte(`{"rules": [
+		{"name": "all", "request": {}},
+		{"name": "openai", "match": {"host": "*.openai.com", "methods": ["post"]}, "request": {}},
+		{"name": "chat", "match": {"path": "/v1/chat/"}, "request": {}},
+		{"name": "team", "match": {"headers": {"X-Team": "ml-*"}}, "request": {}}
+	]}`))
+	require.NoError(t, err)
+
+	names := func(in ModifyRuleInput) []string {
+		var out []string
+		for _, r := range mr.Matching(in) {
+			out = append(out, r.Name)
+		}
+		return out
+	}
+
+	header := http.Header{}
+	header.Set("X-Team", "ml-research")
+	assert.Equal(t, []string{"all", "openai", "chat", "team"}, names(ModifyRuleInput{
+		Host: "api.openai.com", Path: "/v1/chat/completions", Method: http.MethodPost, Header: header,
+	}))
+	assert.Equal(t, []string{"all"}, names(ModifyRuleInput{
+		Host: "api.openai.com", Path: "/v1/embeddings", Method: http.MethodGet, Header: http.Header{},
+	}))
+	assert.Equal(t, []string{"all", "chat"}, names(ModifyRuleInput{
+		Host: "example.com", Path: "/v1/chat/completions", Method: http.MethodPost,
+		Header: http.Header{"X-Team": {"infra"}},
+	}))
+}
+
+func TestHeaderModifications(t *testing.T) {
+	t.Parallel()
+	h := HeaderModifications{
+		Remove: []string{"x-remove"},
+		Set:    map[string]string{"X-Set": "new"},
+		Add:    map[string]string{"X-Add": "2"},
+	}
+	assert.False(t, h.IsEmpty())
+	assert.True(t, (&HeaderModifications{}).IsEmpty())
+
+	header := http.Header{
+		"X-Remove": {"a"},
+		"X-Set":    {"old", "older"},
+		"X-Add":    {"1"},
+	}
+	h.Apply(header)
+	assert.Equal(t, http.Header{
+		"X-Set": {"new"},
+		"X-Add": {"1", "2"},
+	}, header)
+}
+
+func TestLoadModifyRules(t *testing.T) {
+	t.Parallel()
+
+	_, err := LoadModifyRules(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+
+	badFile := filepath.Join(t.TempDir(), "bad.json")
+	require.NoError(t, os.WriteFile(badFile, []byte(`{"rules": [{}]}`), 0o600))
+	_, err = LoadModifyRules(badFile)
+	assert.Error(t, err)
+
+	mr, err := LoadModifyRules(filepath.Join("..", "examples", "config", "modify-rules.json"))
+	require.NoError(t, err)
+	assert.Len(t, mr.Rules, 3)
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// PolicyAction is what happens to the requests that match a policy rule
+type PolicyAction string
+
+const (
+	PolicyActionAllow PolicyAction = "allow" // send the request
+	PolicyActionDeny  PolicyAction = "deny"  // respond with a 403
+)
+
+// PolicyMatch holds the request conditions for a PolicyRule. Every field that is set must match the
+// request, and an empty match block matches every request.
+type PolicyMatch struct {
+	Hosts   []string `json:"hosts,omitempty"`   // hostnames, exact or glob like "*.openai.com"
+	Paths   []string `json:"paths,omitempty"`   // URL path prefixes, like "/v1/fine_tuning"
+	Methods []string `json:"methods,omitempty"` // HTTP methods, like ["POST"]
+	Models  []string `json:"models,omitempty"`  // models in the request body, exact or glob like "gpt-4-32k*"
+}
+
+// PolicyRule allows or denies the requests that match
+type PolicyRule struct {
+	Name    string       `json:"name,omitempty"`
+	Action  PolicyAction `json:"action"`
+	Match   PolicyMatch  `json:"match"`
+	Message string       `json:"message,omitempty"` // optional error message for the denied requests
+}
+
+// PolicyInput is the request data that is checked against the rules
+type PolicyInput struct {
+	Host   string
+	Path   string
+	Method string
+	Model  string // empty when the body has no model field
+}
+
+// PolicyDecision is the result of evaluating the policy for a request
+type PolicyDecision struct {
+	Action PolicyAction `json:"action"`
+	Rule   string       `json:"rule,omitempty"` // name of the matching rule, empty when the default applied
+	rule   *PolicyRule
+}
+
+// Allowed returns true when the request may be sent
+func (d PolicyDecision) Allowed() bool {
+	return d.Action == PolicyActionAllow
+}
+
+// Message returns the error message for a denied request
+func (d PolicyDecision) Message(in PolicyInput) string {
+	if d.rule != nil && d.rule.Message != "" {
+		return d.rule.Message
+	}
+
+	reason := "by the default policy"
+	if d.Rule != "" {
+		reason = fmt.Sprintf("by the policy rule %q", d.Rule)
+	}
+	if d.rule != nil && len(d.rule.Match.Models) > 0 {
+		return fmt.Sprintf("The model %q is not allowed %s", in.Model, reason)
+	}
+	return fmt.Sprintf("%s %s%s is not allowed %s", in.Method, in.Host, in.Path, reason)
+}
+
+// Policy is the allow and deny rule set for the requests, usually loaded from a JSON file. The
+// rules are checked in order, and the first matching rule decides. The requests that don't match
+// any rule get the default action.
+type Policy struct {
+	Default PolicyAction `json:"default,omitempty"` // defaults to allow
+	Rules   []PolicyRule `json:"rules"`
+}
+
+// LoadPolicy reads and validates a JSON policy file
+func LoadPolicy(fileName string) (*Policy, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read policy file: %w", err)
+	}
+
+	p, err := NewPolicyFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", fileName, err)
+	}
+	return p, nil
+}
+
+// NewPolicyFromJSON parses and validates the JSON policy
+func NewPolicyFromJSON(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("unable to parse policy: %w", err)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// validate checks the default action and each rule
+func (p *Policy) validate() error {
+	errs := make([]error, 0)
+	switch p.Default {
+	case "":
+		p.Default = PolicyActionAllow
+	case PolicyActionAllow, PolicyActionDeny:
+	default:
+		errs = append(errs, fmt.Errorf("invalid default action %q, must be allow or deny", p.Default))
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+
+		if rule.Action != PolicyActionAllow && rule.Action != PolicyActionDeny {
+			errs = append(errs, fmt.Errorf("%s: invalid action %q, must be allow or deny", rule.Name, rule.Action))
+		}
+
+		patterns := append(append([]string{}, rule.Match.Hosts...), rule.Match.Models...)
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid pattern %q: %w", rule.Name, pattern, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Evaluate returns the decision of the first rule that matches the request, or the default action
+func (p *Policy) Evaluate(in PolicyInput) PolicyDecision {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Match.matches(in) {
+			return PolicyDecision{Action: rule.Action, Rule: rule.Name, rule: rule}
+		}
+	}
+	return PolicyDecision{Action: p.Default}
+}
+
+// EvaluateWithoutModel returns the decision for a request before its body is read, with the host,
+// path, and method of the input. It returns false when a rule with models is reached first, so the
+// decision depends on the model in the body.
+func (p *Policy) EvaluateWithoutModel(in PolicyInput) (PolicyDecision, bool) {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.Match.matchesWithoutModel(in) {
+			continue
+		}
+		if len(rule.Match.Models) > 0 {
+			return PolicyDecision{}, false
+		}
+		return PolicyDecision{Action: rule.Action, Rule: rule.Name, rule: rule}, true
+	}
+	return PolicyDecision{Action: p.Default}, true
+}
+
+// matches returns true when all of the conditions that are set match the request. A rule with
+// models doesn't match the requests without a model.
+func (m *PolicyMatch) matches(in PolicyInput) bool {
+	if !m.matchesWithoutModel(in) {
+		return false
+	}
+	if len(m.Models) > 0 && (in.Model == "" || !globMatchAny(m.Models, in.Model)) {
+		return false
+	}
+	return true
+}
+
+// matchesWithoutModel returns true when the host, path, and method conditions match the request
+func (m *PolicyMatch) matchesWithoutModel(in PolicyInput) bool {
+	if len(m.Hosts) > 0 && !globMatchAny(m.Hosts, in.Host) {
+		return false
+	}
+	if len(m.Paths) > 0 && !hasAnyPrefix(in.Path, m.Paths) {
+		return false
+	}
+	if len(m.Methods) > 0 && !containsFold(m.Methods, in.Method) {
+		return false
+	}
+	return true
+}
+
+// hasAnyPrefix returns true when the string starts with any of the prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPolicyFromJSON(t *testing.T) {
+	t.Parallel()
+
+	p, err := NewPolicyFromJSON([]byte(`{"rules": [{"action": "deny", "match": {"models": ["gpt-4-32k*"]}}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, PolicyActionAllow, p.Default)
+	assert.Equal(t, "rule-0", p.Rules[0].Name)
+
+	invalid := map[string]string{
+		"bad json":       `{"rules": [`,
+		"bad default":    `{"default": "block", "rules": []}`,
+		"missing action": `{"rules": [{"match": {"hosts": ["a"]}}]}`,
+		"bad action":     `{"rules": [{"action": "block"}]}`,
+		"bad host glob":  `{"rules": [{"action": "deny", "match": {"hosts": ["["]}}]}`,
+		"bad model glob": `{"rules": [{"action": "deny", "match": {"models": ["["]}}]}`,
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewPolicyFromJSON([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	t.Parallel()
+
+	p, err := LoadPolicy(filepath.Join("..", "examples", "config", "policy.json"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		in       PolicyInput
+		expected PolicyDecision
+		message  string
+	}{
+		{
+			name:     "allowed host",
+			in:       PolicyInput{Host: "api.openai.com", Path: "/v1/chat/completions", Method: http.MethodPost, Model: "gpt-4o"},
+			expected: PolicyDecision{Action: PolicyActionAllow, Rule: "openai"},
+		},
+		{
+			name:     "denied model",
+			in:       PolicyInput{Host: "api.openai.com", Path: "/v1/chat/completions", Method: http.MethodPost, Model: "GPT-4-32k-0613"},
+			expected: PolicyDecision{Action: PolicyActionDeny, Rule: "no-32k"},
+			message:  `The model "GPT-4-32k-0613" is not allowed by the policy rule "no-32k"`,
+		},
+		{
+			name:     "denied path",
+			in:       PolicyInput{Host: "api.openai.com", Path: "/v1/files", Method: http.MethodPost},
+			expected: PolicyDecision{Action: PolicyActionDeny, Rule: "no-fine-tuning"},
+			message:  "Fine-tuning and file uploads are not allowed through this proxy",
+		},
+		{
+			name:     "models rule doesn't match requests without a model",
+			in:       PolicyInput{Host: "api.openai.com", Path: "/v1/models", Method: http.MethodGet},
+			expected: PolicyDecision{Action: PolicyActionAllow, Rule: "openai"},
+		},
+		{
+			name:     "method must match",
+			in:       PolicyInput{Host: "api.anthropic.com", Path: "/v1/messages/batches", Method: http.MethodGet},
+			expected: PolicyDecision{Action: PolicyActionDeny},
+			message:  "GET api.anthropic.com/v1/messages/batches is not allowed by the default policy",
+		},
+		{
+			name:     "default",
+			in:       PolicyInput{Host: "example.com", Path: "/", Method: http.MethodGet},
+			expected: PolicyDecision{Action: PolicyActionDeny},
+			message:  "GET example.com/ is not allowed by the default policy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := p.Evaluate(tt.in)
+			assert.Equal(t, tt.expected.Action, decision.Action)
+			assert.Equal(t, tt.expected.Rule, decision.Rule)
+			assert.Equal(t, tt.expected.Allowed(), decision.Allowed())
+			if !decision.Allowed() {
+				assert.Equal(t, tt.message, decision.Message(tt.in))
+			}
+		})
+	}
+}
+
+func TestPolicyEvaluateWithoutModel(t *testing.T) {
+	t.Parallel()
+
+	p, err := LoadPolicy(filepath.Join("..", "examples", "config", "policy.json"))
+	require.NoError(t, err)
+
+	decision, ok := p.EvaluateWithoutModel(PolicyInput{Host: "api.openai.com", Path: "/v1/files", Method: http.MethodPost})
+	assert.True(t, ok)
+	assert.Equal(t, "no-fine-tuning", decision.Rule)
+	assert.False(t, decision.Allowed())
+
+	_, ok = p.EvaluateWithoutModel(PolicyInput{Host: "api.openai.com", Path: "/v1/chat/completions", Method: http.MethodPost})
+	assert.False(t, ok, "the models rule needs the body")
+}
+
+func TestLoadPolicy(t *testing.T) {
+	t.Parallel()
+
+	_, err := LoadPolicy(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorContains(t, err, "unable to read policy file")
+
+	badFile := filepath.Join(t.TempDir(), "bad.json")
+	require.NoError(t, os.WriteFile(badFile, []byte(`{"rules": [{}]}`), 0o600))
+	_, err = LoadPolicy(badFile)
+	assert.ErrorContains(t, err, "invalid policy file")
+}
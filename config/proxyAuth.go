@@ -0,0 +1,179 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd holds the users and password hashes of an htpasswd file, for the proxy's Basic auth.
+// Only the bcrypt (htpasswd -B) and {SHA} (htpasswd -s) formats are supported.
+type Htpasswd struct {
+	users map[string]string // user -> password hash
+}
+
+// LoadHtpasswd reads and parses an htpasswd file
+func LoadHtpasswd(fileName string) (*Htpasswd, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read htpasswd file: %w", err)
+	}
+
+	h, err := NewHtpasswd(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid htpasswd file %s: %w", fileName, err)
+	}
+	return h, nil
+}
+
+// NewHtpasswd parses the "user:hash" lines of an htpasswd file. Empty lines and # comments are
+// skipped.
+func NewHtpasswd(data []byte) (*Htpasswd, error) {
+	h := &Htpasswd{users: make(map[string]string)}
+	errs := make([]error, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		switch {
+		case !ok || user == "" || hash == "":
+			errs = append(errs, fmt.Errorf("line %d: expected user:hash", lineNum))
+		case !isSupportedPasswordHash(hash):
+			errs = append(errs, fmt.Errorf("line %d: unsupported hash for user %q, use bcrypt (htpasswd -B)", lineNum, user))
+		default:
+			if _, dupe := h.users[user]; dupe {
+				errs = append(errs, fmt.Errorf("line %d: duplicate user %q", lineNum, user))
+				continue
+			}
+			h.users[user] = hash
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(h.users) == 0 && len(errs) == 0 {
+		errs = append(errs, errors.New("at least one user is required"))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// isSupportedPasswordHash returns true for the bcrypt and {SHA} formats
+func isSupportedPasswordHash(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hash, "{SHA}"))
+		return err == nil && len(decoded) == sha1.Size
+	}
+	return false
+}
+
+// Verify returns true when the user exists and the password matches its hash
+func (h *Htpasswd) Verify(user, password string) bool {
+	if h == nil {
+		return false
+	}
+	hash, ok := h.users[user]
+	if !ok {
+		return false
+	}
+
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Len returns the number of users
+func (h *Htpasswd) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.users)
+}
+
+// ClientAllowlist is a list of client IP networks that may use the proxy. An empty list allows
+// every client.
+type ClientAllowlist []netip.Prefix
+
+// ParseClientAllowlist parses a list of IP addresses and CIDR networks, like
+// "10.0.0.0/8,192.168.1.10"
+func ParseClientAllowlist(entries []string) (ClientAllowlist, error) {
+	allowlist := make(ClientAllowlist, 0, len(entries))
+	errs := make([]error, 0)
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid client network %q: %w", entry, err))
+				continue
+			}
+			allowlist = append(allowlist, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid client address %q: %w", entry, err))
+			continue
+		}
+		addr = addr.Unmap()
+		allowlist = append(allowlist, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return allowlist, errors.Join(errs...)
+}
+
+// Allows returns true when the address is in one of the networks, or when the list is empty
+func (al ClientAllowlist) Allows(addr netip.Addr) bool {
+	if len(al) == 0 {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range al {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsRemoteAddr is like Allows, for a "host:port" remote address. Addresses that can't be
+// parsed are only allowed when the list is empty.
+func (al ClientAllowlist) AllowsRemoteAddr(remoteAddr string) bool {
+	if len(al) == 0 {
+		return true
+	}
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	return al.Allows(addrPort.Addr())
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNewHtpasswd(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	h, err := NewHtpasswd([]byte("# users\nalice:" + string(hash) + "\n\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, h.Len())
+
+	assert.True(t, h.Verify("alice", "secret"))
+	assert.False(t, h.Verify("alice", "wrong"))
+	assert.True(t, h.Verify("bob", "secret"))
+	assert.False(t, h.Verify("bob", "Secret"))
+	assert.False(t, h.Verify("carol", "secret"))
+
+	var nilHtpasswd *Htpasswd
+	assert.False(t, nilHtpasswd.Verify("alice", "secret"))
+	assert.Equal(t, 0, nilHtpasswd.Len())
+
+	invalid := map[string]string{
+		"empty":      "# no users\n",
+		"no hash":    "alice\n",
+		"empty hash": "alice:\n",
+		"md5":        "alice:$apr1$abc$def\n",
+		"crypt":      "alice:rl0uTLGoJQT.g\n",
+		"bad sha":    "alice:{SHA}abc\n",
+		"duplicate":  "bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n",
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewHtpasswd([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	t.Parallel()
+
+	fileName := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(fileName, []byte("bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0o600))
+	h, err := LoadHtpasswd(fileName)
+	require.NoError(t, err)
+	assert.True(t, h.Verify("bob", "secret"))
+
+	_, err = LoadHtpasswd("does-not-exist")
+	assert.Error(t, err)
+}
+
+func TestParseClientAllowlist(t *testing.T) {
+	t.Parallel()
+
+	al, err := ParseClientAllowlist([]string{"10.0.0.0/8", " 192.168.1.10 ", "", "fd00::/8"})
+	require.NoError(t, err)
+	assert.Len(t, al, 3)
+
+	assert.True(t, al.Allows(netip.MustParseAddr("10.1.2.3")))
+	assert.True(t, al.Allows(netip.MustParseAddr("::ffff:10.1.2.3")), "IPv4-mapped addresses are unmapped")
+	assert.True(t, al.Allows(netip.MustParseAddr("192.168.1.10")))
+	assert.False(t, al.Allows(netip.MustParseAddr("192.168.1.11")))
+	assert.True(t, al.Allows(netip.MustParseAddr("fd12::1")))
+
+	assert.True(t, al.AllowsRemoteAddr("10.0.0.1:51234"))
+	assert.True(t, al.AllowsRemoteAddr("[fd12::1]:51234"))
+	assert.False(t, al.AllowsRemoteAddr("127.0.0.1:51234"))
+	assert.False(t, al.AllowsRemoteAddr("not an address"))
+
+	var empty ClientAllowlist
+	assert.True(t, empty.Allows(netip.MustParseAddr("127.0.0.1")))
+	assert.True(t, empty.AllowsRemoteAddr("not an address"))
+
+	_, err = ParseClientAllowlist([]string{"10.0.0.0/33", "localhost"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// RateLimitKey selects which requests share a rate limit bucket
+type RateLimitKey string
+
+const (
+	RateLimitKeyGlobal   RateLimitKey = "global"   // every request shares one bucket
+	RateLimitKeyIP       RateLimitKey = "ip"       // client IP address
+	RateLimitKeyWorkflow RateLimitKey = "workflow" // X-Llm_workflow-name request header
+	RateLimitKeyAPIKey   RateLimitKey = "api_key"  // hash of the API key in the request headers
+	RateLimitKeyModel    RateLimitKey = "model"    // model in the request body
+)
+
+// RateLimitAction is what happens to a request that is over the limit
+type RateLimitAction string
+
+const (
+	RateLimitActionReject RateLimitAction = "reject" // respond with a 429 and a Retry-After header
+	RateLimitActionQueue  RateLimitAction = "queue"  // hold the request until there's room, up to max_wait
+)
+
+const defaultRateLimitMaxWait = 30 * time.Second
+
+// RateLimit is a token bucket limit on the requests and/or the estimated tokens per minute, with
+// one bucket for each value of the key
+type RateLimit struct {
+	Name              string          `json:"name,omitempty"`
+	Key               RateLimitKey    `json:"key"`
+	Models            []string        `json:"models,omitempty"`              // optional, only limit these models, exact or glob
+	Hosts             []string        `json:"hosts,omitempty"`               // optional, only limit these hostnames, exact or glob
+	RequestsPerMinute float64         `json:"requests_per_minute,omitempty"` // 0 for no request limit
+	TokensPerMinute   float64         `json:"tokens_per_minute,omitempty"`   // 0 for no token limit
+	Action            RateLimitAction `json:"action,omitempty"`              // defaults to reject
+	MaxWait           string          `json:"max_wait,omitempty"`            // longest queue time, like "10s", defaults to 30s
+	maxWait           time.Duration
+}
+
+// UpstreamRateLimits holds back requests when the rate limits reported by the upstream API, in the
+// OpenAI x-ratelimit-* response headers, are nearly used up for an API key and model
+type UpstreamRateLimits struct {
+	Enabled         bool            `json:"enabled"`
+	ReserveRequests float64         `json:"reserve_requests,omitempty"` // remaining requests to keep unused
+	ReserveTokens   float64         `json:"reserve_tokens,omitempty"`   // remaining tokens to keep unused
+	Action          RateLimitAction `json:"action,omitempty"`           // defaults to queue
+	MaxWait         string          `json:"max_wait,omitempty"`         // longest queue time, like "10s", defaults to 30s
+	maxWait         time.Duration
+}
+
+// RateLimits is the rule set for the rate limit addon, usually loaded from a JSON file
+type RateLimits struct {
+	Limits   []RateLimit        `json:"limits"`
+	Upstream UpstreamRateLimits `json:"upstream"`
+}
+
+// LoadRateLimits reads and validates a JSON rate limits file
+func LoadRateLimits(fileName string) (*RateLimits, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rate limits file: %w", err)
+	}
+
+	rl, err := NewRateLimitsFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limits file %s: %w", fileName, err)
+	}
+	return rl, nil
+}
+
+// NewRateLimitsFromJSON parses and validates the JSON rate limits
+func NewRateLimitsFromJSON(data []byte) (*RateLimits, error) {
+	rl := &RateLimits{}
+	if err := json.Unmarshal(data, rl); err != nil {
+		return nil, fmt.Errorf("unable to parse rate limits: %w", err)
+	}
+
+	if err := rl.validate(); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+// validate checks each limit, and sets the defaults
+func (rl *RateLimits) validate() error {
+	errs := make([]error, 0)
+
+	upstream := &rl.Upstream
+	if upstream.ReserveRequests < 0 || upstream.ReserveTokens < 0 {
+		errs = append(errs, errors.New("upstream: reserves can't be negative"))
+	}
+	if upstream.Action == "" {
+		upstream.Action = RateLimitActionQueue
+	}
+	upstream.maxWait, errs = validateRateLimitAction("upstream", upstream.Action, upstream.MaxWait, errs)
+
+	for i := range rl.Limits {
+		limit := &rl.Limits[i]
+		if limit.Name == "" {
+			limit.Name = fmt.Sprintf("limit-%d", i)
+		}
+
+		switch limit.Key {
+		case RateLimitKeyGlobal, RateLimitKeyIP, RateLimitKeyWorkflow, RateLimitKeyAPIKey, RateLimitKeyModel:
+		default:
+			errs = append(errs, fmt.Errorf("%s: invalid key %q, must be one of: global, ip, workflow, api_key, model", limit.Name, limit.Key))
+		}
+
+		if limit.RequestsPerMinute < 0 || limit.TokensPerMinute < 0 {
+			errs = append(errs, fmt.Errorf("%s: limits can't be negative", limit.Name))
+		}
+		if limit.RequestsPerMinute == 0 && limit.TokensPerMinute == 0 {
+			errs = append(errs, fmt.Errorf("%s: requests_per_minute or tokens_per_minute is required", limit.Name))
+		}
+
+		if limit.Action == "" {
+			limit.Action = RateLimitActionReject
+		}
+		limit.maxWait, errs = validateRateLimitAction(limit.Name, limit.Action, limit.MaxWait, errs)
+
+		patterns := append(append([]string{}, limit.Models...), limit.Hosts...)
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid pattern %q: %w", limit.Name, pattern, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateRateLimitAction checks the action, and returns the parsed max wait
+func validateRateLimitAction(name string, action RateLimitAction, maxWait string, errs []error) (time.Duration, []error) {
+	switch action {
+	case RateLimitActionReject, RateLimitActionQueue:
+	default:
+		errs = append(errs, fmt.Errorf("%s: invalid action %q, must be reject or queue", name, action))
+	}
+
+	if maxWait == "" {
+		return defaultRateLimitMaxWait, errs
+	}
+	d, err := time.ParseDuration(maxWait)
+	if err != nil || d < 0 {
+		errs = append(errs, fmt.Errorf("%s: invalid max_wait: %q", name, maxWait))
+	}
+	return d, errs
+}
+
+// Applies returns true when the limit applies to a request for the model and hostname
+func (l *RateLimit) Applies(model, hostname string) bool {
+	if len(l.Models) > 0 && !globMatchAny(l.Models, model) {
+		return false
+	}
+	if len(l.Hosts) > 0 && !globMatchAny(l.Hosts, hostname) {
+		return false
+	}
+	return true
+}
+
+// GetMaxWait returns the longest time a queued request is held, zero for reject limits
+func (l *RateLimit) GetMaxWait() time.Duration {
+	if l.Action != RateLimitActionQueue {
+		return 0
+	}
+	return l.maxWait
+}
+
+// GetMaxWait returns the longest time a queued request is held, zero for reject
+func (u *UpstreamRateLimits) GetMaxWait() time.Duration {
+	if u.Action != RateLimitActionQueue {
+		return 0
+	}
+	return u.maxWait
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRateLimitsFromJSON(t *testing.T) {
+	t.Parallel()
+
+	rl, err := NewRateLimitsFromJSON([]byte(`{"limits": [
+		{"key": "ip", "requests_per_minute": 60},
+		{"name": "queued", "key": "model", "models": ["gpt-4o*"], "tokens_per_minute": 1000, "action": "queue", "max_wait": "5s"}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, rl.Limits, 2)
+
+	limit := rl.Limits[0]
+	assert.Equal(t, "limit-0", limit.Name)
+	assert.Equal(t, RateLimitActionReject, limit.Action)
+	assert.Zero(t, limit.GetMaxWait(), "reject limits don't wait")
+	assert.True(t, limit.Applies("anything", "api.openai.com"))
+
+	limit = rl.Limits[1]
+	assert.Equal(t, 5*time.Second, limit.GetMaxWait())
+	assert.True(t, limit.Applies("gpt-4o-mini", "api.openai.com"))
+	assert.False(t, limit.Applies("o1", "api.openai.com"))
+
+	assert.False(t, rl.Upstream.Enabled)
+	assert.Equal(t, RateLimitActionQueue, rl.Upstream.Action, "upstream limits queue by default")
+	assert.Equal(t, defaultRateLimitMaxWait, rl.Upstream.GetMaxWait())
+
+	invalid := map[string]string{
+		"bad json":       `{"limits": [`,
+		"bad key":        `{"limits": [{"key": "user", "requests_per_minute": 1}]}`,
+		"no limit":       `{"limits": [{"key": "ip"}]}`,
+		"negative limit": `{"limits": [{"key": "ip", "requests_per_minute": -1}]}`,
+		"bad action":     `{"limits": [{"key": "ip", "requests_per_minute": 1, "action": "drop"}]}`,
+		"bad max wait":   `{"limits": [{"key": "ip", "requests_per_minute": 1, "max_wait": "soon"}]}`,
+		"bad glob":       `{"limits": [{"key": "ip", "requests_per_minute": 1, "models": ["["]}]}`,
+		"bad upstream":   `{"limits": [], "upstream": {"enabled": true, "reserve_tokens": -1}}`,
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewRateLimitsFromJSON([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadRateLimitsExample(t *testing.T) {
+	t.Parallel()
+
+	rl, err := LoadRateLimits(filepath.Join("..", "examples", "config", "rate-limits.json"))
+	require.NoError(t, err)
+	assert.Len(t, rl.Limits, 3)
+	assert.Equal(t, 20*time.Second, rl.Limits[0].GetMaxWait())
+	assert.True(t, rl.Upstream.Enabled)
+	assert.Equal(t, 10*time.Second, rl.Upstream.GetMaxWait())
+
+	_, err = LoadRateLimits("does-not-exist.json")
+	assert.Error(t, err)
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// redactURLList removes any passwords from a comma-delimited list of URLs or file paths
+func redactURLList(targets string) string {
+	if targets == "" {
+		return ""
+	}
+
+	parts := strings.Split(targets, ",")
+	for i, part := range parts {
+		u, err := url.Parse(strings.TrimSpace(part))
+		if err != nil || u.User == nil {
+			continue
+		}
+		parts[i] = u.Redacted()
+	}
+	return strings.Join(parts, ",")
+}
+
+// MarshalRedactedJSON returns the config as indented JSON with secrets removed, so it's safe to
+// display on the admin API or in logs.
+func (cfg *Config) MarshalRedactedJSON() ([]byte, error) {
+	redacted := *cfg // shallow copy, don't edit any pointers from the original config!
+
+	if cfg.TrafficLogger != nil {
+		tl := *cfg.TrafficLogger
+		tl.Output = redactURLList(tl.Output)
+		redacted.TrafficLogger = &tl
+	}
+
+	if cfg.HTTPBehavior != nil {
+		hb := *cfg.HTTPBehavior
+		hb.UpstreamProxy = redactURLList(hb.UpstreamProxy)
+		redacted.HTTPBehavior = &hb
+	}
+
+	return json.MarshalIndent(redacted, "", "  ")
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSizeUnits are the suffixes for ParseByteSize, longest first so "MB" isn't read as "B"
+var byteSizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// ParseByteSize turns a size like "512", "10MB", "1.5MiB", or "2G" into bytes. KB, MB, and GB are
+// powers of 1000, and KiB, MiB, GiB, K, M, and G are powers of 1024. An empty string, or "0", is 0.
+func ParseByteSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 || n*float64(multiplier) > float64(1<<62) {
+		return 0, fmt.Errorf("invalid size %q, must be a number of bytes, like 512, 10MB, or 1MiB", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// RequestLimits are the largest requests and responses that the proxy sends, a limit of 0 is
+// unlimited
+type RequestLimits struct {
+	MaxRequestBodySize  int64 // bytes in the request body, as sent by the client
+	MaxResponseBodySize int64 // bytes in the response body, as sent by the upstream
+	MaxMessages         int   // messages in the messages list of a chat request
+	MaxRequestTokens    int   // estimated prompt and max output tokens of a request
+}
+
+// NewRequestLimits parses the body sizes, and returns nil when every limit is 0
+func NewRequestLimits(maxRequestBodySize, maxResponseBodySize string, maxMessages, maxRequestTokens int) (*RequestLimits, error) {
+	var errs []error
+	requestSize, err := ParseByteSize(maxRequestBodySize)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid max request body size: %w", err))
+	}
+	responseSize, err := ParseByteSize(maxResponseBodySize)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid max response body size: %w", err))
+	}
+	if maxMessages < 0 {
+		errs = append(errs, errors.New("the max messages can't be negative"))
+	}
+	if maxRequestTokens < 0 {
+		errs = append(errs, errors.New("the max request tokens can't be negative"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	limits := &RequestLimits{
+		MaxRequestBodySize:  requestSize,
+		MaxResponseBodySize: responseSize,
+		MaxMessages:         maxMessages,
+		MaxRequestTokens:    maxRequestTokens,
+	}
+	if *limits == (RequestLimits{}) {
+		return nil, nil
+	}
+	return limits, nil
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseByteSize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input    string
+		expected int64
+		err      bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"10MB", 10_000_000, false},
+		{" 10 mb ", 10_000_000, false},
+		{"1KB", 1000, false},
+		{"1KiB", 1024, false},
+		{"1.5MiB", 1572864, false},
+		{"2M", 2 << 20, false},
+		{"1GB", 1_000_000_000, false},
+		{"1G", 1 << 30, false},
+		{"-1MB", 0, true},
+		{"MB", 0, true},
+		{"ten", 0, true},
+		{"10TB", 0, true},
+		{"99999999999GB", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			size, err := ParseByteSize(tt.input)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, size)
+		})
+	}
+}
+
+func TestNewRequestLimits(t *testing.T) {
+	t.Parallel()
+
+	t.Run("no limits", func(t *testing.T) {
+		limits, err := NewRequestLimits("", "0", 0, 0)
+		require.NoError(t, err)
+		assert.Nil(t, limits)
+	})
+
+	t.Run("all limits", func(t *testing.T) {
+		limits, err := NewRequestLimits("1MB", "2MiB", 50, 10000)
+		require.NoError(t, err)
+		assert.Equal(t, &RequestLimits{
+			MaxRequestBodySize:  1_000_000,
+			MaxResponseBodySize: 2 << 20,
+			MaxMessages:         50,
+			MaxRequestTokens:    10000,
+		}, limits)
+	})
+
+	t.Run("one limit", func(t *testing.T) {
+		limits, err := NewRequestLimits("", "", 10, 0)
+		require.NoError(t, err)
+		assert.Equal(t, &RequestLimits{MaxMessages: 10}, limits)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewRequestLimits("big", "1MB", -1, -1)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid max request body size")
+		assert.Contains(t, err.Error(), "max messages can't be negative")
+		assert.Contains(t, err.Error(), "max request tokens can't be negative")
+	})
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultReverseProxyRoutes maps the path prefixes of the reverse proxy listener to the upstream
+// providers, when no routes are configured
+var DefaultReverseProxyRoutes = []string{
+	"/openai=https://api.openai.com",
+}
+
+// ReverseProxyRoute maps requests sent to a path prefix on the reverse proxy listener to an
+// upstream base URL, e.g. /openai/v1/models -> https://api.openai.com/v1/models
+type ReverseProxyRoute struct {
+	Prefix   string
+	Upstream *url.URL
+}
+
+// ReverseProxyRoutes is the route table for the reverse proxy listener, sorted by prefix length so
+// the most specific prefix is matched first
+type ReverseProxyRoutes []ReverseProxyRoute
+
+// ParseReverseProxyRoutes parses a list of "prefix=upstream URL" routes
+func ParseReverseProxyRoutes(routes []string) (ReverseProxyRoutes, error) {
+	parsed := make(ReverseProxyRoutes, 0, len(routes))
+	seen := make(map[string]struct{})
+	errs := make([]error, 0)
+
+	for _, route := range routes {
+		prefix, upstream, ok := strings.Cut(route, "=")
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid route %q, expected prefix=upstream", route))
+			continue
+		}
+
+		prefix = "/" + strings.Trim(strings.TrimSpace(prefix), "/")
+		if prefix == "/" {
+			errs = append(errs, fmt.Errorf("invalid route %q, the prefix can't be empty", route))
+			continue
+		}
+		if _, dupe := seen[prefix]; dupe {
+			errs = append(errs, fmt.Errorf("duplicate route prefix %q", prefix))
+			continue
+		}
+		seen[prefix] = struct{}{}
+
+		u, err := url.Parse(strings.TrimSpace(upstream))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid upstream for route %q: %w", route, err))
+			continue
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid upstream for route %q, must be an http(s) URL", route))
+			continue
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+
+		parsed = append(parsed, ReverseProxyRoute{Prefix: prefix, Upstream: u})
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(parsed, func(i, j int) bool {
+		return len(parsed[i].Prefix) > len(parsed[j].Prefix)
+	})
+	return parsed, nil
+}
+
+// Match returns the upstream URL for a request path, and false when no route matches. The prefix
+// must match whole path segments, so /openai doesn't match /openai-beta.
+func (routes ReverseProxyRoutes) Match(requestPath string) (*url.URL, bool) {
+	for _, route := range routes {
+		rest, ok := strings.CutPrefix(requestPath, route.Prefix)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			continue
+		}
+
+		u := *route.Upstream
+		u.Path = route.Upstream.Path + rest
+		return &u, true
+	}
+	return nil, false
+}
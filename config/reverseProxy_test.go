@@ -0,0 +1,57 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReverseProxyRoutes(t *testing.T) {
+	t.Parallel()
+
+	routes, err := ParseReverseProxyRoutes([]string{
+		"/openai=https://api.openai.com",
+		"openai/beta/=https://beta.example.com/api/",
+		"/local=http://127.0.0.1:9000",
+	})
+	require.NoError(t, err)
+	require.Len(t, routes, 3)
+	assert.Equal(t, "/openai/beta", routes[0].Prefix, "longest prefix first")
+
+	testCases := map[string]string{
+		"/openai/v1/chat/completions": "https://api.openai.com/v1/chat/completions",
+		"/openai":                     "https://api.openai.com",
+		"/openai/beta/v1/models":      "https://beta.example.com/api/v1/models",
+		"/local/v1/models":            "http://127.0.0.1:9000/v1/models",
+	}
+	for requestPath, expected := range testCases {
+		u, ok := routes.Match(requestPath)
+		require.True(t, ok, requestPath)
+		assert.Equal(t, expected, u.String())
+	}
+
+	for _, requestPath := range []string{"/", "/openai-beta/v1", "/anthropic/v1/messages"} {
+		_, ok := routes.Match(requestPath)
+		assert.False(t, ok, requestPath)
+	}
+
+	defaults, err := ParseReverseProxyRoutes(DefaultReverseProxyRoutes)
+	require.NoError(t, err)
+	assert.NotEmpty(t, defaults)
+
+	invalid := map[string][]string{
+		"no separator":   {"/openai"},
+		"empty prefix":   {"/=https://api.openai.com"},
+		"duplicate":      {"/a=https://a.example.com", "/a/=https://b.example.com"},
+		"bad scheme":     {"/a=ftp://a.example.com"},
+		"missing host":   {"/a=https://"},
+		"unparsable url": {"/a=https://[::1"},
+	}
+	for name, routes := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseReverseProxyRoutes(routes)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// SecretStore holds the real provider API keys for the virtual keys, usually loaded from a JSON
+// file that only the proxy can read, like: {"secrets": {"openai-prod": "sk-..."}}
+type SecretStore struct {
+	Secrets map[string]string `json:"secrets"` // secret name -> provider API key
+}
+
+// LoadSecretStore reads and validates a JSON secret store file
+func LoadSecretStore(fileName string) (*SecretStore, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read secret store file: %w", err)
+	}
+
+	ss, err := NewSecretStoreFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret store file %s: %w", fileName, err)
+	}
+	return ss, nil
+}
+
+// NewSecretStoreFromJSON parses and validates the JSON secret store
+func NewSecretStoreFromJSON(data []byte) (*SecretStore, error) {
+	ss := &SecretStore{}
+	if err := json.Unmarshal(data, ss); err != nil {
+		// the error message could include part of a secret, so it's not wrapped
+		return nil, errors.New("unable to parse secret store, it must be valid JSON")
+	}
+
+	if err := ss.validate(); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// validate rejects empty secret names and values
+func (ss *SecretStore) validate() error {
+	errs := make([]error, 0)
+	if len(ss.Secrets) == 0 {
+		errs = append(errs, errors.New("at least one secret is required"))
+	}
+
+	// sorted, for stable error messages
+	names := make([]string, 0, len(ss.Secrets))
+	for name := range ss.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		switch {
+		case name == "":
+			errs = append(errs, errors.New("secret name is required"))
+		case ss.Secrets[name] == "":
+			errs = append(errs, fmt.Errorf("secret %q is empty", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Get returns the secret with the name
+func (ss *SecretStore) Get(name string) (string, bool) {
+	if ss == nil {
+		return "", false
+	}
+	secret, ok := ss.Secrets[name]
+	return secret, ok
+}
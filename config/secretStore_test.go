@@ -0,0 +1,58 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSecretStoreFromJSON(t *testing.T) {
+	t.Parallel()
+
+	ss, err := NewSecretStoreFromJSON([]byte(`{"secrets": {"openai-prod": "sk-test"}}`))
+	require.NoError(t, err)
+
+	secret, ok := ss.Get("openai-prod")
+	assert.True(t, ok)
+	assert.Equal(t, "sk-test", secret)
+	_, ok = ss.Get("missing")
+	assert.False(t, ok)
+
+	var nilStore *SecretStore
+	_, ok = nilStore.Get("openai-prod")
+	assert.False(t, ok)
+
+	invalid := map[string]string{
+		"bad json":     `{"secrets": {"a": "sk-`,
+		"no secrets":   `{"secrets": {}}`,
+		"empty name":   `{"secrets": {"": "sk-test"}}`,
+		"empty secret": `{"secrets": {"a": ""}}`,
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewSecretStoreFromJSON([]byte(data))
+			require.Error(t, err)
+			assert.NotContains(t, err.Error(), "sk-")
+		})
+	}
+}
+
+func TestLoadSecretStore(t *testing.T) {
+	t.Parallel()
+
+	fileName := filepath.Join(t.TempDir(), "secrets.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`{"secrets": {"openai-prod": "sk-test"}}`), 0o600))
+	ss, err := LoadSecretStore(fileName)
+	require.NoError(t, err)
+	assert.Len(t, ss.Secrets, 1)
+
+	ss, err = LoadSecretStore(filepath.Join("..", "examples", "config", "secret-store.json"))
+	require.NoError(t, err)
+	assert.Len(t, ss.Secrets, 2)
+
+	_, err = LoadSecretStore("does-not-exist.json")
+	assert.Error(t, err)
+}
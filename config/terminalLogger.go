@@ -1,6 +1,7 @@
 package config
 
 import (
+	"io"
 	"log/slog"
 	"os"
 
@@ -16,12 +17,16 @@ type terminalLogger struct {
 	TerminalSloggerFormat LogFormat // JSON or TXT ?
 	slogHandlerOpts       *slog.HandlerOptions
 	logger                *slog.Logger
+	output                io.Writer // where to write the log, defaults to stderr
 }
 
 // setupLoggerFormat loads a handler into a new slog instance based on the sLoggerFormat value
 func (tLo *terminalLogger) setupLoggerFormat() *slog.Logger {
 	var handler slog.Handler
-	w := os.Stderr
+	var w io.Writer = os.Stderr
+	if tLo.output != nil {
+		w = tLo.output
+	}
 
 	switch tLo.TerminalSloggerFormat {
 	case LogFormatJSON:
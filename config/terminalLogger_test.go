@@ -1,6 +1,7 @@
 package config
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -42,3 +43,17 @@ func TestConfig_SetLoggerLevel_Default(t *testing.T) {
 	assert.Equal(t, 0, cfg.getDebugLevel())
 	assert.Equal(t, true, cfg.logLevelHasBeenSet)
 }
+
+func TestConfig_SetLoggerLevel_Output(t *testing.T) {
+	t.Parallel()
+	buf := &bytes.Buffer{}
+	cfg := &terminalLogger{
+		Verbose:               true,
+		TerminalSloggerFormat: LogFormatJSON,
+		output:                buf,
+	}
+	cfg.setLoggerLevel()
+
+	cfg.logger.Info("hello")
+	assert.Contains(t, buf.String(), `"msg":"hello"`)
+}
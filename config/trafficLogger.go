@@ -42,6 +42,9 @@ type TrafficLogger struct {
 	NoLogReqBody     bool      // if true, log request body
 	NoLogRespHeaders bool      // if true, log response headers
 	NoLogRespBody    bool      // if true, log response body
+	RulesFile        string    // optional JSON file with rules to sample or trim the traffic log per flow
+	RedactBodies     bool      // if true, mask PII and secrets in the logged request and response bodies
+	RedactRulesFile  string    // optional JSON file with custom redaction rules, implies RedactBodies
 }
 
 func (t *TrafficLogger) GetLogSourceConfig() LogSourceConfig {
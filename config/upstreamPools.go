@@ -0,0 +1,190 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	defaultUpstreamMaxFailures = 3
+	defaultUpstreamCooldown    = 30 * time.Second
+)
+
+// UpstreamTarget is one endpoint in an upstream pool
+type UpstreamTarget struct {
+	Name    string              `json:"name,omitempty"`   // used in the logs and metrics, defaults to the URL host
+	URL     string              `json:"url"`              // base URL, or a full endpoint URL when it has a path
+	Weight  int                 `json:"weight,omitempty"` // relative share of the traffic, defaults to 1
+	Model   string              `json:"model,omitempty"`  // optional model name sent to this target
+	Headers HeaderModifications `json:"headers"`          // e.g. set the api-key header for Azure OpenAI
+	url     *url.URL
+}
+
+// UpstreamPool sends the requests for a logical model to a set of upstream targets
+type UpstreamPool struct {
+	Name        string           `json:"name,omitempty"`
+	Models      []string         `json:"models"`                 // requested model names, exact or glob like "gpt-4o*"
+	Hosts       []string         `json:"hosts,omitempty"`        // optional request hostnames, exact or glob
+	MaxAttempts int              `json:"max_attempts,omitempty"` // defaults to the number of targets
+	Targets     []UpstreamTarget `json:"targets"`
+}
+
+// UpstreamHealth configures when a target is skipped after errors
+type UpstreamHealth struct {
+	MaxFailures int    `json:"max_failures,omitempty"` // consecutive failures before the target is skipped
+	Cooldown    string `json:"cooldown,omitempty"`     // how long an unhealthy target is skipped, like "30s"
+	cooldown    time.Duration
+}
+
+// UpstreamPools is the routing table for the multi-upstream router, usually loaded from a JSON file
+type UpstreamPools struct {
+	Pools  []UpstreamPool `json:"pools"`
+	Health UpstreamHealth `json:"health"`
+}
+
+// LoadUpstreamPools reads and validates a JSON upstream pools file
+func LoadUpstreamPools(fileName string) (*UpstreamPools, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read upstream pools file: %w", err)
+	}
+
+	up, err := NewUpstreamPoolsFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream pools file %s: %w", fileName, err)
+	}
+	return up, nil
+}
+
+// NewUpstreamPoolsFromJSON parses and validates the JSON routing table
+func NewUpstreamPoolsFromJSON(data []byte) (*UpstreamPools, error) {
+	up := &UpstreamPools{}
+	if err := json.Unmarshal(data, up); err != nil {
+		return nil, fmt.Errorf("unable to parse upstream pools: %w", err)
+	}
+
+	if err := up.validate(); err != nil {
+		return nil, err
+	}
+	return up, nil
+}
+
+// validate checks each pool, parses the target URLs, and sets the defaults
+func (up *UpstreamPools) validate() error {
+	errs := make([]error, 0)
+
+	if up.Health.MaxFailures <= 0 {
+		up.Health.MaxFailures = defaultUpstreamMaxFailures
+	}
+	up.Health.cooldown = defaultUpstreamCooldown
+	if up.Health.Cooldown != "" {
+		d, err := time.ParseDuration(up.Health.Cooldown)
+		if err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("invalid health cooldown: %q", up.Health.Cooldown))
+		}
+		up.Health.cooldown = d
+	}
+
+	for i := range up.Pools {
+		pool := &up.Pools[i]
+		if pool.Name == "" {
+			pool.Name = fmt.Sprintf("pool-%d", i)
+		}
+
+		if len(pool.Models) == 0 {
+			errs = append(errs, fmt.Errorf("%s: at least one model is required", pool.Name))
+		}
+		if len(pool.Targets) == 0 {
+			errs = append(errs, fmt.Errorf("%s: at least one target is required", pool.Name))
+		}
+		if pool.MaxAttempts <= 0 {
+			pool.MaxAttempts = len(pool.Targets)
+		}
+
+		patterns := append(append([]string{}, pool.Models...), pool.Hosts...)
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid pattern %q: %w", pool.Name, pattern, err))
+			}
+		}
+
+		for j := range pool.Targets {
+			target := &pool.Targets[j]
+			u, err := url.Parse(target.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("%s: invalid target URL %q, must be an http(s) URL", pool.Name, target.URL))
+				continue
+			}
+			target.url = u
+
+			if target.Name == "" {
+				target.Name = u.Host
+			}
+			if target.Weight < 0 {
+				errs = append(errs, fmt.Errorf("%s: %s: weight can't be negative", pool.Name, target.Name))
+			}
+			if target.Weight == 0 {
+				target.Weight = 1
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Match returns the first pool for the requested model and hostname, or nil
+func (up *UpstreamPools) Match(model, hostname string) *UpstreamPool {
+	if up == nil || model == "" {
+		return nil
+	}
+	for i := range up.Pools {
+		pool := &up.Pools[i]
+		if !globMatchAny(pool.Models, model) {
+			continue
+		}
+		if len(pool.Hosts) > 0 && !globMatchAny(pool.Hosts, hostname) {
+			continue
+		}
+		return pool
+	}
+	return nil
+}
+
+// GetCooldown returns how long an unhealthy target is skipped
+func (h *UpstreamHealth) GetCooldown() time.Duration {
+	return h.cooldown
+}
+
+// Endpoint returns the URL to send a request to this target. When the target URL has a path, it
+// replaces the request path, otherwise the request path is used. Query params from both are kept.
+func (t *UpstreamTarget) Endpoint(reqURL *url.URL) *url.URL {
+	u := *t.url
+	if u.Path == "" || u.Path == "/" {
+		u.Path = reqURL.Path
+		u.RawPath = reqURL.RawPath
+	}
+
+	query := u.Query()
+	for key, values := range reqURL.Query() {
+		if _, ok := query[key]; ok {
+			continue
+		}
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// globMatchAny returns true when the value matches any of the patterns
+func globMatchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if globMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
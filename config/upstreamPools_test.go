@@ -0,0 +1,75 @@
+package config
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUpstreamPoolsFromJSON(t *testing.T) {
+	t.Parallel()
+
+	up, err := NewUpstreamPoolsFromJSON([]byte(`{"pools": [
+		{"models": ["gpt-4o*"], "targets": [{"url": "https://a.example.com", "weight": 2}, {"name": "b", "url": "https://b.example.com/v2/chat"}]},
+		{"name": "mini", "models": ["gpt-4o-mini"], "hosts": ["api.openai.com"], "targets": [{"url": "https://c.example.com"}]}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, up.Pools, 2)
+
+	pool := up.Pools[0]
+	assert.Equal(t, "pool-0", pool.Name)
+	assert.Equal(t, 2, pool.MaxAttempts, "defaults to the number of targets")
+	assert.Equal(t, "a.example.com", pool.Targets[0].Name)
+	assert.Equal(t, 1, pool.Targets[1].Weight)
+	assert.Equal(t, defaultUpstreamMaxFailures, up.Health.MaxFailures)
+	assert.Equal(t, defaultUpstreamCooldown, up.Health.GetCooldown())
+
+	t.Run("match", func(t *testing.T) {
+		assert.Equal(t, "pool-0", up.Match("gpt-4o", "api.openai.com").Name)
+		assert.Equal(t, "pool-0", up.Match("gpt-4o-mini", "api.openai.com").Name, "first pool wins")
+		assert.Nil(t, up.Match("gpt-3.5-turbo", "api.openai.com"))
+		assert.Nil(t, up.Match("", "api.openai.com"))
+
+		var nilPools *UpstreamPools
+		assert.Nil(t, nilPools.Match("gpt-4o", "api.openai.com"))
+	})
+
+	t.Run("endpoint", func(t *testing.T) {
+		reqURL, err := url.Parse("https://api.openai.com/v1/chat/completions?user=1")
+		require.NoError(t, err)
+		assert.Equal(t, "https://a.example.com/v1/chat/completions?user=1", pool.Targets[0].Endpoint(reqURL).String())
+		assert.Equal(t, "https://b.example.com/v2/chat?user=1", pool.Targets[1].Endpoint(reqURL).String())
+	})
+
+	invalid := map[string]string{
+		"bad json":        `{"pools": [`,
+		"no models":       `{"pools": [{"targets": [{"url": "https://a.example.com"}]}]}`,
+		"no targets":      `{"pools": [{"models": ["a"]}]}`,
+		"bad glob":        `{"pools": [{"models": ["["], "targets": [{"url": "https://a.example.com"}]}]}`,
+		"bad url":         `{"pools": [{"models": ["a"], "targets": [{"url": "a.example.com"}]}]}`,
+		"negative weight": `{"pools": [{"models": ["a"], "targets": [{"url": "https://a.example.com", "weight": -1}]}]}`,
+		"bad cooldown":    `{"pools": [], "health": {"cooldown": "soon"}}`,
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewUpstreamPoolsFromJSON([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadUpstreamPoolsExample(t *testing.T) {
+	t.Parallel()
+
+	up, err := LoadUpstreamPools(filepath.Join("..", "examples", "config", "upstream-pools.json"))
+	require.NoError(t, err)
+	assert.Len(t, up.Pools, 2)
+	assert.Equal(t, 30*time.Second, up.Health.GetCooldown())
+
+	_, err = LoadUpstreamPools("does-not-exist.json")
+	assert.Error(t, err)
+}
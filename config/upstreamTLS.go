@@ -0,0 +1,135 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+)
+
+// UpstreamTLSHost is the TLS client config for the upstream hosts that match a glob, e.g. a private
+// inference gateway that requires a client certificate and is signed by an internal CA
+type UpstreamTLSHost struct {
+	Host               string   `json:"host"`                           // hostname, exact or glob like "*.inference.internal"
+	ClientCert         string   `json:"client_cert,omitempty"`          // PEM file with the client certificate chain
+	ClientKey          string   `json:"client_key,omitempty"`           // PEM file with the client private key
+	RootCAs            []string `json:"root_cas,omitempty"`             // PEM bundles trusted in addition to the system roots
+	InsecureSkipVerify bool     `json:"insecure_skip_verify,omitempty"` // don't verify the server certificate of this host
+	certificates       []tls.Certificate
+	rootCAs            *x509.CertPool
+}
+
+// UpstreamTLS is the per-host TLS client config that's used when the proxy connects upstream,
+// usually loaded from a JSON file
+type UpstreamTLS struct {
+	Hosts []UpstreamTLSHost `json:"hosts"`
+}
+
+// LoadUpstreamTLS reads a JSON upstream TLS file, and loads the certificates that it references
+func LoadUpstreamTLS(fileName string) (*UpstreamTLS, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read upstream TLS file: %w", err)
+	}
+
+	ut, err := NewUpstreamTLSFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream TLS file %s: %w", fileName, err)
+	}
+	return ut, nil
+}
+
+// NewUpstreamTLSFromJSON parses the JSON upstream TLS config, and loads the certificates that it
+// references
+func NewUpstreamTLSFromJSON(data []byte) (*UpstreamTLS, error) {
+	ut := &UpstreamTLS{}
+	if err := json.Unmarshal(data, ut); err != nil {
+		return nil, fmt.Errorf("unable to parse upstream TLS config: %w", err)
+	}
+
+	if err := ut.validate(); err != nil {
+		return nil, err
+	}
+	return ut, nil
+}
+
+// validate checks each host, and loads the client certificates and the root CA bundles
+func (ut *UpstreamTLS) validate() error {
+	errs := make([]error, 0)
+
+	for i := range ut.Hosts {
+		host := &ut.Hosts[i]
+		if host.Host == "" {
+			errs = append(errs, fmt.Errorf("hosts[%d]: host is required", i))
+			continue
+		}
+		if _, err := path.Match(host.Host, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid host pattern: %w", host.Host, err))
+			continue
+		}
+
+		if (host.ClientCert == "") != (host.ClientKey == "") {
+			errs = append(errs, fmt.Errorf("%s: client_cert and client_key must be set together", host.Host))
+		} else if host.ClientCert != "" {
+			cert, err := tls.LoadX509KeyPair(host.ClientCert, host.ClientKey)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: unable to load the client certificate: %w", host.Host, err))
+			}
+			host.certificates = []tls.Certificate{cert}
+		}
+
+		if len(host.RootCAs) > 0 {
+			pool, err := loadRootCAs(host.RootCAs)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", host.Host, err))
+			}
+			host.rootCAs = pool
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loadRootCAs returns the system roots, plus the certificates in the PEM bundles
+func loadRootCAs(bundles []string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	for _, bundle := range bundles {
+		data, err := os.ReadFile(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the root CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in the root CA bundle %s", bundle)
+		}
+	}
+	return pool, nil
+}
+
+// Match returns the first host config for the hostname, or nil
+func (ut *UpstreamTLS) Match(hostname string) *UpstreamTLSHost {
+	if ut == nil {
+		return nil
+	}
+	for i := range ut.Hosts {
+		if globMatch(ut.Hosts[i].Host, hostname) {
+			return &ut.Hosts[i]
+		}
+	}
+	return nil
+}
+
+// TLSConfig returns a new TLS client config with the host's client certificate and root CAs. The
+// server certificate isn't verified when insecureSkipVerify is set, or the host skips it.
+func (h *UpstreamTLSHost) TLSConfig(insecureSkipVerify bool) *tls.Config {
+	return &tls.Config{
+		Certificates:       h.certificates,
+		RootCAs:            h.rootCAs, // the system roots when nil
+		InsecureSkipVerify: insecureSkipVerify || h.InsecureSkipVerify,
+	}
+}
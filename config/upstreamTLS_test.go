@@ -0,0 +1,103 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCert writes a self-signed certificate and its key as PEM files, and returns their paths
+func writeTestCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestNewUpstreamTLSFromJSON(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "client")
+	caFile, _ := writeTestCert(t, dir, "internal-ca")
+
+	ut, err := NewUpstreamTLSFromJSON([]byte(`{"hosts": [
+		{"host": "gateway.internal", "client_cert": "` + certFile + `", "client_key": "` + keyFile + `", "root_cas": ["` + caFile + `"]},
+		{"host": "*.inference.internal", "insecure_skip_verify": true}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, ut.Hosts, 2)
+
+	t.Run("match", func(t *testing.T) {
+		assert.Equal(t, "gateway.internal", ut.Match("GATEWAY.internal").Host)
+		assert.Equal(t, "*.inference.internal", ut.Match("east.inference.internal").Host)
+		assert.Nil(t, ut.Match("api.openai.com"))
+
+		var nilTLS *UpstreamTLS
+		assert.Nil(t, nilTLS.Match("gateway.internal"))
+	})
+
+	t.Run("tls config", func(t *testing.T) {
+		tlsConfig := ut.Match("gateway.internal").TLSConfig(false)
+		require.Len(t, tlsConfig.Certificates, 1)
+		assert.NotNil(t, tlsConfig.RootCAs)
+		assert.False(t, tlsConfig.InsecureSkipVerify)
+		assert.True(t, ut.Match("gateway.internal").TLSConfig(true).InsecureSkipVerify, "the global flag applies to every host")
+
+		tlsConfig = ut.Match("east.inference.internal").TLSConfig(false)
+		assert.Empty(t, tlsConfig.Certificates)
+		assert.Nil(t, tlsConfig.RootCAs, "the system roots")
+		assert.True(t, tlsConfig.InsecureSkipVerify)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		notPEM := filepath.Join(dir, "not.pem")
+		require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+
+		testCases := map[string]string{
+			"missing host":     `{"hosts": [{"client_cert": "` + certFile + `", "client_key": "` + keyFile + `"}]}`,
+			"invalid pattern":  `{"hosts": [{"host": "[gateway"}]}`,
+			"cert without key": `{"hosts": [{"host": "gateway.internal", "client_cert": "` + certFile + `"}]}`,
+			"wrong key":        `{"hosts": [{"host": "gateway.internal", "client_cert": "` + certFile + `", "client_key": "` + filepath.Join(dir, "internal-ca.key") + `"}]}`,
+			"missing bundle":   `{"hosts": [{"host": "gateway.internal", "root_cas": ["` + filepath.Join(dir, "missing.pem") + `"]}]}`,
+			"empty bundle":     `{"hosts": [{"host": "gateway.internal", "root_cas": ["` + notPEM + `"]}]}`,
+			"invalid json":     `{"hosts": {}}`,
+		}
+		for name, data := range testCases {
+			_, err := NewUpstreamTLSFromJSON([]byte(data))
+			assert.Error(t, err, name)
+		}
+	})
+
+	_, err = LoadUpstreamTLS(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
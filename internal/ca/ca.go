@@ -0,0 +1,396 @@
+// Package ca manages the certificate authority that the proxy uses to sign the certificates for
+// TLS interception. The active CA is saved in the same file and format that the proxy library
+// loads, so a CA created here is used by the proxy. When the CA is rotated, the old certificate is
+// kept in a second file, and is still exported for the trust stores until its grace period ends.
+package ca
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// CAFileName is the active CA, with the private key and certificate, in the proxy library's
+	// format
+	CAFileName = "mitmproxy-ca.pem"
+
+	// RetiredFileName has the certificates of the rotated CAs, without their private keys
+	RetiredFileName = "mitmproxy-ca-retired.pem"
+
+	// DefaultCommonName is the subject of the generated CA certificates
+	DefaultCommonName = "llm_proxy"
+
+	// DefaultValidity is how long a generated CA certificate is valid, the same as the proxy
+	// library's generated CA
+	DefaultValidity = 3 * 365 * 24 * time.Hour
+
+	// DefaultGracePeriod is how long a rotated CA is still exported for the trust stores
+	DefaultGracePeriod = 30 * 24 * time.Hour
+
+	// trustedUntilHeader is the PEM header of a retired certificate, with the end of its grace
+	// period
+	trustedUntilHeader = "Trusted-Until"
+
+	// backdate is subtracted from the start of the validity, for clients with a slow clock
+	backdate = 48 * time.Hour
+)
+
+// ErrNotFound is returned when there is no CA in the directory
+var ErrNotFound = errors.New("CA not found")
+
+// KeyType is the private key algorithm and size of a generated CA. The proxy library signs the
+// intercepted certificates with an RSA key, so only RSA keys are supported.
+type KeyType string
+
+const (
+	KeyTypeRSA2048 KeyType = "rsa-2048"
+	KeyTypeRSA3072 KeyType = "rsa-3072"
+	KeyTypeRSA4096 KeyType = "rsa-4096"
+)
+
+// KeyTypes are the supported key types, for the command line help
+var KeyTypes = []KeyType{KeyTypeRSA2048, KeyTypeRSA3072, KeyTypeRSA4096}
+
+// bits returns the RSA key size of the key type
+func (kt KeyType) bits() (int, error) {
+	switch kt {
+	case KeyTypeRSA2048, "":
+		return 2048, nil
+	case KeyTypeRSA3072:
+		return 3072, nil
+	case KeyTypeRSA4096:
+		return 4096, nil
+	}
+	return 0, fmt.Errorf("unsupported key type %q, must be one of %v", kt, KeyTypes)
+}
+
+// Options are the settings of a generated CA
+type Options struct {
+	KeyType    KeyType
+	Validity   time.Duration
+	CommonName string
+}
+
+// Retired is the certificate of a rotated CA
+type Retired struct {
+	Cert         *x509.Certificate
+	TrustedUntil time.Time
+}
+
+// Store is a directory with the active CA, and the rotated CAs
+type Store struct {
+	dir string
+}
+
+// Open returns the store for a CA directory, which is created when it doesn't exist. An empty dir
+// is ~/.mitmproxy, the same default as the proxy.
+func Open(dir string) (*Store, error) {
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the home directory: %w", err)
+		}
+		dir = filepath.Join(home, ".mitmproxy")
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create the CA directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the absolute path of the CA directory
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Exists returns true when there is an active CA
+func (s *Store) Exists() bool {
+	_, err := os.Stat(filepath.Join(s.dir, CAFileName))
+	return err == nil
+}
+
+// Init generates a new CA. An existing CA is only replaced when force is true, and it's not kept
+// as a retired CA, use Rotate for that.
+func (s *Store) Init(opts Options, now time.Time, force bool) (*x509.Certificate, error) {
+	if s.Exists() && !force {
+		return nil, fmt.Errorf("a CA already exists in %s, rotate it, or use --force to replace it", s.dir)
+	}
+
+	key, cert, err := Generate(opts, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.save(key, cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// Load returns the certificate of the active CA
+func (s *Store) Load() (*x509.Certificate, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, CAFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("unable to read the CA: %w", err)
+	}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate in %s", CAFileName)
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// Retired returns the certificates of the rotated CAs, including the ones after their grace period
+func (s *Store) Retired() ([]Retired, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, RetiredFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read the retired CAs: %w", err)
+	}
+
+	retired := make([]Retired, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return retired, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retired CA: %w", err)
+		}
+		trustedUntil, err := time.Parse(time.RFC3339, block.Headers[trustedUntilHeader])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header of the retired CA %s: %w", trustedUntilHeader, Fingerprint(cert), err)
+		}
+		retired = append(retired, Retired{Cert: cert, TrustedUntil: trustedUntil})
+	}
+}
+
+// Rotate generates a new active CA. The old certificate is retired, and is still trusted until the
+// end of the grace period. Retired CAs after their grace period are removed.
+func (s *Store) Rotate(opts Options, gracePeriod time.Duration, now time.Time) (newCert, oldCert *x509.Certificate, err error) {
+	oldCert, err = s.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	retired, err := s.Retired()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, newCert, err := Generate(opts, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kept := make([]Retired, 0, len(retired)+1)
+	for _, r := range retired {
+		if now.Before(r.TrustedUntil) {
+			kept = append(kept, r)
+		}
+	}
+	if gracePeriod > 0 {
+		kept = append(kept, Retired{Cert: oldCert, TrustedUntil: now.Add(gracePeriod)})
+	}
+
+	// save the retired CAs first, so the old CA is never lost
+	if err := s.saveRetired(kept); err != nil {
+		return nil, nil, err
+	}
+	if err := s.save(key, newCert); err != nil {
+		return nil, nil, err
+	}
+	return newCert, oldCert, nil
+}
+
+// TrustedCerts returns the active CA certificate, and the retired ones that are still in their
+// grace period, for the trust stores
+func (s *Store) TrustedCerts(now time.Time) ([]*x509.Certificate, error) {
+	active, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	retired, err := s.Retired()
+	if err != nil {
+		return nil, err
+	}
+
+	certs := []*x509.Certificate{active}
+	for _, r := range retired {
+		if now.Before(r.TrustedUntil) {
+			certs = append(certs, r.Cert)
+		}
+	}
+	return certs, nil
+}
+
+// save writes the active CA, in the proxy library's format: a PKCS#8 private key, and then the
+// certificate
+func (s *Store) save(key *rsa.PrivateKey, cert *x509.Certificate) error {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := pem.Encode(buf, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}); err != nil {
+		return err
+	}
+	if err := pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, CAFileName), buf.Bytes(), 0o600)
+}
+
+// saveRetired writes the retired certificates, with the end of the grace period in a PEM header
+func (s *Store) saveRetired(retired []Retired) error {
+	buf := &bytes.Buffer{}
+	for _, r := range retired {
+		block := &pem.Block{
+			Type:    "CERTIFICATE",
+			Headers: map[string]string{trustedUntilHeader: r.TrustedUntil.UTC().Format(time.RFC3339)},
+			Bytes:   r.Cert.Raw,
+		}
+		if err := pem.Encode(buf, block); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(filepath.Join(s.dir, RetiredFileName), buf.Bytes(), 0o644)
+}
+
+// writeFileAtomic writes the data to a temporary file, and renames it, so a running proxy never
+// reads a partial file
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
+
+// Generate creates a new self-signed CA
+func Generate(opts Options, now time.Time) (*rsa.PrivateKey, *x509.Certificate, error) {
+	bits, err := opts.KeyType.bits()
+	if err != nil {
+		return nil, nil, err
+	}
+	validity := opts.Validity
+	if validity == 0 {
+		validity = DefaultValidity
+	}
+	if validity < 0 {
+		return nil, nil, errors.New("the validity must be positive")
+	}
+	commonName := opts.CommonName
+	if commonName == "" {
+		commonName = DefaultCommonName
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to generate the private key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: []string{commonName},
+		},
+		NotBefore:             now.Add(-backdate),
+		NotAfter:              now.Add(validity),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create the CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, cert, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the certificate, in the same format as openssl
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	hexSum := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	parts := make([]string, 0, len(sum))
+	for i := 0; i < len(hexSum); i += 2 {
+		parts = append(parts, hexSum[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// KeyDescription returns the algorithm and size of the certificate's public key, like "RSA 2048"
+func KeyDescription(cert *x509.Certificate) string {
+	if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+// EncodePEM returns the certificates as PEM blocks, for a CA bundle
+func EncodePEM(certs []*x509.Certificate) []byte {
+	buf := &bytes.Buffer{}
+	for _, cert := range certs {
+		_ = pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,161 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/proxati/mitmproxy/cert"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pkcs12"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	s, err := Open(filepath.Join(t.TempDir(), "ca"))
+	require.NoError(t, err)
+	assert.False(t, s.Exists())
+	_, err = s.Load()
+	require.ErrorIs(t, err, ErrNotFound)
+	_, _, err = s.Rotate(Options{}, DefaultGracePeriod, now)
+	require.ErrorIs(t, err, ErrNotFound, "there is nothing to rotate")
+
+	first, err := s.Init(Options{Validity: 24 * time.Hour, CommonName: "test CA"}, now, false)
+	require.NoError(t, err)
+	assert.True(t, s.Exists())
+	assert.True(t, first.IsCA)
+	assert.Equal(t, "test CA", first.Subject.CommonName)
+	assert.Equal(t, now.Add(24*time.Hour), first.NotAfter)
+	assert.Equal(t, "RSA 2048", KeyDescription(first))
+	assert.Len(t, Fingerprint(first), 32*3-1)
+
+	_, err = s.Init(Options{}, now, false)
+	require.Error(t, err, "an existing CA is not replaced without force")
+
+	t.Run("the proxy library loads the CA", func(t *testing.T) {
+		loader, err := cert.NewPathLoader(s.Dir())
+		require.NoError(t, err)
+		loaded, err := cert.New(loader)
+		require.NoError(t, err)
+		assert.Equal(t, first.Raw, loaded.RootCert.Raw)
+
+		info, err := os.Stat(filepath.Join(s.Dir(), CAFileName))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+
+	second, old, err := s.Rotate(Options{}, time.Hour, now)
+	require.NoError(t, err)
+	assert.Equal(t, first.Raw, old.Raw)
+	active, err := s.Load()
+	require.NoError(t, err)
+	assert.Equal(t, second.Raw, active.Raw)
+
+	retired, err := s.Retired()
+	require.NoError(t, err)
+	require.Len(t, retired, 1)
+	assert.Equal(t, first.Raw, retired[0].Cert.Raw)
+	assert.Equal(t, now.Add(time.Hour), retired[0].TrustedUntil)
+
+	certs, err := s.TrustedCerts(now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, certs, 2, "the old CA is trusted during the grace period")
+	certs, err = s.TrustedCerts(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, certs, 1)
+
+	// the first CA is removed after its grace period
+	_, _, err = s.Rotate(Options{}, time.Hour, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	retired, err = s.Retired()
+	require.NoError(t, err)
+	require.Len(t, retired, 1)
+	assert.Equal(t, second.Raw, retired[0].Cert.Raw)
+}
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	_, _, err := Generate(Options{KeyType: "ed25519"}, time.Now())
+	require.Error(t, err)
+	_, _, err = Generate(Options{Validity: -time.Hour}, time.Now())
+	require.Error(t, err)
+
+	key, cert, err := Generate(Options{KeyType: KeyTypeRSA3072}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 3072, key.N.BitLen())
+	assert.Equal(t, DefaultCommonName, cert.Subject.CommonName)
+	assert.NoError(t, cert.CheckSignatureFrom(cert))
+}
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	_, first, err := Generate(Options{}, time.Now())
+	require.NoError(t, err)
+	_, second, err := Generate(Options{CommonName: "second"}, time.Now())
+	require.NoError(t, err)
+	certs := []*x509.Certificate{first, second}
+
+	t.Run("pem", func(t *testing.T) {
+		block, rest := pem.Decode(EncodePEM(certs))
+		require.NotNil(t, block)
+		assert.Equal(t, first.Raw, block.Bytes)
+		block, _ = pem.Decode(rest)
+		require.NotNil(t, block)
+		assert.Equal(t, second.Raw, block.Bytes)
+	})
+
+	for _, password := range []string{"", "changeit"} {
+		t.Run("pkcs12 password "+password, func(t *testing.T) {
+			data, err := EncodePKCS12TrustStore(certs, password)
+			require.NoError(t, err)
+
+			// the decoder only supports files with a private key, but it checks the MAC first
+			_, err = pkcs12.ToPEM(data, password+"wrong")
+			require.ErrorIs(t, err, pkcs12.ErrIncorrectPassword)
+			_, err = pkcs12.ToPEM(data, password)
+			require.NotErrorIs(t, err, pkcs12.ErrIncorrectPassword)
+
+			var pfx pfxPdu
+			_, err = asn1.Unmarshal(data, &pfx)
+			require.NoError(t, err)
+			var authSafe []byte
+			_, err = asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe)
+			require.NoError(t, err)
+			var infos []contentInfo
+			_, err = asn1.Unmarshal(authSafe, &infos)
+			require.NoError(t, err)
+			require.Len(t, infos, 1)
+			var safeContents []byte
+			_, err = asn1.Unmarshal(infos[0].Content.Bytes, &safeContents)
+			require.NoError(t, err)
+			var bags []safeBag
+			_, err = asn1.Unmarshal(safeContents, &bags)
+			require.NoError(t, err)
+			require.Len(t, bags, 2)
+
+			for i, bag := range bags {
+				assert.Equal(t, oidCertBag, bag.ID)
+				var cb certBag
+				_, err = asn1.Unmarshal(bag.Value.Bytes, &cb)
+				require.NoError(t, err)
+				var der []byte
+				_, err = asn1.Unmarshal(cb.Data.Bytes, &der)
+				require.NoError(t, err)
+				assert.Equal(t, certs[i].Raw, der)
+				assert.Len(t, bag.Attributes, 2)
+			}
+		})
+	}
+
+	_, err = EncodePKCS12TrustStore(nil, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,231 @@
+package ca
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"unicode/utf16"
+)
+
+// The PKCS#12 (RFC 7292) structures for a trust store: a password-integrity (MAC) protected file
+// with certificate bags, and no private keys. golang.org/x/crypto/pkcs12 can only decode, so the
+// few structures needed here are encoded directly.
+
+var (
+	oidDataContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidJavaTrustedUsage  = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKeyUse = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+)
+
+const (
+	pkcs12MACIterations = 2048
+	pkcs12SaltLength    = 8
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data asn1.RawValue
+}
+
+// EncodePKCS12TrustStore returns a PKCS#12 file with the certificates as trusted entries, for trust
+// stores like the Windows certificate store and Java's keytool. The password can be empty.
+func EncodePKCS12TrustStore(certs []*x509.Certificate, password string) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("at least one certificate is required")
+	}
+
+	bags := make([]safeBag, 0, len(certs))
+	for _, cert := range certs {
+		bag, err := newTrustedCertBag(cert)
+		if err != nil {
+			return nil, err
+		}
+		bags = append(bags, bag)
+	}
+
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+	safeContentsInfo, err := newDataContentInfo(safeContents)
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := asn1.Marshal([]contentInfo{safeContentsInfo})
+	if err != nil {
+		return nil, err
+	}
+	authSafeInfo, err := newDataContentInfo(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, pkcs12SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, pkcs12MACKey(password, salt, pkcs12MACIterations))
+	mac.Write(authSafe)
+
+	return asn1.Marshal(pfxPdu{
+		Version:  3,
+		AuthSafe: authSafeInfo,
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: pkcs12MACIterations,
+		},
+	})
+}
+
+// newTrustedCertBag returns a certificate bag with a friendly name, and the attribute that makes
+// Java treat it as a trusted certificate entry
+func newTrustedCertBag(cert *x509.Certificate) (safeBag, error) {
+	certValue, err := explicitTag(cert.Raw)
+	if err != nil {
+		return safeBag{}, err
+	}
+	bagValue, err := asn1.Marshal(certBag{ID: oidCertTypeX509, Data: certValue})
+	if err != nil {
+		return safeBag{}, err
+	}
+
+	friendlyName, err := setOf(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(cert.Subject.CommonName)})
+	if err != nil {
+		return safeBag{}, err
+	}
+	trustedUsage, err := setOf(oidAnyExtendedKeyUse)
+	if err != nil {
+		return safeBag{}, err
+	}
+
+	return safeBag{
+		ID:    oidCertBag,
+		Value: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bagValue},
+		Attributes: []pkcs12Attribute{
+			{ID: oidFriendlyName, Value: friendlyName},
+			{ID: oidJavaTrustedUsage, Value: trustedUsage},
+		},
+	}, nil
+}
+
+// newDataContentInfo wraps the bytes in a ContentInfo of the "data" type
+func newDataContentInfo(data []byte) (contentInfo, error) {
+	content, err := explicitTag(data)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidDataContentType, Content: content}, nil
+}
+
+// explicitTag returns the bytes as an OCTET STRING, in an explicit [0] tag
+func explicitTag(data []byte) (asn1.RawValue, error) {
+	octets, err := asn1.Marshal(data)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets}, nil
+}
+
+// setOf returns a SET with the one value
+func setOf(value any) (asn1.RawValue, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der}, nil
+}
+
+// bmpString returns the string as big-endian UTF-16
+func bmpString(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	out := make([]byte, 0, 2*len(encoded))
+	for _, r := range encoded {
+		out = append(out, byte(r>>8), byte(r))
+	}
+	return out
+}
+
+// pkcs12MACKey derives the SHA-1 HMAC key from the password, with the PKCS#12 key derivation
+// function (RFC 7292, appendix B.2). The key is one hash long, so only the first block is needed.
+func pkcs12MACKey(password string, salt []byte, iterations int) []byte {
+	const (
+		u  = sha1.Size // hash length
+		v  = 64        // hash block length
+		id = 3         // MAC key material
+	)
+
+	// the password is a zero-terminated BMPString
+	pass := append(bmpString(password), 0, 0)
+
+	fill := func(in []byte) []byte {
+		if len(in) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(in)+v-1)/v))
+		for i := range out {
+			out[i] = in[i%len(in)]
+		}
+		return out
+	}
+
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+
+	h := sha1.New()
+	h.Write(d)
+	h.Write(fill(salt))
+	h.Write(fill(pass))
+	a := h.Sum(nil)
+	for i := 1; i < iterations; i++ {
+		sum := sha1.Sum(a)
+		a = sum[:]
+	}
+	return a[:u]
+}
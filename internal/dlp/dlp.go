@@ -0,0 +1,44 @@
+package dlp
+
+import (
+	"fmt"
+
+	"github.com/proxati/llm_proxy/v2/internal/redact"
+)
+
+// Config is the DLP config, usually loaded from a JSON file. It has the same format as the
+// redaction config, but only the secret detectors are enabled when Detectors is omitted.
+type Config = redact.Config
+
+// New creates the Redactor that masks the secrets in the request bodies, and returns an error if
+// any rule is invalid
+func New(cfg Config) (*redact.Redactor, error) {
+	if cfg.Detectors == nil {
+		cfg.Detectors = redact.SecretDetectorNames()
+	}
+	return redact.New(cfg)
+}
+
+// NewDefault creates a Redactor with all of the secret detectors, and no custom rules
+func NewDefault() *redact.Redactor {
+	r, err := New(Config{})
+	if err != nil {
+		// this should never happen, the built-in detectors are static
+		panic(err)
+	}
+	return r
+}
+
+// LoadFile reads a JSON DLP config file, and creates a Redactor
+func LoadFile(fileName string) (*redact.Redactor, error) {
+	cfg, err := redact.LoadConfig(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DLP rules file %s: %w", fileName, err)
+	}
+	return r, nil
+}
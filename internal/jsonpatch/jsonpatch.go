@@ -0,0 +1,428 @@
+// Package jsonpatch applies RFC 6902 JSON Patch documents to JSON bodies. In addition to the
+// standard operations (add, remove, replace, move, copy, test), the non-standard "cap" operation
+// lowers a number to a maximum value, and does nothing when the value is missing or already lower.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// operation names
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+	OpMove    = "move"
+	OpCopy    = "copy"
+	OpTest    = "test"
+	OpCap     = "cap"
+)
+
+// Operation is a single JSON Patch operation
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`  // only for move and copy
+	Value json.RawMessage `json:"value,omitempty"` // only for add, replace, test, and cap
+}
+
+// Patch is a list of operations, applied in order
+type Patch []Operation
+
+// Validate checks that every operation is known, and has the fields it needs
+func (p Patch) Validate() error {
+	errs := make([]error, 0)
+	for i, op := range p {
+		if err := op.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("operation %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (op Operation) validate() error {
+	if _, err := parsePointer(op.Path); err != nil {
+		return err
+	}
+
+	switch op.Op {
+	case OpRemove:
+		if op.Path == "" {
+			return errors.New("can't remove the whole document")
+		}
+	case OpAdd, OpReplace, OpTest:
+		if len(op.Value) == 0 {
+			return fmt.Errorf("%s requires a value", op.Op)
+		}
+	case OpCap:
+		var n json.Number
+		if err := json.Unmarshal(op.Value, &n); err != nil {
+			return fmt.Errorf("cap requires a number value: %w", err)
+		}
+	case OpMove, OpCopy:
+		if _, err := parsePointer(op.From); err != nil {
+			return fmt.Errorf("invalid from: %w", err)
+		}
+		if op.Op == OpMove && op.Path != op.From && strings.HasPrefix(op.Path+"/", op.From+"/") {
+			return errors.New("can't move a value into itself")
+		}
+	default:
+		return fmt.Errorf("unknown op: %q", op.Op)
+	}
+	return nil
+}
+
+// Apply decodes the JSON document, applies all operations, and returns the encoded result. The
+// document is not modified when any operation fails.
+func (p Patch) Apply(doc []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber() // keep the original number formatting
+	var root any
+	if err := dec.Decode(&root); err != nil {
+		return nil, fmt.Errorf("unable to decode JSON document: %w", err)
+	}
+
+	for i, op := range p {
+		var err error
+		root, err = op.apply(root)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(root); err != nil {
+		return nil, fmt.Errorf("unable to encode JSON document: %w", err)
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), nil
+}
+
+// apply runs the operation on the decoded document, and returns the new root
+func (op Operation) apply(root any) (any, error) {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case OpAdd:
+		value, err := decodeValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, tokens, value)
+	case OpRemove:
+		root, _, err := remove(root, tokens)
+		return root, err
+	case OpReplace:
+		value, err := decodeValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := get(root, tokens); err != nil {
+			return nil, err
+		}
+		return set(root, tokens, value)
+	case OpMove:
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		root, value, err := remove(root, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, tokens, value)
+	case OpCopy:
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(root, from)
+		if err != nil {
+			return nil, err
+		}
+		value, err = deepCopy(value)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, tokens, value)
+	case OpTest:
+		expected, err := decodeValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := get(root, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(expected, actual) {
+			return nil, errors.New("test failed, the value is different")
+		}
+		return root, nil
+	case OpCap:
+		return capNumber(root, tokens, op.Value)
+	}
+	return nil, fmt.Errorf("unknown op: %q", op.Op)
+}
+
+// capNumber lowers the number at the path to the max value, when it's higher
+func capNumber(root any, tokens []string, rawMax json.RawMessage) (any, error) {
+	var maxValue json.Number
+	if err := json.Unmarshal(rawMax, &maxValue); err != nil {
+		return nil, fmt.Errorf("cap requires a number value: %w", err)
+	}
+	maxFloat, err := maxValue.Float64()
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := get(root, tokens)
+	if err != nil {
+		// nothing to cap
+		return root, nil
+	}
+	n, ok := current.(json.Number)
+	if !ok {
+		return nil, errors.New("cap target is not a number")
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, err
+	}
+	if f <= maxFloat {
+		return root, nil
+	}
+	return set(root, tokens, maxValue)
+}
+
+// parsePointer converts an RFC 6901 JSON Pointer to a list of unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer, must start with /: %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token. When allowEnd is true, the index can be one past the
+// last item (or "-"), for adding to the end of the array.
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index: %q", token)
+	}
+	if i > length || (i == length && !allowEnd) {
+		return 0, fmt.Errorf("array index out of range: %d", i)
+	}
+	return i, nil
+}
+
+// get returns the value at the path
+func get(node any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found: %q", token)
+			}
+			node = child
+		case []any:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("path not found: %q", token)
+		}
+	}
+	return node, nil
+}
+
+// update walks to the parent of the last token, and replaces it with the result of fn
+func update(node any, tokens []string, fn func(parent any, key string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return fn(node, tokens[0])
+	}
+
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("path not found: %q", tokens[0])
+		}
+		newChild, err := update(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[tokens[0]] = newChild
+		return n, nil
+	case []any:
+		i, err := arrayIndex(tokens[0], len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		newChild, err := update(n[i], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = newChild
+		return n, nil
+	}
+	return nil, fmt.Errorf("path not found: %q", tokens[0])
+}
+
+// add inserts the value into an array, or sets an object key
+func add(root any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	return update(root, tokens, func(parent any, key string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			p[key] = value
+			return p, nil
+		case []any:
+			i, err := arrayIndex(key, len(p), true)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+			return p, nil
+		}
+		return nil, fmt.Errorf("parent of %q is not an object or array", key)
+	})
+}
+
+// set replaces an existing value
+func set(root any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	return update(root, tokens, func(parent any, key string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			p[key] = value
+			return p, nil
+		case []any:
+			i, err := arrayIndex(key, len(p), false)
+			if err != nil {
+				return nil, err
+			}
+			p[i] = value
+			return p, nil
+		}
+		return nil, fmt.Errorf("parent of %q is not an object or array", key)
+	})
+}
+
+// remove deletes the value at the path, and returns the new root and the removed value
+func remove(root any, tokens []string) (any, any, error) {
+	if len(tokens) == 0 {
+		return nil, nil, errors.New("can't remove the whole document")
+	}
+
+	var removed any
+	root, err := update(root, tokens, func(parent any, key string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			v, ok := p[key]
+			if !ok {
+				return nil, fmt.Errorf("path not found: %q", key)
+			}
+			removed = v
+			delete(p, key)
+			return p, nil
+		case []any:
+			i, err := arrayIndex(key, len(p), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = p[i]
+			return append(p[:i], p[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("parent of %q is not an object or array", key)
+	})
+	return root, removed, err
+}
+
+// decodeValue decodes an operation value, keeping numbers as json.Number
+func decodeValue(raw json.RawMessage) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+	return v, nil
+}
+
+// deepCopy copies a decoded value, so copied objects aren't shared with the source
+func deepCopy(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(data)
+}
+
+// equal compares decoded values, treating numbers with the same value as equal (1 and 1.0)
+func equal(a, b any) bool {
+	an, aIsNum := a.(json.Number)
+	bn, bIsNum := b.(json.Number)
+	if aIsNum && bIsNum {
+		af, aErr := an.Float64()
+		bf, bErr := bn.Float64()
+		return aErr == nil && bErr == nil && af == bf
+	}
+
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			if other, ok := bv[k]; !ok || !equal(v, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,124 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustPatch(t *testing.T, s string) Patch {
+	t.Helper()
+	var p Patch
+	require.NoError(t, json.Unmarshal([]byte(s), &p))
+	require.NoError(t, p.Validate())
+	return p
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+	doc := `{"model":"gpt-4","max_tokens":4096,"temperature":0.70,"messages":[{"role":"user","content":"hi <b>"}],"a/b":{"c~d":1}}`
+
+	tests := []struct {
+		name     string
+		patch    string
+		expected string
+	}{
+		{
+			"force model",
+			`[{"op": "replace", "path": "/model", "value": "gpt-4o-mini"}]`,
+			`{"a/b":{"c~d":1},"max_tokens":4096,"messages":[{"content":"hi <b>","role":"user"}],"model":"gpt-4o-mini","temperature":0.70}`,
+		},
+		{
+			"cap max_tokens",
+			`[{"op": "cap", "path": "/max_tokens", "value": 1024}]`,
+			`{"a/b":{"c~d":1},"max_tokens":1024,"messages":[{"content":"hi <b>","role":"user"}],"model":"gpt-4","temperature":0.70}`,
+		},
+		{
+			"cap is a no-op below the max",
+			`[{"op": "cap", "path": "/max_tokens", "value": 8192}, {"op": "cap", "path": "/missing", "value": 1}]`,
+			`{"a/b":{"c~d":1},"max_tokens":4096,"messages":[{"content":"hi <b>","role":"user"}],"model":"gpt-4","temperature":0.70}`,
+		},
+		{
+			"inject system prompt",
+			`[{"op": "add", "path": "/messages/0", "value": {"role": "system", "content": "be brief"}}]`,
+			`{"a/b":{"c~d":1},"max_tokens":4096,"messages":[{"content":"be brief","role":"system"},{"content":"hi <b>","role":"user"}],"model":"gpt-4","temperature":0.70}`,
+		},
+		{
+			"append, remove, escaped keys",
+			`[{"op": "add", "path": "/messages/-", "value": {"role": "user", "content": "bye"}},
+			  {"op": "remove", "path": "/temperature"},
+			  {"op": "replace", "path": "/a~1b/c~0d", "value": 2}]`,
+			`{"a/b":{"c~d":2},"max_tokens":4096,"messages":[{"content":"hi <b>","role":"user"},{"content":"bye","role":"user"}],"model":"gpt-4"}`,
+		},
+		{
+			"move and copy",
+			`[{"op": "copy", "from": "/model", "path": "/original_model"},
+			  {"op": "move", "from": "/max_tokens", "path": "/max_completion_tokens"},
+			  {"op": "test", "path": "/max_completion_tokens", "value": 4096.0}]`,
+			`{"a/b":{"c~d":1},"max_completion_tokens":4096,"messages":[{"content":"hi <b>","role":"user"}],"model":"gpt-4","original_model":"gpt-4","temperature":0.70}`,
+		},
+		{
+			"replace the whole document",
+			`[{"op": "replace", "path": "", "value": {"ok": true}}]`,
+			`{"ok":true}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := mustPatch(t, tt.patch).Apply([]byte(doc))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(out))
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	t.Parallel()
+	doc := []byte(`{"model": "gpt-4", "messages": [], "n": "2"}`)
+
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"remove missing", `[{"op": "remove", "path": "/missing"}]`},
+		{"replace missing", `[{"op": "replace", "path": "/missing", "value": 1}]`},
+		{"add to a missing parent", `[{"op": "add", "path": "/a/b", "value": 1}]`},
+		{"array index out of range", `[{"op": "add", "path": "/messages/1", "value": 1}]`},
+		{"leading zero index", `[{"op": "add", "path": "/messages/00", "value": 1}]`},
+		{"failed test", `[{"op": "test", "path": "/model", "value": "gpt-3"}]`},
+		{"cap a string", `[{"op": "cap", "path": "/n", "value": 1}]`},
+		{"add into a string", `[{"op": "add", "path": "/model/x", "value": 1}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := mustPatch(t, tt.patch).Apply(doc)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := Patch{}.Apply([]byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	invalid := []Patch{
+		{{Op: "merge", Path: "/a"}},
+		{{Op: OpAdd, Path: "a", Value: json.RawMessage(`1`)}},
+		{{Op: OpAdd, Path: "/a"}},
+		{{Op: OpRemove, Path: ""}},
+		{{Op: OpCap, Path: "/a", Value: json.RawMessage(`"x"`)}},
+		{{Op: OpMove, Path: "/a/b", From: "/a"}},
+		{{Op: OpCopy, Path: "/a", From: "b"}},
+	}
+	for _, p := range invalid {
+		assert.Error(t, p.Validate(), "%+v", p)
+	}
+
+	assert.NoError(t, Patch{{Op: OpMove, Path: "/a", From: "/a"}}.Validate())
+}
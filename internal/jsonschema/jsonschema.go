@@ -0,0 +1,551 @@
+// Package jsonschema validates JSON documents against the subset of JSON Schema that is used for
+// LLM structured outputs and tool parameters: type, enum, const, properties, required,
+// additionalProperties, items, the length, size, and range limits, pattern, the anyOf, oneOf,
+// allOf, and not combinators, and local $ref pointers like "#/$defs/step". The other keywords, like
+// format, description, and default, are ignored.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxErrors is the most validation errors returned for one document
+	maxErrors = 10
+
+	// maxDepth is the most nested schemas checked for one value, and maxSteps the most schemas
+	// checked for one document, so a schema with circular $refs, or with nested combinators, can't
+	// recurse forever
+	maxDepth = 256
+	maxSteps = 100_000
+)
+
+// Schema is a compiled JSON schema
+type Schema struct {
+	root *node
+}
+
+// node is one compiled schema object, or a boolean schema
+type node struct {
+	boolean *bool // true accepts everything, false rejects everything
+
+	types      []string
+	enum       []any
+	constValue any
+	hasConst   bool
+
+	properties           map[string]*node
+	required             []string
+	additionalProperties *node // nil when any additional property is allowed
+	minProperties        *int
+	maxProperties        *int
+
+	items    *node
+	minItems *int
+	maxItems *int
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	anyOf []*node
+	oneOf []*node
+	allOf []*node
+	not   *node
+
+	ref *node // the target of $ref, the other keywords still apply
+}
+
+// compiler compiles the nodes of a schema document, and resolves the $ref pointers
+type compiler struct {
+	root  any
+	nodes map[string]*node // JSON pointer -> compiled node, so recursive schemas are compiled once
+	errs  []error
+}
+
+// Compile parses and compiles a JSON schema
+func Compile(data []byte) (*Schema, error) {
+	root, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse schema: %w", err)
+	}
+
+	c := &compiler{root: root, nodes: make(map[string]*node)}
+	n := c.compile("#", root)
+	if err := errors.Join(c.errs...); err != nil {
+		return nil, err
+	}
+	return &Schema{root: n}, nil
+}
+
+// Validate returns the validation errors of a JSON document, like "$.steps[0].id: expected
+// integer, got string", or nil when it's valid
+func (s *Schema) Validate(data []byte) []string {
+	doc, err := decode(data)
+	if err != nil {
+		return []string{"invalid JSON: " + err.Error()}
+	}
+	return s.ValidateValue(doc)
+}
+
+// ValidateValue is like Validate, for a document decoded with json.Decoder.UseNumber
+func (s *Schema) ValidateValue(doc any) []string {
+	errs := s.root.validate("$", doc, nil, &state{})
+	if len(errs) > maxErrors {
+		errs = errs[:maxErrors]
+	}
+	return errs
+}
+
+// decode parses a JSON document, with the numbers as json.Number
+func decode(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+func (c *compiler) compile(pointer string, raw any) *node {
+	if n, ok := c.nodes[pointer]; ok {
+		return n
+	}
+	n := &node{}
+	c.nodes[pointer] = n
+
+	switch schema := raw.(type) {
+	case bool:
+		n.boolean = &schema
+		return n
+	case map[string]any:
+		c.compileObject(pointer, n, schema)
+	default:
+		c.errs = append(c.errs, fmt.Errorf("%s: a schema must be an object or a boolean", pointer))
+	}
+	return n
+}
+
+func (c *compiler) compileObject(pointer string, n *node, schema map[string]any) {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := resolvePointer(c.root, ref)
+		if err != nil {
+			c.errs = append(c.errs, fmt.Errorf("%s: %w", pointer, err))
+		} else {
+			n.ref = c.compile(ref, target)
+		}
+	}
+
+	switch t := schema["type"].(type) {
+	case string:
+		n.types = []string{t}
+	case []any:
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				n.types = append(n.types, s)
+			}
+		}
+	}
+	for _, t := range n.types {
+		switch t {
+		case "string", "number", "integer", "boolean", "object", "array", "null":
+		default:
+			c.errs = append(c.errs, fmt.Errorf("%s: unknown type %q", pointer, t))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		n.enum = enum
+	}
+	n.constValue, n.hasConst = schema["const"]
+
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		n.properties = make(map[string]*node, len(properties))
+		for name, property := range properties {
+			n.properties[name] = c.compile(pointer+"/properties/"+escapePointer(name), property)
+		}
+	}
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if s, ok := name.(string); ok {
+				n.required = append(n.required, s)
+			}
+		}
+	}
+	if additional, ok := schema["additionalProperties"]; ok {
+		n.additionalProperties = c.compile(pointer+"/additionalProperties", additional)
+	}
+	if items, ok := schema["items"]; ok {
+		n.items = c.compile(pointer+"/items", items)
+	}
+	if not, ok := schema["not"]; ok {
+		n.not = c.compile(pointer+"/not", not)
+	}
+	n.anyOf = c.compileList(pointer, "anyOf", schema)
+	n.oneOf = c.compileList(pointer, "oneOf", schema)
+	n.allOf = c.compileList(pointer, "allOf", schema)
+
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			c.errs = append(c.errs, fmt.Errorf("%s: invalid pattern: %w", pointer, err))
+		}
+		n.pattern = re
+	}
+
+	n.minProperties = intKeyword(schema, "minProperties")
+	n.maxProperties = intKeyword(schema, "maxProperties")
+	n.minItems = intKeyword(schema, "minItems")
+	n.maxItems = intKeyword(schema, "maxItems")
+	n.minLength = intKeyword(schema, "minLength")
+	n.maxLength = intKeyword(schema, "maxLength")
+	n.minimum = numberKeyword(schema, "minimum")
+	n.maximum = numberKeyword(schema, "maximum")
+	n.exclusiveMinimum = numberKeyword(schema, "exclusiveMinimum")
+	n.exclusiveMaximum = numberKeyword(schema, "exclusiveMaximum")
+	n.multipleOf = numberKeyword(schema, "multipleOf")
+	if n.multipleOf != nil && *n.multipleOf <= 0 {
+		c.errs = append(c.errs, fmt.Errorf("%s: multipleOf must be positive", pointer))
+	}
+}
+
+func (c *compiler) compileList(pointer, keyword string, schema map[string]any) []*node {
+	list, ok := schema[keyword].([]any)
+	if !ok {
+		return nil
+	}
+	nodes := make([]*node, len(list))
+	for i, item := range list {
+		nodes[i] = c.compile(pointer+"/"+keyword+"/"+strconv.Itoa(i), item)
+	}
+	return nodes
+}
+
+// state counts the nested schemas, and all of the schemas checked for a document
+type state struct {
+	depth int
+	steps int
+}
+
+// validate appends the errors of the value at path to errs
+func (n *node) validate(path string, v any, errs []string, st *state) []string {
+	if st.depth >= maxDepth || st.steps >= maxSteps {
+		return append(errs, path+": the schema is too complex to validate")
+	}
+	st.depth++
+	st.steps++
+	defer func() { st.depth-- }()
+	if n.boolean != nil {
+		if !*n.boolean {
+			errs = append(errs, path+": no value is allowed")
+		}
+		return errs
+	}
+	if n.ref != nil {
+		errs = n.ref.validate(path, v, errs, st)
+	}
+
+	if len(n.types) > 0 && !n.hasType(v) {
+		return append(errs, fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(n.types, " or "), typeOf(v)))
+	}
+	if n.enum != nil && !containsValue(n.enum, v) {
+		errs = append(errs, fmt.Sprintf("%s: %s is not one of the allowed values", path, shortJSON(v)))
+	}
+	if n.hasConst && !equal(n.constValue, v) {
+		errs = append(errs, fmt.Sprintf("%s: must be %s", path, shortJSON(n.constValue)))
+	}
+
+	switch value := v.(type) {
+	case map[string]any:
+		errs = n.validateObject(path, value, errs, st)
+	case []any:
+		errs = n.validateArray(path, value, errs, st)
+	case string:
+		errs = n.validateString(path, value, errs)
+	case json.Number:
+		errs = n.validateNumber(path, value, errs)
+	}
+
+	for _, sub := range n.allOf {
+		errs = sub.validate(path, v, errs, st)
+	}
+	if len(n.anyOf) > 0 && countMatches(n.anyOf, path, v, st) == 0 {
+		errs = append(errs, path+": doesn't match any of the anyOf schemas")
+	}
+	if len(n.oneOf) > 0 {
+		if matches := countMatches(n.oneOf, path, v, st); matches != 1 {
+			errs = append(errs, fmt.Sprintf("%s: must match exactly one of the oneOf schemas, matches %d", path, matches))
+		}
+	}
+	if n.not != nil && len(n.not.validate(path, v, nil, st)) == 0 {
+		errs = append(errs, path+": must not match the not schema")
+	}
+	return errs
+}
+
+func (n *node) validateObject(path string, obj map[string]any, errs []string, st *state) []string {
+	for _, name := range n.required {
+		if _, ok := obj[name]; !ok {
+			errs = append(errs, fmt.Sprintf("%s: missing required property %q", path, name))
+		}
+	}
+	if n.minProperties != nil && len(obj) < *n.minProperties {
+		errs = append(errs, fmt.Sprintf("%s: must have at least %d properties", path, *n.minProperties))
+	}
+	if n.maxProperties != nil && len(obj) > *n.maxProperties {
+		errs = append(errs, fmt.Sprintf("%s: must have at most %d properties", path, *n.maxProperties))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(obj)) {
+		propertyPath := path + "." + name
+		if property, ok := n.properties[name]; ok {
+			errs = property.validate(propertyPath, obj[name], errs, st)
+			continue
+		}
+		if n.additionalProperties == nil {
+			continue
+		}
+		if b := n.additionalProperties.boolean; b != nil && !*b {
+			errs = append(errs, fmt.Sprintf("%s: unexpected property %q", path, name))
+			continue
+		}
+		errs = n.additionalProperties.validate(propertyPath, obj[name], errs, st)
+	}
+	return errs
+}
+
+func (n *node) validateArray(path string, arr []any, errs []string, st *state) []string {
+	if n.minItems != nil && len(arr) < *n.minItems {
+		errs = append(errs, fmt.Sprintf("%s: must have at least %d items", path, *n.minItems))
+	}
+	if n.maxItems != nil && len(arr) > *n.maxItems {
+		errs = append(errs, fmt.Sprintf("%s: must have at most %d items", path, *n.maxItems))
+	}
+	if n.items != nil {
+		for i, item := range arr {
+			errs = n.items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs, st)
+		}
+	}
+	return errs
+}
+
+func (n *node) validateString(path, s string, errs []string) []string {
+	length := len([]rune(s))
+	if n.minLength != nil && length < *n.minLength {
+		errs = append(errs, fmt.Sprintf("%s: must be at least %d characters", path, *n.minLength))
+	}
+	if n.maxLength != nil && length > *n.maxLength {
+		errs = append(errs, fmt.Sprintf("%s: must be at most %d characters", path, *n.maxLength))
+	}
+	if n.pattern != nil && !n.pattern.MatchString(s) {
+		errs = append(errs, fmt.Sprintf("%s: doesn't match the pattern %q", path, n.pattern.String()))
+	}
+	return errs
+}
+
+func (n *node) validateNumber(path string, number json.Number, errs []string) []string {
+	f, err := number.Float64()
+	if err != nil {
+		return append(errs, fmt.Sprintf("%s: invalid number %s", path, number))
+	}
+	if n.minimum != nil && f < *n.minimum {
+		errs = append(errs, fmt.Sprintf("%s: must be >= %v", path, *n.minimum))
+	}
+	if n.maximum != nil && f > *n.maximum {
+		errs = append(errs, fmt.Sprintf("%s: must be <= %v", path, *n.maximum))
+	}
+	if n.exclusiveMinimum != nil && f <= *n.exclusiveMinimum {
+		errs = append(errs, fmt.Sprintf("%s: must be > %v", path, *n.exclusiveMinimum))
+	}
+	if n.exclusiveMaximum != nil && f >= *n.exclusiveMaximum {
+		errs = append(errs, fmt.Sprintf("%s: must be < %v", path, *n.exclusiveMaximum))
+	}
+	if n.multipleOf != nil {
+		if q := f / *n.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+			errs = append(errs, fmt.Sprintf("%s: must be a multiple of %v", path, *n.multipleOf))
+		}
+	}
+	return errs
+}
+
+// countMatches returns the number of schemas that the value is valid for
+func countMatches(schemas []*node, path string, v any, st *state) int {
+	matches := 0
+	for _, sub := range schemas {
+		if len(sub.validate(path, v, nil, st)) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// hasType returns true when the value has one of the types, an integer is also a number
+func (n *node) hasType(v any) bool {
+	actual := typeOf(v)
+	for _, t := range n.types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON schema type of a decoded value
+func typeOf(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if f, err := value.Float64(); err == nil && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// equal compares two decoded JSON values, the numbers by value
+func equal(a, b any) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, ok := y[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func containsValue(values []any, v any) bool {
+	for _, value := range values {
+		if equal(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePointer returns the value at a local $ref, like "#" or "#/$defs/step"
+func resolvePointer(root any, ref string) (any, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q, only local references are supported", ref)
+	}
+
+	v := root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch container := v.(type) {
+		case map[string]any:
+			next, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("unresolved $ref %q", ref)
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(container) {
+				return nil, fmt.Errorf("unresolved $ref %q", ref)
+			}
+			v = container[i]
+		default:
+			return nil, fmt.Errorf("unresolved $ref %q", ref)
+		}
+	}
+	return v, nil
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func intKeyword(schema map[string]any, keyword string) *int {
+	number, ok := schema[keyword].(json.Number)
+	if !ok {
+		return nil
+	}
+	i, err := number.Int64()
+	if err != nil {
+		return nil
+	}
+	n := int(i)
+	return &n
+}
+
+func numberKeyword(schema map[string]any, keyword string) *float64 {
+	number, ok := schema[keyword].(json.Number)
+	if !ok {
+		return nil
+	}
+	f, err := number.Float64()
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// shortJSON encodes a value for the error messages, truncated to 40 characters
+func shortJSON(v any) string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if len(encoded) > 40 {
+		return string(encoded[:37]) + "..."
+	}
+	return string(encoded)
+}
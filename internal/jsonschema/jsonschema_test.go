@@ -0,0 +1,157 @@
+package jsonschema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const recipeSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "maxLength": 20},
+		"servings": {"type": "integer", "minimum": 1, "maximum": 12},
+		"rating": {"type": ["number", "null"], "exclusiveMaximum": 5},
+		"difficulty": {"enum": ["easy", "medium", "hard"]},
+		"kind": {"const": "recipe"},
+		"code": {"type": "string", "pattern": "^[A-Z]{3}-\\d+$"},
+		"steps": {"type": "array", "items": {"$ref": "#/$defs/step"}, "minItems": 1},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+	},
+	"required": ["name", "servings", "steps"],
+	"additionalProperties": false,
+	"$defs": {
+		"step": {
+			"type": "object",
+			"properties": {
+				"text": {"type": "string"},
+				"substeps": {"type": "array", "items": {"$ref": "#/$defs/step"}}
+			},
+			"required": ["text"]
+		}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	s, err := Compile([]byte(recipeSchema))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		doc      string
+		expected []string
+	}{
+		{
+			name: "valid",
+			doc: `{"name": "soup", "servings": 4.0, "rating": 4.5, "difficulty": "easy", "kind": "recipe", "code": "SOU-1",
+				"steps": [{"text": "boil", "substeps": [{"text": "add salt"}]}], "tags": ["hot"]}`,
+		},
+		{
+			name: "wrong types",
+			doc:  `{"name": 1, "servings": "4", "rating": "good", "steps": {}}`,
+			expected: []string{
+				"$.name: expected string, got integer",
+				"$.rating: expected number or null, got string",
+				"$.servings: expected integer, got string",
+				"$.steps: expected array, got object",
+			},
+		},
+		{
+			name:     "null is allowed",
+			doc:      `{"name": "soup", "servings": 1, "rating": null, "steps": [{"text": "boil"}]}`,
+			expected: nil,
+		},
+		{
+			name: "missing and extra properties",
+			doc:  `{"name": "soup", "calories": 100}`,
+			expected: []string{
+				`$: missing required property "servings"`,
+				`$: missing required property "steps"`,
+				`$: unexpected property "calories"`,
+			},
+		},
+		{
+			name: "limits",
+			doc: `{"name": "", "servings": 13, "rating": 5, "difficulty": "extreme", "kind": "other", "code": "abc",
+				"steps": [], "tags": ["a", "b", "c"]}`,
+			expected: []string{
+				"$.code: doesn't match the pattern \"^[A-Z]{3}-\\\\d+$\"",
+				`$.difficulty: "extreme" is not one of the allowed values`,
+				`$.kind: must be "recipe"`,
+				"$.name: must be at least 1 characters",
+				"$.rating: must be < 5",
+				"$.servings: must be <= 12",
+				"$.steps: must have at least 1 items",
+				"$.tags: must have at most 2 items",
+			},
+		},
+		{
+			name:     "recursive ref",
+			doc:      `{"name": "soup", "servings": 1, "steps": [{"text": "boil", "substeps": [{"note": "x"}]}]}`,
+			expected: []string{`$.steps[0].substeps[0]: missing required property "text"`},
+		},
+		{
+			name:     "not json",
+			doc:      `{"name": `,
+			expected: []string{"invalid JSON: unexpected EOF"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, s.Validate([]byte(tt.doc)))
+		})
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	t.Parallel()
+	s, err := Compile([]byte(`{
+		"anyOf": [{"type": "string"}, {"type": "integer"}],
+		"oneOf": [{"type": "integer"}, {"multipleOf": 2}],
+		"allOf": [{"not": {"const": 3}}]
+	}`))
+	require.NoError(t, err)
+
+	assert.Empty(t, s.Validate([]byte(`"text"`)), "the string matches the multipleOf schema only")
+	assert.Empty(t, s.Validate([]byte(`5`)))
+	assert.Equal(t, []string{"$: must match exactly one of the oneOf schemas, matches 2"}, s.Validate([]byte(`4`)))
+	assert.Equal(t, []string{"$: must not match the not schema"}, s.Validate([]byte(`3`)))
+	assert.Equal(t, []string{
+		"$: doesn't match any of the anyOf schemas",
+		"$: must match exactly one of the oneOf schemas, matches 0",
+	}, s.Validate([]byte(`1.5`)))
+
+	never, err := Compile([]byte(`false`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"$: no value is allowed"}, never.Validate([]byte(`{}`)))
+}
+
+func TestCircularSchema(t *testing.T) {
+	t.Parallel()
+	s, err := Compile([]byte(`{"anyOf": [{"$ref": "#"}, {"$ref": "#"}]}`))
+	require.NoError(t, err)
+	assert.Contains(t, s.Validate([]byte(`{}`)), "$: doesn't match any of the anyOf schemas")
+}
+
+func TestCompileErrors(t *testing.T) {
+	t.Parallel()
+	invalid := map[string]string{
+		"not json":        `{`,
+		"not an object":   `"string"`,
+		"unknown type":    `{"type": "date"}`,
+		"bad pattern":     `{"pattern": "("}`,
+		"remote ref":      `{"$ref": "https://example.com/schema.json"}`,
+		"unresolved ref":  `{"$ref": "#/$defs/missing"}`,
+		"bad multipleOf":  `{"multipleOf": 0}`,
+		"bad nested item": `{"items": 1}`,
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := Compile([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
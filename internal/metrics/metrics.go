@@ -0,0 +1,286 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "llm_proxy"
+
+// Registry holds all of the collectors for this app. A dedicated registry is used instead of the
+// prometheus default, so the /metrics output only contains metrics owned by this proxy.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// RequestsTotal counts completed flows by upstream host, requested model, and response status
+	RequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Number of completed proxy requests, by host, model, and response status code.",
+		},
+		[]string{"host", "model", "status"},
+	)
+
+	// UpstreamLatency tracks the full duration of each flow, in seconds
+	UpstreamLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upstream_latency_seconds",
+			Help:      "Time from receiving the request headers until the response was sent to the client.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+		},
+		[]string{"host", "model"},
+	)
+
+	// CacheLookupsTotal counts the cache status (HIT, MISS, SKIP) of each request in cache mode
+	CacheLookupsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_lookups_total",
+			Help:      "Number of response cache lookups, by cache status.",
+		},
+		[]string{"status"},
+	)
+
+	// TokensTotal counts the tokens reported by the upstream API, split into input and output
+	TokensTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tokens_total",
+			Help:      "Number of tokens reported by the upstream API, by model and direction (input, output).",
+		},
+		[]string{"model", "direction"},
+	)
+
+	// CostTotal is the estimated spend, as calculated by a CostCounter
+	CostTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cost_total",
+			Help:      "Estimated API spend, from the tokens reported by the upstream API, by model and currency.",
+		},
+		[]string{"model", "currency"},
+	)
+
+	// LogWriteFailuresTotal counts errors returned by each traffic log destination
+	LogWriteFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_write_failures_total",
+			Help:      "Number of traffic log records that could not be written, by log destination.",
+		},
+		[]string{"destination"},
+	)
+
+	// LogRecordsSampledOutTotal counts flows that were not logged because of the log sampling rules
+	LogRecordsSampledOutTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_records_sampled_out_total",
+			Help:      "Number of traffic log records skipped by the log sampling rules, by rule name.",
+		},
+		[]string{"rule"},
+	)
+
+	// UpstreamAttemptsTotal counts each request sent by the upstream router, by pool, target, and
+	// result (the status code, or "error" for connection errors)
+	UpstreamAttemptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_attempts_total",
+			Help:      "Number of requests sent by the upstream router, by pool, target, and result.",
+		},
+		[]string{"pool", "target", "result"},
+	)
+
+	// UpstreamHealthy is 1 when an upstream target is used by the router, and 0 while it's skipped
+	// after too many failures
+	UpstreamHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "upstream_healthy",
+			Help:      "Health of each upstream router target, 1 when healthy and 0 when skipped after failures.",
+		},
+		[]string{"pool", "target"},
+	)
+
+	// RetryAttemptsTotal counts each request sent by the retry addon, by host and result (the status
+	// code, or "error" for connection errors)
+	RetryAttemptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retry_attempts_total",
+			Help:      "Number of requests sent by the retry addon, by host and result.",
+		},
+		[]string{"host", "result"},
+	)
+
+	// ProxyAuthRejectedTotal counts the clients and requests rejected by the proxy auth, by status
+	// code (403 for the client allowlist, 407 for missing or wrong credentials)
+	ProxyAuthRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_auth_rejected_total",
+			Help:      "Number of clients and requests rejected by the proxy auth, by status code.",
+		},
+		[]string{"code"},
+	)
+
+	// RateLimitedTotal counts the requests that were over a rate limit, by limit name and action
+	// (queued or rejected)
+	RateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_total",
+			Help:      "Number of requests over a rate limit, by limit and action (queued or rejected).",
+		},
+		[]string{"limit", "action"},
+	)
+
+	// RateLimitQueueSeconds tracks how long queued requests waited for a rate limit, in seconds
+	RateLimitQueueSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "rate_limit_queue_seconds",
+			Help:      "Time queued requests waited for a rate limit, in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"limit"},
+	)
+
+	// UpstreamRateLimit is the rate limit reported by the upstream API in the x-ratelimit-limit-*
+	// response headers, by API key hash, model, and type (requests or tokens)
+	UpstreamRateLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "upstream_rate_limit",
+			Help:      "Rate limit reported by the upstream API, by API key hash, model, and type (requests or tokens).",
+		},
+		[]string{"api_key", "model", "type"},
+	)
+
+	// UpstreamRateLimitRemaining is the remaining capacity reported by the upstream API in the
+	// x-ratelimit-remaining-* response headers, by API key hash, model, and type
+	UpstreamRateLimitRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "upstream_rate_limit_remaining",
+			Help:      "Remaining rate limit reported by the upstream API, by API key hash, model, and type (requests or tokens).",
+		},
+		[]string{"api_key", "model", "type"},
+	)
+
+	// PolicyDecisionsTotal counts the policy decisions, by action (allow or deny) and the name of the
+	// matching rule, which is empty when the default action applied
+	PolicyDecisionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "policy_decisions_total",
+			Help:      "Number of requests allowed or denied by the policy, by action and rule.",
+		},
+		[]string{"action", "rule"},
+	)
+
+	// DLPFindingsTotal counts the secrets found in the requests, by detector and action (block or
+	// redact)
+	DLPFindingsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dlp_findings_total",
+			Help:      "Number of secrets found in the requests by DLP, by detector and action (block or redact).",
+		},
+		[]string{"detector", "action"},
+	)
+
+	// PromptGuardFlaggedTotal counts the requests flagged by the prompt guard, by category and
+	// action (flagged in monitor mode, or blocked in enforce mode)
+	PromptGuardFlaggedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "prompt_guard_flagged_total",
+			Help:      "Number of requests flagged by the prompt guard, by category and action (flagged or blocked).",
+		},
+		[]string{"category", "action"},
+	)
+
+	// ModerationActionsTotal counts the moderated requests and responses, by stage (request or
+	// response) and action (allow, annotate, or block)
+	ModerationActionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "moderation_actions_total",
+			Help:      "Number of moderated requests and responses, by stage and action (allow, annotate, or block).",
+		},
+		[]string{"stage", "action"},
+	)
+
+	// SchemaValidationsTotal counts the responses validated against the schemas in the request, by
+	// result (valid or invalid) and whether they were retried
+	SchemaValidationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "schema_validations_total",
+			Help:      "Number of responses validated against the requested schemas, by result and whether they were retried.",
+		},
+		[]string{"result", "retried"},
+	)
+
+	// LimitViolationsTotal counts the requests and responses rejected for being over a size limit, by
+	// the limit: request_body_size, response_body_size, messages, or tokens
+	LimitViolationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "limit_violations_total",
+			Help:      "Number of requests and responses rejected for being over a size limit, by limit.",
+		},
+		[]string{"limit"},
+	)
+
+	// InFlightFlows is the number of flows currently held open by each addon's waitgroup
+	InFlightFlows = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "inflight_flows",
+			Help:      "Number of flows currently being processed, by addon.",
+		},
+		[]string{"addon"},
+	)
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RequestsTotal,
+		UpstreamLatency,
+		CacheLookupsTotal,
+		TokensTotal,
+		CostTotal,
+		LogWriteFailuresTotal,
+		LogRecordsSampledOutTotal,
+		UpstreamAttemptsTotal,
+		UpstreamHealthy,
+		RetryAttemptsTotal,
+		ProxyAuthRejectedTotal,
+		RateLimitedTotal,
+		RateLimitQueueSeconds,
+		UpstreamRateLimit,
+		UpstreamRateLimitRemaining,
+		PolicyDecisionsTotal,
+		DLPFindingsTotal,
+		PromptGuardFlaggedTotal,
+		ModerationActionsTotal,
+		SchemaValidationsTotal,
+		LimitViolationsTotal,
+		InFlightFlows,
+	)
+}
+
+// Handler returns an http.Handler that serves all metrics in the prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
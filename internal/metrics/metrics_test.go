@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	RequestsTotal.WithLabelValues("api.openai.com", "gpt-4o", "200").Inc()
+	CacheLookupsTotal.WithLabelValues("HIT").Inc()
+
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `llm_proxy_requests_total{host="api.openai.com",model="gpt-4o",status="200"}`)
+	assert.Contains(t, string(body), `llm_proxy_cache_lookups_total{status="HIT"}`)
+	assert.Contains(t, string(body), "go_goroutines")
+}
@@ -0,0 +1,219 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// backend types
+const (
+	BackendOpenAI   = "openai"   // the OpenAI /v1/moderations API, or a compatible endpoint
+	BackendWebhook  = "webhook"  // a custom endpoint that responds in the OpenAI format
+	BackendKeywords = "keywords" // a local keyword list, for each category
+)
+
+const (
+	defaultOpenAIModerationURL   = "https://api.openai.com/v1/moderations"
+	defaultOpenAIModerationModel = "omni-moderation-latest"
+	defaultOpenAIAPIKeyEnv       = "OPENAI_API_KEY"
+	defaultBackendTimeout        = 5 * time.Second
+
+	// maxBackendResponseSize limits how much of the backend response is read
+	maxBackendResponseSize = 1024 * 1024
+)
+
+// BackendConfig selects and configures the moderation backend
+type BackendConfig struct {
+	Type string `json:"type"` // openai, webhook, or keywords
+
+	// openai and webhook
+	URL     string            `json:"url,omitempty"`     // default for openai: https://api.openai.com/v1/moderations
+	Headers map[string]string `json:"headers,omitempty"` // extra request headers
+	Timeout string            `json:"timeout,omitempty"` // like "2s", default 5s
+
+	// openai only
+	Model     string `json:"model,omitempty"`       // default: omni-moderation-latest
+	APIKeyEnv string `json:"api_key_env,omitempty"` // environment variable with the API key, default: OPENAI_API_KEY
+
+	// keywords only: category -> words or phrases, matched case-insensitively on word boundaries
+	Keywords map[string][]string `json:"keywords,omitempty"`
+}
+
+// backend returns the category scores for each text, between 0 and 1
+type backend interface {
+	moderate(ctx context.Context, stage Stage, texts []string) ([]map[string]float64, error)
+	String() string
+}
+
+func newBackend(cfg BackendConfig) (backend, error) {
+	switch cfg.Type {
+	case BackendOpenAI:
+		if cfg.URL == "" {
+			cfg.URL = defaultOpenAIModerationURL
+		}
+		if cfg.Model == "" {
+			cfg.Model = defaultOpenAIModerationModel
+		}
+		if cfg.APIKeyEnv == "" {
+			cfg.APIKeyEnv = defaultOpenAIAPIKeyEnv
+		}
+		return newHTTPBackend(cfg)
+	case BackendWebhook:
+		if cfg.URL == "" {
+			return nil, errors.New("url is required for the webhook backend")
+		}
+		return newHTTPBackend(cfg)
+	case BackendKeywords:
+		return newKeywordsBackend(cfg.Keywords)
+	case "":
+		return nil, errors.New("type is required")
+	default:
+		return nil, fmt.Errorf("unknown type %q, must be openai, webhook, or keywords", cfg.Type)
+	}
+}
+
+// httpBackend sends the texts to the OpenAI moderations API, or a webhook with the same response
+// format. The webhook requests also have the stage, so it can use different policies for the
+// requests and responses.
+type httpBackend struct {
+	cfg    BackendConfig
+	client *http.Client
+}
+
+type moderationRequest struct {
+	Model string   `json:"model,omitempty"`
+	Stage Stage    `json:"stage,omitempty"` // webhook only
+	Input []string `json:"input"`
+}
+
+type moderationResponse struct {
+	Results []struct {
+		CategoryScores map[string]float64 `json:"category_scores"`
+	} `json:"results"`
+}
+
+func newHTTPBackend(cfg BackendConfig) (*httpBackend, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q, must be an http(s) URL", cfg.URL)
+	}
+	timeout, err := parseTimeout(cfg.Timeout, defaultBackendTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &httpBackend{cfg: cfg, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (b *httpBackend) moderate(ctx context.Context, stage Stage, texts []string) ([]map[string]float64, error) {
+	in := moderationRequest{Input: texts}
+	if b.cfg.Type == BackendOpenAI {
+		in.Model = b.cfg.Model
+	} else {
+		in.Stage = stage
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if b.cfg.APIKeyEnv != "" {
+		if apiKey := os.Getenv(b.cfg.APIKeyEnv); apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+	}
+	for name, value := range b.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the moderation backend: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the moderation backend responded with %s", resp.Status)
+	}
+
+	var out moderationResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBackendResponseSize)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("unable to parse the moderation response: %w", err)
+	}
+	if len(out.Results) != len(texts) {
+		return nil, errors.New("the moderation backend must respond with one result for each input")
+	}
+
+	results := make([]map[string]float64, 0, len(out.Results))
+	for _, result := range out.Results {
+		results = append(results, result.CategoryScores)
+	}
+	return results, nil
+}
+
+func (b *httpBackend) String() string {
+	return b.cfg.Type
+}
+
+// keywordsBackend scores a category 1 when the text has one of its keywords, and 0 otherwise
+type keywordsBackend struct {
+	categories []string
+	patterns   map[string]*regexp.Regexp
+}
+
+func newKeywordsBackend(keywords map[string][]string) (*keywordsBackend, error) {
+	if len(keywords) == 0 {
+		return nil, errors.New("keywords are required for the keywords backend")
+	}
+
+	b := &keywordsBackend{patterns: make(map[string]*regexp.Regexp)}
+	for _, category := range slices.Sorted(maps.Keys(keywords)) {
+		quoted := make([]string, 0, len(keywords[category]))
+		for _, keyword := range keywords[category] {
+			if keyword = strings.TrimSpace(keyword); keyword != "" {
+				quoted = append(quoted, regexp.QuoteMeta(keyword))
+			}
+		}
+		if len(quoted) == 0 {
+			return nil, fmt.Errorf("%s: at least one keyword is required", category)
+		}
+		b.categories = append(b.categories, category)
+		b.patterns[category] = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return b, nil
+}
+
+func (b *keywordsBackend) moderate(_ context.Context, _ Stage, texts []string) ([]map[string]float64, error) {
+	results := make([]map[string]float64, 0, len(texts))
+	for _, text := range texts {
+		scores := make(map[string]float64, len(b.categories))
+		for _, category := range b.categories {
+			if b.patterns[category].MatchString(text) {
+				scores[category] = 1
+			} else {
+				scores[category] = 0
+			}
+		}
+		results = append(results, scores)
+	}
+	return results, nil
+}
+
+func (b *keywordsBackend) String() string {
+	return BackendKeywords
+}
@@ -0,0 +1,213 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// Stage is the part of the flow that is moderated
+type Stage string
+
+const (
+	StageRequest  Stage = "request"  // the chat messages, before the request is sent upstream
+	StageResponse Stage = "response" // the generated text, before the response is sent to the client
+)
+
+// Action is what happens to the moderated request or response
+type Action string
+
+const (
+	ActionAllow    Action = "allow"    // no category is over a threshold
+	ActionAnnotate Action = "annotate" // tag the traffic log and the response headers
+	ActionBlock    Action = "block"    // reject with an error response
+)
+
+// actionRank orders the actions, so the strictest action of all categories is used
+var actionRank = map[Action]int{ActionAllow: 0, ActionAnnotate: 1, ActionBlock: 2}
+
+// AnyCategory is the thresholds key for the categories that aren't listed
+const AnyCategory = "*"
+
+const defaultAnnotateThreshold = 0.5
+
+// Threshold is the category score from which a request or response is annotated or blocked. A
+// threshold of zero is disabled.
+type Threshold struct {
+	Annotate float64 `json:"annotate,omitempty"`
+	Block    float64 `json:"block,omitempty"`
+}
+
+// Config is the moderation config, usually loaded from a JSON file
+type Config struct {
+	Backend BackendConfig `json:"backend"`
+
+	// Thresholds for each category name of the backend, and "*" for the other categories. Default:
+	// every category with a score of 0.5 or more is annotated.
+	Thresholds map[string]Threshold `json:"thresholds,omitempty"`
+
+	// Roles of the request messages that are moderated, default: user
+	Roles []string `json:"roles,omitempty"`
+
+	// Responses enables the moderation of the generated text, after the upstream responds
+	Responses bool `json:"responses,omitempty"`
+
+	// OnError is the action when the backend fails: "allow" (default) or "block"
+	OnError Action `json:"on_error,omitempty"`
+}
+
+// Verdict is the moderation result of a request or response
+type Verdict struct {
+	Action     Action             `json:"action"`
+	Categories []string           `json:"categories,omitempty"` // categories over a threshold, sorted
+	Scores     map[string]float64 `json:"scores,omitempty"`     // highest score of each category, over all texts
+	Error      string             `json:"error,omitempty"`      // the backend failed, the action is on_error
+}
+
+// Moderator sends texts to a moderation backend, and decides what to do with them
+type Moderator struct {
+	backend    backend
+	thresholds map[string]Threshold
+	roles      []string
+	responses  bool
+	onError    Action
+}
+
+// New creates a Moderator from the config, and returns an error if the config is invalid
+func New(cfg Config) (*Moderator, error) {
+	m := &Moderator{
+		thresholds: cfg.Thresholds,
+		roles:      cfg.Roles,
+		responses:  cfg.Responses,
+		onError:    cfg.OnError,
+	}
+	if len(m.thresholds) == 0 {
+		m.thresholds = map[string]Threshold{AnyCategory: {Annotate: defaultAnnotateThreshold}}
+	}
+	if len(m.roles) == 0 {
+		m.roles = []string{"user"}
+	}
+	if m.onError == "" {
+		m.onError = ActionAllow
+	}
+
+	errs := make([]error, 0)
+	backend, err := newBackend(cfg.Backend)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("backend: %w", err))
+	}
+	m.backend = backend
+
+	for _, category := range slices.Sorted(maps.Keys(m.thresholds)) {
+		t := m.thresholds[category]
+		if t.Annotate < 0 || t.Annotate > 1 || t.Block < 0 || t.Block > 1 {
+			errs = append(errs, fmt.Errorf("thresholds: %s: thresholds must be between 0 and 1", category))
+		}
+	}
+	if m.onError != ActionAllow && m.onError != ActionBlock {
+		errs = append(errs, fmt.Errorf("on_error must be allow or block, got %q", cfg.OnError))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LoadFile reads a JSON moderation config file, and creates a Moderator
+func LoadFile(fileName string) (*Moderator, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read moderation file: %w", err)
+	}
+
+	cfg := Config{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse moderation file %s: %w", fileName, err)
+	}
+
+	m, err := New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid moderation file %s: %w", fileName, err)
+	}
+	return m, nil
+}
+
+// Roles returns the roles of the request messages that are moderated
+func (m *Moderator) Roles() []string {
+	return m.roles
+}
+
+// Responses returns true when the generated text is moderated too
+func (m *Moderator) Responses() bool {
+	return m.responses
+}
+
+// Backend returns the backend type, for the logs
+func (m *Moderator) Backend() string {
+	return m.backend.String()
+}
+
+// Moderate sends the texts to the backend, and returns the verdict with the strictest action of
+// all categories
+func (m *Moderator) Moderate(ctx context.Context, stage Stage, texts []string) Verdict {
+	results, err := m.backend.moderate(ctx, stage, texts)
+	if err != nil {
+		return Verdict{Action: m.onError, Error: err.Error()}
+	}
+
+	verdict := Verdict{Action: ActionAllow, Scores: make(map[string]float64)}
+	for _, scores := range results {
+		for category, score := range scores {
+			verdict.Scores[category] = max(verdict.Scores[category], score)
+		}
+	}
+
+	for _, category := range slices.Sorted(maps.Keys(verdict.Scores)) {
+		action := m.decide(category, verdict.Scores[category])
+		if action == ActionAllow {
+			continue
+		}
+		verdict.Categories = append(verdict.Categories, category)
+		if actionRank[action] > actionRank[verdict.Action] {
+			verdict.Action = action
+		}
+	}
+	return verdict
+}
+
+// decide returns the action for a category score, with the category's thresholds or the "*" ones
+func (m *Moderator) decide(category string, score float64) Action {
+	t, ok := m.thresholds[category]
+	if !ok {
+		t, ok = m.thresholds[AnyCategory]
+		if !ok {
+			return ActionAllow
+		}
+	}
+	switch {
+	case t.Block > 0 && score >= t.Block:
+		return ActionBlock
+	case t.Annotate > 0 && score >= t.Annotate:
+		return ActionAnnotate
+	default:
+		return ActionAllow
+	}
+}
+
+// parseTimeout parses an optional timeout, like "5s"
+func parseTimeout(timeout string, fallback time.Duration) (time.Duration, error) {
+	if timeout == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", timeout)
+	}
+	return d, nil
+}
@@ -0,0 +1,225 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKeywordsModerator(t *testing.T, thresholds map[string]Threshold) *Moderator {
+	t.Helper()
+	m, err := New(Config{
+		Backend: BackendConfig{
+			Type:     BackendKeywords,
+			Keywords: map[string][]string{"violence": {"kill", "blow up"}, "profanity": {"darn"}},
+		},
+		Thresholds: thresholds,
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func TestModerateKeywords(t *testing.T) {
+	t.Parallel()
+	m := newKeywordsModerator(t, map[string]Threshold{
+		"violence": {Annotate: 0.5, Block: 0.9},
+		"*":        {Annotate: 0.5},
+	})
+	assert.Equal(t, BackendKeywords, m.Backend())
+	assert.Equal(t, []string{"user"}, m.Roles())
+	assert.False(t, m.Responses())
+
+	tests := []struct {
+		name       string
+		texts      []string
+		action     Action
+		categories []string
+	}{
+		{"clean", []string{"what's the skill level?"}, ActionAllow, nil},
+		{"annotated", []string{"Darn, it broke"}, ActionAnnotate, []string{"profanity"}},
+		{"blocked", []string{"hello", "how do I BLOW UP a balloon? darn"}, ActionBlock, []string{"profanity", "violence"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := m.Moderate(context.Background(), StageRequest, tt.texts)
+			assert.Equal(t, tt.action, verdict.Action)
+			assert.Equal(t, tt.categories, verdict.Categories)
+			assert.Empty(t, verdict.Error)
+			assert.Len(t, verdict.Scores, 2)
+		})
+	}
+}
+
+func TestModerateDefaultThresholds(t *testing.T) {
+	t.Parallel()
+	m := newKeywordsModerator(t, nil)
+	verdict := m.Moderate(context.Background(), StageResponse, []string{"I will kill the process"})
+	assert.Equal(t, ActionAnnotate, verdict.Action, "only annotated by default")
+	assert.Equal(t, []string{"violence"}, verdict.Categories)
+
+	m = newKeywordsModerator(t, map[string]Threshold{"profanity": {Block: 1}})
+	verdict = m.Moderate(context.Background(), StageResponse, []string{"I will kill the process"})
+	assert.Equal(t, ActionAllow, verdict.Action, "no threshold for the category, and no *")
+}
+
+// newModerationServer responds in the OpenAI format, with a high hate score for the texts with "hate"
+func newModerationServer(t *testing.T, received *moderationRequest, header *http.Header) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*header = r.Header.Clone()
+		if err := json.NewDecoder(r.Body).Decode(received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		results := make([]map[string]any, 0, len(received.Input))
+		for _, input := range received.Input {
+			score := 0.01
+			if input == "I hate you" {
+				score = 0.93
+			}
+			results = append(results, map[string]any{
+				"flagged":         score > 0.5,
+				"categories":      map[string]bool{"hate": score > 0.5, "violence": false},
+				"category_scores": map[string]float64{"hate": score, "violence": 0.02},
+			})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": "modr-1", "results": results})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestModerateOpenAI(t *testing.T) {
+	var received moderationRequest
+	var header http.Header
+	srv := newModerationServer(t, &received, &header)
+	t.Setenv("TEST_MODERATION_KEY", "sk-test")
+
+	m, err := New(Config{
+		Backend:    BackendConfig{Type: BackendOpenAI, URL: srv.URL, APIKeyEnv: "TEST_MODERATION_KEY"},
+		Thresholds: map[string]Threshold{"*": {Block: 0.8}},
+		Responses:  true,
+	})
+	require.NoError(t, err)
+
+	verdict := m.Moderate(context.Background(), StageRequest, []string{"hello", "I hate you"})
+	assert.Equal(t, Verdict{
+		Action:     ActionBlock,
+		Categories: []string{"hate"},
+		Scores:     map[string]float64{"hate": 0.93, "violence": 0.02},
+	}, verdict)
+	assert.Equal(t, moderationRequest{Model: defaultOpenAIModerationModel, Input: []string{"hello", "I hate you"}}, received)
+	assert.Equal(t, "Bearer sk-test", header.Get("Authorization"))
+}
+
+func TestModerateWebhook(t *testing.T) {
+	var received moderationRequest
+	var header http.Header
+	srv := newModerationServer(t, &received, &header)
+
+	m, err := New(Config{
+		Backend: BackendConfig{Type: BackendWebhook, URL: srv.URL, Headers: map[string]string{"X-Team": "bots"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, BackendWebhook, m.Backend())
+
+	verdict := m.Moderate(context.Background(), StageResponse, []string{"I hate you"})
+	assert.Equal(t, ActionAnnotate, verdict.Action)
+	assert.Equal(t, moderationRequest{Stage: StageResponse, Input: []string{"I hate you"}}, received)
+	assert.Equal(t, "bots", header.Get("X-Team"))
+	assert.Empty(t, header.Get("Authorization"))
+}
+
+func TestModerateBackendError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(srv.Close)
+
+	for _, onError := range []Action{"", ActionBlock} {
+		m, err := New(Config{Backend: BackendConfig{Type: BackendWebhook, URL: srv.URL}, OnError: onError})
+		require.NoError(t, err)
+
+		verdict := m.Moderate(context.Background(), StageRequest, []string{"hello"})
+		assert.Contains(t, verdict.Error, "429")
+		if onError == "" {
+			assert.Equal(t, ActionAllow, verdict.Action, "fail open by default")
+		} else {
+			assert.Equal(t, ActionBlock, verdict.Action)
+		}
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		cfg  Config
+		err  string
+	}{
+		{"no backend", Config{}, "backend: type is required"},
+		{"unknown backend", Config{Backend: BackendConfig{Type: "azure"}}, `unknown type "azure"`},
+		{"webhook without url", Config{Backend: BackendConfig{Type: BackendWebhook}}, "url is required"},
+		{"invalid url", Config{Backend: BackendConfig{Type: BackendOpenAI, URL: "api.openai.com"}}, "invalid url"},
+		{"invalid timeout", Config{Backend: BackendConfig{Type: BackendOpenAI, Timeout: "soon"}}, "invalid timeout"},
+		{"no keywords", Config{Backend: BackendConfig{Type: BackendKeywords}}, "keywords are required"},
+		{
+			"empty keyword list",
+			Config{Backend: BackendConfig{Type: BackendKeywords, Keywords: map[string][]string{"hate": {" "}}}},
+			"hate: at least one keyword is required",
+		},
+		{
+			"threshold out of range",
+			Config{
+				Backend:    BackendConfig{Type: BackendOpenAI},
+				Thresholds: map[string]Threshold{"hate": {Block: 90}},
+			},
+			"hate: thresholds must be between 0 and 1",
+		},
+		{"invalid on_error", Config{Backend: BackendConfig{Type: BackendOpenAI}, OnError: ActionAnnotate}, "on_error must be allow or block"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	fileName := filepath.Join(dir, "moderation.json")
+	err := os.WriteFile(fileName, []byte(`{
+		"backend": {"type": "keywords", "keywords": {"violence": ["kill"]}},
+		"thresholds": {"violence": {"block": 1}},
+		"roles": ["user", "tool"],
+		"responses": true,
+		"on_error": "block"
+	}`), 0o600)
+	require.NoError(t, err)
+
+	m, err := LoadFile(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user", "tool"}, m.Roles())
+	assert.True(t, m.Responses())
+	assert.Equal(t, ActionBlock, m.onError)
+
+	_, err = LoadFile(filepath.Join(dir, "missing.json"))
+	assert.ErrorContains(t, err, "unable to read moderation file")
+
+	badFile := filepath.Join(dir, "bad.json")
+	require.NoError(t, os.WriteFile(badFile, []byte(`{"backend": {}}`), 0o600))
+	_, err = LoadFile(badFile)
+	assert.ErrorContains(t, err, "type is required")
+}
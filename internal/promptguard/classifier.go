@@ -0,0 +1,133 @@
+package promptguard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const (
+	defaultClassifierThreshold = 0.5
+	defaultClassifierTimeout   = 2 * time.Second
+
+	// classifierRule is the rule name of the classifier detections
+	classifierRule = "classifier"
+
+	// maxClassifierResponseSize limits how much of the classifier response is read
+	maxClassifierResponseSize = 1024 * 1024
+)
+
+// ClassifierConfig is a local classifier endpoint, e.g. a small prompt injection model served next
+// to the proxy. It receives {"inputs": ["text", ...]}, and responds with
+// {"results": [{"score": 0.98, "label": "jailbreak"}, ...]}, one result for each input.
+type ClassifierConfig struct {
+	URL       string  `json:"url"`
+	Threshold float64 `json:"threshold,omitempty"` // flag the messages with a score >= threshold, default 0.5
+	Timeout   string  `json:"timeout,omitempty"`   // like "500ms", default 2s
+}
+
+type classifierRequest struct {
+	Inputs []string `json:"inputs"`
+}
+
+type classifierResponse struct {
+	Results []struct {
+		Score float64 `json:"score"` // probability that the text is a prompt injection or jailbreak
+		Label string  `json:"label"` // optional category
+	} `json:"results"`
+}
+
+type classifier struct {
+	url       string
+	threshold float64
+	client    *http.Client
+}
+
+func newClassifier(cfg ClassifierConfig) (*classifier, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q, must be an http(s) URL", cfg.URL)
+	}
+
+	c := &classifier{
+		url:       cfg.URL,
+		threshold: cfg.Threshold,
+		client:    &http.Client{Timeout: defaultClassifierTimeout},
+	}
+	if c.threshold == 0 {
+		c.threshold = defaultClassifierThreshold
+	}
+	if c.threshold < 0 || c.threshold > 1 {
+		return nil, fmt.Errorf("threshold must be between 0 and 1, got %v", cfg.Threshold)
+	}
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", cfg.Timeout)
+		}
+		c.client.Timeout = timeout
+	}
+	return c, nil
+}
+
+// classify sends the messages to the classifier endpoint in one request, and returns a detection
+// for each message with a score over the threshold
+func (c *classifier) classify(ctx context.Context, messages []utils.ChatMessage) ([]Detection, error) {
+	in := classifierRequest{Inputs: make([]string, 0, len(messages))}
+	for _, msg := range messages {
+		in.Inputs = append(in.Inputs, msg.Text)
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the classifier: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the classifier responded with %s", resp.Status)
+	}
+
+	var out classifierResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxClassifierResponseSize)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("unable to parse the classifier response: %w", err)
+	}
+	if len(out.Results) != len(messages) {
+		return nil, errors.New("the classifier must respond with one result for each input")
+	}
+
+	var detections []Detection
+	for i, result := range out.Results {
+		if result.Score < c.threshold {
+			continue
+		}
+		category := result.Label
+		if category == "" {
+			category = CategoryPromptInjection
+		}
+		detections = append(detections, Detection{
+			Rule:     classifierRule,
+			Category: category,
+			Message:  messages[i].Index,
+			Role:     messages[i].Role,
+			Score:    result.Score,
+		})
+	}
+	return detections, nil
+}
@@ -0,0 +1,83 @@
+package promptguard
+
+import "regexp"
+
+// categories of the detections
+const (
+	CategoryPromptInjection = "prompt_injection"
+	CategoryJailbreak       = "jailbreak"
+)
+
+// names of the built-in heuristics, used in the rules file to select heuristics
+const (
+	HeuristicIgnoreInstructions = "ignore_instructions"
+	HeuristicRevealPrompt       = "reveal_system_prompt"
+	HeuristicChatTemplate       = "chat_template_tokens"
+	HeuristicRoleOverride       = "role_override"
+	HeuristicDAN                = "do_anything_now"
+	HeuristicUnrestrictedMode   = "unrestricted_mode"
+	HeuristicNoRestrictions     = "no_restrictions"
+)
+
+// rule is a compiled pattern for a prompt injection or jailbreak technique
+type rule struct {
+	name     string
+	category string
+	pattern  *regexp.Regexp
+}
+
+// builtinHeuristics catch the common phrasings of the known techniques. They are tuned to avoid
+// false positives on ordinary prompts, so they miss paraphrased attacks; the classifier is for those.
+var builtinHeuristics = []rule{
+	{
+		name:     HeuristicIgnoreInstructions,
+		category: CategoryPromptInjection,
+		pattern: regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget|override|bypass)\s+(?:all\s+|any\s+|of\s+)?` +
+			`(?:the\s+|your\s+|my\s+|these\s+|those\s+)?(?:previous|prior|above|earlier|preceding|original|system)\s+` +
+			`(?:instructions?|prompts?|rules|directions|directives|guidelines|messages)`),
+	},
+	{
+		name:     HeuristicRevealPrompt,
+		category: CategoryPromptInjection,
+		pattern: regexp.MustCompile(`(?i)\b(?:reveal|print|show|repeat|output|display|leak|tell\s+me)\s+(?:me\s+)?` +
+			`(?:your|the)\s+(?:full\s+|entire\s+|exact\s+)?(?:system|initial|hidden|original|secret)\s+` +
+			`(?:prompt|instructions|message)`),
+	},
+	{
+		// special tokens of chat templates, to fake a system or assistant turn inside a message
+		name:     HeuristicChatTemplate,
+		category: CategoryPromptInjection,
+		pattern:  regexp.MustCompile(`(?i)<\|(?:im_start|im_end|system|endoftext|start_header_id)\|>|\[/?(?:INST|SYS)\]|<</?SYS>>`),
+	},
+	{
+		name:     HeuristicRoleOverride,
+		category: CategoryJailbreak,
+		pattern: regexp.MustCompile(`(?i)\b(?:you\s+are\s+now|from\s+now\s+on,?\s+you\s+are|pretend\s+(?:that\s+)?you\s+are|act\s+as)\s+` +
+			`(?:an?\s+)?(?:unfiltered|uncensored|unrestricted|unaligned|evil|jailbroken)\b`),
+	},
+	{
+		name:     HeuristicDAN,
+		category: CategoryJailbreak,
+		pattern:  regexp.MustCompile(`(?i)\bdo\s+anything\s+now\b|\bDAN\s+mode\b`),
+	},
+	{
+		name:     HeuristicUnrestrictedMode,
+		category: CategoryJailbreak,
+		pattern:  regexp.MustCompile(`(?i)\b(?:enable|enter|activate|switch\s+to)\s+(?:the\s+)?(?:developer|god|jailbreak|unrestricted|debug)\s+mode\b`),
+	},
+	{
+		name:     HeuristicNoRestrictions,
+		category: CategoryJailbreak,
+		pattern: regexp.MustCompile(`(?i)\b(?:without|free\s+(?:of|from)|no\s+longer\s+bound\s+by)\s+(?:any\s+)?` +
+			`(?:ethical\s+|moral\s+|content\s+)?(?:restrictions|filters|guidelines|safety\s+guidelines|content\s+polic(?:y|ies))\b`),
+	},
+}
+
+// builtinHeuristicNames returns the names of all built-in heuristics
+func builtinHeuristicNames() []string {
+	names := make([]string, 0, len(builtinHeuristics))
+	for _, r := range builtinHeuristics {
+		names = append(names, r.name)
+	}
+	return names
+}
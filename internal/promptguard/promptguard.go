@@ -0,0 +1,174 @@
+package promptguard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+// Rule is a custom heuristic, a regex that flags the messages that match it
+type Rule struct {
+	Name     string `json:"name,omitempty"`
+	Regex    string `json:"regex"`
+	Category string `json:"category,omitempty"` // default: "prompt_injection"
+}
+
+// Config is the prompt guard config, usually loaded from a JSON file
+type Config struct {
+	// Heuristics is the list of built-in heuristics to enable. All of them are enabled when this is
+	// omitted, and none when it's an empty list.
+	Heuristics []string `json:"heuristics"`
+	Rules      []Rule   `json:"rules,omitempty"`
+
+	// Roles of the messages that are inspected, default: user and tool. System messages are written
+	// by the developer, so they're only inspected when they're listed here.
+	Roles []string `json:"roles,omitempty"`
+
+	Classifier *ClassifierConfig `json:"classifier,omitempty"` // optional local classifier endpoint
+}
+
+// Detection is a likely prompt injection or jailbreak in one of the request messages
+type Detection struct {
+	Rule     string  `json:"rule"`     // heuristic name, or "classifier"
+	Category string  `json:"category"` // "prompt_injection", "jailbreak", or the classifier label
+	Message  int     `json:"message"`  // index in the messages list
+	Role     string  `json:"role,omitempty"`
+	Score    float64 `json:"score,omitempty"` // classifier score, 0 for the heuristics
+}
+
+// defaultRoles are the roles of the messages with untrusted text: the user's input, and the results
+// of the tool calls, e.g. a web page or a document that was retrieved
+var defaultRoles = []string{"user", "tool", "function"}
+
+// Guard flags likely prompt injections and jailbreaks in the messages of chat requests
+type Guard struct {
+	rules      []rule
+	roles      []string
+	classifier *classifier
+}
+
+// New creates a Guard from the config, and returns an error if any rule is invalid
+func New(cfg Config) (*Guard, error) {
+	g := &Guard{roles: cfg.Roles}
+	if len(g.roles) == 0 {
+		g.roles = defaultRoles
+	}
+	errs := make([]error, 0)
+
+	enabled := cfg.Heuristics
+	if enabled == nil {
+		enabled = builtinHeuristicNames()
+	}
+	for _, name := range enabled {
+		i := slices.IndexFunc(builtinHeuristics, func(r rule) bool { return r.name == name })
+		if i < 0 {
+			errs = append(errs, fmt.Errorf("unknown heuristic: %s", name))
+			continue
+		}
+		g.rules = append(g.rules, builtinHeuristics[i])
+	}
+
+	for i, r := range cfg.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if r.Category == "" {
+			r.Category = CategoryPromptInjection
+		}
+		if r.Regex == "" {
+			errs = append(errs, fmt.Errorf("%s: regex is required", r.Name))
+			continue
+		}
+		pattern, err := regexp.Compile(r.Regex)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid regex: %w", r.Name, err))
+			continue
+		}
+		g.rules = append(g.rules, rule{name: r.Name, category: r.Category, pattern: pattern})
+	}
+
+	if cfg.Classifier != nil {
+		c, err := newClassifier(*cfg.Classifier)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("classifier: %w", err))
+		}
+		g.classifier = c
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// NewDefault creates a Guard with all of the built-in heuristics, and no classifier
+func NewDefault() *Guard {
+	g, err := New(Config{})
+	if err != nil {
+		// this should never happen, the built-in heuristics are static
+		panic(err)
+	}
+	return g
+}
+
+// LoadFile reads a JSON prompt guard config file, and creates a Guard
+func LoadFile(fileName string) (*Guard, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read prompt guard rules file: %w", err)
+	}
+
+	cfg := Config{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse prompt guard rules file %s: %w", fileName, err)
+	}
+
+	g, err := New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt guard rules file %s: %w", fileName, err)
+	}
+	return g, nil
+}
+
+// Scan inspects the messages of a chat request body, and returns the detections. Each heuristic is
+// reported once per message. When the classifier fails, the heuristic detections are returned
+// with the error, so a classifier outage doesn't stop the requests.
+func (g *Guard) Scan(ctx context.Context, body []byte) ([]Detection, error) {
+	messages := make([]utils.ChatMessage, 0)
+	for _, msg := range utils.ChatMessages(body) {
+		if msg.Text != "" && slices.Contains(g.roles, msg.Role) {
+			messages = append(messages, msg)
+		}
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	var detections []Detection
+	for _, msg := range messages {
+		for _, r := range g.rules {
+			if r.pattern.MatchString(msg.Text) {
+				detections = append(detections, Detection{
+					Rule: r.name, Category: r.category, Message: msg.Index, Role: msg.Role,
+				})
+			}
+		}
+	}
+
+	if g.classifier == nil {
+		return detections, nil
+	}
+	flagged, err := g.classifier.classify(ctx, messages)
+	return append(detections, flagged...), err
+}
+
+// HasClassifier returns true when the messages are also sent to a classifier endpoint
+func (g *Guard) HasClassifier() bool {
+	return g.classifier != nil
+}
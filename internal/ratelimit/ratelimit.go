@@ -0,0 +1,99 @@
+// Package ratelimit implements keyed token buckets. A bucket holds up to its capacity, and refills
+// continuously at a fixed rate. Taking more than is available puts the bucket in debt, and the
+// caller is told how long to wait before the taken amount is covered by the refill.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a single token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a set of token buckets with the same capacity and refill rate, one per key
+type Limiter struct {
+	capacity   float64
+	refillRate float64 // tokens per second
+	buckets    map[string]*bucket
+	mu         sync.Mutex
+	now        func() time.Time
+}
+
+// NewLimiter creates a limiter that allows up to perMinute per key, each minute. The bucket
+// capacity is the same as perMinute, so a key can burst up to a full minute of its limit.
+func NewLimiter(perMinute float64) *Limiter {
+	return &Limiter{
+		capacity:   perMinute,
+		refillRate: perMinute / 60,
+		buckets:    make(map[string]*bucket),
+		now:        time.Now,
+	}
+}
+
+// refill adds the tokens earned since the last update, and returns the bucket for the key
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.capacity, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = min(l.capacity, b.tokens+elapsed*l.refillRate)
+		b.last = now
+	}
+	return b
+}
+
+// Reserve takes n tokens from the bucket for the key, and returns how long the caller must wait
+// before using them. Zero means the tokens were available right away. An amount larger than the
+// capacity is capped at the capacity, so it can still be reserved.
+func (l *Limiter) Reserve(key string, n float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n = min(n, l.capacity)
+	b := l.refill(key, l.now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.refillRate * float64(time.Second))
+}
+
+// Cancel returns n tokens to the bucket for the key, for a reservation that won't be used
+func (l *Limiter) Cancel(key string, n float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n = min(n, l.capacity)
+	b := l.refill(key, l.now())
+	b.tokens = min(l.capacity, b.tokens+n)
+}
+
+// Available returns the tokens that can be taken right away for the key
+func (l *Limiter) Available(key string) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return max(0, l.refill(key, l.now()).tokens)
+}
+
+// Prune removes the buckets that are full, to keep memory bounded when there are many keys. A
+// removed bucket is recreated as full, so this doesn't change the limits.
+func (l *Limiter) Prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key := range l.buckets {
+		if l.refill(key, now).tokens >= l.capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
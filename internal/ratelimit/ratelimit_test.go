@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLimiter(perMinute float64) (*Limiter, *time.Time) {
+	now := time.Now()
+	l := NewLimiter(perMinute)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+	l, now := newTestLimiter(60) // 1 token per second
+
+	// the bucket starts full
+	for range 60 {
+		assert.Zero(t, l.Reserve("a", 1))
+	}
+	assert.Equal(t, time.Second, l.Reserve("a", 1))
+	assert.Equal(t, 2*time.Second, l.Reserve("a", 1), "debt adds up")
+	assert.Zero(t, l.Reserve("b", 1), "other keys have their own bucket")
+
+	l.Cancel("a", 2)
+	assert.Zero(t, l.Available("a"))
+
+	*now = now.Add(10 * time.Second)
+	assert.InDelta(t, 10, l.Available("a"), 0.001)
+
+	*now = now.Add(time.Hour)
+	assert.InDelta(t, 60, l.Available("a"), 0.001, "refill is capped at the capacity")
+}
+
+func TestLimiter_LargeReservation(t *testing.T) {
+	t.Parallel()
+	l, _ := newTestLimiter(60)
+
+	assert.Zero(t, l.Reserve("a", 1000), "capped at the capacity")
+	assert.Equal(t, 60*time.Second, l.Reserve("a", 1000))
+}
+
+func TestLimiter_Prune(t *testing.T) {
+	t.Parallel()
+	l, now := newTestLimiter(60)
+
+	l.Reserve("a", 1)
+	l.Reserve("b", 1)
+	*now = now.Add(time.Second)
+	l.Reserve("b", 1)
+	l.Prune()
+	assert.Len(t, l.buckets, 1)
+	assert.Contains(t, l.buckets, "b")
+}
@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OpenAI rate limit response headers, see https://platform.openai.com/docs/guides/rate-limits
+const (
+	headerLimitRequests     = "X-Ratelimit-Limit-Requests"
+	headerLimitTokens       = "X-Ratelimit-Limit-Tokens"
+	headerRemainingRequests = "X-Ratelimit-Remaining-Requests"
+	headerRemainingTokens   = "X-Ratelimit-Remaining-Tokens"
+	headerResetRequests     = "X-Ratelimit-Reset-Requests"
+	headerResetTokens       = "X-Ratelimit-Reset-Tokens"
+)
+
+// UpstreamLimits are the rate limits reported by an upstream API in its response headers. A
+// negative value means the header was missing.
+type UpstreamLimits struct {
+	LimitRequests     float64
+	LimitTokens       float64
+	RemainingRequests float64
+	RemainingTokens   float64
+	ResetRequests     time.Time // when the remaining requests are back to the limit
+	ResetTokens       time.Time // when the remaining tokens are back to the limit
+}
+
+// ParseUpstreamLimits reads the OpenAI rate limit headers of a response. The reset headers are
+// durations like "1s" or "6m0s", relative to now. False is returned when there are no remaining
+// requests or tokens headers.
+func ParseUpstreamLimits(header http.Header, now time.Time) (UpstreamLimits, bool) {
+	l := UpstreamLimits{
+		LimitRequests:     parseHeaderFloat(header, headerLimitRequests),
+		LimitTokens:       parseHeaderFloat(header, headerLimitTokens),
+		RemainingRequests: parseHeaderFloat(header, headerRemainingRequests),
+		RemainingTokens:   parseHeaderFloat(header, headerRemainingTokens),
+		ResetRequests:     parseHeaderReset(header, headerResetRequests, now),
+		ResetTokens:       parseHeaderReset(header, headerResetTokens, now),
+	}
+	return l, l.RemainingRequests >= 0 || l.RemainingTokens >= 0
+}
+
+func parseHeaderFloat(header http.Header, name string) float64 {
+	v, err := strconv.ParseFloat(header.Get(name), 64)
+	if err != nil || v < 0 {
+		return -1
+	}
+	return v
+}
+
+func parseHeaderReset(header http.Header, name string, now time.Time) time.Time {
+	value := header.Get(name)
+	if value == "" {
+		return time.Time{}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d)
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return now.Add(time.Duration(seconds * float64(time.Second)))
+	}
+	return time.Time{}
+}
+
+// UpstreamTracker holds the last rate limits reported by the upstream API for each key, e.g. an
+// API key and model, and counts down the remaining capacity for the requests sent since then
+type UpstreamTracker struct {
+	limits map[string]*UpstreamLimits
+	mu     sync.Mutex
+	now    func() time.Time
+}
+
+// NewUpstreamTracker creates an empty tracker
+func NewUpstreamTracker() *UpstreamTracker {
+	return &UpstreamTracker{
+		limits: make(map[string]*UpstreamLimits),
+		now:    time.Now,
+	}
+}
+
+// Update saves the limits from the latest response for the key
+func (t *UpstreamTracker) Update(key string, l UpstreamLimits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits[key] = &l
+}
+
+// Get returns the tracked limits for the key
+func (t *UpstreamTracker) Get(key string) (UpstreamLimits, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.limits[key]
+	if !ok {
+		return UpstreamLimits{}, false
+	}
+	return *l, true
+}
+
+// Reserve takes one request and the tokens from the remaining capacity for the key, and returns
+// zero. When the remaining requests would drop below reserveRequests, or the remaining tokens
+// below reserveTokens, nothing is taken, and the time until the limit resets is returned instead.
+// Limits that have already reset, or were never reported, don't hold back a request.
+func (t *UpstreamTracker) Reserve(key string, tokens, reserveRequests, reserveTokens float64) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.limits[key]
+	if !ok {
+		return 0
+	}
+
+	now := t.now()
+	checkRequests := l.RemainingRequests >= 0 && now.Before(l.ResetRequests)
+	checkTokens := l.RemainingTokens >= 0 && now.Before(l.ResetTokens)
+
+	var wait time.Duration
+	if checkRequests && l.RemainingRequests-1 < reserveRequests {
+		wait = l.ResetRequests.Sub(now)
+	}
+	if checkTokens && l.RemainingTokens-tokens < reserveTokens {
+		wait = max(wait, l.ResetTokens.Sub(now))
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	if checkRequests {
+		l.RemainingRequests--
+	}
+	if checkTokens {
+		l.RemainingTokens -= tokens
+	}
+	return 0
+}
+
+// Cancel gives back a request and tokens taken by Reserve, when the request isn't sent
+func (t *UpstreamTracker) Cancel(key string, tokens float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.limits[key]
+	if !ok {
+		return
+	}
+	now := t.now()
+	if l.RemainingRequests >= 0 && now.Before(l.ResetRequests) {
+		l.RemainingRequests++
+	}
+	if l.RemainingTokens >= 0 && now.Before(l.ResetTokens) {
+		l.RemainingTokens += tokens
+	}
+}
+
+// Prune removes the keys with limits that have reset
+func (t *UpstreamTracker) Prune() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for key, l := range t.limits {
+		if !now.Before(l.ResetRequests) && !now.Before(l.ResetTokens) {
+			delete(t.limits, key)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUpstreamLimits(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+
+	header := http.Header{}
+	header.Set("x-ratelimit-limit-requests", "60")
+	header.Set("x-ratelimit-limit-tokens", "150000")
+	header.Set("x-ratelimit-remaining-requests", "59")
+	header.Set("x-ratelimit-remaining-tokens", "149984")
+	header.Set("x-ratelimit-reset-requests", "1s")
+	header.Set("x-ratelimit-reset-tokens", "6m0s")
+
+	l, ok := ParseUpstreamLimits(header, now)
+	require.True(t, ok)
+	assert.Equal(t, UpstreamLimits{
+		LimitRequests:     60,
+		LimitTokens:       150000,
+		RemainingRequests: 59,
+		RemainingTokens:   149984,
+		ResetRequests:     now.Add(time.Second),
+		ResetTokens:       now.Add(6 * time.Minute),
+	}, l)
+
+	_, ok = ParseUpstreamLimits(http.Header{}, now)
+	assert.False(t, ok)
+}
+
+func TestUpstreamTracker(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	tracker := NewUpstreamTracker()
+	tracker.now = func() time.Time { return now }
+
+	assert.Zero(t, tracker.Reserve("a", 100, 0, 0), "unknown keys are not limited")
+
+	tracker.Update("a", UpstreamLimits{
+		LimitRequests:     10,
+		LimitTokens:       1000,
+		RemainingRequests: 2,
+		RemainingTokens:   500,
+		ResetRequests:     now.Add(time.Second),
+		ResetTokens:       now.Add(time.Minute),
+	})
+
+	assert.Zero(t, tracker.Reserve("a", 100, 0, 0))
+	assert.Equal(t, time.Minute, tracker.Reserve("a", 450, 0, 0), "not enough tokens")
+	assert.Equal(t, time.Second, tracker.Reserve("a", 100, 1, 0), "keeps a reserve of requests")
+	assert.Zero(t, tracker.Reserve("a", 100, 0, 0))
+	assert.Equal(t, time.Second, tracker.Reserve("a", 100, 0, 0), "no requests left")
+
+	tracker.Cancel("a", 100)
+	l, ok := tracker.Get("a")
+	require.True(t, ok)
+	assert.InDelta(t, 1, l.RemainingRequests, 0.001)
+	assert.InDelta(t, 400, l.RemainingTokens, 0.001)
+
+	now = now.Add(2 * time.Minute)
+	assert.Zero(t, tracker.Reserve("a", 10000, 0, 0), "the limits have reset")
+	tracker.Prune()
+	_, ok = tracker.Get("a")
+	assert.False(t, ok)
+}
@@ -0,0 +1,194 @@
+package redact
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// names of the built-in detectors, used in the rules files to select detectors
+const (
+	DetectorEmail       = "email"
+	DetectorPhone       = "phone"
+	DetectorCreditCard  = "credit_card"
+	DetectorAPIKey      = "api_key"
+	DetectorAWSKey      = "aws_key"
+	DetectorPrivateKey  = "private_key"
+	DetectorJWT         = "jwt"
+	DetectorGCPKey      = "gcp_key"
+	DetectorAzureKey    = "azure_key"
+	DetectorGitHubToken = "github_token"
+	DetectorSlackToken  = "slack_token"
+	DetectorStripeKey   = "stripe_key"
+	DetectorHighEntropy = "high_entropy"
+)
+
+const (
+	// DefaultEntropyThreshold is the default for Config.EntropyThreshold, in bits per character
+	DefaultEntropyThreshold = 4.3
+
+	// high entropy tokens are between these lengths, longer runs are usually base64 data, like images
+	minEntropyTokenLength = 32
+	maxEntropyTokenLength = 128
+)
+
+// defaultDetectorNames are the detectors enabled when the config doesn't select any
+var defaultDetectorNames = []string{DetectorEmail, DetectorPhone, DetectorCreditCard, DetectorAPIKey, DetectorAWSKey}
+
+// secretDetectorNames are the detectors for credentials, which are never expected in a prompt
+var secretDetectorNames = []string{
+	DetectorPrivateKey, DetectorJWT, DetectorAWSKey, DetectorGCPKey, DetectorAzureKey, DetectorGitHubToken,
+	DetectorSlackToken, DetectorStripeKey, DetectorAPIKey, DetectorHighEntropy,
+}
+
+// detector is a regex pattern for a type of sensitive data. When validate is set, it's called
+// with each match to filter false positives (e.g. the Luhn check for card numbers).
+type detector struct {
+	name        string
+	pattern     *regexp.Regexp
+	replacement string // optional, a regexp replacement template, to keep part of the match
+	validate    func(match string) bool
+}
+
+// builtinDetectors are run in this order, so the more specific patterns (keys, cards) are masked
+// before the broader patterns (phone numbers, high entropy tokens) have a chance to match part of
+// them.
+func builtinDetectors(entropyThreshold float64) []detector {
+	return []detector{
+		{
+			// the key may be truncated, so everything after the header is masked when there's no footer
+			name:    DetectorPrivateKey,
+			pattern: regexp.MustCompile(`-----BEGIN[A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----[\s\S]*?(?:-----END[A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----|$)`),
+		},
+		{
+			name:    DetectorJWT,
+			pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`),
+		},
+		{
+			name:    DetectorAPIKey,
+			pattern: regexp.MustCompile(`\bsk-(?:proj-|ant-(?:api\d\d-)?|svcacct-|admin-)?[A-Za-z0-9_-]{20,}`),
+		},
+		{
+			name:    DetectorAWSKey,
+			pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA|AGPA|AIDA|AIPA|ANPA|ANVA|AROA|APKA)[A-Z0-9]{16}\b`),
+		},
+		{
+			// secret keys have no fixed prefix, so only match when they're labeled
+			name:        DetectorAWSKey,
+			pattern:     regexp.MustCompile(`(?i)(aws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?)[A-Za-z0-9/+=]{40}\b`),
+			replacement: "${1}" + placeholder(DetectorAWSKey),
+		},
+		{
+			name:    DetectorGCPKey,
+			pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`),
+		},
+		{
+			// storage account keys in connection strings, and SAS token signatures
+			name:        DetectorAzureKey,
+			pattern:     regexp.MustCompile(`(?i)(AccountKey=|[?&]sig=)[A-Za-z0-9+/%]{40,}={0,2}`),
+			replacement: "${1}" + placeholder(DetectorAzureKey),
+		},
+		{
+			name:    DetectorGitHubToken,
+			pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{82})\b`),
+		},
+		{
+			name:    DetectorSlackToken,
+			pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`),
+		},
+		{
+			name:    DetectorStripeKey,
+			pattern: regexp.MustCompile(`\b(?:sk|rk)_live_[A-Za-z0-9]{24,}\b`),
+		},
+		{
+			name:    DetectorEmail,
+			pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+		},
+		{
+			name:     DetectorCreditCard,
+			pattern:  regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+			validate: isLuhnValid,
+		},
+		{
+			// require separators or a leading +, so timestamps and token counts aren't matched
+			name:    DetectorPhone,
+			pattern: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{3}\)\s?|\b\d{3}[ .-])\d{3}[ .-]\d{4}\b|\+\d{8,15}\b`),
+		},
+		{
+			name:     DetectorHighEntropy,
+			pattern:  regexp.MustCompile(`[A-Za-z0-9+/=_-]{32,}`),
+			validate: func(match string) bool { return isHighEntropyToken(match, entropyThreshold) },
+		},
+	}
+}
+
+// SecretDetectorNames returns the names of the built-in detectors for credentials, like private
+// keys, cloud keys, and API tokens
+func SecretDetectorNames() []string {
+	return append([]string(nil), secretDetectorNames...)
+}
+
+// isLuhnValid checks a card number (with optional spaces or dashes) using the Luhn algorithm
+func isLuhnValid(number string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(number)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// isHighEntropyToken returns true for a random looking token: lowercase and uppercase letters and
+// digits, with a Shannon entropy of at least the threshold
+func isHighEntropyToken(token string, threshold float64) bool {
+	if len(token) < minEntropyTokenLength || len(token) > maxEntropyTokenLength {
+		return false
+	}
+	var upper, lower, digit bool
+	for _, r := range token {
+		upper = upper || unicode.IsUpper(r)
+		lower = lower || unicode.IsLower(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+	if !upper || !lower || !digit {
+		return false
+	}
+	return shannonEntropy(token) >= threshold
+}
+
+// shannonEntropy returns the entropy of the string, in bits per character
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	entropy := 0.0
+	length := float64(len(s))
+	for _, count := range counts {
+		p := float64(count) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// placeholder is the default replacement text for a redacted value
+func placeholder(name string) string {
+	return "[REDACTED:" + name + "]"
+}
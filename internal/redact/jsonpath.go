@@ -0,0 +1,162 @@
+package redact
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathStep is a single segment of a parsed JSONPath expression
+type pathStep struct {
+	key       string // object key, empty for index or wildcard steps
+	index     int    // array index, only used when isIndex is true
+	isIndex   bool
+	wildcard  bool // matches every key of an object, or every item of an array
+	recursive bool // ".." descent, matches the step at any depth
+}
+
+// jsonPath is a parsed JSONPath expression. Only a subset of JSONPath is supported, which covers
+// the common cases for selecting fields to redact:
+//
+//	$.user.email              child keys
+//	$['user']['email']        bracket notation
+//	$.messages[0].content     array index
+//	$.messages[*].content     every array item, or every object value
+//	$..content                recursive descent, any key named "content" at any depth
+type jsonPath struct {
+	expr  string
+	steps []pathStep
+}
+
+// parseJSONPath converts the JSONPath expression into steps
+func parseJSONPath(expr string) (*jsonPath, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("json path must start with $: %q", expr)
+	}
+
+	p := &jsonPath{expr: expr}
+	rest := expr[1:]
+	for rest != "" {
+		recursive := false
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			recursive = true
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+		case strings.HasPrefix(rest, "["):
+			// bracket step, handled below
+		default:
+			return nil, fmt.Errorf("invalid json path %q near %q", expr, rest)
+		}
+
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed bracket in json path %q", expr)
+			}
+			step, err := parseBracket(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid json path %q: %w", expr, err)
+			}
+			step.recursive = recursive
+			p.steps = append(p.steps, step)
+			rest = rest[end+1:]
+			continue
+		}
+
+		// dotted key, ends at the next "." or "["
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		key := rest[:end]
+		if key == "" {
+			return nil, fmt.Errorf("empty key in json path %q", expr)
+		}
+		p.steps = append(p.steps, pathStep{key: key, wildcard: key == "*", recursive: recursive})
+		rest = rest[end:]
+	}
+
+	if len(p.steps) == 0 {
+		return nil, fmt.Errorf("json path selects the whole document: %q", expr)
+	}
+	return p, nil
+}
+
+// parseBracket parses the contents of a bracket step: *, an index, or a quoted key
+func parseBracket(s string) (pathStep, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "*":
+		return pathStep{wildcard: true}, nil
+	case len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0]:
+		return pathStep{key: s[1 : len(s)-1]}, nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return pathStep{}, fmt.Errorf("invalid bracket expression: %q", s)
+	}
+	return pathStep{index: i, isIndex: true}, nil
+}
+
+// replace walks the decoded JSON document, and calls fn with every value selected by the path.
+// The value is replaced by the return value of fn. Returns the number of replaced values.
+func (p *jsonPath) replace(doc any, fn func(any) any) int {
+	return p.walk(doc, 0, fn)
+}
+
+func (p *jsonPath) walk(node any, stepIdx int, fn func(any) any) int {
+	if stepIdx >= len(p.steps) {
+		return 0
+	}
+	step := p.steps[stepIdx]
+	last := stepIdx == len(p.steps)-1
+	count := 0
+
+	// apply this step to a child, either replacing the value or walking further down
+	visit := func(child any, set func(any)) {
+		if last {
+			set(fn(child))
+			count++
+			return
+		}
+		count += p.walk(child, stepIdx+1, fn)
+	}
+
+	switch n := node.(type) {
+	case map[string]any:
+		for k, v := range n {
+			if step.wildcard || (!step.isIndex && k == step.key) {
+				visit(v, func(nv any) { n[k] = nv })
+			}
+		}
+	case []any:
+		for i, v := range n {
+			if step.wildcard || (step.isIndex && (i == step.index || i == len(n)+step.index)) {
+				visit(v, func(nv any) { n[i] = nv })
+			}
+		}
+	}
+
+	if step.recursive {
+		// keep looking for the same step in all children, but not inside values that were just replaced
+		switch n := node.(type) {
+		case map[string]any:
+			for k, v := range n {
+				if last && !step.wildcard && k == step.key {
+					continue
+				}
+				count += p.walk(v, stepIdx, fn)
+			}
+		case []any:
+			for _, v := range n {
+				count += p.walk(v, stepIdx, fn)
+			}
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,86 @@
+package redact
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	t.Parallel()
+
+	valid := []string{
+		"$.a",
+		"$.a.b",
+		"$['a']['b']",
+		`$["a"]`,
+		"$.a[0]",
+		"$.a[-1]",
+		"$.a[*].b",
+		"$..b",
+		"$..[0]",
+		"$.*",
+	}
+	for _, expr := range valid {
+		t.Run(expr, func(t *testing.T) {
+			_, err := parseJSONPath(expr)
+			assert.NoError(t, err)
+		})
+	}
+
+	invalid := []string{"", "a.b", "$", "$.", "$.a[", "$.a[x]", "$a"}
+	for _, expr := range invalid {
+		t.Run("invalid "+expr, func(t *testing.T) {
+			_, err := parseJSONPath(expr)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestJSONPathReplace(t *testing.T) {
+	t.Parallel()
+	doc := `{
+		"model": "gpt-4o",
+		"messages": [
+			{"role": "system", "content": "be nice"},
+			{"role": "user", "content": "hello", "meta": {"content": "nested"}}
+		],
+		"user": {"email": "bob@example.com"}
+	}`
+
+	tests := []struct {
+		path     string
+		count    int
+		expected string
+	}{
+		{"$.model", 1, `"model":"X"`},
+		{"$.user.email", 1, `"user":{"email":"X"}`},
+		{"$['user']['email']", 1, `"user":{"email":"X"}`},
+		{"$.messages[0].content", 1, `{"content":"X","role":"system"}`},
+		{"$.messages[-1].content", 1, `"content":"X","meta":{"content":"nested"}`},
+		{"$.messages[*].content", 2, `{"content":"X","role":"system"}`},
+		{"$..content", 3, `"meta":{"content":"X"}`},
+		{"$.messages[*].role", 2, `"role":"X"`},
+		{"$.missing", 0, `"model":"gpt-4o"`},
+		{"$.model.nope", 0, `"model":"gpt-4o"`},
+		{"$.user", 1, `"user":"X"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var parsed any
+			require.NoError(t, json.Unmarshal([]byte(doc), &parsed))
+
+			p, err := parseJSONPath(tt.path)
+			require.NoError(t, err)
+			count := p.replace(parsed, func(any) any { return "X" })
+			assert.Equal(t, tt.count, count)
+
+			out, err := json.Marshal(parsed)
+			require.NoError(t, err)
+			assert.Contains(t, string(out), tt.expected)
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Rule is a custom redaction rule, which sets either a regex or a JSONPath. Regex matches are
+// replaced anywhere in the body, and JSONPath values are replaced only when the body is JSON.
+type Rule struct {
+	Name        string `json:"name,omitempty"`
+	Regex       string `json:"regex,omitempty"`
+	JSONPath    string `json:"json_path,omitempty"`
+	Replacement string `json:"replacement,omitempty"` // default: "[REDACTED:<name>]", regex rules may use $1 etc
+}
+
+// Config is the redaction config, usually loaded from a JSON file
+type Config struct {
+	// Detectors is the list of built-in detectors to enable. All of them are enabled when this is
+	// omitted, and none when it's an empty list.
+	Detectors []string `json:"detectors"`
+	Rules     []Rule   `json:"rules,omitempty"`
+
+	// EntropyThreshold is the Shannon entropy, in bits per character, from which a random looking
+	// token is a secret, for the high_entropy detector. Default: 4.3
+	EntropyThreshold float64 `json:"entropy_threshold,omitempty"`
+}
+
+// Finding is the number of values masked by a detector or rule, the values themselves are never kept
+type Finding struct {
+	Detector string `json:"detector"`
+	Count    int    `json:"count"`
+}
+
+// jsonRule is a compiled JSONPath rule
+type jsonRule struct {
+	name        string
+	path        *jsonPath
+	replacement string
+}
+
+// Redactor masks sensitive data in request and response bodies, before they're logged or sent
+type Redactor struct {
+	jsonRules []jsonRule
+	detectors []detector // regex rules and the enabled built-in detectors, in the order they run
+}
+
+// New creates a Redactor from the config, and returns an error if any rule is invalid
+func New(cfg Config) (*Redactor, error) {
+	r := &Redactor{}
+	errs := make([]error, 0)
+
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = placeholder(rule.Name)
+		}
+
+		switch {
+		case rule.Regex != "" && rule.JSONPath != "":
+			errs = append(errs, fmt.Errorf("%s: set either regex or json_path, not both", rule.Name))
+		case rule.Regex != "":
+			pattern, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid regex: %w", rule.Name, err))
+				continue
+			}
+			r.detectors = append(r.detectors, detector{name: rule.Name, pattern: pattern, replacement: replacement})
+		case rule.JSONPath != "":
+			path, err := parseJSONPath(rule.JSONPath)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", rule.Name, err))
+				continue
+			}
+			r.jsonRules = append(r.jsonRules, jsonRule{name: rule.Name, path: path, replacement: replacement})
+		default:
+			errs = append(errs, fmt.Errorf("%s: regex or json_path is required", rule.Name))
+		}
+	}
+
+	threshold := cfg.EntropyThreshold
+	if threshold == 0 {
+		threshold = DefaultEntropyThreshold
+	}
+	if threshold < 0 || threshold > 8 {
+		errs = append(errs, fmt.Errorf("entropy_threshold must be between 0 and 8, got %v", cfg.EntropyThreshold))
+	}
+
+	enabled := cfg.Detectors
+	if enabled == nil {
+		enabled = defaultDetectorNames
+	}
+	builtin := builtinDetectors(threshold)
+	for _, name := range enabled {
+		if !slices.ContainsFunc(builtin, func(d detector) bool { return d.name == name }) {
+			errs = append(errs, fmt.Errorf("unknown detector: %s", name))
+		}
+	}
+	for _, d := range builtin {
+		if slices.Contains(enabled, d.name) {
+			r.detectors = append(r.detectors, d)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewDefault creates a Redactor with all of the built-in detectors, and no custom rules
+func NewDefault() *Redactor {
+	r, err := New(Config{})
+	if err != nil {
+		// this should never happen, the built-in detectors are static
+		panic(err)
+	}
+	return r
+}
+
+// LoadFile reads a JSON redaction config file, and creates a Redactor
+func LoadFile(fileName string) (*Redactor, error) {
+	cfg, err := LoadConfig(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redaction rules file %s: %w", fileName, err)
+	}
+	return r, nil
+}
+
+// LoadConfig reads a JSON redaction config file, for the callers that change the defaults before
+// creating the Redactor
+func LoadConfig(fileName string) (Config, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return Config{}, fmt.Errorf("unable to read rules file: %w", err)
+	}
+
+	cfg := Config{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("unable to parse rules file %s: %w", fileName, err)
+	}
+	return cfg, nil
+}
+
+// Redact returns the body with all sensitive data masked. A nil Redactor returns the body as-is.
+func (r *Redactor) Redact(body string) string {
+	if r == nil || body == "" {
+		return body
+	}
+
+	if len(r.jsonRules) > 0 {
+		body, _ = rewriteJSON(body, func(doc any) bool {
+			count := 0
+			for _, rule := range r.jsonRules {
+				count += rule.path.replace(doc, func(any) any { return rule.replacement })
+			}
+			return count > 0
+		})
+	}
+
+	for _, d := range r.detectors {
+		body, _ = d.redact(body)
+	}
+	return body
+}
+
+// Scan returns the body with the sensitive data masked, and the number of values masked by each
+// detector and rule. Unlike Redact, only the string values of a JSON body are scanned, so the
+// masked body is still valid JSON. The body is returned as-is when nothing is found.
+func (r *Redactor) Scan(body []byte) ([]byte, []Finding) {
+	if r == nil || len(body) == 0 {
+		return body, nil
+	}
+	counts := make(map[string]int)
+
+	redacted, isJSON := rewriteJSON(string(body), func(doc any) bool {
+		for _, rule := range r.jsonRules {
+			if count := rule.path.replace(doc, func(any) any { return rule.replacement }); count > 0 {
+				counts[rule.name] += count
+			}
+		}
+		replaceStrings(doc, func(s string) string { return r.redactText(s, counts) })
+		return len(counts) > 0
+	})
+	if !isJSON {
+		redacted = r.redactText(string(body), counts)
+	}
+
+	if len(counts) == 0 {
+		return body, nil
+	}
+	findings := make([]Finding, 0, len(counts))
+	addFinding := func(name string) {
+		if count, ok := counts[name]; ok {
+			findings = append(findings, Finding{Detector: name, Count: count})
+			delete(counts, name) // the detectors with several patterns are listed once
+		}
+	}
+	for _, rule := range r.jsonRules {
+		addFinding(rule.name)
+	}
+	for _, d := range r.detectors {
+		addFinding(d.name)
+	}
+	return []byte(redacted), findings
+}
+
+// redactText runs the detectors on the text, and counts the matches
+func (r *Redactor) redactText(text string, counts map[string]int) string {
+	for _, d := range r.detectors {
+		var count int
+		if text, count = d.redact(text); count > 0 {
+			counts[d.name] += count
+		}
+	}
+	return text
+}
+
+// rewriteJSON decodes the body when it's a JSON document, and calls fn to change it in place. The
+// body is re-encoded when fn returns true, otherwise it keeps its original formatting. Returns
+// false when the body is not JSON.
+func rewriteJSON(body string, fn func(doc any) bool) (string, bool) {
+	trimmed := strings.TrimSpace(body)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return body, false
+	}
+
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber() // don't convert numbers to float64, to keep the original values
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return body, false
+	}
+
+	if !fn(doc) {
+		return body, true
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return body, true
+	}
+	return strings.TrimSuffix(out.String(), "\n"), true
+}
+
+// replaceStrings replaces the strings in a decoded JSON document with the return value of fn,
+// recursively. A string at the root can't be replaced in place, so it's skipped.
+func replaceStrings(node any, fn func(string) string) {
+	switch n := node.(type) {
+	case map[string]any:
+		for k, v := range n {
+			if s, ok := v.(string); ok {
+				n[k] = fn(s)
+				continue
+			}
+			replaceStrings(v, fn)
+		}
+	case []any:
+		for i, v := range n {
+			if s, ok := v.(string); ok {
+				n[i] = fn(s)
+				continue
+			}
+			replaceStrings(v, fn)
+		}
+	}
+}
+
+// redact replaces all valid matches of the detector in the text, and returns the number of matches
+func (d *detector) redact(text string) (string, int) {
+	replacement := d.replacement
+	if replacement == "" {
+		replacement = placeholder(d.name)
+	}
+
+	count := 0
+	text = d.pattern.ReplaceAllStringFunc(text, func(match string) string {
+		if d.validate != nil && !d.validate(match) {
+			return match
+		}
+		count++
+		return d.pattern.ReplaceAllString(match, replacement)
+	})
+	return text, count
+}
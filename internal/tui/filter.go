@@ -0,0 +1,64 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/proxati/llm_proxy/v2/proxy/addons"
+)
+
+// filter matches flows by host and workflow name. The filter text is split on whitespace, and
+// every term must match. A term can be prefixed with "host:" or "workflow:" to match only that
+// field, otherwise it matches either one. Matching is a case-insensitive substring match.
+type filter struct {
+	hosts     []string
+	workflows []string
+	any       []string
+}
+
+// parseFilter converts the text typed by the user into a filter
+func parseFilter(text string) filter {
+	f := filter{}
+	for _, term := range strings.Fields(strings.ToLower(text)) {
+		switch {
+		case strings.HasPrefix(term, "host:"):
+			if v := strings.TrimPrefix(term, "host:"); v != "" {
+				f.hosts = append(f.hosts, v)
+			}
+		case strings.HasPrefix(term, "workflow:"):
+			if v := strings.TrimPrefix(term, "workflow:"); v != "" {
+				f.workflows = append(f.workflows, v)
+			}
+		default:
+			f.any = append(f.any, term)
+		}
+	}
+	return f
+}
+
+// isEmpty returns true when the filter matches everything
+func (f filter) isEmpty() bool {
+	return len(f.hosts) == 0 && len(f.workflows) == 0 && len(f.any) == 0
+}
+
+// match returns true when the event matches all filter terms
+func (f filter) match(event *addons.TrafficEvent) bool {
+	host := strings.ToLower(event.Host)
+	workflow := strings.ToLower(event.Workflow)
+
+	for _, term := range f.hosts {
+		if !strings.Contains(host, term) {
+			return false
+		}
+	}
+	for _, term := range f.workflows {
+		if !strings.Contains(workflow, term) {
+			return false
+		}
+	}
+	for _, term := range f.any {
+		if !strings.Contains(host, term) && !strings.Contains(workflow, term) {
+			return false
+		}
+	}
+	return true
+}
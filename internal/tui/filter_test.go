@@ -0,0 +1,49 @@
+package tui
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/proxati/llm_proxy/v2/proxy/addons"
+)
+
+func TestParseFilter(t *testing.T) {
+	t.Parallel()
+
+	f := parseFilter("")
+	assert.True(t, f.isEmpty())
+
+	f = parseFilter("  Host:OpenAI  workflow:summarize chat host: ")
+	assert.Equal(t, []string{"openai"}, f.hosts)
+	assert.Equal(t, []string{"summarize"}, f.workflows)
+	assert.Equal(t, []string{"chat"}, f.any)
+	assert.False(t, f.isEmpty())
+}
+
+func TestFilterMatch(t *testing.T) {
+	t.Parallel()
+	event := &addons.TrafficEvent{Host: "api.openai.com", Workflow: "Summarize-Docs"}
+
+	tests := []struct {
+		filter   string
+		expected bool
+	}{
+		{"", true},
+		{"openai", true},
+		{"summarize", true},
+		{"anthropic", false},
+		{"host:openai", true},
+		{"host:summarize", false},
+		{"workflow:summarize", true},
+		{"workflow:openai", false},
+		{"host:openai workflow:docs", true},
+		{"host:openai workflow:other", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseFilter(tt.filter).match(event))
+		})
+	}
+}
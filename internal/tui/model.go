@@ -0,0 +1,272 @@
+package tui
+
+import (
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/proxati/llm_proxy/v2/proxy/addons"
+)
+
+// maxFlows is the number of flows kept in memory, the oldest flows are removed first
+const maxFlows = 1000
+
+// viewMode is the screen currently shown by the TUI
+type viewMode int
+
+const (
+	listView viewMode = iota
+	detailView
+	filterView
+)
+
+// eventMsg wraps a completed flow received from the TrafficFeed
+type eventMsg addons.TrafficEvent
+
+// feedClosedMsg is sent when the TrafficFeed channel is closed
+type feedClosedMsg struct{}
+
+// Model is the bubbletea model for the live traffic view
+type Model struct {
+	events       <-chan addons.TrafficEvent
+	flows        []addons.TrafficEvent
+	visible      []int // indexes into flows that match the current filter
+	cursor       int   // index into visible
+	offset       int   // first visible row shown on the list screen
+	mode         viewMode
+	filter       filter
+	filterInput  textinput.Model
+	detail       viewport.Model
+	width        int
+	height       int
+	sessionTotal string
+}
+
+// New creates the TUI model, which reads completed flows from the events channel
+func New(events <-chan addons.TrafficEvent) Model {
+	ti := textinput.New()
+	ti.Prompt = "/"
+	ti.Placeholder = "host:api.openai.com workflow:my-workflow"
+	ti.CharLimit = 256
+
+	return Model{
+		events:      events,
+		filterInput: ti,
+		detail:      viewport.New(0, 0),
+		width:       80,
+		height:      24,
+	}
+}
+
+// waitForEvent returns a command that blocks until the next flow is received
+func waitForEvent(events <-chan addons.TrafficEvent) tea.Cmd {
+	return func() tea.Msg {
+		event, ok := <-events
+		if !ok {
+			return feedClosedMsg{}
+		}
+		return eventMsg(event)
+	}
+}
+
+func (m Model) Init() tea.Cmd {
+	return waitForEvent(m.events)
+}
+
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.detail.Width = msg.Width
+		m.detail.Height = m.detailHeight()
+		m.scrollToCursor()
+		return m, nil
+	case eventMsg:
+		m.addFlow(addons.TrafficEvent(msg))
+		return m, waitForEvent(m.events)
+	case feedClosedMsg:
+		return m, nil
+	case tea.KeyMsg:
+		switch m.mode {
+		case filterView:
+			return m.updateFilter(msg)
+		case detailView:
+			return m.updateDetail(msg)
+		default:
+			return m.updateList(msg)
+		}
+	}
+	return m, nil
+}
+
+// updateList handles key presses on the flow list screen
+func (m Model) updateList(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "up", "k":
+		m.moveCursor(-1)
+	case "down", "j":
+		m.moveCursor(1)
+	case "pgup":
+		m.moveCursor(-m.listHeight())
+	case "pgdown":
+		m.moveCursor(m.listHeight())
+	case "home", "g":
+		m.moveCursor(-len(m.visible))
+	case "end", "G":
+		m.moveCursor(len(m.visible))
+	case "enter":
+		if event := m.selected(); event != nil {
+			m.mode = detailView
+			m.detail.Width = m.width
+			m.detail.Height = m.detailHeight()
+			m.detail.SetContent(renderDetail(event, m.width))
+			m.detail.GotoTop()
+		}
+	case "/":
+		m.mode = filterView
+		return m, m.filterInput.Focus()
+	case "esc":
+		m.setFilter("")
+	case "c":
+		m.flows = nil
+		m.visible = nil
+		m.cursor = 0
+		m.offset = 0
+	}
+	return m, nil
+}
+
+// updateDetail handles key presses on the flow detail screen
+func (m Model) updateDetail(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "q", "esc", "backspace":
+		m.mode = listView
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.detail, cmd = m.detail.Update(msg)
+	return m, cmd
+}
+
+// updateFilter handles key presses while editing the filter, the list is filtered as you type
+func (m Model) updateFilter(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "enter":
+		m.mode = listView
+		m.filterInput.Blur()
+		return m, nil
+	case "esc":
+		m.mode = listView
+		m.filterInput.Blur()
+		m.filterInput.SetValue("")
+		m.setFilter("")
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.filterInput, cmd = m.filterInput.Update(msg)
+	m.setFilter(m.filterInput.Value())
+	return m, cmd
+}
+
+// addFlow appends a new flow, and follows the tail of the list when the cursor is on the last row
+func (m *Model) addFlow(event addons.TrafficEvent) {
+	following := len(m.visible) == 0 || m.cursor == len(m.visible)-1
+
+	if event.SessionTotal != "" {
+		m.sessionTotal = event.SessionTotal
+	}
+
+	m.flows = append(m.flows, event)
+	if len(m.flows) > maxFlows {
+		m.flows = m.flows[len(m.flows)-maxFlows:]
+		m.applyFilter()
+	} else if m.filter.match(&m.flows[len(m.flows)-1]) {
+		m.visible = append(m.visible, len(m.flows)-1)
+	}
+
+	if following {
+		m.cursor = len(m.visible) - 1
+	}
+	m.clampCursor()
+	m.scrollToCursor()
+}
+
+// setFilter parses the filter text, and rebuilds the visible list
+func (m *Model) setFilter(text string) {
+	m.filter = parseFilter(text)
+	m.applyFilter()
+	m.clampCursor()
+	m.scrollToCursor()
+}
+
+// applyFilter rebuilds the visible list from all flows
+func (m *Model) applyFilter() {
+	m.visible = m.visible[:0]
+	for i := range m.flows {
+		if m.filter.match(&m.flows[i]) {
+			m.visible = append(m.visible, i)
+		}
+	}
+}
+
+func (m *Model) moveCursor(delta int) {
+	m.cursor += delta
+	m.clampCursor()
+	m.scrollToCursor()
+}
+
+func (m *Model) clampCursor() {
+	if m.cursor >= len(m.visible) {
+		m.cursor = len(m.visible) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+}
+
+// scrollToCursor moves the list window so the cursor is always on screen
+func (m *Model) scrollToCursor() {
+	height := m.listHeight()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+height {
+		m.offset = m.cursor - height + 1
+	}
+	if m.offset < 0 {
+		m.offset = 0
+	}
+}
+
+// selected returns the flow under the cursor, or nil when the list is empty
+func (m *Model) selected() *addons.TrafficEvent {
+	if len(m.visible) == 0 {
+		return nil
+	}
+	return &m.flows[m.visible[m.cursor]]
+}
+
+// listHeight is the number of flow rows that fit on screen, after the title, header, and status bar
+func (m *Model) listHeight() int {
+	return max(m.height-3, 1)
+}
+
+// detailHeight is the number of body lines that fit on the detail screen, after the title and help
+func (m *Model) detailHeight() int {
+	return max(m.height-2, 1)
+}
+
+// NewProgram creates the bubbletea program for the TUI, which takes over the terminal using the
+// alternate screen until it's closed.
+func NewProgram(events <-chan addons.TrafficEvent) *tea.Program {
+	return tea.NewProgram(New(events), tea.WithAltScreen())
+}
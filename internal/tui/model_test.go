@@ -0,0 +1,214 @@
+package tui
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/proxy/addons"
+	"github.com/proxati/llm_proxy/v2/schema"
+)
+
+func newTestEvent(i int, host, workflow string) addons.TrafficEvent {
+	ldc := schema.NewLogDumpContainerEmpty()
+	ldc.Request.Method = http.MethodPost
+	ldc.Request.URL = &url.URL{Scheme: "https", Host: host, Path: "/v1/chat/completions"}
+	ldc.Request.Body = `{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`
+	ldc.Response.Status = http.StatusOK
+	ldc.Response.Body = `{"choices":[{"message":{"content":"hi there"}}]}`
+
+	return addons.TrafficEvent{
+		ID:           fmt.Sprintf("flow-%d", i),
+		Timestamp:    time.Now(),
+		Method:       http.MethodPost,
+		Host:         host,
+		Path:         "/v1/chat/completions",
+		Workflow:     workflow,
+		StatusCode:   http.StatusOK,
+		Model:        "gpt-4o",
+		InputTokens:  10,
+		OutputTokens: 20,
+		Latency:      1500 * time.Millisecond,
+		CacheStatus:  "MISS",
+		Cost:         "$0.01",
+		SessionTotal: fmt.Sprintf("$0.%02d", i),
+		Log:          ldc,
+	}
+}
+
+// update sends a message to the model, and returns the updated model
+func update(t *testing.T, m Model, msg tea.Msg) Model {
+	t.Helper()
+	newModel, _ := m.Update(msg)
+	updated, ok := newModel.(Model)
+	require.True(t, ok)
+	return updated
+}
+
+func keyMsg(key string) tea.KeyMsg {
+	switch key {
+	case "enter":
+		return tea.KeyMsg{Type: tea.KeyEnter}
+	case "esc":
+		return tea.KeyMsg{Type: tea.KeyEscape}
+	case "up":
+		return tea.KeyMsg{Type: tea.KeyUp}
+	default:
+		return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+	}
+}
+
+func TestModel_Events(t *testing.T) {
+	events := make(chan addons.TrafficEvent, 1)
+	m := New(events)
+	m = update(t, m, tea.WindowSizeMsg{Width: 160, Height: 20})
+
+	cmd := m.Init()
+	require.NotNil(t, cmd)
+
+	events <- newTestEvent(1, "api.openai.com", "")
+	msg := cmd()
+	require.IsType(t, eventMsg{}, msg)
+
+	m = update(t, m, msg)
+	assert.Len(t, m.flows, 1)
+	assert.Equal(t, "$0.01", m.sessionTotal)
+	assert.Contains(t, m.View(), "api.openai.com/v1/chat/completions")
+	assert.Contains(t, m.View(), "1/1 flows")
+
+	close(events)
+	assert.IsType(t, feedClosedMsg{}, waitForEvent(events)())
+}
+
+func TestModel_CursorFollowsTail(t *testing.T) {
+	m := New(nil)
+	m = update(t, m, tea.WindowSizeMsg{Width: 120, Height: 10})
+
+	for i := range 20 {
+		m = update(t, m, eventMsg(newTestEvent(i, "api.openai.com", "")))
+	}
+	assert.Equal(t, 19, m.cursor, "cursor follows new flows")
+	assert.Equal(t, 19-m.listHeight()+1, m.offset, "list scrolls to show the cursor")
+
+	m = update(t, m, keyMsg("up"))
+	assert.Equal(t, 18, m.cursor)
+	m = update(t, m, eventMsg(newTestEvent(20, "api.openai.com", "")))
+	assert.Equal(t, 18, m.cursor, "cursor stays put when not on the last row")
+
+	m = update(t, m, keyMsg("g"))
+	assert.Equal(t, 0, m.cursor)
+	assert.Equal(t, 0, m.offset)
+
+	m = update(t, m, keyMsg("G"))
+	assert.Equal(t, 20, m.cursor)
+}
+
+func TestModel_MaxFlows(t *testing.T) {
+	m := New(nil)
+	for i := range maxFlows + 10 {
+		m.addFlow(newTestEvent(i, "api.openai.com", ""))
+	}
+	assert.Len(t, m.flows, maxFlows)
+	assert.Len(t, m.visible, maxFlows)
+	assert.Equal(t, "flow-10", m.flows[0].ID)
+	assert.Equal(t, maxFlows-1, m.cursor)
+}
+
+func TestModel_Filter(t *testing.T) {
+	m := New(nil)
+	m.addFlow(newTestEvent(1, "api.openai.com", "summarize"))
+	m.addFlow(newTestEvent(2, "api.anthropic.com", "summarize"))
+	m.addFlow(newTestEvent(3, "api.openai.com", "translate"))
+
+	m = update(t, m, keyMsg("/"))
+	assert.Equal(t, filterView, m.mode)
+	for _, r := range "host:openai" {
+		m = update(t, m, keyMsg(string(r)))
+	}
+	assert.Equal(t, []int{0, 2}, m.visible, "filtered while typing")
+
+	m = update(t, m, keyMsg("enter"))
+	assert.Equal(t, listView, m.mode)
+	assert.Contains(t, m.View(), "filter: host:openai")
+
+	// new flows are filtered too
+	m.addFlow(newTestEvent(4, "api.anthropic.com", "translate"))
+	assert.Equal(t, []int{0, 2}, m.visible)
+
+	m.setFilter("workflow:translate")
+	assert.Equal(t, []int{2, 3}, m.visible)
+
+	m = update(t, m, keyMsg("esc"))
+	assert.Len(t, m.visible, 4, "esc clears the filter")
+}
+
+func TestModel_Detail(t *testing.T) {
+	m := New(nil)
+	m = update(t, m, tea.WindowSizeMsg{Width: 120, Height: 60})
+
+	// enter does nothing on an empty list
+	m = update(t, m, keyMsg("enter"))
+	assert.Equal(t, listView, m.mode)
+
+	m.addFlow(newTestEvent(1, "api.openai.com", "summarize"))
+	m = update(t, m, keyMsg("enter"))
+	assert.Equal(t, detailView, m.mode)
+
+	view := m.View()
+	assert.Contains(t, view, "POST api.openai.com/v1/chat/completions")
+	assert.Contains(t, view, `"content": "hello"`, "request body is pretty-printed")
+	assert.Contains(t, view, `"content": "hi there"`, "response body is pretty-printed")
+
+	m = update(t, m, keyMsg("esc"))
+	assert.Equal(t, listView, m.mode)
+}
+
+func TestModel_Clear(t *testing.T) {
+	m := New(nil)
+	m.addFlow(newTestEvent(1, "api.openai.com", ""))
+	m = update(t, m, keyMsg("c"))
+	assert.Empty(t, m.flows)
+	assert.Empty(t, m.visible)
+	assert.Nil(t, m.selected())
+}
+
+func TestModel_Quit(t *testing.T) {
+	m := New(nil)
+	_, cmd := m.Update(keyMsg("q"))
+	require.NotNil(t, cmd)
+	assert.Equal(t, tea.Quit(), cmd())
+}
+
+func TestFormatBody(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "(empty body)", formatBody(""))
+	assert.Equal(t, "plain text", formatBody("plain text"))
+	assert.Equal(t, "{\n  \"a\": 1\n}", formatBody(`{"a":1}`))
+	assert.Equal(t, "data: {\n  \"a\": 1\n}\n\ndata: [DONE]", formatBody("data: {\"a\":1}\n\ndata: [DONE]"))
+}
+
+func TestFit(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "", fit("abc", 0))
+	assert.Equal(t, "abc  ", fit("abc", 5))
+	assert.Equal(t, "ab…", fit("abcdef", 3))
+	assert.Equal(t, "…", fit("abcdef", 1))
+}
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "abc\ndef\ng", wrap("abcdefg", 3))
+	assert.Equal(t, "ab\ncd", wrap("ab\ncd", 3))
+}
+
+func TestFormatLatency(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "250ms", formatLatency(250*time.Millisecond))
+	assert.Equal(t, "1.50s", formatLatency(1500*time.Millisecond))
+}
@@ -0,0 +1,290 @@
+package tui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/proxati/llm_proxy/v2/proxy/addons"
+)
+
+var (
+	titleStyle    = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	headerStyle   = lipgloss.NewStyle().Bold(true).Underline(true)
+	selectedStyle = lipgloss.NewStyle().Reverse(true)
+	errorStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	helpStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	sectionStyle  = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("11"))
+)
+
+// column is a single column on the flow list screen
+type column struct {
+	title string
+	width int
+	value func(e *addons.TrafficEvent) string
+}
+
+// minFlexWidth is the minimum width of the URL column, which fills the remaining screen width
+const minFlexWidth = 10
+
+// columns are the fields shown for each flow, a column with width 0 fills the remaining width
+var columns = []column{
+	{"TIME", 8, func(e *addons.TrafficEvent) string { return e.Timestamp.Format(time.TimeOnly) }},
+	{"STATUS", 6, func(e *addons.TrafficEvent) string { return formatStatus(e.StatusCode) }},
+	{"METHOD", 6, func(e *addons.TrafficEvent) string { return e.Method }},
+	{"URL", 0, func(e *addons.TrafficEvent) string { return e.Host + e.Path }},
+	{"MODEL", 20, func(e *addons.TrafficEvent) string { return e.Model }},
+	{"TOKENS", 11, formatTokens},
+	{"LATENCY", 8, func(e *addons.TrafficEvent) string { return formatLatency(e.Latency) }},
+	{"CACHE", 5, func(e *addons.TrafficEvent) string { return e.CacheStatus }},
+	{"COST", 9, func(e *addons.TrafficEvent) string { return e.Cost }},
+	{"WORKFLOW", 16, func(e *addons.TrafficEvent) string { return e.Workflow }},
+}
+
+func (m Model) View() string {
+	if m.mode == detailView {
+		return m.viewDetail()
+	}
+	return m.viewList()
+}
+
+// viewList renders the title, the visible part of the flow list, and the status bar
+func (m Model) viewList() string {
+	var b strings.Builder
+
+	b.WriteString(titleStyle.Render("llm_proxy live traffic"))
+	b.WriteString("\n")
+	b.WriteString(headerStyle.Render(m.renderRow(nil)))
+	b.WriteString("\n")
+
+	height := m.listHeight()
+	for i := m.offset; i < len(m.visible) && i < m.offset+height; i++ {
+		event := &m.flows[m.visible[i]]
+		row := m.renderRow(event)
+		switch {
+		case i == m.cursor:
+			row = selectedStyle.Render(row)
+		case event.StatusCode == 0 || event.StatusCode >= http.StatusBadRequest:
+			row = errorStyle.Render(row)
+		}
+		b.WriteString(row)
+		b.WriteString("\n")
+	}
+
+	// pad the list, so the status bar stays at the bottom of the screen
+	for i := len(m.visible) - m.offset; i < height; i++ {
+		b.WriteString("\n")
+	}
+
+	b.WriteString(m.renderStatusBar())
+	return b.String()
+}
+
+// renderRow returns a single line with all columns, or the column titles when event is nil
+func (m Model) renderRow(event *addons.TrafficEvent) string {
+	fixedWidth := 0
+	for _, col := range columns {
+		fixedWidth += col.width + 1 // +1 for the space between columns
+	}
+	flexWidth := max(m.width-fixedWidth, minFlexWidth)
+
+	cells := make([]string, 0, len(columns))
+	for _, col := range columns {
+		width := col.width
+		if width == 0 {
+			width = flexWidth
+		}
+
+		text := col.title
+		if event != nil {
+			text = col.value(event)
+		}
+		cells = append(cells, fit(text, width))
+	}
+	return strings.Join(cells, " ")
+}
+
+// renderStatusBar shows the flow count, spend, filter, and help text
+func (m Model) renderStatusBar() string {
+	if m.mode == filterView {
+		return m.filterInput.View()
+	}
+
+	status := fmt.Sprintf("%d/%d flows", len(m.visible), len(m.flows))
+	if m.sessionTotal != "" {
+		status += " | session cost " + m.sessionTotal
+	}
+	if !m.filter.isEmpty() {
+		status += " | filter: " + m.filterInput.Value()
+	}
+	return status + " " + helpStyle.Render("[enter] details [/] filter [esc] clear filter [c] clear [q] quit")
+}
+
+// viewDetail renders the decoded request and response of the selected flow
+func (m Model) viewDetail() string {
+	title := "flow details"
+	if event := m.selected(); event != nil {
+		title = fmt.Sprintf("%s %s%s", event.Method, event.Host, event.Path)
+	}
+	return titleStyle.Render(fit(title, m.width)) + "\n" +
+		m.detail.View() + "\n" +
+		helpStyle.Render(fmt.Sprintf("%3.f%% [up/down/pgup/pgdown] scroll [esc] back", m.detail.ScrollPercent()*100))
+}
+
+// renderDetail builds the scrollable text for the detail screen
+func renderDetail(event *addons.TrafficEvent, width int) string {
+	var b strings.Builder
+
+	b.WriteString(sectionStyle.Render("Summary"))
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "ID:        %s\n", event.ID)
+	fmt.Fprintf(&b, "Time:      %s\n", event.Timestamp.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Status:    %s\n", formatStatus(event.StatusCode))
+	fmt.Fprintf(&b, "Model:     %s\n", event.Model)
+	fmt.Fprintf(&b, "Tokens:    %s\n", formatTokens(event))
+	fmt.Fprintf(&b, "Latency:   %s\n", formatLatency(event.Latency))
+	fmt.Fprintf(&b, "Cache:     %s\n", event.CacheStatus)
+	fmt.Fprintf(&b, "Cost:      %s\n", event.Cost)
+	fmt.Fprintf(&b, "Workflow:  %s\n", event.Workflow)
+
+	if event.Log == nil {
+		b.WriteString("\n(request and response were not captured)\n")
+		return b.String()
+	}
+
+	if req := event.Log.Request; req != nil {
+		b.WriteString("\n")
+		b.WriteString(sectionStyle.Render("Request"))
+		b.WriteString("\n")
+		if req.URL != nil {
+			fmt.Fprintf(&b, "%s %s %s\n", req.Method, req.URL.String(), req.Proto)
+		}
+		b.WriteString(formatHeaders(req.Header))
+		b.WriteString("\n")
+		b.WriteString(wrap(formatBody(req.Body), width))
+		b.WriteString("\n")
+	}
+
+	if resp := event.Log.Response; resp != nil {
+		b.WriteString("\n")
+		b.WriteString(sectionStyle.Render("Response"))
+		b.WriteString("\n")
+		fmt.Fprintf(&b, "%s\n", formatStatus(resp.Status))
+		b.WriteString(formatHeaders(resp.Header))
+		b.WriteString("\n")
+		b.WriteString(wrap(formatBody(resp.Body), width))
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// formatHeaders returns the headers sorted by name, one per line
+func formatHeaders(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", k, strings.Join(h[k], ", "))
+	}
+	return b.String()
+}
+
+// formatBody pretty-prints JSON bodies, other bodies are returned as-is
+func formatBody(body string) string {
+	if body == "" {
+		return "(empty body)"
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(body), "", "  "); err == nil {
+		return out.String()
+	}
+
+	// streaming responses are a list of "data: {json}" lines, so try to pretty-print each event
+	if strings.HasPrefix(body, "data:") {
+		lines := strings.Split(body, "\n")
+		for i, line := range lines {
+			data, ok := strings.CutPrefix(line, "data: ")
+			if !ok {
+				continue
+			}
+			out.Reset()
+			if err := json.Indent(&out, []byte(data), "", "  "); err == nil {
+				lines[i] = "data: " + out.String()
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	return body
+}
+
+func formatStatus(code int) string {
+	if code == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", code)
+}
+
+func formatTokens(e *addons.TrafficEvent) string {
+	if e.InputTokens == 0 && e.OutputTokens == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", e.InputTokens, e.OutputTokens)
+}
+
+func formatLatency(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return fmt.Sprintf("%.2fs", d.Seconds())
+}
+
+// fit truncates or pads the text to exactly width cells
+func fit(text string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+
+	runes := []rune(text)
+	if len(runes) > width {
+		if width == 1 {
+			return "…"
+		}
+		return string(runes[:width-1]) + "…"
+	}
+	return text + strings.Repeat(" ", width-len(runes))
+}
+
+// wrap hard-wraps long lines, so long prompts are readable in the viewport
+func wrap(text string, width int) string {
+	if width <= 0 {
+		return text
+	}
+
+	var b strings.Builder
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		runes := []rune(line)
+		for len(runes) > width {
+			b.WriteString(string(runes[:width]))
+			b.WriteString("\n")
+			runes = runes[width:]
+		}
+		b.WriteString(string(runes))
+	}
+	return b.String()
+}
@@ -0,0 +1,241 @@
+// Package virtualkeys stores the proxy-issued API keys, and the usage of each key, in a bolt
+// database. Only a SHA-256 hash of each key is stored, so the keys are shown once, when created.
+//
+// Bolt allows one process to open the database at a time, so the proxy and the keys command open
+// it briefly for each change, instead of holding it open.
+package virtualkeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// KeyPrefix starts every virtual key, so they can be told apart from the provider keys
+	KeyPrefix = "llmp-"
+
+	keysBucket = "virtual_keys"
+
+	// DefaultOpenTimeout is how long to wait for another process to close the database
+	DefaultOpenTimeout = 5 * time.Second
+)
+
+// ErrKeyNotFound is returned when no key has the requested ID or name
+var ErrKeyNotFound = errors.New("virtual key not found")
+
+// Usage is the traffic sent with a virtual key
+type Usage struct {
+	Requests     int64      `json:"requests"`
+	InputTokens  int64      `json:"input_tokens"`
+	OutputTokens int64      `json:"output_tokens"`
+	Cost         float64    `json:"cost_usd"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Add sums the usage, and keeps the latest LastUsedAt
+func (u *Usage) Add(other Usage) {
+	u.Requests += other.Requests
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.Cost += other.Cost
+	if other.LastUsedAt != nil && (u.LastUsedAt == nil || other.LastUsedAt.After(*u.LastUsedAt)) {
+		u.LastUsedAt = other.LastUsedAt
+	}
+}
+
+// Key is a proxy-issued API key, mapped to a real provider key in the secret store
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Secret    string     `json:"secret"` // name of the real provider key in the secret store
+	Hash      string     `json:"hash"`   // SHA-256 of the key
+	Hint      string     `json:"hint"`   // the start of the key, to help find it
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Usage     Usage      `json:"usage"`
+}
+
+// IsRevoked returns true when the key can no longer be used
+func (k *Key) IsRevoked() bool {
+	return k.RevokedAt != nil
+}
+
+// Store is a bolt database of virtual keys
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the database file, waiting up to the timeout for another process to close it
+func Open(fileName string, timeout time.Duration) (*Store, error) {
+	if fileName == "" {
+		return nil, errors.New("virtual keys database file name is empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create virtual keys database directory: %w", err)
+	}
+
+	db, err := bolt.Open(fileName, 0o600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open virtual keys database %s: %w", fileName, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(keysBucket))
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("unable to create virtual keys bucket: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Create makes a new virtual key for the secret, and returns the key. The key is not stored, so
+// this is the only time it's available.
+func (s *Store) Create(name, secret string, now time.Time) (string, *Key, error) {
+	if name == "" || secret == "" {
+		return "", nil, errors.New("a name and a secret are required")
+	}
+
+	keys, err := s.List()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, k := range keys {
+		if k.Name == name && !k.IsRevoked() {
+			return "", nil, fmt.Errorf("a virtual key named %q already exists", name)
+		}
+	}
+
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, fmt.Errorf("unable to generate a key: %w", err)
+	}
+	plaintext := KeyPrefix + hex.EncodeToString(random)
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, fmt.Errorf("unable to generate a key ID: %w", err)
+	}
+
+	key := &Key{
+		ID:        "vk_" + hex.EncodeToString(id),
+		Name:      name,
+		Secret:    secret,
+		Hash:      HashKey(plaintext),
+		Hint:      plaintext[:len(KeyPrefix)+4] + "...",
+		CreatedAt: now.UTC(),
+	}
+	if err := s.put(key); err != nil {
+		return "", nil, err
+	}
+	return plaintext, key, nil
+}
+
+// List returns every key, including the revoked keys, oldest first
+func (s *Store) List() ([]Key, error) {
+	keys := make([]Key, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(keysBucket)).ForEach(func(_, v []byte) error {
+			var key Key
+			if err := json.Unmarshal(v, &key); err != nil {
+				return fmt.Errorf("unable to parse virtual key: %w", err)
+			}
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Revoke disables the key with the ID, or the active key with the name
+func (s *Store) Revoke(idOrName string, now time.Time) (*Key, error) {
+	keys, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		if k.ID != idOrName && (k.Name != idOrName || k.IsRevoked()) {
+			continue
+		}
+		if k.IsRevoked() {
+			return &k, nil
+		}
+		revokedAt := now.UTC()
+		k.RevokedAt = &revokedAt
+		if err := s.put(&k); err != nil {
+			return nil, err
+		}
+		return &k, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, idOrName)
+}
+
+// AddUsage adds the usage of each key ID. Usage for keys that no longer exist is dropped.
+func (s *Store) AddUsage(usage map[string]Usage) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(keysBucket))
+		for id, u := range usage {
+			v := bucket.Get([]byte(id))
+			if v == nil {
+				continue
+			}
+			var key Key
+			if err := json.Unmarshal(v, &key); err != nil {
+				return fmt.Errorf("unable to parse virtual key %s: %w", id, err)
+			}
+			key.Usage.Add(u)
+			data, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(id), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// put saves the key
+func (s *Store) put(key *Key) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(keysBucket)).Put([]byte(key.ID), data)
+	})
+}
+
+// HashKey returns the SHA-256 of a virtual key, as stored in the database
+func HashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsVirtualKey returns true when the API key was issued by this proxy
+func IsVirtualKey(apiKey string) bool {
+	return strings.HasPrefix(apiKey, KeyPrefix)
+}
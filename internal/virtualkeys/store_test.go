@@ -0,0 +1,67 @@
+package virtualkeys
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	fileName := filepath.Join(t.TempDir(), "keys", "virtual_keys.db")
+	now := time.Now()
+
+	s, err := Open(fileName, time.Second)
+	require.NoError(t, err)
+
+	plaintext, key, err := s.Create("team-a", "openai-prod", now)
+	require.NoError(t, err)
+	assert.True(t, IsVirtualKey(plaintext))
+	assert.Equal(t, HashKey(plaintext), key.Hash)
+	assert.Contains(t, key.Hint, KeyPrefix)
+	assert.NotContains(t, key.Hash, plaintext)
+
+	_, _, err = s.Create("team-a", "openai-prod", now)
+	assert.Error(t, err, "names are unique")
+	_, _, err = s.Create("", "openai-prod", now)
+	assert.Error(t, err)
+
+	_, other, err := s.Create("team-b", "openai-prod", now.Add(time.Second))
+	require.NoError(t, err)
+
+	used := now.Add(time.Minute)
+	require.NoError(t, s.AddUsage(map[string]Usage{
+		key.ID:   {Requests: 2, InputTokens: 10, OutputTokens: 5, Cost: 0.5, LastUsedAt: &used},
+		"vk_old": {Requests: 1},
+	}))
+	require.NoError(t, s.Close())
+
+	// reopen, to check that everything was saved
+	s, err = Open(fileName, time.Second)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	keys, err := s.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "team-a", keys[0].Name)
+	assert.Equal(t, int64(2), keys[0].Usage.Requests)
+	assert.InDelta(t, 0.5, keys[0].Usage.Cost, 0.0001)
+	assert.True(t, used.Equal(*keys[0].Usage.LastUsedAt))
+
+	revoked, err := s.Revoke("team-a", now)
+	require.NoError(t, err)
+	assert.True(t, revoked.IsRevoked())
+	revoked, err = s.Revoke(other.ID, now)
+	require.NoError(t, err)
+	assert.Equal(t, "team-b", revoked.Name)
+
+	_, err = s.Revoke("team-c", now)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, _, err = s.Create("team-a", "openai-prod", now)
+	assert.NoError(t, err, "the name of a revoked key can be used again")
+}
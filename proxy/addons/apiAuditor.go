@@ -6,12 +6,16 @@ import (
 	"sync/atomic"
 
 	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
 	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
 	"github.com/proxati/llm_proxy/v2/schema/providers"
 	"github.com/proxati/llm_proxy/v2/schema/proxyadapters/mitm"
 	px "github.com/proxati/mitmproxy/proxy"
 )
 
+const apiAuditorAddonName = "APIAuditorAddon"
+
 // APIAuditorAddon log connection and flow
 type APIAuditorAddon struct {
 	px.BaseAddon
@@ -30,10 +34,12 @@ func (aud *APIAuditorAddon) Response(f *px.Flow) {
 		return
 	} else {
 		aud.wg.Add(1) // for blocking this addon during shutdown in .Close()
+		metrics.InFlightFlows.WithLabelValues(apiAuditorAddonName).Inc()
 	}
 
 	go func() {
 		defer aud.wg.Done()
+		defer metrics.InFlightFlows.WithLabelValues(apiAuditorAddonName).Dec()
 		<-f.Done()
 
 		// only account when the request domain is supported
@@ -82,11 +88,12 @@ func (aud *APIAuditorAddon) Response(f *px.Flow) {
 			return
 		}
 
-		// show the transaction
+		// show the transaction, the model is the one sent upstream when it was rewritten by an alias
 		aud.auditLogger.Info(
 			"Transaction Received",
 			"URL", auditOutput.URL,
 			"Model", auditOutput.Model,
+			"OriginalModel", f.Request.Header.Get(headers.OriginalModel),
 			"InputCost", auditOutput.InputCost,
 			"OutputCost", auditOutput.OutputCost,
 			"TotalReqCost", auditOutput.TotalReqCost,
@@ -95,6 +102,40 @@ func (aud *APIAuditorAddon) Response(f *px.Flow) {
 	}()
 }
 
+// auditFlowCost adds the tokens and cost of a successful response from a known API host to the
+// CostCounter, and returns them. Returns nil for the other flows, which aren't counted.
+func auditFlowCost(costCounter *schema.CostCounter, f *px.Flow) (*schema.AuditOutput, error) {
+	if f.Request == nil || f.Request.URL == nil || f.Response == nil {
+		return nil, nil
+	}
+	if _, ok := providers.APIHostnames[f.Request.URL.Hostname()]; !ok {
+		return nil, nil
+	}
+	if _, ok := cacheOnlyResponseCodes[f.Response.StatusCode]; !ok {
+		return nil, nil
+	}
+
+	emptyFilter := config.NewHeaderFilterGroup("empty", []string{}, []string{})
+	req, err := schema.NewProxyRequest(mitm.NewProxyRequestAdapter(f.Request), emptyFilter)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := schema.NewProxyResponse(mitm.NewProxyResponseAdapter(f.Response), emptyFilter)
+	if err != nil {
+		return nil, err
+	}
+	return costCounter.Add(*req, *resp)
+}
+
+// GetCostCounter returns the CostCounter used to account the cost of each transaction
+func (aud *APIAuditorAddon) GetCostCounter() *schema.CostCounter {
+	return aud.costCounter
+}
+
+func (aud *APIAuditorAddon) String() string {
+	return apiAuditorAddonName
+}
+
 func (aud *APIAuditorAddon) Close() error {
 	if !aud.closed.Swap(true) {
 		aud.logger.Debug("Closing...")
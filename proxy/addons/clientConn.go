@@ -0,0 +1,144 @@
+package addons
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// maxRequestHeadBytes is the largest request head that's read by a firstRequestConn
+const maxRequestHeadBytes = 64 << 10
+
+// firstRequestHandler is called with the head of the first request on a client connection. The
+// err is set when the head is larger than maxRequestHeadBytes (bufio.ErrBufferFull), or can't be
+// parsed. When the handler returns an error, the proxy gets that error instead of the request,
+// and closes the connection, so the handler should write a response first, or take over the
+// connection.
+type firstRequestHandler func(c *firstRequestConn, req *http.Request, err error) error
+
+// firstRequestConn wraps a client connection, to look at the first request before the proxy reads
+// it. This is the only way for an addon to see a CONNECT request, because the proxy library
+// handles those without calling the addons.
+//
+// Wrap the connection in ClientConnected, by replacing client.Conn.Conn. Several addons can wrap
+// the same connection, and the last one to wrap it sees the request first.
+type firstRequestConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	handler firstRequestHandler
+	headLen int
+	once    sync.Once
+	err     error
+}
+
+func newFirstRequestConn(conn net.Conn, handler firstRequestHandler) *firstRequestConn {
+	return &firstRequestConn{
+		Conn:    conn,
+		reader:  bufio.NewReaderSize(conn, maxRequestHeadBytes),
+		handler: handler,
+	}
+}
+
+// Read returns the bytes of the client connection, after the first request was handled. The
+// request head is buffered, and is read again by the proxy.
+func (c *firstRequestConn) Read(b []byte) (int, error) {
+	c.once.Do(func() {
+		c.err = c.handleFirstRequest()
+	})
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// handleFirstRequest peeks at the first request head, and calls the handler
+func (c *firstRequestConn) handleFirstRequest() error {
+	head, err := peekRequestHead(c.reader)
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return c.handler(c, nil, err)
+		}
+		// the client went away before sending a request
+		return err
+	}
+	c.headLen = len(head)
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	if err != nil {
+		return c.handler(c, nil, err)
+	}
+	return c.handler(c, req, nil)
+}
+
+// hijack returns a reader for the bytes the client sent after the first request head, for a
+// handler that takes over the connection instead of the proxy
+func (c *firstRequestConn) hijack() io.Reader {
+	_, _ = c.reader.Discard(c.headLen)
+	return c.reader
+}
+
+// writeResponse writes a response with a JSON body to the client, before the connection is closed
+func (c *firstRequestConn) writeResponse(status int, header http.Header, body []byte) error {
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "application/json")
+	resp := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         true,
+	}
+	return resp.Write(c.Conn)
+}
+
+// pipeConns copies the bytes between a client and an upstream connection, until either side closes
+// its connection. Both connections are closed when it returns. The clientReader has the bytes that
+// the client sent, including the ones that were already buffered.
+func pipeConns(logger *slog.Logger, client net.Conn, clientReader io.Reader, upstream net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := io.Copy(upstream, clientReader); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Debug("Tunnel closed by the client", "error", err)
+		}
+		// end the copy in the other direction
+		upstream.Close()
+		client.Close()
+	}()
+	if _, err := io.Copy(client, upstream); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Debug("Tunnel closed by the upstream host", "error", err)
+	}
+	upstream.Close()
+	client.Close()
+	<-done
+}
+
+// peekRequestHead returns the request line and headers, without consuming them from the reader.
+// bufio.ErrBufferFull is returned when the head is larger than the reader's buffer.
+func peekRequestHead(r *bufio.Reader) ([]byte, error) {
+	n := 1
+	for {
+		// block until n bytes are buffered, then look at everything that's buffered
+		if _, err := r.Peek(n); err != nil {
+			return nil, err
+		}
+		buf, _ := r.Peek(r.Buffered())
+		if i := bytes.Index(buf, []byte("\r\n\r\n")); i >= 0 {
+			return buf[:i+4], nil
+		}
+		if i := bytes.Index(buf, []byte("\n\n")); i >= 0 {
+			return buf[:i+2], nil
+		}
+		// wait for more bytes than are already buffered
+		n = r.Buffered() + 1
+	}
+}
@@ -0,0 +1,105 @@
+package addons
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/internal/redact"
+	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
+	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const dlpName = "DLP"
+
+// DLP finds secrets in the request bodies, like private keys, cloud credentials, API tokens, and
+// the custom patterns, before the request leaves the network. In redact mode, the secrets are
+// masked and the request is sent upstream, with the detectors in the DLP response header. In block
+// mode, the request is rejected with a 400. The body is redacted in both modes, so the secrets never
+// reach the traffic logs either. The result is saved in the internal DLPResult request header.
+type DLP struct {
+	px.BaseAddon
+	scanner *redact.Redactor
+	action  config.DLPAction
+	logger  *slog.Logger
+}
+
+// Request redacts the secrets in the body, and blocks the request in block mode
+func (d *DLP) Request(f *px.Flow) {
+	if f.Request == nil || len(f.Request.Body) == 0 {
+		return
+	}
+	logger := configLoggerFieldsWithFlow(d.logger, f).WithGroup("Request")
+
+	body, err := utils.DecodeBody(f.Request.Body, f.Request.Header.Get("Content-Encoding"))
+	if err != nil {
+		logger.Debug("Unable to decode request body, skipping DLP", "error", err)
+		return
+	}
+
+	redacted, findings := d.scanner.Scan(body)
+	if len(findings) == 0 {
+		return
+	}
+
+	setDecodedRequestBody(f.Request, redacted)
+
+	result := schema.DLPResult{Action: string(d.action), Blocked: d.action == config.DLPActionBlock, Findings: findings}
+	saveResultHeader(logger, f, headers.DLPResult, result)
+
+	for _, finding := range findings {
+		metrics.DLPFindingsTotal.WithLabelValues(finding.Detector, string(d.action)).Add(float64(finding.Count))
+	}
+	detectors := findingDetectors(findings)
+	logger.Info("DLP found secrets in a request", "action", d.action, "detectors", detectors)
+
+	if result.Blocked {
+		helpers.GenerateErrorResponse(f, http.StatusBadRequest, "invalid_request_error", "secret_detected",
+			fmt.Sprintf("The request was blocked because it contains secrets (%s), remove them and try again",
+				strings.Join(detectors, ", ")))
+	}
+}
+
+// Response adds the detectors of the redacted secrets to the response headers, in redact mode
+func (d *DLP) Response(f *px.Flow) {
+	if f.Response == nil || f.Request == nil {
+		return
+	}
+	result := decodeResultHeader[schema.DLPResult](d.logger, f, headers.DLPResult)
+	if result == nil || result.Blocked || len(result.Findings) == 0 {
+		return
+	}
+	if f.Response.Header == nil {
+		f.Response.Header = make(http.Header)
+	}
+	f.Response.Header.Set(headers.DLP, strings.Join(findingDetectors(result.Findings), ","))
+}
+
+func (d *DLP) String() string {
+	return dlpName
+}
+
+// findingDetectors returns the detector names of the findings
+func findingDetectors(findings []redact.Finding) []string {
+	detectors := make([]string, len(findings))
+	for i, finding := range findings {
+		detectors[i] = finding.Detector
+	}
+	return detectors
+}
+
+// NewDLP creates a new DLP addon, the action must be block or redact
+func NewDLP(logger *slog.Logger, scanner *redact.Redactor, action config.DLPAction) *DLP {
+	return &DLP{
+		scanner: scanner,
+		action:  action,
+		logger:  logger.WithGroup("addons.DLP"),
+	}
+}
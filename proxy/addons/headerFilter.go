@@ -0,0 +1,135 @@
+package addons
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/schema/proxyadapters/mitm"
+)
+
+const headerFilterName = "HeaderFilter"
+
+// HeaderFilter removes the RequestToUpstream headers from requests before they are sent upstream,
+// and the ResponseToClient headers from responses before they are sent to the client. The traffic
+// logs still include these headers, because the request is logged before it's filtered, and the
+// log addons keep a reference to the unfiltered response headers.
+//
+// Filtering happens in the Stream*Modifier hooks, because they run for every flow after all of the
+// Request and Response hooks, right before the headers are sent. The responses set in the Request
+// hooks, like cache hits, skip those hooks, so they're filtered in LocalResponse.
+type HeaderFilter struct {
+	px.BaseAddon
+	requestToUpstream  *config.HeaderFilterGroup
+	responseToClient   *config.HeaderFilterGroup
+	originalReqHeaders sync.Map // flow ID -> unfiltered request headers, until the upstream responds
+	logger             *slog.Logger
+}
+
+// StreamRequestModifier removes the filtered headers from the request sent upstream
+func (h *HeaderFilter) StreamRequestModifier(f *px.Flow, in io.Reader) io.Reader {
+	if f.Request == nil || h.requestToUpstream.IsEmpty() {
+		return in
+	}
+
+	filtered := h.requestToUpstream.FilterHeaders(f.Request.Header)
+	if len(filtered) == len(f.Request.Header) {
+		return in
+	}
+
+	logger := configLoggerFieldsWithFlow(h.logger, f)
+	logger.Debug("Removing request headers before sending upstream", "count", len(f.Request.Header)-len(filtered))
+
+	// other addons keep per-flow state in internal request headers, so the unfiltered headers are
+	// restored after the upstream responds
+	h.originalReqHeaders.Store(f.Id, f.Request.Header)
+	f.Request.Header = filtered
+	go func() {
+		// cleanup for when the upstream request fails, and Responseheaders is never called
+		<-f.Done()
+		h.originalReqHeaders.Delete(f.Id)
+	}()
+
+	return in
+}
+
+// Responseheaders restores the unfiltered request headers. The HeaderFilter is loaded before the
+// addons that keep per-flow state in internal request headers, so their response hooks can read it.
+func (h *HeaderFilter) Responseheaders(f *px.Flow) {
+	if original, ok := h.originalReqHeaders.LoadAndDelete(f.Id); ok {
+		f.Request.Header = original.(http.Header)
+	}
+}
+
+// StreamResponseModifier removes the filtered headers from the response sent to the client
+func (h *HeaderFilter) StreamResponseModifier(f *px.Flow, in io.Reader) io.Reader {
+	h.filterResponse(f)
+	return in
+}
+
+// LocalResponse restores the unfiltered request headers, when the response was set before this
+// addon's Responseheaders hook ran, and removes the filtered headers from the response
+func (h *HeaderFilter) LocalResponse(f *px.Flow) {
+	h.Responseheaders(f)
+	h.filterResponse(f)
+}
+
+// filterResponse removes the ResponseToClient headers from the response
+func (h *HeaderFilter) filterResponse(f *px.Flow) {
+	if f.Response == nil || h.responseToClient.IsEmpty() {
+		return
+	}
+
+	// replace the map instead of deleting from it, so the log addons still see the removed headers
+	f.Response.Header = h.responseToClient.FilterHeaders(f.Response.Header)
+}
+
+func (h *HeaderFilter) String() string {
+	return headerFilterName
+}
+
+// NewHeaderFilter creates a new HeaderFilter addon
+func NewHeaderFilter(
+	logger *slog.Logger,
+	requestToUpstream *config.HeaderFilterGroup, // headers to remove from requests sent upstream
+	responseToClient *config.HeaderFilterGroup, // headers to remove from responses sent to the client
+) *HeaderFilter {
+	return &HeaderFilter{
+		requestToUpstream: requestToUpstream,
+		responseToClient:  responseToClient,
+		logger:            logger.WithGroup("addons.HeaderFilter"),
+	}
+}
+
+// unfilteredResponseHeaders keeps a reference to the response header map of each in-flight flow,
+// so the log addons can log the headers removed by the HeaderFilter before the response is sent
+// to the client. Headers added by other addons are still included, because they modify the same map.
+type unfilteredResponseHeaders struct {
+	refs sync.Map // flow ID -> http.Header
+}
+
+// store saves a reference to the response headers, called from the Responseheaders hook
+func (u *unfilteredResponseHeaders) store(f *px.Flow) {
+	if f.Response != nil && f.Response.Header != nil {
+		u.refs.Store(f.Id, f.Response.Header)
+	}
+}
+
+// setResponse sets the response in the FlowAdapter with the unfiltered headers, when they were
+// stored for this flow. This must be called before FlowAdapter.SetFlow.
+func (u *unfilteredResponseHeaders) setResponse(f *px.Flow, fa *mitm.FlowAdapter) {
+	header, ok := u.refs.LoadAndDelete(f.Id)
+	if !ok || f.Response == nil {
+		return
+	}
+
+	fa.SetResponse(&px.Response{
+		StatusCode: f.Response.StatusCode,
+		Header:     header.(http.Header),
+		Body:       f.Response.Body,
+	})
+}
@@ -0,0 +1,163 @@
+package addons
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/proxyadapters/mitm"
+)
+
+// setHeaderFilterTestHeaders adds the internal and team headers to the request, and the upstream
+// headers that are filtered from the client to the response
+func setHeaderFilterTestHeaders(f *px.Flow) *px.Flow {
+	f.Request.Header.Set(headers.WorkflowName, "summarize")
+	f.Request.Header.Set(headers.SchemeUpgraded, "true")
+	f.Request.Header.Set("X-Team-Id", "ml")
+	f.Response.Header.Set("Openai-Processing-Ms", "120")
+	f.Response.Header.Set("X-Ratelimit-Remaining-Tokens", "1000")
+	return f
+}
+
+func TestHeaderFilter_Request(t *testing.T) {
+	hfc := config.NewHeaderFiltersContainer()
+	hfc.RequestToUpstream.Headers = []string{"X-Team-*"}
+	hfc.BuildIndexes()
+	hf := NewHeaderFilter(slog.Default(), hfc.RequestToUpstream, hfc.ResponseToClient)
+	assert.Equal(t, headerFilterName, hf.String())
+
+	f := setHeaderFilterTestHeaders(newTestFlow(t, testChatCompletionsURL, "", `{"id": "chatcmpl-123"}`))
+	body := strings.NewReader("body")
+	assert.Equal(t, body, hf.StreamRequestModifier(f, body), "the body is not modified")
+
+	// the upstream request only has the non-internal headers
+	assert.Equal(t, http.Header{"Content-Type": {"application/json"}}, f.Request.Header)
+
+	// the headers are restored for the response addons
+	hf.Responseheaders(f)
+	assert.Equal(t, "summarize", f.Request.Header.Get(headers.WorkflowName))
+	assert.Equal(t, "true", f.Request.Header.Get(headers.SchemeUpgraded))
+	assert.Equal(t, "ml", f.Request.Header.Get("X-Team-Id"))
+
+	t.Run("nothing to filter", func(t *testing.T) {
+		f := setHeaderFilterTestHeaders(newTestFlow(t, testChatCompletionsURL, "", `{"id": "chatcmpl-123"}`))
+		f.Request.Header = http.Header{"Content-Type": {"application/json"}}
+		hf.StreamRequestModifier(f, nil)
+		assert.Equal(t, http.Header{"Content-Type": {"application/json"}}, f.Request.Header)
+		_, stored := hf.originalReqHeaders.Load(f.Id)
+		assert.False(t, stored)
+	})
+}
+
+func TestHeaderFilter_Response(t *testing.T) {
+	hfc := config.NewHeaderFiltersContainer()
+	hfc.ResponseToClient.Headers = []string{"openai-processing-ms", "X-Ratelimit-*"}
+	hfc.BuildIndexes()
+	hf := NewHeaderFilter(slog.Default(), hfc.RequestToUpstream, hfc.ResponseToClient)
+	dumper := &MegaTrafficDumper{}
+
+	f := setHeaderFilterTestHeaders(newTestFlow(t, testChatCompletionsURL, "", `{"id": "chatcmpl-123"}`))
+	dumper.Responseheaders(f)
+	f.Response.Header.Set(headers.ProxyID, "abc") // added by a later addon, in the Response hook
+	hf.StreamResponseModifier(f, nil)
+
+	assert.Equal(t, http.Header{
+		"Content-Type":   {"application/json"},
+		"X-Llm_proxy-Id": {"abc"},
+	}, f.Response.Header, "client response is filtered")
+
+	// the log still has all headers
+	fa := &mitm.FlowAdapter{}
+	dumper.respHeaders.setResponse(f, fa)
+	fa.SetFlow(f)
+	logged := fa.GetResponse().GetHeaders()
+	assert.Equal(t, "120", logged.Get("Openai-Processing-Ms"))
+	assert.Equal(t, "1000", logged.Get("X-Ratelimit-Remaining-Tokens"))
+	assert.Equal(t, "abc", logged.Get(headers.ProxyID))
+	assert.Equal(t, f.Response.Body, fa.GetResponse().GetBodyBytes())
+	assert.Equal(t, http.StatusOK, fa.GetResponse().GetStatusCode())
+
+	_, stored := dumper.respHeaders.refs.Load(f.Id)
+	require.False(t, stored, "the reference is removed once the flow is logged")
+}
+
+func TestHeaderFilter_LocalResponse(t *testing.T) {
+	hfc := config.NewHeaderFiltersContainer()
+	hfc.ResponseToClient.Headers = []string{"openai-processing-ms", "X-Ratelimit-*"}
+	hfc.BuildIndexes()
+	hf := NewHeaderFilter(slog.Default(), hfc.RequestToUpstream, hfc.ResponseToClient)
+	dumper := &MegaTrafficDumper{}
+
+	cache, err := NewCacheAddon(slog.Default(), "memory", t.TempDir(), hfc.RequestToLogs, hfc.ResponseToLogs)
+	require.NoError(t, err)
+	t.Cleanup(func() { cache.Close() })
+
+	// store the upstream response with the headers that are filtered from the client
+	require.NoError(t, cache.responseStorage(setHeaderFilterTestHeaders(newTestFlow(t, testChatCompletionsURL, "", `{"id": "chatcmpl-123"}`))))
+
+	// a cache hit is set in the Request hook, which skips the response hooks
+	f := setHeaderFilterTestHeaders(newTestFlow(t, testChatCompletionsURL, "", `{"id": "chatcmpl-123"}`))
+	f.Response = nil
+	cache.Request(f)
+	require.NotNil(t, f.Response)
+	require.Equal(t, headers.CacheStatusValueHit, f.Response.Header.Get(headers.CacheStatusHeader))
+	require.Equal(t, "120", f.Response.Header.Get("Openai-Processing-Ms"))
+
+	dumper.LocalResponse(f)
+	hf.LocalResponse(f)
+	assert.Empty(t, f.Response.Header.Get("Openai-Processing-Ms"), "client response is filtered")
+	assert.Empty(t, f.Response.Header.Get("X-Ratelimit-Remaining-Tokens"))
+	assert.Equal(t, headers.CacheStatusValueHit, f.Response.Header.Get(headers.CacheStatusHeader))
+
+	// the log still has all headers
+	fa := &mitm.FlowAdapter{}
+	dumper.respHeaders.setResponse(f, fa)
+	fa.SetFlow(f)
+	logged := fa.GetResponse().GetHeaders()
+	assert.Equal(t, "120", logged.Get("Openai-Processing-Ms"))
+	assert.Equal(t, "1000", logged.Get("X-Ratelimit-Remaining-Tokens"))
+}
+
+func TestHeaderFilter_LocalResponseFromResponseheaders(t *testing.T) {
+	hfc := config.NewHeaderFiltersContainer()
+	hfc.RequestToUpstream.Headers = []string{"X-Team-*"}
+	hfc.ResponseToClient.Headers = []string{"openai-processing-ms", "X-Ratelimit-*"}
+	hfc.BuildIndexes()
+	hf := NewHeaderFilter(slog.Default(), hfc.RequestToUpstream, hfc.ResponseToClient)
+	limits := NewLimits(slog.Default(), &config.RequestLimits{MaxResponseBodySize: 10})
+	dumper := &MegaTrafficDumper{}
+
+	f := setHeaderFilterTestHeaders(newTestFlow(t, testChatCompletionsURL, "", `{"id": "chatcmpl-123"}`))
+	hf.StreamRequestModifier(f, nil)
+	require.Empty(t, f.Request.Header.Get("X-Team-Id"))
+
+	// the upstream response headers, before the body is read
+	f.Response.Body = nil
+	f.Response.Header.Set("Content-Length", "100")
+	dumper.Responseheaders(f)
+	limits.Responseheaders(f)
+	require.Equal(t, http.StatusRequestEntityTooLarge, f.Response.StatusCode)
+
+	// the HeaderFilter's Responseheaders hook didn't run, so the restore happens here
+	dumper.LocalResponse(f)
+	hf.LocalResponse(f)
+	assert.Equal(t, "ml", f.Request.Header.Get("X-Team-Id"))
+	assert.Equal(t, "summarize", f.Request.Header.Get(headers.WorkflowName))
+	_, stored := hf.originalReqHeaders.Load(f.Id)
+	assert.False(t, stored)
+
+	// the log has the error response, not the upstream headers
+	fa := &mitm.FlowAdapter{}
+	dumper.respHeaders.setResponse(f, fa)
+	fa.SetFlow(f)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, fa.GetResponse().GetStatusCode())
+	assert.Empty(t, fa.GetResponse().GetHeaders().Get("Openai-Processing-Ms"))
+	assert.Equal(t, "ml", fa.GetRequest().GetHeaders().Get("X-Team-Id"))
+}
@@ -0,0 +1,53 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	px "github.com/proxati/mitmproxy/proxy"
+)
+
+// errorBody is the error format used by the OpenAI API, so SDKs can parse errors from this proxy
+type errorBody struct {
+	Error errorDetails `json:"error"`
+}
+
+type errorDetails struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+// GenerateErrorResponse attaches a JSON error response to the flow, in the same format as the
+// OpenAI API. When the proxy sees the response != nil, it will skip the rest of the addons.
+func GenerateErrorResponse(f *px.Flow, statusCode int, errType, code, message string) {
+	f.Response = &px.Response{
+		StatusCode: statusCode,
+		Body:       NewErrorBody(errType, code, message),
+		Header: http.Header{
+			"Content-Type": {"application/json"},
+		},
+	}
+}
+
+// ReplaceWithErrorResponse replaces the upstream response of the flow with a JSON error, in the same
+// format as the OpenAI API. The response is changed in place, so the log addons that keep a
+// reference to the response header map see the error, and the other headers are kept.
+func ReplaceWithErrorResponse(f *px.Flow, statusCode int, errType, code, message string) {
+	if f.Response == nil || f.Response.Header == nil {
+		GenerateErrorResponse(f, statusCode, errType, code, message)
+		return
+	}
+	f.Response.StatusCode = statusCode
+	f.Response.Body = NewErrorBody(errType, code, message)
+	f.Response.Header.Del("Content-Encoding")
+	f.Response.Header.Del("Content-Length")
+	f.Response.Header.Set("Content-Type", "application/json")
+}
+
+// NewErrorBody returns a JSON error body in the same format as the OpenAI API, for responses that
+// aren't attached to a flow
+func NewErrorBody(errType, code, message string) []byte {
+	body, _ := json.Marshal(errorBody{Error: errorDetails{Message: message, Type: errType, Code: code}})
+	return body
+}
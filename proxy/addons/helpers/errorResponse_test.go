@@ -0,0 +1,50 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateErrorResponse(t *testing.T) {
+	t.Parallel()
+	f := &px.Flow{}
+	GenerateErrorResponse(f, http.StatusBadGateway, "upstream_error", "all_upstreams_failed", "no upstream available")
+
+	require.NotNil(t, f.Response)
+	assert.Equal(t, http.StatusBadGateway, f.Response.StatusCode)
+	assert.Equal(t, "application/json", f.Response.Header.Get("Content-Type"))
+
+	var body map[string]map[string]string
+	require.NoError(t, json.Unmarshal(f.Response.Body, &body))
+	assert.Equal(t, map[string]string{
+		"message": "no upstream available",
+		"type":    "upstream_error",
+		"code":    "all_upstreams_failed",
+	}, body["error"])
+}
+
+func TestReplaceWithErrorResponse(t *testing.T) {
+	t.Parallel()
+	header := http.Header{
+		"Content-Type":     {"application/json"},
+		"Content-Encoding": {"gzip"},
+		"Content-Length":   {"1234"},
+		"X-Request-Id":     {"req-1"},
+	}
+	f := &px.Flow{Response: &px.Response{StatusCode: http.StatusOK, Header: header, Body: []byte("gzipped")}}
+	ReplaceWithErrorResponse(f, http.StatusBadRequest, "invalid_request_error", "content_filter", "blocked")
+
+	assert.Equal(t, http.StatusBadRequest, f.Response.StatusCode)
+	assert.JSONEq(t, `{"error": {"message": "blocked", "type": "invalid_request_error", "code": "content_filter"}}`, string(f.Response.Body))
+	assert.Equal(t, http.Header{"Content-Type": {"application/json"}, "X-Request-Id": {"req-1"}}, header, "changed in place")
+
+	f = &px.Flow{}
+	ReplaceWithErrorResponse(f, http.StatusBadRequest, "invalid_request_error", "content_filter", "blocked")
+	require.NotNil(t, f.Response)
+	assert.Equal(t, http.StatusBadRequest, f.Response.StatusCode)
+}
@@ -0,0 +1,17 @@
+package helpers
+
+import (
+	"context"
+
+	px "github.com/proxati/mitmproxy/proxy"
+)
+
+// FlowContext returns the context of the client request, which is canceled when the client
+// disconnects or the flow ends, for the calls an addon makes while handling the flow. Flows without
+// a client request, like the ones built in tests, get a background context.
+func FlowContext(f *px.Flow) context.Context {
+	if f == nil || f.Request == nil || f.Request.Raw() == nil {
+		return context.Background()
+	}
+	return f.Request.Raw().Context()
+}
@@ -0,0 +1,19 @@
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	px "github.com/proxati/mitmproxy/proxy"
+)
+
+func TestFlowContext(t *testing.T) {
+	t.Parallel()
+
+	for _, f := range []*px.Flow{nil, {}, {Request: &px.Request{}}} {
+		ctx := FlowContext(f)
+		assert.NotNil(t, ctx)
+		assert.NoError(t, ctx.Err(), "flows without a client request get a background context")
+	}
+}
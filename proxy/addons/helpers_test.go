@@ -0,0 +1,37 @@
+package addons
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/require"
+)
+
+const testChatCompletionsURL = "https://api.openai.com/v1/chat/completions"
+
+// newTestFlow returns a POST flow to the URL with JSON bodies. The flow has no response when
+// respBody is empty, like a flow in the request hooks.
+func newTestFlow(t *testing.T, rawURL, reqBody, respBody string) *px.Flow {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+
+	f := &px.Flow{
+		Request: &px.Request{
+			Method: http.MethodPost,
+			URL:    u,
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   []byte(reqBody),
+		},
+	}
+	if respBody != "" {
+		f.Response = &px.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       []byte(respBody),
+		}
+	}
+	return f
+}
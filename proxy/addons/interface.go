@@ -1,7 +1,31 @@
 package addons
 
+import px "github.com/proxati/mitmproxy/proxy"
+
 // ClosableAddon is an interface that defines an addon that can be closed
 type ClosableAddon interface {
 	String() string
 	Close() error
 }
+
+// UpstreamSender is an addon that sends some requests upstream itself, instead of the proxy
+// library, e.g. to pick a different upstream host or to retry. The metaAddon calls SendUpstream of
+// the first sender whose Handles returns true, after the Request hooks, and then runs the response
+// hooks of all addons on the response set by the sender.
+//
+// SendUpstream reads the full response, up to MaxUpstreamResponseSize, before the response hooks
+// run. So Handles returns false for the requests with a streamed response (see isStreamingRequest),
+// which are left to the proxy library, and the events aren't held until the end of the stream.
+type UpstreamSender interface {
+	String() string
+	Handles(f *px.Flow) bool
+	SendUpstream(f *px.Flow, body []byte) error
+}
+
+// LocalResponseHandler is an addon that handles the responses set in the Requestheaders or Request
+// hooks, like cache hits and error responses. The proxy library sends these to the client without
+// calling the response hooks, so the metaAddon calls LocalResponse right before they're sent.
+type LocalResponseHandler interface {
+	String() string
+	LocalResponse(f *px.Flow)
+}
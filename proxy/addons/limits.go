@@ -0,0 +1,222 @@
+package addons
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const limitsName = "Limits"
+
+// limit names, for the metrics and the logs
+const (
+	limitRequestBodySize  = "request_body_size"
+	limitResponseBodySize = "response_body_size"
+	limitMessages         = "messages"
+	limitTokens           = "tokens"
+)
+
+// Limits rejects the requests with a body, messages list, or estimated token count that is over the
+// limits, and the responses with a body that is over the limit, with a 413. Each violation is logged
+// and counted in the metrics.
+//
+// The sizes are checked with the Content-Length header before the bodies are read, and with the
+// body in the Request and Response hooks. The bodies that are larger than the proxy library's
+// stream threshold skip those hooks, so they're cut off at the limit while they're streamed.
+type Limits struct {
+	px.BaseAddon
+	limits *config.RequestLimits
+	logger *slog.Logger
+}
+
+// Requestheaders rejects the requests with a Content-Length over the max request body size, before
+// the body is read
+func (l *Limits) Requestheaders(f *px.Flow) {
+	if f.Request == nil || l.limits.MaxRequestBodySize <= 0 {
+		return
+	}
+	size := requestContentLength(f.Request)
+	if size > l.limits.MaxRequestBodySize {
+		l.rejectRequest(f, limitRequestBodySize, "request_too_large", size, l.limits.MaxRequestBodySize,
+			fmt.Sprintf("The request body is %d bytes, which is over the limit of %d bytes", size, l.limits.MaxRequestBodySize))
+	}
+}
+
+// Request rejects the requests with a body, messages list, or estimated token count over the limits
+func (l *Limits) Request(f *px.Flow) {
+	if f.Request == nil {
+		return
+	}
+
+	if maxSize := l.limits.MaxRequestBodySize; maxSize > 0 && int64(len(f.Request.Body)) > maxSize {
+		size := int64(len(f.Request.Body))
+		l.rejectRequest(f, limitRequestBodySize, "request_too_large", size, maxSize,
+			fmt.Sprintf("The request body is %d bytes, which is over the limit of %d bytes", size, maxSize))
+		return
+	}
+	if len(f.Request.Body) == 0 || (l.limits.MaxMessages <= 0 && l.limits.MaxRequestTokens <= 0) {
+		return
+	}
+
+	if maxMessages := l.limits.MaxMessages; maxMessages > 0 {
+		body, err := utils.DecodeBody(f.Request.Body, f.Request.Header.Get("Content-Encoding"))
+		if err != nil {
+			body = f.Request.Body
+		}
+		if count := len(utils.ChatMessages(body)); count > maxMessages {
+			l.rejectRequest(f, limitMessages, "too_many_messages", int64(count), int64(maxMessages),
+				fmt.Sprintf("The request has %d messages, which is over the limit of %d messages", count, maxMessages))
+			return
+		}
+	}
+
+	if maxTokens := l.limits.MaxRequestTokens; maxTokens > 0 {
+		if tokens := int64(estimateRequestTokens(f.Request)); tokens > int64(maxTokens) {
+			l.rejectRequest(f, limitTokens, "too_many_tokens", tokens, int64(maxTokens),
+				fmt.Sprintf("The request has about %d tokens, including the max output tokens, which is over the limit of %d tokens", tokens, maxTokens))
+		}
+	}
+}
+
+// rejectRequest logs the violation, and responds to the request with a 413
+func (l *Limits) rejectRequest(f *px.Flow, limit, code string, value, maxValue int64, message string) {
+	metrics.LimitViolationsTotal.WithLabelValues(limit).Inc()
+	configLoggerFieldsWithFlow(l.logger, f).WithGroup("Request").Warn(
+		"Rejected a request over the limit", "limit", limit, "value", value, "max", maxValue,
+		"model", getRequestModel(f.Request),
+	)
+	helpers.GenerateErrorResponse(f, http.StatusRequestEntityTooLarge, "invalid_request_error", code, message)
+}
+
+// Responseheaders responds with a 413 when the Content-Length is over the max response body size,
+// before the body is read. The proxy library sends this response without calling the Response
+// hooks, so it's a new response, instead of the upstream one with its headers.
+func (l *Limits) Responseheaders(f *px.Flow) {
+	if f.Response == nil || f.Response.Body != nil || l.limits.MaxResponseBodySize <= 0 {
+		return
+	}
+	size, err := strconv.ParseInt(f.Response.Header.Get("Content-Length"), 10, 64)
+	if err == nil && size > l.limits.MaxResponseBodySize {
+		helpers.GenerateErrorResponse(f, http.StatusRequestEntityTooLarge, "upstream_error", "response_too_large",
+			l.rejectResponse(f, size))
+	}
+}
+
+// Response replaces the responses with a body over the max response body size
+func (l *Limits) Response(f *px.Flow) {
+	if f.Response == nil || l.limits.MaxResponseBodySize <= 0 {
+		return
+	}
+	if size := int64(len(f.Response.Body)); size > l.limits.MaxResponseBodySize {
+		helpers.ReplaceWithErrorResponse(f, http.StatusRequestEntityTooLarge, "upstream_error", "response_too_large",
+			l.rejectResponse(f, size))
+	}
+}
+
+// rejectResponse logs the violation, and returns the error message for the 413 response
+func (l *Limits) rejectResponse(f *px.Flow, size int64) string {
+	maxSize := l.limits.MaxResponseBodySize
+	metrics.LimitViolationsTotal.WithLabelValues(limitResponseBodySize).Inc()
+	configLoggerFieldsWithFlow(l.logger, f).WithGroup("Response").Warn(
+		"Rejected a response over the limit", "limit", limitResponseBodySize, "value", size, "max", maxSize,
+		"status", f.Response.StatusCode,
+	)
+	return fmt.Sprintf("The upstream response body is %d bytes, which is over the limit of %d bytes", size, maxSize)
+}
+
+// StreamRequestModifier cuts off the streamed request bodies at the max request body size, the
+// upstream request fails when the limit is reached
+func (l *Limits) StreamRequestModifier(f *px.Flow, in io.Reader) io.Reader {
+	if !f.Stream || l.limits.MaxRequestBodySize <= 0 {
+		return in
+	}
+	return newMaxBytesReader(in, l.limits.MaxRequestBodySize, func() {
+		metrics.LimitViolationsTotal.WithLabelValues(limitRequestBodySize).Inc()
+		configLoggerFieldsWithFlow(l.logger, f).WithGroup("Request").Warn(
+			"Cut off a streamed request over the limit", "limit", limitRequestBodySize,
+			"max", l.limits.MaxRequestBodySize,
+		)
+	})
+}
+
+// StreamResponseModifier cuts off the streamed response bodies at the max response body size
+func (l *Limits) StreamResponseModifier(f *px.Flow, in io.Reader) io.Reader {
+	if !f.Stream || l.limits.MaxResponseBodySize <= 0 {
+		return in
+	}
+	return newMaxBytesReader(in, l.limits.MaxResponseBodySize, func() {
+		metrics.LimitViolationsTotal.WithLabelValues(limitResponseBodySize).Inc()
+		configLoggerFieldsWithFlow(l.logger, f).WithGroup("Response").Warn(
+			"Cut off a streamed response over the limit", "limit", limitResponseBodySize,
+			"max", l.limits.MaxResponseBodySize,
+		)
+	})
+}
+
+func (l *Limits) String() string {
+	return limitsName
+}
+
+// NewLimits creates a new Limits addon with the limits
+func NewLimits(logger *slog.Logger, limits *config.RequestLimits) *Limits {
+	return &Limits{
+		limits: limits,
+		logger: logger.WithGroup("addons.Limits"),
+	}
+}
+
+// requestContentLength returns the Content-Length of the request, or -1 when it's unknown
+func requestContentLength(req *px.Request) int64 {
+	if raw := req.Raw(); raw != nil {
+		return raw.ContentLength
+	}
+	size, err := strconv.ParseInt(req.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// errBodyTooLarge is returned by maxBytesReader and doUpstream when the body is over the limit
+var errBodyTooLarge = errors.New("the body is over the size limit")
+
+// maxBytesReader reads up to max bytes, and then returns errBodyTooLarge instead of the rest of the
+// body. onExceeded is called once, when the limit is reached.
+type maxBytesReader struct {
+	r          io.Reader
+	remaining  int64 // -1 after the limit is reached
+	onExceeded func()
+}
+
+func newMaxBytesReader(r io.Reader, limit int64, onExceeded func()) *maxBytesReader {
+	return &maxBytesReader{r: r, remaining: limit, onExceeded: onExceeded}
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	// read one more byte than the limit, to know if the body is over it
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err := m.r.Read(p)
+	if int64(n) <= m.remaining {
+		m.remaining -= int64(n)
+		return n, err
+	}
+	n = int(m.remaining)
+	m.remaining = -1
+	m.onExceeded()
+	return n, errBodyTooLarge
+}
@@ -0,0 +1,161 @@
+package addons
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+)
+
+func TestLimits(t *testing.T) {
+	l := NewLimits(slog.Default(), &config.RequestLimits{
+		MaxRequestBodySize:  200,
+		MaxResponseBodySize: 20,
+		MaxMessages:         2,
+		MaxRequestTokens:    30,
+	})
+	assert.Equal(t, limitsName, l.String())
+
+	t.Run("allowed", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`, "")
+		l.Requestheaders(f)
+		l.Request(f)
+		assert.Nil(t, f.Response)
+	})
+
+	t.Run("content length over the limit", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, "", "")
+		f.Request.Header.Set("Content-Length", "5000000")
+		l.Requestheaders(f)
+		require.NotNil(t, f.Response)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, f.Response.StatusCode)
+		assert.JSONEq(t, `{"error": {
+			"message": "The request body is 5000000 bytes, which is over the limit of 200 bytes",
+			"type": "invalid_request_error",
+			"code": "request_too_large"
+		}}`, string(f.Response.Body))
+	})
+
+	t.Run("body over the limit", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, `{"image": "`+strings.Repeat("A", 300)+`"}`, "")
+		l.Requestheaders(f)
+		assert.Nil(t, f.Response)
+		l.Request(f)
+		require.NotNil(t, f.Response)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, f.Response.StatusCode)
+		assert.Contains(t, string(f.Response.Body), `"code":"request_too_large"`)
+	})
+
+	t.Run("too many messages", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, `{"messages": [
+			{"role": "user", "content": "a"}, {"role": "assistant", "content": "b"}, {"role": "user", "content": "c"}
+		]}`, "")
+		l.Request(f)
+		require.NotNil(t, f.Response)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, f.Response.StatusCode)
+		assert.JSONEq(t, `{"error": {
+			"message": "The request has 3 messages, which is over the limit of 2 messages",
+			"type": "invalid_request_error",
+			"code": "too_many_messages"
+		}}`, string(f.Response.Body))
+	})
+
+	t.Run("too many tokens", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o", "max_tokens": 100}`, "")
+		l.Request(f)
+		require.NotNil(t, f.Response)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, f.Response.StatusCode)
+		assert.Contains(t, string(f.Response.Body), `"code":"too_many_tokens"`)
+	})
+
+	t.Run("response content length over the limit", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, "", "{}")
+		f.Response.Body = nil
+		f.Response.Header.Set("Content-Length", "100")
+		f.Response.Header.Set("Openai-Organization", "org-1")
+		l.Responseheaders(f)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, f.Response.StatusCode)
+		assert.Contains(t, string(f.Response.Body), `"code":"response_too_large"`)
+		assert.Empty(t, f.Response.Header.Get("Content-Length"))
+		assert.Empty(t, f.Response.Header.Get("Openai-Organization"), "the upstream headers aren't sent with the error")
+		assert.Equal(t, "application/json", f.Response.Header.Get("Content-Type"))
+	})
+
+	t.Run("response body over the limit", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, "", `{"choices": [{"text": "a long answer"}]}`)
+		l.Response(f)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, f.Response.StatusCode)
+		assert.JSONEq(t, `{"error": {
+			"message": "The upstream response body is 40 bytes, which is over the limit of 20 bytes",
+			"type": "upstream_error",
+			"code": "response_too_large"
+		}}`, string(f.Response.Body))
+	})
+
+	t.Run("response under the limit", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, "", `{"ok": true}`)
+		l.Responseheaders(f)
+		l.Response(f)
+		assert.Equal(t, http.StatusOK, f.Response.StatusCode)
+		assert.Equal(t, `{"ok": true}`, string(f.Response.Body))
+	})
+
+	t.Run("streamed bodies", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, "", "")
+		in := bytes.NewReader(bytes.Repeat([]byte("a"), 100))
+		assert.Same(t, in, l.StreamResponseModifier(f, in), "bodies that aren't streamed are checked in the hooks")
+
+		f.Stream = true
+		body, err := io.ReadAll(l.StreamResponseModifier(f, in))
+		assert.ErrorIs(t, err, errBodyTooLarge)
+		assert.Len(t, body, 20)
+
+		body, err = io.ReadAll(l.StreamRequestModifier(f, strings.NewReader("small")))
+		assert.NoError(t, err)
+		assert.Equal(t, "small", string(body))
+	})
+}
+
+func TestMaxBytesReader(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		body     string
+		limit    int64
+		exceeded bool
+	}{
+		{"empty", "", 5, false},
+		{"under", "abc", 5, false},
+		{"exactly", "abcde", 5, false},
+		{"over", "abcdef", 5, true},
+		{"zero limit", "a", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			r := newMaxBytesReader(strings.NewReader(tt.body), tt.limit, func() { calls++ })
+			body, err := io.ReadAll(r)
+			if !tt.exceeded {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.body, string(body))
+				assert.Zero(t, calls)
+				return
+			}
+			assert.ErrorIs(t, err, errBodyTooLarge)
+			assert.Equal(t, tt.body[:tt.limit], string(body))
+			assert.Equal(t, 1, calls)
+
+			_, err = r.Read(make([]byte, 10))
+			assert.ErrorIs(t, err, errBodyTooLarge)
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
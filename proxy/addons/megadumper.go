@@ -1,6 +1,7 @@
 package addons
 
 import (
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"sync"
@@ -10,18 +11,27 @@ import (
 	px "github.com/proxati/mitmproxy/proxy"
 
 	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/internal/redact"
 	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
 	md "github.com/proxati/llm_proxy/v2/proxy/addons/megadumper"
 	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
 	"github.com/proxati/llm_proxy/v2/schema/proxyadapters/mitm"
 )
 
+const megaTrafficDumperName = "MegaTrafficDumper"
+
 type MegaTrafficDumper struct {
 	px.BaseAddon
 	logSources            config.LogSourceConfig
 	logDestinationConfigs []md.LogDestination
 	filterReqHeaders      *config.HeaderFilterGroup
 	filterRespHeaders     *config.HeaderFilterGroup
+	logRules              *config.LogRules
+	redactor              *redact.Redactor
+	respHeaders           unfilteredResponseHeaders
+	observers             []FlowObserver
 	wg                    sync.WaitGroup
 	closed                atomic.Bool
 	logger                *slog.Logger
@@ -38,6 +48,7 @@ func (d *MegaTrafficDumper) Requestheaders(f *px.Flow) {
 		return
 	} else {
 		d.wg.Add(1) // for blocking this addon during shutdown in .Close()
+		metrics.InFlightFlows.WithLabelValues(megaTrafficDumperName).Inc()
 	}
 
 	// store a copy of the request in a FlowAdapter right away
@@ -47,16 +58,32 @@ func (d *MegaTrafficDumper) Requestheaders(f *px.Flow) {
 	go func() {
 		logger.Debug("Request starting...")
 		defer d.wg.Done()
+		defer metrics.InFlightFlows.WithLabelValues(megaTrafficDumperName).Dec()
 		start := time.Now()
 		<-f.Done() // block this goroutine until the entire flow is done
-		doneAt := time.Since(start).Milliseconds()
+		duration := time.Since(start)
+		doneAt := duration.Milliseconds()
 		logger := configLoggerFieldsWithFlow(d.logger, f)
 
+		for _, o := range d.observers {
+			o.ObserveFlow(f, duration)
+		}
+
 		// save the other fields in the FlowAdapter
+		d.respHeaders.setResponse(f, fa)
 		fa.SetFlow(f)
 
+		// check the log rules, to decide if (and what) to log for this flow
+		decision := d.decideLogging(f, time.Duration(doneAt)*time.Millisecond)
+		if !decision.Log {
+			logger.Debug("Skipping traffic log, flow was not sampled", "rule", decision.SampledBy)
+			metrics.LogRecordsSampledOutTotal.WithLabelValues(decision.SampledBy).Inc()
+			return
+		}
+
 		// load the selected fields into a container object
-		dumpContainer := d.convertFlowToLogDump(logger, fa, doneAt)
+		dumpContainer := d.convertFlowToLogDump(logger, fa, doneAt, decision)
+		addFlowResults(logger, f, dumpContainer)
 
 		// write the formatted log data to... somewhere
 		d.sendToLogDestinations(logger, f.Id.String(), dumpContainer)
@@ -65,8 +92,66 @@ func (d *MegaTrafficDumper) Requestheaders(f *px.Flow) {
 	}()
 }
 
+// Responseheaders keeps a reference to the response headers, to log them before they're filtered
+func (d *MegaTrafficDumper) Responseheaders(f *px.Flow) {
+	d.respHeaders.store(f)
+}
+
+// LocalResponse keeps a reference to the headers of a response that was set in the Request hooks,
+// like a cache hit, to log them before they're filtered
+func (d *MegaTrafficDumper) LocalResponse(f *px.Flow) {
+	d.respHeaders.store(f)
+}
+
+// AddFlowObserver calls the observer with each completed flow, and its duration. The observers are
+// added before the proxy starts.
+func (d *MegaTrafficDumper) AddFlowObserver(o FlowObserver) {
+	d.observers = append(d.observers, o)
+}
+
+// addFlowResults adds the results that the other addons saved in the request headers to the log
+// container. The headers are set after the request was copied in Requestheaders, so they're read
+// from the flow.
+func addFlowResults(logger *slog.Logger, f *px.Flow, dumpContainer *schema.LogDumpContainer) {
+	if dumpContainer == nil || f.Request == nil {
+		return
+	}
+	dumpContainer.OriginalModel = f.Request.Header.Get(headers.OriginalModel)
+	dumpContainer.Policy = decodeResultHeader[config.PolicyDecision](logger, f, headers.PolicyDecision)
+	dumpContainer.DLP = decodeResultHeader[schema.DLPResult](logger, f, headers.DLPResult)
+	dumpContainer.PromptGuard = decodeResultHeader[schema.PromptGuardResult](logger, f, headers.PromptGuardResult)
+	dumpContainer.SchemaValidation = decodeResultHeader[schema.SchemaValidationResult](logger, f, headers.SchemaValidationResult)
+	dumpContainer.Moderation = decodeResultHeader[schema.ModerationResult](logger, f, headers.ModerationResult)
+}
+
+// saveResultHeader saves the JSON result of a guardrail addon in an internal request header, for
+// its own response hooks and the traffic logs
+func saveResultHeader[T any](logger *slog.Logger, f *px.Flow, name string, result T) {
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		logger.Error("Unable to encode the result header", "header", name, "error", err)
+		return
+	}
+	f.Request.Header.Set(name, string(encoded))
+}
+
+// decodeResultHeader returns the JSON result that a guardrail addon saved in an internal request
+// header, or nil
+func decodeResultHeader[T any](logger *slog.Logger, f *px.Flow, name string) *T {
+	encoded := f.Request.Header.Get(name)
+	if encoded == "" {
+		return nil
+	}
+	result := new(T)
+	if err := json.Unmarshal([]byte(encoded), result); err != nil {
+		logger.Error("Unable to parse the result header", "header", name, "error", err)
+		return nil
+	}
+	return result
+}
+
 func (d *MegaTrafficDumper) String() string {
-	return "MegaTrafficDumper"
+	return megaTrafficDumperName
 }
 
 func (d *MegaTrafficDumper) Close() error {
@@ -78,14 +163,63 @@ func (d *MegaTrafficDumper) Close() error {
 	return nil
 }
 
-// convertFlowToLogDump creates a LogDumpContainer from a px.Flow object
-func (d *MegaTrafficDumper) convertFlowToLogDump(logger *slog.Logger, flowAdapter *mitm.FlowAdapter, doneAt int64) *schema.LogDumpContainer {
+// decideLogging evaluates the log rules for a completed flow. Without rules, every flow is logged.
+func (d *MegaTrafficDumper) decideLogging(f *px.Flow, latency time.Duration) config.LogRuleDecision {
+	if d.logRules == nil {
+		return config.LogRuleDecision{Log: true}
+	}
+
+	in := config.LogRuleInput{Latency: latency}
+	if f.Request != nil {
+		in.Workflow = f.Request.Header.Get(headers.WorkflowName)
+		in.Model = getRequestModel(f.Request)
+		if f.Request.URL != nil {
+			in.Host = f.Request.URL.Hostname()
+			in.Path = f.Request.URL.Path
+		}
+	}
+	if f.Response != nil {
+		in.StatusCode = f.Response.StatusCode
+	}
+
+	return d.logRules.Decide(in)
+}
+
+// convertFlowToLogDump creates a LogDumpContainer from a px.Flow object, without the parts that
+// are omitted by the log rules decision
+func (d *MegaTrafficDumper) convertFlowToLogDump(
+	logger *slog.Logger,
+	flowAdapter *mitm.FlowAdapter,
+	doneAt int64,
+	decision config.LogRuleDecision,
+) *schema.LogDumpContainer {
+	logSources := d.logSources
+	if decision.Omits(config.LogOmitConnectionStats) {
+		logSources.LogConnectionStats = false
+	}
+	if decision.Omits(config.LogOmitRequestHeaders) {
+		logSources.LogRequestHeaders = false
+	}
+	if decision.Omits(config.LogOmitResponseHeaders) {
+		logSources.LogResponseHeaders = false
+	}
+
 	// load the selected fields into a container object
-	dumpContainer, err := schema.NewLogDumpContainerFromFlowAdapter(flowAdapter, d.logSources, doneAt, d.filterReqHeaders, d.filterRespHeaders)
+	dumpContainer, err := schema.NewLogDumpContainerFromFlowAdapter(
+		flowAdapter, logSources, doneAt, d.filterReqHeaders, d.filterRespHeaders, d.redactor,
+	)
 	if err != nil {
 		logger.Error("Could not create LogDumpContainer", "error", err)
 		return nil
 	}
+
+	// only the body is dropped, so the method, URL, and status are still logged
+	if decision.Omits(config.LogOmitRequestBody) && dumpContainer.Request != nil {
+		dumpContainer.Request.Body = ""
+	}
+	if decision.Omits(config.LogOmitResponseBody) && dumpContainer.Response != nil {
+		dumpContainer.Response.Body = ""
+	}
 	return dumpContainer
 }
 
@@ -109,6 +243,7 @@ func (d *MegaTrafficDumper) sendToLogDestinations(logger *slog.Logger, id string
 			bytesWritten, err := ldc.Write(id, output)
 			if err != nil {
 				wLogger.Error("Could not write log", "error", err)
+				metrics.LogWriteFailuresTotal.WithLabelValues(ldc.String()).Inc()
 				return
 			}
 			wLogger.Info("Wrote log", "bytesWritten", bytesWritten)
@@ -125,6 +260,8 @@ func NewMegaTrafficDumperAddon(
 	logSources config.LogSourceConfig, // which fields from the transaction to log
 	filterReqHeaders *config.HeaderFilterGroup, // which headers to filter out from the request before logging
 	filterRespHeaders *config.HeaderFilterGroup, // which headers to filter out from the response before logging
+	logRules *config.LogRules, // optional rules to sample or trim the log for each flow
+	redactor *redact.Redactor, // optional, masks sensitive data in the bodies before logging
 ) (*MegaTrafficDumper, error) {
 	logger = logger.WithGroup("addons.MegaTrafficDumper")
 	logger.Debug("Set log output", "logTarget", logTarget)
@@ -142,6 +279,8 @@ func NewMegaTrafficDumperAddon(
 		logDestinationConfigs: logDestinationConfigs,
 		filterReqHeaders:      filterReqHeaders,
 		filterRespHeaders:     filterRespHeaders,
+		logRules:              logRules,
+		redactor:              redactor,
 		logger:                logger,
 	}
 
@@ -1,12 +1,19 @@
 package addons
 
 import (
+	"encoding/json"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/redact"
+	"github.com/proxati/llm_proxy/v2/schema/proxyadapters/mitm"
 	px "github.com/proxati/mitmproxy/proxy"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewMegaDumpAddon(t *testing.T) {
@@ -19,7 +26,7 @@ func TestNewMegaDumpAddon(t *testing.T) {
 		logTarget := "/tmp/logs"
 		logFormat := config.LogFormatJSON
 		mda, err := NewMegaTrafficDumperAddon(
-			testLogger, logTarget, logFormat, logSources, hfc.RequestToLogs, hfc.ResponseToLogs)
+			testLogger, logTarget, logFormat, logSources, hfc.RequestToLogs, hfc.ResponseToLogs, nil, nil)
 
 		assert.NoError(t, err)
 		assert.NotNil(t, mda)
@@ -32,7 +39,7 @@ func TestNewMegaDumpAddon(t *testing.T) {
 		logFormat := config.LogFormatTXT
 
 		mda, err := NewMegaTrafficDumperAddon(
-			testLogger, logTarget, logFormat, logSources, hfc.RequestToLogs, hfc.ResponseToLogs)
+			testLogger, logTarget, logFormat, logSources, hfc.RequestToLogs, hfc.ResponseToLogs, nil, nil)
 
 		assert.NoError(t, err)
 		assert.NotNil(t, mda)
@@ -45,7 +52,7 @@ func TestNewMegaDumpAddon(t *testing.T) {
 		logFormat := config.LogFormatTXT
 
 		mda, err := NewMegaTrafficDumperAddon(
-			testLogger, logTarget, logFormat, logSources, hfc.RequestToLogs, hfc.ResponseToLogs)
+			testLogger, logTarget, logFormat, logSources, hfc.RequestToLogs, hfc.ResponseToLogs, nil, nil)
 
 		assert.NoError(t, err)
 		assert.NotNil(t, mda)
@@ -64,7 +71,7 @@ func TestMegaTrafficDumper_Requestheaders_ValidFlow(t *testing.T) {
 	filterHeaders := config.NewHeaderFiltersContainer()
 
 	mda, err := NewMegaTrafficDumperAddon(
-		testLogger, logTarget, logFormat, logSources, filterHeaders.RequestToLogs, filterHeaders.ResponseToLogs)
+		testLogger, logTarget, logFormat, logSources, filterHeaders.RequestToLogs, filterHeaders.ResponseToLogs, nil, nil)
 	assert.NoError(t, err)
 	assert.NotNil(t, mda)
 
@@ -90,7 +97,7 @@ func TestMegaTrafficDumper_Close(t *testing.T) {
 	filterHeaders := config.NewHeaderFiltersContainer()
 
 	mda, err := NewMegaTrafficDumperAddon(
-		testLogger, logTarget, logFormat, logSources, filterHeaders.RequestToLogs, filterHeaders.ResponseToLogs)
+		testLogger, logTarget, logFormat, logSources, filterHeaders.RequestToLogs, filterHeaders.ResponseToLogs, nil, nil)
 	assert.NoError(t, err)
 	assert.NotNil(t, mda)
 
@@ -100,6 +107,138 @@ func TestMegaTrafficDumper_Close(t *testing.T) {
 	})
 }
 
+func TestMegaTrafficDumper_LogRules(t *testing.T) {
+	t.Parallel()
+	testLogger := slog.Default()
+	filterHeaders := config.NewHeaderFiltersContainer()
+
+	logRules, err := config.NewLogRulesFromJSON([]byte(`{"rules": [
+		{"name": "errors", "match": {"status": ["4xx", "5xx"]}, "sample_percent": 100},
+		{"name": "embeddings", "match": {"path": "/v1/embeddings"}, "omit": ["request_body", "response_body", "request_headers"]},
+		{"name": "successes", "sample_percent": 0}
+	]}`))
+	require.NoError(t, err)
+
+	mda, err := NewMegaTrafficDumperAddon(
+		testLogger, "", config.LogFormatJSON, config.LogSourceConfigAllTrue,
+		filterHeaders.RequestToLogs, filterHeaders.ResponseToLogs, logRules, nil)
+	require.NoError(t, err)
+
+	newFlow := func(path string, status int) *px.Flow {
+		return &px.Flow{
+			Request: &px.Request{
+				Method: http.MethodPost,
+				URL:    &url.URL{Scheme: "https", Host: "api.openai.com", Path: path},
+				Header: http.Header{"User-Agent": []string{"test"}},
+				Body:   []byte(`{"model": "text-embedding-3-small", "input": "hello"}`),
+			},
+			Response: &px.Response{
+				StatusCode: status,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       []byte(`{"data": []}`),
+			},
+		}
+	}
+
+	t.Run("successes are sampled out", func(t *testing.T) {
+		decision := mda.decideLogging(newFlow("/v1/chat/completions", http.StatusOK), time.Second)
+		assert.False(t, decision.Log)
+		assert.Equal(t, "successes", decision.SampledBy)
+	})
+
+	t.Run("errors are logged without bodies", func(t *testing.T) {
+		f := newFlow("/v1/embeddings", http.StatusInternalServerError)
+		decision := mda.decideLogging(f, time.Second)
+		require.True(t, decision.Log)
+		assert.Equal(t, "errors", decision.SampledBy)
+
+		ldc := mda.convertFlowToLogDump(testLogger, mitm.NewFlowAdapter(f), 1000, decision)
+		require.NotNil(t, ldc)
+		assert.Equal(t, "/v1/embeddings", ldc.Request.URL.Path, "request details are still logged")
+		assert.Empty(t, ldc.Request.Body)
+		assert.Nil(t, ldc.Request.Header)
+		assert.Empty(t, ldc.Response.Body)
+		assert.Equal(t, "application/json", ldc.Response.Header.Get("Content-Type"))
+		assert.Equal(t, http.StatusInternalServerError, ldc.Response.Status)
+	})
+
+	t.Run("no rules", func(t *testing.T) {
+		noRules, err := NewMegaTrafficDumperAddon(
+			testLogger, "", config.LogFormatJSON, config.LogSourceConfigAllTrue,
+			filterHeaders.RequestToLogs, filterHeaders.ResponseToLogs, nil, nil)
+		require.NoError(t, err)
+
+		f := newFlow("/v1/embeddings", http.StatusOK)
+		decision := noRules.decideLogging(f, time.Second)
+		assert.True(t, decision.Log)
+
+		ldc := noRules.convertFlowToLogDump(testLogger, mitm.NewFlowAdapter(f), 1000, decision)
+		require.NotNil(t, ldc)
+		assert.Contains(t, ldc.Request.Body, "hello")
+		assert.NotNil(t, ldc.Request.Header)
+	})
+}
+
+func TestMegaTrafficDumper_Redaction(t *testing.T) {
+	t.Parallel()
+	testLogger := slog.Default()
+	filterHeaders := config.NewHeaderFiltersContainer()
+
+	mda, err := NewMegaTrafficDumperAddon(
+		testLogger, "", config.LogFormatJSON, config.LogSourceConfigAllTrue,
+		filterHeaders.RequestToLogs, filterHeaders.ResponseToLogs, nil, redact.NewDefault())
+	require.NoError(t, err)
+
+	f := &px.Flow{
+		Request: &px.Request{
+			Method: http.MethodPost,
+			URL:    &url.URL{Scheme: "https", Host: "api.openai.com", Path: "/v1/chat/completions"},
+			Header: http.Header{"User-Agent": []string{"test"}},
+			Body:   []byte(`{"messages": [{"role": "user", "content": "my card is 4111 1111 1111 1111"}]}`),
+		},
+		Response: &px.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       []byte(`{"content": "I'll email bob@example.com"}`),
+		},
+	}
+
+	ldc := mda.convertFlowToLogDump(testLogger, mitm.NewFlowAdapter(f), 1000, config.LogRuleDecision{Log: true})
+	require.NotNil(t, ldc)
+	assert.Equal(t, `{"messages": [{"role": "user", "content": "my card is [REDACTED:credit_card]"}]}`, ldc.Request.Body)
+	assert.Equal(t, `{"content": "I'll email [REDACTED:email]"}`, ldc.Response.Body)
+	assert.Contains(t, string(f.Request.Body), "4111", "the proxied request is not modified")
+}
+
+func TestMegaTrafficDumper_OriginalModel(t *testing.T) {
+	t.Parallel()
+	testLogger := slog.Default()
+	filterHeaders := config.NewHeaderFiltersContainer()
+
+	mda, err := NewMegaTrafficDumperAddon(
+		testLogger, "", config.LogFormatJSON, config.LogSourceConfigAllTrue,
+		filterHeaders.RequestToLogs, filterHeaders.ResponseToLogs, nil, nil)
+	require.NoError(t, err)
+
+	aliases, err := config.NewModelAliasesFromJSON([]byte(`{"aliases": {"gpt-4": "gpt-4o"}}`))
+	require.NoError(t, err)
+
+	// the request is copied in Requestheaders, before the ModelAlias addon rewrites it in Request
+	f := newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4"}`, `{"model": "gpt-4o"}`)
+	fa := &mitm.FlowAdapter{}
+	fa.SetRequest(f.Request)
+	NewModelAlias(testLogger, aliases).Request(f)
+	fa.SetFlow(f)
+
+	ldc := mda.convertFlowToLogDump(testLogger, fa, 1000, config.LogRuleDecision{Log: true})
+	addFlowResults(testLogger, f, ldc)
+	require.NotNil(t, ldc)
+
+	record, err := json.Marshal(ldc)
+	require.NoError(t, err)
+	assert.Contains(t, string(record), `"original_model":"gpt-4"`)
+}
+
 // unable to get this test working, because .Done() isn't working as expected
 /*
 func TestMegaTrafficDumper_LogWriting(t *testing.T) {
@@ -117,7 +256,7 @@ func TestMegaTrafficDumper_LogWriting(t *testing.T) {
 	filterRespHeaders := []string{}
 
 	mda, err := NewMegaTrafficDumperAddon(
-		testLogger, logTarget, logFormat, logSources, filterReqHeaders, filterRespHeaders)
+		testLogger, logTarget, logFormat, logSources, filterReqHeaders, filterRespHeaders, nil, nil)
 	assert.NoError(t, err)
 	assert.NotNil(t, mda)
 
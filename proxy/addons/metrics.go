@@ -0,0 +1,206 @@
+package addons
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/providers"
+	"github.com/proxati/llm_proxy/v2/schema/providers/openai"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const (
+	metricsAddonName    = "MetricsAddon"
+	metricsUnknownLabel = "unknown"
+
+	// metricsOtherLabel replaces the hosts and models that aren't known, so clients can't create an
+	// unlimited number of label values
+	metricsOtherLabel = "other"
+)
+
+// FlowObserver is called with each completed flow, and the time from when the request headers were
+// received until the flow was done
+type FlowObserver interface {
+	ObserveFlow(f *px.Flow, duration time.Duration)
+}
+
+// MetricsAddon counts requests, tokens, and cost, and measures the latency of each flow, for the
+// prometheus endpoint. When the MegaTrafficDumper is loaded, the flows are observed with its timing,
+// otherwise they're timed the same way: from when the request headers are received until the flow
+// is done.
+//
+// The host and model labels are limited to the known API hosts, the configured intercept hosts, and
+// the models in the pricing data. Other values are counted as "other".
+type MetricsAddon struct {
+	px.BaseAddon
+	hosts         map[string]struct{}
+	costCounter   *schema.CostCounter
+	timedByDumper atomic.Bool
+	wg            sync.WaitGroup
+	closed        atomic.Bool
+	logger        *slog.Logger
+}
+
+func (m *MetricsAddon) Requestheaders(f *px.Flow) {
+	if m.timedByDumper.Load() {
+		// observed by the MegaTrafficDumper, when the flow is done
+		return
+	}
+	logger := configLoggerFieldsWithFlow(m.logger, f)
+
+	if m.closed.Load() {
+		logger.Warn("MetricsAddon is being closed, not recording request")
+		return
+	} else {
+		m.wg.Add(1) // for blocking this addon during shutdown in .Close()
+		metrics.InFlightFlows.WithLabelValues(metricsAddonName).Inc()
+	}
+
+	go func() {
+		defer m.wg.Done()
+		defer metrics.InFlightFlows.WithLabelValues(metricsAddonName).Dec()
+		start := time.Now()
+		<-f.Done() // block this goroutine until the entire flow is done
+		m.ObserveFlow(f, time.Since(start))
+	}()
+}
+
+// UseDumperTiming observes the flows with the timing of the MegaTrafficDumper, instead of timing
+// them in this addon
+func (m *MetricsAddon) UseDumperTiming(d *MegaTrafficDumper) {
+	d.AddFlowObserver(m)
+	m.timedByDumper.Store(true)
+}
+
+// ObserveFlow records the request counter, latency histogram, and the tokens and cost of a
+// completed flow
+func (m *MetricsAddon) ObserveFlow(f *px.Flow, duration time.Duration) {
+	host := metricsUnknownLabel
+	model := metricsUnknownLabel
+	status := "0"
+
+	if f.Request != nil {
+		if f.Request.URL != nil && f.Request.URL.Hostname() != "" {
+			host = m.hostLabel(f.Request.URL.Hostname())
+		}
+		if reqModel := getRequestModel(f.Request); reqModel != "" {
+			model = modelLabel(reqModel)
+		}
+	}
+
+	if f.Response != nil {
+		status = strconv.Itoa(f.Response.StatusCode)
+	}
+
+	metrics.RequestsTotal.WithLabelValues(host, model, status).Inc()
+	metrics.UpstreamLatency.WithLabelValues(host, model).Observe(duration.Seconds())
+	m.observeCost(f)
+}
+
+// observeCost records the tokens and cost of a successful upstream response, for the APIs that are
+// supported by the CostCounter. Cached responses didn't use any tokens, so they're skipped.
+func (m *MetricsAddon) observeCost(f *px.Flow) {
+	if f.Response == nil || f.Response.Header.Get(headers.CacheStatusHeader) == headers.CacheStatusValueHit {
+		return
+	}
+	auditOutput, err := auditFlowCost(m.costCounter, f)
+	if err != nil {
+		configLoggerFieldsWithFlow(m.logger, f).Debug("Unable to count the tokens", "error", err)
+		return
+	}
+	if auditOutput == nil {
+		return
+	}
+
+	model := modelLabel(auditOutput.Model)
+	metrics.TokensTotal.WithLabelValues(model, "input").Add(float64(auditOutput.InputTokens))
+	metrics.TokensTotal.WithLabelValues(model, "output").Add(float64(auditOutput.OutputTokens))
+	metrics.CostTotal.WithLabelValues(model, auditOutput.Currency).Add(auditOutput.TotalReqCostValue)
+}
+
+// hostLabel returns the host, or "other" when it's not a known host
+func (m *MetricsAddon) hostLabel(host string) string {
+	if _, ok := m.hosts[strings.ToLower(host)]; ok {
+		return host
+	}
+	return metricsOtherLabel
+}
+
+// pricedModels returns the names of the models in the pricing data
+var pricedModels = sync.OnceValue(func() map[string]struct{} {
+	models := make(map[string]struct{})
+	for _, endpoint := range openai.APIEndpointData {
+		for _, product := range endpoint.Products {
+			models[product.Name] = struct{}{}
+		}
+	}
+	return models
+})
+
+// modelLabel returns the model, or "other" when it's not in the pricing data
+func modelLabel(model string) string {
+	if _, ok := pricedModels()[model]; ok {
+		return model
+	}
+	return metricsOtherLabel
+}
+
+func (m *MetricsAddon) String() string {
+	return metricsAddonName
+}
+
+func (m *MetricsAddon) Close() error {
+	if !m.closed.Swap(true) {
+		m.logger.Debug("Closing...")
+		m.wg.Wait()
+	}
+
+	return nil
+}
+
+// getRequestModel decodes the request body and returns the "model" field, or an empty string
+func getRequestModel(req *px.Request) string {
+	if req == nil || len(req.Body) == 0 {
+		return ""
+	}
+
+	body, err := utils.DecodeBody(req.Body, req.Header.Get("Content-Encoding"))
+	if err != nil {
+		return ""
+	}
+
+	model, err := openai.GetModelFromRequestBody(body)
+	if err != nil {
+		return ""
+	}
+	return model
+}
+
+// NewMetricsAddon creates a new MetricsAddon. The hosts are used as host labels, with the known API
+// hosts, the globs are ignored.
+func NewMetricsAddon(logger *slog.Logger, hosts []string) *MetricsAddon {
+	m := &MetricsAddon{
+		hosts:       make(map[string]struct{}, len(providers.APIHostnames)+len(hosts)),
+		costCounter: schema.NewCostCounterDefaults(),
+		logger:      logger.WithGroup("addons.MetricsAddon"),
+	}
+	for host := range providers.APIHostnames {
+		m.hosts[host] = struct{}{}
+	}
+	for _, host := range hosts {
+		if !strings.ContainsAny(host, "*?[") {
+			m.hosts[strings.ToLower(strings.TrimSpace(host))] = struct{}{}
+		}
+	}
+	m.closed.Store(false) // initialize as open
+	return m
+}
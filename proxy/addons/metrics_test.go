@@ -0,0 +1,124 @@
+package addons
+
+import (
+	"log/slog"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+func TestNewMetricsAddon(t *testing.T) {
+	m := NewMetricsAddon(slog.Default(), nil)
+	assert.NotNil(t, m)
+	assert.Equal(t, "MetricsAddon", m.String())
+	assert.NoError(t, m.Close())
+}
+
+func TestMetricsAddon_ObserveFlow(t *testing.T) {
+	m := NewMetricsAddon(slog.Default(), []string{"metrics-test.example.com", "*.openai.azure.com"})
+
+	t.Run("chat completion", func(t *testing.T) {
+		f := &px.Flow{
+			Request: &px.Request{
+				URL:    &url.URL{Scheme: "https", Host: "metrics-test.example.com", Path: "/v1/chat/completions"},
+				Header: map[string][]string{},
+				Body:   []byte(`{"model": "gpt-4o"}`),
+			},
+			Response: &px.Response{StatusCode: 200},
+		}
+		counter := metrics.RequestsTotal.WithLabelValues("metrics-test.example.com", "gpt-4o", "200")
+		before := testutil.ToFloat64(counter)
+
+		m.ObserveFlow(f, 250*time.Millisecond)
+		assert.Equal(t, before+1, testutil.ToFloat64(counter))
+	})
+
+	t.Run("unknown host and model", func(t *testing.T) {
+		f := &px.Flow{
+			Request: &px.Request{
+				URL:    &url.URL{Scheme: "https", Host: "random-1234.openai.azure.com", Path: "/v1/chat/completions"},
+				Header: map[string][]string{},
+				Body:   []byte(`{"model": "made-up-model-1234"}`),
+			},
+			Response: &px.Response{StatusCode: 404},
+		}
+		counter := metrics.RequestsTotal.WithLabelValues(metricsOtherLabel, metricsOtherLabel, "404")
+		before := testutil.ToFloat64(counter)
+
+		m.ObserveFlow(f, time.Millisecond)
+		assert.Equal(t, before+1, testutil.ToFloat64(counter))
+	})
+
+	t.Run("missing response and body", func(t *testing.T) {
+		f := &px.Flow{
+			Request: &px.Request{
+				URL:    &url.URL{Scheme: "https", Host: "metrics-test.example.com"},
+				Header: map[string][]string{},
+			},
+		}
+		counter := metrics.RequestsTotal.WithLabelValues("metrics-test.example.com", metricsUnknownLabel, "0")
+		before := testutil.ToFloat64(counter)
+
+		m.ObserveFlow(f, time.Millisecond)
+		assert.Equal(t, before+1, testutil.ToFloat64(counter))
+	})
+
+	t.Run("tokens", func(t *testing.T) {
+		newFlow := func(cacheStatus string) *px.Flow {
+			return &px.Flow{
+				Request: &px.Request{
+					URL:    &url.URL{Scheme: "https", Host: "api.openai.com", Path: "/v1/chat/completions"},
+					Header: map[string][]string{},
+					Body:   []byte(`{"model": "gpt-3.5-turbo", "messages": [{"role": "user", "content": "hi"}]}`),
+				},
+				Response: &px.Response{
+					StatusCode: 200,
+					Header:     map[string][]string{headers.CacheStatusHeader: {cacheStatus}},
+					Body:       []byte(`{"model": "gpt-3.5-turbo", "usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}}`),
+				},
+			}
+		}
+		input := metrics.TokensTotal.WithLabelValues("gpt-3.5-turbo", "input")
+		output := metrics.TokensTotal.WithLabelValues("gpt-3.5-turbo", "output")
+		beforeInput, beforeOutput := testutil.ToFloat64(input), testutil.ToFloat64(output)
+
+		m.ObserveFlow(newFlow(headers.CacheStatusValueMiss), time.Millisecond)
+		assert.Equal(t, beforeInput+10, testutil.ToFloat64(input))
+		assert.Equal(t, beforeOutput+5, testutil.ToFloat64(output))
+
+		m.ObserveFlow(newFlow(headers.CacheStatusValueHit), time.Millisecond)
+		assert.Equal(t, beforeInput+10, testutil.ToFloat64(input), "cached responses didn't use tokens")
+	})
+}
+
+func TestMetricsAddon_UseDumperTiming(t *testing.T) {
+	m := NewMetricsAddon(slog.Default(), nil)
+	filterHeaders := config.NewHeaderFiltersContainer()
+	d, err := NewMegaTrafficDumperAddon(
+		slog.Default(), "", config.LogFormatJSON, config.LogSourceConfigAllTrue,
+		filterHeaders.RequestToLogs, filterHeaders.ResponseToLogs, nil, nil)
+	require.NoError(t, err)
+
+	m.UseDumperTiming(d)
+	assert.Equal(t, []FlowObserver{m}, d.observers)
+
+	// the flows are observed by the dumper, so no goroutine waits for the flow here
+	m.Requestheaders(&px.Flow{Request: &px.Request{Header: map[string][]string{}}})
+	assert.NoError(t, m.Close())
+}
+
+func TestGetRequestModel(t *testing.T) {
+	assert.Equal(t, "", getRequestModel(nil))
+	assert.Equal(t, "", getRequestModel(&px.Request{Header: map[string][]string{}}))
+	assert.Equal(t, "", getRequestModel(&px.Request{Header: map[string][]string{}, Body: []byte("not json")}))
+	assert.Equal(t, "gpt-4", getRequestModel(&px.Request{Header: map[string][]string{}, Body: []byte(`{"model":"gpt-4"}`)}))
+}
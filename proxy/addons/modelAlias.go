@@ -0,0 +1,79 @@
+package addons
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/jsonpatch"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const modelAliasName = "ModelAlias"
+
+// ModelAlias rewrites the model field in request bodies with the model alias table, before the
+// request is cached or sent upstream. The requested model is saved in the internal OriginalModel
+// request header, for the traffic logs, and the cost is accounted with the rewritten model.
+type ModelAlias struct {
+	px.BaseAddon
+	aliases *config.ModelAliases
+	logger  *slog.Logger
+}
+
+// Request rewrites the model field, when the requested model has an alias
+func (m *ModelAlias) Request(f *px.Flow) {
+	if f.Request == nil || len(f.Request.Body) == 0 {
+		return
+	}
+	logger := configLoggerFieldsWithFlow(m.logger, f).WithGroup("Request")
+
+	body, err := utils.DecodeBody(f.Request.Body, f.Request.Header.Get("Content-Encoding"))
+	if err != nil {
+		logger.Debug("Unable to decode request body, skipping model alias", "error", err)
+		return
+	}
+
+	var req struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Model == "" {
+		// not a JSON body with a model
+		return
+	}
+
+	target, ok := m.aliases.Lookup(req.Model)
+	if !ok {
+		return
+	}
+
+	value, err := json.Marshal(target)
+	if err != nil {
+		logger.Error("Unable to encode the target model", "error", err)
+		return
+	}
+	patch := jsonpatch.Patch{{Op: jsonpatch.OpReplace, Path: "/model", Value: value}}
+	newBody, err := patch.Apply(body)
+	if err != nil {
+		logger.Warn("Unable to rewrite the model", "model", req.Model, "error", err)
+		return
+	}
+
+	setDecodedRequestBody(f.Request, newBody)
+	f.Request.Header.Set(headers.OriginalModel, req.Model)
+	logger.Debug("Rewrote model", "originalModel", req.Model, "model", target)
+}
+
+func (m *ModelAlias) String() string {
+	return modelAliasName
+}
+
+// NewModelAlias creates a new ModelAlias addon with the alias table
+func NewModelAlias(logger *slog.Logger, aliases *config.ModelAliases) *ModelAlias {
+	return &ModelAlias{
+		aliases: aliases,
+		logger:  logger.WithGroup("addons.ModelAlias"),
+	}
+}
@@ -0,0 +1,59 @@
+package addons
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log/slog"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+func TestModelAlias(t *testing.T) {
+	aliases, err := config.NewModelAliasesFromJSON([]byte(`{"aliases": {"gpt-4": "gpt-4o"}}`))
+	require.NoError(t, err)
+	m := NewModelAlias(slog.Default(), aliases)
+	assert.Equal(t, modelAliasName, m.String())
+
+	t.Run("rewritten", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4", "messages": []}`, "")
+		m.Request(f)
+		assert.JSONEq(t, `{"model": "gpt-4o", "messages": []}`, string(f.Request.Body))
+		assert.Equal(t, "gpt-4", f.Request.Header.Get(headers.OriginalModel))
+		assert.Equal(t, strconv.Itoa(len(f.Request.Body)), f.Request.Header.Get("Content-Length"))
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte(`{"model": "gpt-4"}`))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		f := newTestFlow(t, testChatCompletionsURL, buf.String(), "")
+		f.Request.Header.Set("Content-Encoding", "gzip")
+		m.Request(f)
+		assert.JSONEq(t, `{"model": "gpt-4o"}`, string(f.Request.Body))
+		assert.Empty(t, f.Request.Header.Get("Content-Encoding"))
+	})
+
+	unchanged := map[string]string{
+		"no alias":   `{"model": "gpt-4o-mini"}`,
+		"no model":   `{"input": "hello"}`,
+		"not a json": `model=gpt-4`,
+		"not string": `{"model": 4}`,
+	}
+	for name, body := range unchanged {
+		t.Run(name, func(t *testing.T) {
+			f := newTestFlow(t, testChatCompletionsURL, body, "")
+			m.Request(f)
+			assert.Equal(t, body, string(f.Request.Body))
+			assert.Empty(t, f.Request.Header.Get(headers.OriginalModel))
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package addons
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/internal/moderation"
+	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
+	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const moderationName = "Moderation"
+
+// Moderation sends the chat messages of each request to a moderation backend before the request is
+// sent upstream, and optionally the generated text of the response before it's sent to the client.
+// Depending on the category thresholds, the request or response is allowed, annotated with the
+// Moderation response header, or replaced with a 400 error. The verdicts are saved in the internal
+// ModerationResult request header, for the traffic logs, and the request verdict is kept by the
+// addon until the response, which doesn't trust the header.
+type Moderation struct {
+	px.BaseAddon
+	moderator *moderation.Moderator
+	results   sync.Map // flow ID -> *schema.ModerationResult of the request, until the response or the end of the flow
+	logger    *slog.Logger
+}
+
+// Request moderates the chat messages, and blocks the request when a category is over its block
+// threshold
+func (m *Moderation) Request(f *px.Flow) {
+	if f.Request == nil || len(f.Request.Body) == 0 {
+		return
+	}
+	logger := configLoggerFieldsWithFlow(m.logger, f).WithGroup("Request")
+
+	body, err := utils.DecodeBody(f.Request.Body, f.Request.Header.Get("Content-Encoding"))
+	if err != nil {
+		logger.Debug("Unable to decode request body, skipping moderation", "error", err)
+		return
+	}
+
+	texts := make([]string, 0)
+	for _, msg := range utils.ChatMessages(body) {
+		if msg.Text != "" && slices.Contains(m.moderator.Roles(), msg.Role) {
+			texts = append(texts, msg.Text)
+		}
+	}
+	if len(texts) == 0 {
+		return
+	}
+
+	verdict := m.moderate(logger, f, moderation.StageRequest, texts)
+	result := &schema.ModerationResult{Backend: m.moderator.Backend(), Request: &verdict}
+	m.results.Store(f.Id, result)
+	go func() {
+		// cleanup for the flows that skip the Response hook, like upstream errors and streamed bodies
+		<-f.Done()
+		m.results.Delete(f.Id)
+	}()
+	saveResultHeader(logger, f, headers.ModerationResult, result)
+
+	if verdict.Action == moderation.ActionBlock {
+		helpers.GenerateErrorResponse(f, http.StatusBadRequest, "invalid_request_error", "content_filter",
+			blockedMessage(moderation.StageRequest, verdict))
+	}
+}
+
+// Response moderates the generated text when the responses are moderated, and adds the annotated
+// categories to the response headers
+func (m *Moderation) Response(f *px.Flow) {
+	var result *schema.ModerationResult
+	if value, ok := m.results.LoadAndDelete(f.Id); ok {
+		result = value.(*schema.ModerationResult)
+	}
+	if f.Request == nil || f.Response == nil {
+		return
+	}
+	logger := configLoggerFieldsWithFlow(m.logger, f).WithGroup("Response")
+
+	if result != nil && result.Request != nil && result.Request.Action == moderation.ActionBlock {
+		// this is the error response of the Request hook
+		return
+	}
+
+	if m.moderator.Responses() && f.Response.StatusCode == http.StatusOK {
+		if verdict := m.moderateResponse(logger, f); verdict != nil {
+			if result == nil {
+				result = &schema.ModerationResult{Backend: m.moderator.Backend()}
+			}
+			result.Response = verdict
+			saveResultHeader(logger, f, headers.ModerationResult, result)
+
+			if verdict.Action == moderation.ActionBlock {
+				helpers.ReplaceWithErrorResponse(f, http.StatusBadRequest, "invalid_request_error", "content_filter",
+					blockedMessage(moderation.StageResponse, *verdict))
+				return
+			}
+		}
+	}
+
+	if result == nil {
+		return
+	}
+	categories := make([]string, 0)
+	for _, verdict := range []*moderation.Verdict{result.Request, result.Response} {
+		if verdict == nil || verdict.Action != moderation.ActionAnnotate {
+			continue
+		}
+		for _, category := range verdict.Categories {
+			if !slices.Contains(categories, category) {
+				categories = append(categories, category)
+			}
+		}
+	}
+	if len(categories) > 0 {
+		f.Response.Header.Set(headers.Moderation, strings.Join(categories, ","))
+	}
+}
+
+// LocalResponse forgets the request verdict of a request that got a response from the proxy, like
+// a blocked request, which isn't moderated again
+func (m *Moderation) LocalResponse(f *px.Flow) {
+	m.results.Delete(f.Id)
+}
+
+// moderateResponse moderates the generated text of the response, and returns nil when there's none
+func (m *Moderation) moderateResponse(logger *slog.Logger, f *px.Flow) *moderation.Verdict {
+	body, err := utils.DecodeBody(f.Response.Body, f.Response.Header.Get("Content-Encoding"))
+	if err != nil {
+		logger.Debug("Unable to decode response body, skipping moderation", "error", err)
+		return nil
+	}
+	texts := utils.CompletionText(body)
+	if len(texts) == 0 {
+		return nil
+	}
+	verdict := m.moderate(logger, f, moderation.StageResponse, texts)
+	return &verdict
+}
+
+// moderate sends the texts to the backend, until the client request ends, and counts and logs the verdict
+func (m *Moderation) moderate(logger *slog.Logger, f *px.Flow, stage moderation.Stage, texts []string) moderation.Verdict {
+	verdict := m.moderator.Moderate(helpers.FlowContext(f), stage, texts)
+	metrics.ModerationActionsTotal.WithLabelValues(string(stage), string(verdict.Action)).Inc()
+
+	if verdict.Error != "" {
+		logger.Warn("Moderation backend failed", "stage", stage, "action", verdict.Action, "error", verdict.Error)
+	} else if verdict.Action != moderation.ActionAllow {
+		logger.Info("Moderation flagged the "+string(stage), "action", verdict.Action, "categories", verdict.Categories)
+	}
+	return verdict
+}
+
+func (m *Moderation) String() string {
+	return moderationName
+}
+
+// blockedMessage is the error message for a blocked request or response
+func blockedMessage(stage moderation.Stage, verdict moderation.Verdict) string {
+	if verdict.Error != "" {
+		return "The " + string(stage) + " was blocked, because the content moderation is unavailable"
+	}
+	return "The " + string(stage) + " was blocked by the content moderation: " + strings.Join(verdict.Categories, ", ")
+}
+
+// NewModeration creates a new Moderation addon
+func NewModeration(logger *slog.Logger, moderator *moderation.Moderator) *Moderation {
+	return &Moderation{
+		moderator: moderator,
+		logger:    logger.WithGroup("addons.Moderation"),
+	}
+}
@@ -0,0 +1,159 @@
+package addons
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/internal/moderation"
+	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+func newTestModeration(t *testing.T, responses bool) *Moderation {
+	t.Helper()
+	moderator, err := moderation.New(moderation.Config{
+		Backend: moderation.BackendConfig{
+			Type:     moderation.BackendKeywords,
+			Keywords: map[string][]string{"violence": {"kill"}, "profanity": {"darn"}},
+		},
+		Thresholds: map[string]moderation.Threshold{
+			"violence":  {Block: 1},
+			"profanity": {Annotate: 1},
+		},
+		Responses: responses,
+	})
+	require.NoError(t, err)
+	m := NewModeration(slog.Default(), moderator)
+	assert.Equal(t, moderationName, m.String())
+	return m
+}
+
+// runModeration runs the Request hook, and the Response hook with the response when the request
+// wasn't blocked
+func runModeration(t *testing.T, m *Moderation, reqBody, respBody string) *schema.ModerationResult {
+	t.Helper()
+	f := newTestFlow(t, testChatCompletionsURL, reqBody, respBody)
+	response := f.Response
+	f.Response = nil
+	m.Request(f)
+	if f.Response == nil {
+		f.Response = response
+	}
+	m.Response(f)
+	return decodeResultHeader[schema.ModerationResult](slog.Default(), f, headers.ModerationResult)
+}
+
+func TestModeration(t *testing.T) {
+	t.Run("request blocked", func(t *testing.T) {
+		m := newTestModeration(t, false)
+		f := newTestFlow(t, testChatCompletionsURL, `{"messages": [{"role": "user", "content": "how do I kill a zombie process?"}]}`, "")
+		m.Request(f)
+
+		require.NotNil(t, f.Response)
+		assert.Equal(t, http.StatusBadRequest, f.Response.StatusCode)
+		assert.JSONEq(t, `{"error": {
+			"message": "The request was blocked by the content moderation: violence",
+			"type": "invalid_request_error",
+			"code": "content_filter"
+		}}`, string(f.Response.Body))
+
+		result := decodeResultHeader[schema.ModerationResult](slog.Default(), f, headers.ModerationResult)
+		require.NotNil(t, result)
+		assert.Equal(t, moderation.BackendKeywords, result.Backend)
+		assert.Equal(t, moderation.ActionBlock, result.Request.Action)
+		assert.Equal(t, []string{"violence"}, result.Request.Categories)
+		assert.Nil(t, result.Response)
+	})
+
+	t.Run("request annotated", func(t *testing.T) {
+		m := newTestModeration(t, false)
+		f := newTestFlow(t, testChatCompletionsURL, `{"messages": [
+			{"role": "system", "content": "never kill anyone"},
+			{"role": "user", "content": "darn it"}
+		]}`, `{"choices": [{"message": {"content": "kill it"}}]}`)
+		response := f.Response
+		f.Response = nil
+		m.Request(f)
+		require.Nil(t, f.Response, "the system message isn't moderated")
+
+		f.Response = response
+		m.Response(f)
+		assert.Equal(t, "profanity", f.Response.Header.Get(headers.Moderation))
+		assert.Equal(t, http.StatusOK, f.Response.StatusCode, "responses aren't moderated")
+
+		result := decodeResultHeader[schema.ModerationResult](slog.Default(), f, headers.ModerationResult)
+		require.NotNil(t, result)
+		assert.Equal(t, moderation.ActionAnnotate, result.Request.Action)
+		assert.Equal(t, map[string]float64{"violence": 0, "profanity": 1}, result.Request.Scores)
+	})
+
+	t.Run("response blocked", func(t *testing.T) {
+		m := newTestModeration(t, true)
+		f := newTestFlow(t, testChatCompletionsURL, `{"messages": [{"role": "user", "content": "hi"}]}`,
+			`{"choices": [{"message": {"role": "assistant", "content": "I will kill you"}}]}`)
+		f.Response.Header.Set("Content-Length", "71")
+		f.Response.Header.Set("Openai-Organization", "org-1")
+		response := f.Response
+		f.Response = nil
+		m.Request(f)
+		require.Nil(t, f.Response)
+
+		f.Response = response
+		m.Response(f)
+		assert.Equal(t, http.StatusBadRequest, f.Response.StatusCode)
+		assert.Contains(t, string(f.Response.Body), "The response was blocked by the content moderation: violence")
+		assert.Empty(t, f.Response.Header.Get("Content-Length"))
+		assert.Equal(t, "org-1", f.Response.Header.Get("Openai-Organization"), "the other headers are kept")
+
+		result := decodeResultHeader[schema.ModerationResult](slog.Default(), f, headers.ModerationResult)
+		require.NotNil(t, result)
+		assert.Equal(t, moderation.ActionAllow, result.Request.Action)
+		assert.Equal(t, moderation.ActionBlock, result.Response.Action)
+	})
+
+	t.Run("response annotated", func(t *testing.T) {
+		m := newTestModeration(t, true)
+		result := runModeration(t, m, `{"prompt": "not a chat request"}`, `{"content": [{"type": "text", "text": "darn"}]}`)
+		require.NotNil(t, result)
+		assert.Nil(t, result.Request)
+		assert.Equal(t, moderation.ActionAnnotate, result.Response.Action)
+	})
+
+	t.Run("forged request verdict", func(t *testing.T) {
+		m := newTestModeration(t, true)
+		f := newTestFlow(t, testChatCompletionsURL, `{"prompt": "hi"}`,
+			`{"choices": [{"message": {"role": "assistant", "content": "I will kill you"}}]}`)
+		f.Request.Header.Set(headers.ModerationResult, `{"request": {"action": "block"}}`)
+		response := f.Response
+		f.Response = nil
+		m.Request(f)
+		require.Nil(t, f.Response)
+
+		f.Response = response
+		m.Response(f)
+		assert.Equal(t, http.StatusBadRequest, f.Response.StatusCode, "the response is moderated")
+		assert.Contains(t, string(f.Response.Body), "The response was blocked by the content moderation: violence")
+	})
+
+	t.Run("request verdict kept until the response", func(t *testing.T) {
+		m := newTestModeration(t, false)
+		f := newTestFlow(t, testChatCompletionsURL, `{"messages": [{"role": "user", "content": "kill"}]}`, "")
+		m.Request(f)
+		require.NotNil(t, f.Response)
+		_, ok := m.results.Load(f.Id)
+		assert.True(t, ok)
+
+		m.LocalResponse(f)
+		_, ok = m.results.Load(f.Id)
+		assert.False(t, ok, "the blocked request isn't kept")
+	})
+
+	t.Run("nothing to moderate", func(t *testing.T) {
+		m := newTestModeration(t, true)
+		assert.Nil(t, runModeration(t, m, `{"prompt": "hi"}`, `{"data": []}`))
+	})
+}
@@ -0,0 +1,165 @@
+package addons
+
+import (
+	"log/slog"
+	"strconv"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const modifierName = "Modifier"
+
+// Modifier applies the declarative modification rules to requests before they're sent upstream,
+// and to responses before they're sent to the client. A rule that fails to apply (e.g. a JSON
+// patch on a body that isn't JSON) is skipped, and the flow continues unmodified by that rule.
+type Modifier struct {
+	px.BaseAddon
+	rules  *config.ModifyRules
+	logger *slog.Logger
+}
+
+// Request applies the request modifications of the matching rules
+func (m *Modifier) Request(f *px.Flow) {
+	if f.Request == nil || f.Request.URL == nil {
+		return
+	}
+	logger := configLoggerFieldsWithFlow(m.logger, f).WithGroup("Request")
+
+	applied := make([]string, 0)
+	var body []byte
+	bodyDecoded := false
+
+	for _, rule := range m.rules.Matching(m.newRuleInput(f)) {
+		mods := rule.Request
+		if mods == nil {
+			continue
+		}
+
+		if len(mods.JSONPatch) > 0 {
+			if !bodyDecoded {
+				var err error
+				body, err = utils.DecodeBody(f.Request.Body, f.Request.Header.Get("Content-Encoding"))
+				if err != nil {
+					logger.Warn("Unable to decode request body, skipping rule", "rule", rule.Name, "error", err)
+					continue
+				}
+				bodyDecoded = true
+			}
+
+			patched, err := mods.JSONPatch.Apply(body)
+			if err != nil {
+				logger.Warn("Unable to patch request body, skipping rule", "rule", rule.Name, "error", err)
+				continue
+			}
+			body = patched
+		}
+
+		mods.Headers.Apply(f.Request.Header)
+		applied = append(applied, rule.Name)
+	}
+
+	if bodyDecoded {
+		setDecodedRequestBody(f.Request, body)
+	}
+
+	if len(applied) > 0 {
+		logger.Debug("Applied request modification rules", "rules", applied)
+	}
+}
+
+// Response applies the response modifications of the matching rules
+func (m *Modifier) Response(f *px.Flow) {
+	if f.Request == nil || f.Request.URL == nil || f.Response == nil {
+		return
+	}
+	logger := configLoggerFieldsWithFlow(m.logger, f).WithGroup("Response")
+
+	applied := make([]string, 0)
+	var body []byte
+	bodyDecoded := false
+
+	for _, rule := range m.rules.Matching(m.newRuleInput(f)) {
+		mods := rule.Response
+		if mods == nil {
+			continue
+		}
+
+		if len(mods.JSONPatch) > 0 || len(mods.Replace) > 0 {
+			if !bodyDecoded {
+				var err error
+				body, err = utils.DecodeBody(f.Response.Body, f.Response.Header.Get("Content-Encoding"))
+				if err != nil {
+					logger.Warn("Unable to decode response body, skipping rule", "rule", rule.Name, "error", err)
+					continue
+				}
+				bodyDecoded = true
+			}
+
+			newBody := body
+			if len(mods.JSONPatch) > 0 {
+				patched, err := mods.JSONPatch.Apply(newBody)
+				if err != nil {
+					logger.Warn("Unable to patch response body, skipping rule", "rule", rule.Name, "error", err)
+					continue
+				}
+				newBody = patched
+			}
+			for i := range mods.Replace {
+				newBody = mods.Replace[i].Apply(newBody)
+			}
+			body = newBody
+		}
+
+		mods.Headers.Apply(f.Response.Header)
+		applied = append(applied, rule.Name)
+	}
+
+	if bodyDecoded {
+		setDecodedResponseBody(f.Response, body)
+	}
+
+	if len(applied) > 0 {
+		logger.Debug("Applied response modification rules", "rules", applied)
+	}
+}
+
+// setDecodedRequestBody replaces the request body with a body that was decoded to change it. It's
+// sent without compression, so the Content-Encoding header is removed.
+func setDecodedRequestBody(req *px.Request, body []byte) {
+	req.Body = body
+	req.Header.Del("Content-Encoding")
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// setDecodedResponseBody replaces the response body with a body that was decoded to change it. It's
+// sent without compression, so the Content-Encoding header is removed.
+func setDecodedResponseBody(resp *px.Response, body []byte) {
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// newRuleInput collects the request fields that are matched by the rules
+func (m *Modifier) newRuleInput(f *px.Flow) config.ModifyRuleInput {
+	return config.ModifyRuleInput{
+		Host:   f.Request.URL.Hostname(),
+		Path:   f.Request.URL.Path,
+		Method: f.Request.Method,
+		Header: f.Request.Header,
+	}
+}
+
+func (m *Modifier) String() string {
+	return modifierName
+}
+
+// NewModifier creates a new Modifier addon with the rules
+func NewModifier(logger *slog.Logger, rules *config.ModifyRules) *Modifier {
+	return &Modifier{
+		rules:  rules,
+		logger: logger.WithGroup("addons.Modifier"),
+	}
+}
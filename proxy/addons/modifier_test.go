@@ -0,0 +1,94 @@
+package addons
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log/slog"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+)
+
+func TestModifier(t *testing.T) {
+	rules, err := config.NewModifyRulesFromJSON([]byte(`{"rules": [
+		{
+			"name": "defaults",
+			"match": {"host": "api.openai.com", "methods": ["POST"]},
+			"request": {
+				"headers": {"set": {"X-Org": "acme"}, "remove": ["X-Team"]},
+				"json_patch": [
+					{"op": "cap", "path": "/max_tokens", "value": 100},
+					{"op": "add", "path": "/messages/0", "value": {"role": "system", "content": "be brief"}}
+				]
+			},
+			"response": {
+				"headers": {"remove": ["Openai-Organization"]},
+				"json_patch": [{"op": "remove", "path": "/system_fingerprint"}],
+				"replace": [{"regex": "secret-\\w+", "replacement": "[hidden]"}]
+			}
+		},
+		{
+			"name": "not json",
+			"match": {"headers": {"X-Team": "batch-*"}},
+			"request": {"json_patch": [{"op": "replace", "path": "/missing", "value": 1}]}
+		}
+	]}`))
+	require.NoError(t, err)
+	m := NewModifier(slog.Default(), rules)
+	assert.Equal(t, modifierName, m.String())
+
+	t.Run("matching request and response", func(t *testing.T) {
+		f := newTestFlow(t,
+			testChatCompletionsURL,
+			`{"model":"gpt-4o","max_tokens":4096,"messages":[{"role":"user","content":"hi"}]}`,
+			`{"id":"1","system_fingerprint":"fp_1","content":"the secret-abc123 is here"}`,
+		)
+		f.Request.Header.Set("X-Team", "batch-1")
+		f.Response.Header.Set("Openai-Organization", "org-1")
+
+		m.Request(f)
+		assert.JSONEq(t,
+			`{"model":"gpt-4o","max_tokens":100,"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}]}`,
+			string(f.Request.Body))
+		assert.Equal(t, "acme", f.Request.Header.Get("X-Org"))
+		assert.Empty(t, f.Request.Header.Get("X-Team"))
+		assert.Equal(t, strconv.Itoa(len(f.Request.Body)), f.Request.Header.Get("Content-Length"))
+
+		m.Response(f)
+		assert.Equal(t, `{"content":"the [hidden] is here","id":"1"}`, string(f.Response.Body))
+		assert.Empty(t, f.Response.Header.Get("Openai-Organization"))
+		assert.Equal(t, strconv.Itoa(len(f.Response.Body)), f.Response.Header.Get("Content-Length"))
+	})
+
+	t.Run("failed patch leaves the body", func(t *testing.T) {
+		f := newTestFlow(t, "https://example.com/v1/chat/completions", `{"model":"gpt-4o"}`, `plain text`)
+		f.Request.Header.Set("X-Team", "batch-1")
+		f.Response.Header.Set("Openai-Organization", "org-1")
+		m.Request(f)
+		assert.Equal(t, `{"model":"gpt-4o"}`, string(f.Request.Body))
+
+		m.Response(f)
+		assert.Equal(t, `plain text`, string(f.Response.Body))
+		assert.Equal(t, "org-1", f.Response.Header.Get("Openai-Organization"))
+	})
+
+	t.Run("compressed response", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte(`{"content":"secret-xyz","system_fingerprint":"fp"}`))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		f := newTestFlow(t, testChatCompletionsURL, `{}`, "{}")
+		f.Response.Body = buf.Bytes()
+		f.Response.Header.Set("Content-Encoding", "gzip")
+
+		m.Response(f)
+		assert.Equal(t, `{"content":"[hidden]"}`, string(f.Response.Body))
+		assert.Empty(t, f.Response.Header.Get("Content-Encoding"))
+	})
+}
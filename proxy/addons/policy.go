@@ -0,0 +1,119 @@
+package addons
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+const policyName = "Policy"
+
+// Policy allows or denies the requests with the allow and deny rules on the host, path, method, and
+// model. The denied requests get a 403. Each decision is logged, and saved in the internal
+// PolicyDecision request header, for the traffic logs.
+//
+// The host, path, and method are checked in the Requestheaders hook, and only the requests that
+// reach a rule with models wait for the Request hook, to read the model from the body. The bodies
+// that are larger than the proxy library's stream threshold skip the Request hook, so those
+// requests fail instead of being sent without the model check.
+type Policy struct {
+	px.BaseAddon
+	policy *config.Policy
+	logger *slog.Logger
+}
+
+// errPolicyNotChecked is returned by the streamed request bodies that the policy couldn't check
+var errPolicyNotChecked = errors.New("the request body is too large to check the policy")
+
+// Requestheaders evaluates the policy before the body is read, when the decision doesn't depend on
+// the model
+func (p *Policy) Requestheaders(f *px.Flow) {
+	if f.Request == nil || f.Request.URL == nil {
+		return
+	}
+	in := newPolicyInput(f.Request, "")
+	if decision, ok := p.policy.EvaluateWithoutModel(in); ok {
+		p.apply(f, in, decision)
+	}
+}
+
+// Request evaluates the policy with the model, for the requests that Requestheaders couldn't decide
+func (p *Policy) Request(f *px.Flow) {
+	if f.Request == nil || f.Request.URL == nil {
+		return
+	}
+	if _, ok := p.policy.EvaluateWithoutModel(newPolicyInput(f.Request, "")); ok {
+		return // decided in Requestheaders
+	}
+	in := newPolicyInput(f.Request, getRequestModel(f.Request))
+	p.apply(f, in, p.policy.Evaluate(in))
+}
+
+// StreamRequestModifier fails the streamed requests that need the model for the decision, because
+// the Request hook didn't run for them
+func (p *Policy) StreamRequestModifier(f *px.Flow, in io.Reader) io.Reader {
+	if !f.Stream || f.Request == nil || f.Request.URL == nil {
+		return in
+	}
+	if _, ok := p.policy.EvaluateWithoutModel(newPolicyInput(f.Request, "")); ok {
+		return in
+	}
+	metrics.PolicyDecisionsTotal.WithLabelValues(string(config.PolicyActionDeny), "").Inc()
+	configLoggerFieldsWithFlow(p.logger, f).WithGroup("Request").Warn(
+		"Policy denied a streamed request, the model in the body can't be checked")
+	return errReader{err: errPolicyNotChecked}
+}
+
+// apply records the decision, and responds with a 403 when the request is denied
+func (p *Policy) apply(f *px.Flow, in config.PolicyInput, decision config.PolicyDecision) {
+	logger := configLoggerFieldsWithFlow(p.logger, f).WithGroup("Request")
+	metrics.PolicyDecisionsTotal.WithLabelValues(string(decision.Action), decision.Rule).Inc()
+
+	saveResultHeader(logger, f, headers.PolicyDecision, decision)
+
+	if decision.Allowed() {
+		logger.Debug("Policy allowed a request", "rule", decision.Rule, "model", in.Model)
+		return
+	}
+	logger.Info("Policy denied a request", "rule", decision.Rule, "model", in.Model)
+	helpers.GenerateErrorResponse(f, http.StatusForbidden, "permission_error", "policy_denied", decision.Message(in))
+}
+
+func (p *Policy) String() string {
+	return policyName
+}
+
+// NewPolicy creates a new Policy addon with the rules
+func NewPolicy(logger *slog.Logger, policy *config.Policy) *Policy {
+	return &Policy{
+		policy: policy,
+		logger: logger.WithGroup("addons.Policy"),
+	}
+}
+
+// newPolicyInput returns the policy input for the request and model
+func newPolicyInput(req *px.Request, model string) config.PolicyInput {
+	return config.PolicyInput{
+		Host:   req.URL.Hostname(),
+		Path:   req.URL.Path,
+		Method: req.Method,
+		Model:  model,
+	}
+}
+
+// errReader is a request body that fails with err, so the upstream request isn't sent
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,69 @@
+package addons
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+func TestPolicy(t *testing.T) {
+	policy, err := config.NewPolicyFromJSON([]byte(`{"rules": [
+		{"name": "no-files", "action": "deny", "match": {"paths": ["/v1/files"]}},
+		{"name": "no-32k", "action": "deny", "match": {"models": ["gpt-4-32k*"]}}
+	]}`))
+	require.NoError(t, err)
+	p := NewPolicy(slog.Default(), policy)
+	assert.Equal(t, policyName, p.String())
+
+	t.Run("allow", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o"}`, "")
+		p.Requestheaders(f)
+		assert.Empty(t, f.Request.Header.Get(headers.PolicyDecision), "the models rule needs the body")
+		p.Request(f)
+		assert.Nil(t, f.Response)
+		assert.JSONEq(t, `{"action": "allow"}`, f.Request.Header.Get(headers.PolicyDecision))
+	})
+
+	t.Run("deny model", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4-32k"}`, "")
+		p.Request(f)
+		require.NotNil(t, f.Response)
+		assert.Equal(t, http.StatusForbidden, f.Response.StatusCode)
+		assert.JSONEq(t, `{"error": {
+			"message": "The model \"gpt-4-32k\" is not allowed by the policy rule \"no-32k\"",
+			"type": "permission_error",
+			"code": "policy_denied"
+		}}`, string(f.Response.Body))
+		assert.JSONEq(t, `{"action": "deny", "rule": "no-32k"}`, f.Request.Header.Get(headers.PolicyDecision))
+	})
+
+	t.Run("deny path", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, "", "")
+		f.Request.URL.Path = "/v1/files"
+		p.Requestheaders(f)
+		require.NotNil(t, f.Response, "the path is checked before the body is read")
+		assert.Equal(t, http.StatusForbidden, f.Response.StatusCode)
+		assert.Contains(t, string(f.Response.Body), `POST api.openai.com/v1/files is not allowed by the policy rule \"no-files\"`)
+	})
+
+	t.Run("streamed body", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4-32k"}`, "")
+		f.Stream = true
+		body := strings.NewReader("body")
+		_, err := io.ReadAll(p.StreamRequestModifier(f, body))
+		assert.ErrorIs(t, err, errPolicyNotChecked, "the model can't be checked, so the request fails")
+
+		f.Request.URL.Path = "/v1/files"
+		p.Requestheaders(f)
+		require.NotNil(t, f.Response)
+		assert.Same(t, body, p.StreamRequestModifier(f, body), "decided before the body is read")
+	})
+}
@@ -0,0 +1,120 @@
+package addons
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/internal/promptguard"
+	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
+	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const promptGuardName = "PromptGuard"
+
+// PromptGuard flags likely prompt injections and jailbreaks in the messages of chat requests, with
+// heuristic rules and an optional local classifier. In monitor mode, the request is sent upstream,
+// and the categories are added to the PromptGuard response header. In enforce mode, the request is
+// rejected with a 400. The result is saved in the internal PromptGuardResult request header, for
+// the traffic logs.
+type PromptGuard struct {
+	px.BaseAddon
+	guard  *promptguard.Guard
+	mode   config.GuardrailMode
+	logger *slog.Logger
+}
+
+// Request scans the messages, and blocks the flagged requests in enforce mode
+func (pg *PromptGuard) Request(f *px.Flow) {
+	if f.Request == nil || len(f.Request.Body) == 0 {
+		return
+	}
+	logger := configLoggerFieldsWithFlow(pg.logger, f).WithGroup("Request")
+
+	body, err := utils.DecodeBody(f.Request.Body, f.Request.Header.Get("Content-Encoding"))
+	if err != nil {
+		logger.Debug("Unable to decode request body, skipping prompt guard", "error", err)
+		return
+	}
+
+	detections, err := pg.guard.Scan(helpers.FlowContext(f), body)
+	result := schema.PromptGuardResult{Mode: string(pg.mode), Detections: detections}
+	if err != nil {
+		// fail open, the heuristics still ran
+		logger.Warn("Prompt guard classifier failed", "error", err)
+		result.Error = err.Error()
+	}
+	if len(detections) == 0 {
+		if result.Error != "" {
+			saveResultHeader(logger, f, headers.PromptGuardResult, result)
+		}
+		return
+	}
+
+	categories := detectionCategories(detections)
+	result.Blocked = pg.mode == config.GuardrailModeEnforce
+	saveResultHeader(logger, f, headers.PromptGuardResult, result)
+
+	action := "flagged"
+	if result.Blocked {
+		action = "blocked"
+	}
+	for _, category := range categories {
+		metrics.PromptGuardFlaggedTotal.WithLabelValues(category, action).Inc()
+	}
+	logger.Info("Prompt guard "+action+" a request", "categories", categories, "detections", len(detections))
+
+	if result.Blocked {
+		first := detections[0]
+		helpers.GenerateErrorResponse(f, http.StatusBadRequest, "invalid_request_error", "prompt_guard_blocked",
+			fmt.Sprintf("The request was blocked by the prompt guard: likely %s in messages[%d] (%s)",
+				strings.ReplaceAll(first.Category, "_", " "), first.Message, first.Rule))
+	}
+}
+
+// Response adds the flagged categories to the response headers, in monitor mode
+func (pg *PromptGuard) Response(f *px.Flow) {
+	if f.Response == nil || f.Request == nil {
+		return
+	}
+	result := decodeResultHeader[schema.PromptGuardResult](pg.logger, f, headers.PromptGuardResult)
+	if result == nil || result.Blocked || len(result.Detections) == 0 {
+		return
+	}
+	if f.Response.Header == nil {
+		f.Response.Header = make(http.Header)
+	}
+	f.Response.Header.Set(headers.PromptGuard, strings.Join(detectionCategories(result.Detections), ","))
+}
+
+func (pg *PromptGuard) String() string {
+	return promptGuardName
+}
+
+// detectionCategories returns the unique categories of the detections, in the order they were found
+func detectionCategories(detections []promptguard.Detection) []string {
+	categories := make([]string, 0, len(detections))
+	for _, d := range detections {
+		if !slices.Contains(categories, d.Category) {
+			categories = append(categories, d.Category)
+		}
+	}
+	return categories
+}
+
+// NewPromptGuard creates a new PromptGuard addon, the mode must be monitor or enforce
+func NewPromptGuard(logger *slog.Logger, guard *promptguard.Guard, mode config.GuardrailMode) *PromptGuard {
+	return &PromptGuard{
+		guard:  guard,
+		mode:   mode,
+		logger: logger.WithGroup("addons.PromptGuard"),
+	}
+}
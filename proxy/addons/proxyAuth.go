@@ -0,0 +1,234 @@
+package addons
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
+)
+
+const (
+	proxyAuthName = "ProxyAuth"
+
+	// proxyAuthRealm is sent in the Proxy-Authenticate header of a 407 response
+	proxyAuthRealm = `Basic realm="llm_proxy"`
+
+	// proxyAuthInternalUser is the user of the reverse proxy listener, which sends its requests
+	// through this proxy with a random password
+	proxyAuthInternalUser = "llm_proxy-internal"
+)
+
+// ProxyAuth only lets clients from the allowlist, with valid Proxy-Authorization Basic credentials
+// from an htpasswd file, use the proxy. Other clients get a 403 (not in the allowlist) or a 407
+// (missing or wrong credentials), so a shared proxy can run in a VPC.
+//
+// Requests for HTTPS hosts are tunneled with a CONNECT request, which the proxy library handles
+// without calling the addons, and the requests inside the tunnel don't carry the credentials. So
+// the first request of every client connection is checked in ClientConnected, by wrapping the
+// connection before the proxy reads from it. Plain HTTP requests on the same connection are
+// checked again in Requestheaders.
+type ProxyAuth struct {
+	px.BaseAddon
+	htpasswd  *config.Htpasswd       // nil when only the allowlist is checked
+	allowlist config.ClientAllowlist // empty when every client address is allowed
+	internal  *url.Userinfo          // credentials of the reverse proxy listener
+	verified  sync.Map               // sha256 of verified Proxy-Authorization values -> user
+	logger    *slog.Logger
+}
+
+// ClientConnected wraps the client connection, so the first request is checked before the proxy
+// reads it
+func (pa *ProxyAuth) ClientConnected(client *px.ClientConn) {
+	if client == nil || client.Conn == nil || client.Conn.Conn == nil {
+		return
+	}
+	client.Conn.Conn = newFirstRequestConn(client.Conn.Conn, pa.handleFirstRequest)
+}
+
+// Requestheaders checks every plain HTTP request, because a keep-alive connection can send more
+// than one. Requests inside a TLS tunnel were already checked with the CONNECT request. The
+// Proxy-Authorization header is always removed, so it isn't logged or sent upstream.
+func (pa *ProxyAuth) Requestheaders(f *px.Flow) {
+	if f.Request == nil {
+		return
+	}
+	proxyAuthorization := f.Request.Header.Get("Proxy-Authorization")
+	f.Request.Header.Del("Proxy-Authorization")
+
+	if f.ConnContext == nil || f.ConnContext.ClientConn == nil || f.ConnContext.ClientConn.TLS {
+		return
+	}
+
+	remoteAddr := ""
+	if conn := f.ConnContext.ClientConn.Conn; conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	status, message := pa.check(remoteAddr, proxyAuthorization)
+	if status == 0 {
+		return
+	}
+
+	configLoggerFieldsWithFlow(pa.logger, f).Warn("Rejected proxy request", "status", status, "reason", message)
+	metrics.ProxyAuthRejectedTotal.WithLabelValues(strconv.Itoa(status)).Inc()
+	errType, code := proxyAuthErrorType(status)
+	helpers.GenerateErrorResponse(f, status, errType, code, message)
+	if status == http.StatusProxyAuthRequired {
+		f.Response.Header.Set("Proxy-Authenticate", proxyAuthRealm)
+	}
+}
+
+// check returns 0 when the client may use the proxy, otherwise the status code and the reason
+func (pa *ProxyAuth) check(remoteAddr, proxyAuthorization string) (int, string) {
+	user, password, ok := parseProxyAuthorization(proxyAuthorization)
+	if ok && user == proxyAuthInternalUser {
+		internalPassword, _ := pa.internal.Password()
+		if subtle.ConstantTimeCompare([]byte(password), []byte(internalPassword)) == 1 {
+			return 0, ""
+		}
+	}
+
+	if !pa.allowlist.AllowsRemoteAddr(remoteAddr) {
+		return http.StatusForbidden, fmt.Sprintf("client address %s is not allowed to use this proxy", remoteAddr)
+	}
+
+	if pa.htpasswd == nil {
+		return 0, ""
+	}
+	if !ok {
+		return http.StatusProxyAuthRequired, "proxy authentication is required"
+	}
+
+	sum := sha256.Sum256([]byte(proxyAuthorization))
+	key := hex.EncodeToString(sum[:])
+	if _, found := pa.verified.Load(key); found {
+		return 0, ""
+	}
+	// bcrypt is slow on purpose, so verified credentials are cached
+	if !pa.htpasswd.Verify(user, password) {
+		return http.StatusProxyAuthRequired, "invalid proxy credentials"
+	}
+	pa.verified.Store(key, user)
+	return 0, ""
+}
+
+// CheckListenerRequest checks a client of the reverse proxy listener. These clients don't use a
+// proxy, so the htpasswd credentials are read from the Proxy-Authorization header, or from a Basic
+// Authorization header when there is none, and are removed so they aren't sent upstream. Returns 0
+// when the client may use the proxy, otherwise the status code and the reason.
+func (pa *ProxyAuth) CheckListenerRequest(r *http.Request) (int, string) {
+	credentials := r.Header.Get("Proxy-Authorization")
+	r.Header.Del("Proxy-Authorization")
+	if credentials == "" && pa.htpasswd != nil {
+		if _, _, ok := parseProxyAuthorization(r.Header.Get("Authorization")); ok {
+			credentials = r.Header.Get("Authorization")
+			r.Header.Del("Authorization")
+		}
+	}
+	return pa.check(r.RemoteAddr, credentials)
+}
+
+// InternalUser returns the credentials for the reverse proxy listener, which are accepted from any
+// client address
+func (pa *ProxyAuth) InternalUser() *url.Userinfo {
+	return pa.internal
+}
+
+// Allows returns true when the remote address is in the client allowlist
+func (pa *ProxyAuth) Allows(remoteAddr string) bool {
+	return pa.allowlist.AllowsRemoteAddr(remoteAddr)
+}
+
+func (pa *ProxyAuth) String() string {
+	return proxyAuthName
+}
+
+// parseProxyAuthorization returns the user and password of a "Basic" Proxy-Authorization value
+func parseProxyAuthorization(value string) (user, password string, ok bool) {
+	scheme, encoded, found := strings.Cut(strings.TrimSpace(value), " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// proxyAuthErrorType returns the OpenAI-style error type and code for a rejection
+func proxyAuthErrorType(status int) (errType, code string) {
+	if status == http.StatusProxyAuthRequired {
+		return "authentication_error", "proxy_authentication_required"
+	}
+	return "permission_error", "client_not_allowed"
+}
+
+// NewProxyAuth creates a new ProxyAuth addon. The htpasswd can be nil, to only check the client
+// allowlist.
+func NewProxyAuth(logger *slog.Logger, htpasswd *config.Htpasswd, allowlist config.ClientAllowlist) (*ProxyAuth, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("unable to create the internal proxy credentials: %w", err)
+	}
+
+	return &ProxyAuth{
+		htpasswd:  htpasswd,
+		allowlist: allowlist,
+		internal:  url.UserPassword(proxyAuthInternalUser, hex.EncodeToString(secret)),
+		logger:    logger.WithGroup("addons.ProxyAuth"),
+	}, nil
+}
+
+// handleFirstRequest checks the first request of a client connection, and writes a 403 or 407
+// response when the client is rejected
+func (pa *ProxyAuth) handleFirstRequest(c *firstRequestConn, req *http.Request, err error) error {
+	remoteAddr := c.RemoteAddr().String()
+	logger := pa.logger.With("client", remoteAddr)
+
+	status, message := 0, ""
+	switch {
+	case errors.Is(err, bufio.ErrBufferFull):
+		status, message = http.StatusRequestHeaderFieldsTooLarge, "request headers are too large"
+	case err != nil:
+		status, message = http.StatusBadRequest, "malformed request"
+	default:
+		status, message = pa.check(remoteAddr, req.Header.Get("Proxy-Authorization"))
+		if status == 0 {
+			return nil
+		}
+		logger.Warn("Rejected proxy client", "method", req.Method, "host", req.Host, "status", status, "reason", message)
+		metrics.ProxyAuthRejectedTotal.WithLabelValues(strconv.Itoa(status)).Inc()
+	}
+
+	errType, code := proxyAuthErrorType(status)
+	header := http.Header{}
+	switch status {
+	case http.StatusProxyAuthRequired:
+		header.Set("Proxy-Authenticate", proxyAuthRealm)
+	case http.StatusForbidden:
+	default:
+		errType, code = "invalid_request_error", ""
+	}
+	if err := c.writeResponse(status, header, helpers.NewErrorBody(errType, code, message)); err != nil {
+		logger.Debug("Unable to write the rejection response", "error", err)
+	}
+	return io.EOF
+}
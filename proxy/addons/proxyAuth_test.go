@@ -0,0 +1,220 @@
+package addons
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+)
+
+func newTestProxyAuth(t *testing.T, allowedClients ...string) *ProxyAuth {
+	t.Helper()
+	// bob:secret
+	htpasswd, err := config.NewHtpasswd([]byte("bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"))
+	require.NoError(t, err)
+	allowlist, err := config.ParseClientAllowlist(allowedClients)
+	require.NoError(t, err)
+	pa, err := NewProxyAuth(slog.Default(), htpasswd, allowlist)
+	require.NoError(t, err)
+	assert.Equal(t, proxyAuthName, pa.String())
+	return pa
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// sendThroughFirstRequestConn sends the raw request on a TCP connection that's wrapped with the
+// handler, and returns the request read by the server, or the response written by the handler
+func sendThroughFirstRequestConn(t *testing.T, handler firstRequestHandler, rawRequest string) (*http.Request, *http.Response) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan *http.Request, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(newFirstRequestConn(conn, handler)))
+		if err != nil {
+			received <- nil
+			return
+		}
+		received <- req
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, rawRequest)
+	require.NoError(t, err)
+
+	if req := <-received; req != nil {
+		return req, nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	return nil, resp
+}
+
+func TestProxyAuthFirstRequest(t *testing.T) {
+	connect := "CONNECT api.openai.com:443 HTTP/1.1\r\nHost: api.openai.com:443\r\n"
+
+	t.Run("valid credentials", func(t *testing.T) {
+		req, resp := sendThroughFirstRequestConn(t, newTestProxyAuth(t).handleFirstRequest,
+			connect+"Proxy-Authorization: "+basicAuth("bob", "secret")+"\r\n\r\n")
+		require.Nil(t, resp)
+		require.NotNil(t, req)
+		assert.Equal(t, http.MethodConnect, req.Method, "the checked request is read again by the proxy")
+		assert.Equal(t, "api.openai.com:443", req.Host)
+	})
+
+	t.Run("missing credentials", func(t *testing.T) {
+		req, resp := sendThroughFirstRequestConn(t, newTestProxyAuth(t).handleFirstRequest, connect+"\r\n")
+		require.Nil(t, req)
+		assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+		assert.Equal(t, proxyAuthRealm, resp.Header.Get("Proxy-Authenticate"))
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "proxy_authentication_required")
+	})
+
+	t.Run("wrong credentials", func(t *testing.T) {
+		_, resp := sendThroughFirstRequestConn(t, newTestProxyAuth(t).handleFirstRequest,
+			connect+"Proxy-Authorization: "+basicAuth("bob", "wrong")+"\r\n\r\n")
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+	})
+
+	t.Run("client not allowed", func(t *testing.T) {
+		_, resp := sendThroughFirstRequestConn(t, newTestProxyAuth(t, "10.0.0.0/8").handleFirstRequest,
+			connect+"Proxy-Authorization: "+basicAuth("bob", "secret")+"\r\n\r\n")
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("internal credentials are allowed from any client", func(t *testing.T) {
+		pa := newTestProxyAuth(t, "10.0.0.0/8")
+		password, _ := pa.InternalUser().Password()
+		req, resp := sendThroughFirstRequestConn(t, pa.handleFirstRequest,
+			connect+"Proxy-Authorization: "+basicAuth(proxyAuthInternalUser, password)+"\r\n\r\n")
+		require.Nil(t, resp)
+		assert.NotNil(t, req)
+	})
+
+	t.Run("headers too large", func(t *testing.T) {
+		_, resp := sendThroughFirstRequestConn(t, newTestProxyAuth(t).handleFirstRequest,
+			connect+"X-Large: "+strings.Repeat("a", maxRequestHeadBytes)+"\r\n\r\n")
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+	})
+}
+
+func TestProxyAuthRequestheaders(t *testing.T) {
+	pa := newTestProxyAuth(t)
+
+	newFlow := func(proxyAuthorization string, tls bool) *px.Flow {
+		return &px.Flow{
+			Request: &px.Request{
+				Method: http.MethodGet,
+				URL:    &url.URL{Scheme: "http", Host: "example.com", Path: "/"},
+				Header: http.Header{"Proxy-Authorization": {proxyAuthorization}},
+			},
+			ConnContext: &px.ConnContext{ClientConn: &px.ClientConn{TLS: tls}},
+		}
+	}
+
+	f := newFlow(basicAuth("bob", "secret"), false)
+	pa.Requestheaders(f)
+	assert.Nil(t, f.Response)
+	assert.Empty(t, f.Request.Header.Get("Proxy-Authorization"), "not logged or sent upstream")
+
+	f = newFlow(basicAuth("bob", "wrong"), false)
+	pa.Requestheaders(f)
+	require.NotNil(t, f.Response)
+	assert.Equal(t, http.StatusProxyAuthRequired, f.Response.StatusCode)
+	assert.Equal(t, proxyAuthRealm, f.Response.Header.Get("Proxy-Authenticate"))
+
+	// the CONNECT request of a TLS tunnel was already checked
+	f = newFlow("", true)
+	pa.Requestheaders(f)
+	assert.Nil(t, f.Response)
+}
+
+func TestProxyAuthCheckListenerRequest(t *testing.T) {
+	pa := newTestProxyAuth(t, "127.0.0.1")
+
+	newRequest := func(remoteAddr string, header http.Header) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/openai/v1/chat/completions", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header = header
+		return r
+	}
+
+	r := newRequest("127.0.0.1:5000", http.Header{
+		"Proxy-Authorization": {basicAuth("bob", "secret")},
+		"Authorization":       {"Bearer sk-test"},
+	})
+	status, _ := pa.CheckListenerRequest(r)
+	assert.Zero(t, status)
+	assert.Empty(t, r.Header.Get("Proxy-Authorization"), "not logged or sent upstream")
+	assert.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"), "the API key is kept")
+
+	r = newRequest("127.0.0.1:5000", http.Header{"Authorization": {basicAuth("bob", "secret")}})
+	status, _ = pa.CheckListenerRequest(r)
+	assert.Zero(t, status)
+	assert.Empty(t, r.Header.Get("Authorization"))
+
+	r = newRequest("127.0.0.1:5000", http.Header{"Authorization": {"Bearer sk-test"}})
+	status, _ = pa.CheckListenerRequest(r)
+	assert.Equal(t, http.StatusProxyAuthRequired, status, "an allowed client needs credentials")
+
+	r = newRequest("127.0.0.1:5000", http.Header{"Proxy-Authorization": {basicAuth("bob", "wrong")}})
+	status, _ = pa.CheckListenerRequest(r)
+	assert.Equal(t, http.StatusProxyAuthRequired, status)
+
+	r = newRequest("10.0.0.1:5000", http.Header{"Proxy-Authorization": {basicAuth("bob", "secret")}})
+	status, _ = pa.CheckListenerRequest(r)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	t.Run("allowlist only", func(t *testing.T) {
+		allowlist, err := config.ParseClientAllowlist([]string{"127.0.0.1"})
+		require.NoError(t, err)
+		pa, err := NewProxyAuth(slog.Default(), nil, allowlist)
+		require.NoError(t, err)
+
+		r := newRequest("127.0.0.1:5000", http.Header{"Authorization": {basicAuth("upstream", "password")}})
+		status, _ := pa.CheckListenerRequest(r)
+		assert.Zero(t, status)
+		assert.NotEmpty(t, r.Header.Get("Authorization"), "not proxy credentials without an htpasswd file")
+	})
+}
+
+func TestParseProxyAuthorization(t *testing.T) {
+	user, password, ok := parseProxyAuthorization(basicAuth("bob", "se:cret"))
+	assert.True(t, ok)
+	assert.Equal(t, "bob", user)
+	assert.Equal(t, "se:cret", password)
+
+	for _, value := range []string{"", "Bearer abc", "Basic !!!", "Basic " + base64.StdEncoding.EncodeToString([]byte("bob"))} {
+		_, _, ok := parseProxyAuthorization(value)
+		assert.False(t, ok, value)
+	}
+}
@@ -0,0 +1,370 @@
+package addons
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/internal/ratelimit"
+	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/providers"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const (
+	rateLimiterName = "RateLimiter"
+
+	// upstreamRateLimitName is the limit name for the rate limits reported by the upstream API
+	upstreamRateLimitName = "upstream"
+
+	// charsPerToken is the rough size of a token, for estimating the tokens in a request body
+	charsPerToken = 4
+
+	// rateLimitPruneInterval is how often the full buckets are removed from memory
+	rateLimitPruneInterval = time.Minute
+)
+
+// RateLimiter limits the requests and estimated tokens per minute that are sent upstream, with a
+// token bucket for each client IP, workflow, API key, or model. A request that is over a limit is
+// either held until there's room (queue), or answered with a 429 and a Retry-After header (reject).
+// This runs after the cache, so cache hits are not limited.
+//
+// When the upstream limits are enabled, the x-ratelimit-* headers of the upstream responses are
+// tracked for each API key and model, and requests are also held back when they are nearly used up.
+type RateLimiter struct {
+	px.BaseAddon
+	limits   []*rateLimitState
+	upstream config.UpstreamRateLimits
+	tracker  *ratelimit.UpstreamTracker
+	done     chan struct{}
+	closed   atomic.Bool
+	logger   *slog.Logger
+
+	// forwardedFrom returns true for the client connections with a trusted X-Forwarded-For header
+	forwardedFrom func(remoteAddr string) bool
+}
+
+// rateLimitState holds the buckets of one configured limit
+type rateLimitState struct {
+	limit    *config.RateLimit
+	requests *ratelimit.Limiter // nil when there's no requests_per_minute
+	tokens   *ratelimit.Limiter // nil when there's no tokens_per_minute
+}
+
+// rateLimitReservation is what one request took from the buckets of a limit
+type rateLimitReservation struct {
+	name    string        // the limit name, for the logs and metrics
+	wait    time.Duration // how long until the request can be sent
+	maxWait time.Duration // how long the request can be queued
+	cancel  func()        // gives back what the request took, when it's rejected
+}
+
+func (r *RateLimiter) Request(f *px.Flow) {
+	if r.closed.Load() {
+		helpers.GenerateClosedResponse(r.logger, f)
+		return
+	}
+	logger := configLoggerFieldsWithFlow(r.logger, f)
+
+	model := getRequestModel(f.Request)
+	tokens := -1.0 // estimated on the first token limit
+	getTokens := func() float64 {
+		if tokens < 0 {
+			tokens = estimateRequestTokens(f.Request)
+		}
+		return tokens
+	}
+
+	reservations := make([]rateLimitReservation, 0, len(r.limits)+1)
+	for _, state := range r.limits {
+		if state.limit.Applies(model, f.Request.URL.Hostname()) {
+			reservations = append(reservations, state.reserve(r.rateLimitKeyValue(state.limit.Key, f, model), getTokens))
+		}
+	}
+	if r.upstream.Enabled {
+		reservations = append(reservations, r.reserveUpstream(upstreamRateLimitKey(f, model), getTokens))
+	}
+
+	var wait time.Duration
+	var rejectedBy string
+	for _, res := range reservations {
+		if res.wait > res.maxWait && rejectedBy == "" {
+			rejectedBy = res.name
+		}
+		wait = max(wait, res.wait)
+	}
+
+	if rejectedBy != "" {
+		for _, res := range reservations {
+			res.cancel()
+		}
+		metrics.RateLimitedTotal.WithLabelValues(rejectedBy, "rejected").Inc()
+		logger.Warn("Request rejected by rate limit", "limit", rejectedBy, "retryAfter", wait)
+		r.reject(f, rejectedBy, wait)
+		return
+	}
+
+	if wait == 0 {
+		return
+	}
+
+	for _, res := range reservations {
+		if res.wait > 0 {
+			metrics.RateLimitedTotal.WithLabelValues(res.name, "queued").Inc()
+		}
+	}
+	logger.Info("Request queued by rate limit", "wait", wait)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		for _, res := range reservations {
+			if res.wait > 0 {
+				metrics.RateLimitQueueSeconds.WithLabelValues(res.name).Observe(wait.Seconds())
+			}
+		}
+	case <-r.done:
+		helpers.GenerateClosedResponse(r.logger, f)
+	}
+}
+
+// reserve takes a request and the estimated tokens from the buckets of the limit
+func (state *rateLimitState) reserve(key string, getTokens func() float64) rateLimitReservation {
+	res := rateLimitReservation{name: state.limit.Name, maxWait: state.limit.GetMaxWait()}
+	var tokens float64
+	if state.requests != nil {
+		res.wait = state.requests.Reserve(key, 1)
+	}
+	if state.tokens != nil {
+		tokens = getTokens()
+		res.wait = max(res.wait, state.tokens.Reserve(key, tokens))
+	}
+
+	res.cancel = func() {
+		if state.requests != nil {
+			state.requests.Cancel(key, 1)
+		}
+		if state.tokens != nil {
+			state.tokens.Cancel(key, tokens)
+		}
+	}
+	return res
+}
+
+// reserveUpstream takes a request and the estimated tokens from the remaining capacity reported
+// by the upstream API
+func (r *RateLimiter) reserveUpstream(key string, getTokens func() float64) rateLimitReservation {
+	tokens := getTokens()
+	res := rateLimitReservation{
+		name:    upstreamRateLimitName,
+		wait:    r.tracker.Reserve(key, tokens, r.upstream.ReserveRequests, r.upstream.ReserveTokens),
+		maxWait: r.upstream.GetMaxWait(),
+	}
+
+	res.cancel = func() {
+		if res.wait == 0 {
+			r.tracker.Cancel(key, tokens)
+		}
+	}
+	return res
+}
+
+// Responseheaders saves the rate limits reported by the upstream API, when the upstream limits
+// are enabled. The limits are only exported as metrics for the known API hosts, and the model label
+// is limited to the models in the pricing data, so clients can't create new label values.
+func (r *RateLimiter) Responseheaders(f *px.Flow) {
+	if !r.upstream.Enabled || f.Response == nil {
+		return
+	}
+
+	limits, ok := ratelimit.ParseUpstreamLimits(f.Response.Header, time.Now())
+	if !ok {
+		return
+	}
+
+	model := getRequestModel(f.Request)
+	apiKey := utils.APIKeyHash(f.Request.Header)
+	r.tracker.Update(upstreamRateLimitKey(f, model), limits)
+
+	if _, ok := providers.APIHostnames[f.Request.URL.Hostname()]; ok {
+		for limitType, values := range map[string][2]float64{
+			"requests": {limits.LimitRequests, limits.RemainingRequests},
+			"tokens":   {limits.LimitTokens, limits.RemainingTokens},
+		} {
+			if values[0] >= 0 {
+				metrics.UpstreamRateLimit.WithLabelValues(apiKey, modelLabel(model), limitType).Set(values[0])
+			}
+			if values[1] >= 0 {
+				metrics.UpstreamRateLimitRemaining.WithLabelValues(apiKey, modelLabel(model), limitType).Set(values[1])
+			}
+		}
+	}
+
+	logger := configLoggerFieldsWithFlow(r.logger, f).With(
+		"apiKeyHash", apiKey,
+		"model", model,
+		"remainingRequests", limits.RemainingRequests,
+		"remainingTokens", limits.RemainingTokens,
+		"resetRequests", limits.ResetRequests,
+		"resetTokens", limits.ResetTokens,
+	)
+	if f.Response.StatusCode == http.StatusTooManyRequests {
+		logger.Warn("Upstream rate limit reached")
+		return
+	}
+	logger.Debug("Upstream rate limits updated")
+}
+
+// reject responds with a 429 in the same format as the OpenAI API, so SDKs can back off
+func (r *RateLimiter) reject(f *px.Flow, limitName string, retryAfter time.Duration) {
+	seconds := max(1, int(math.Ceil(retryAfter.Seconds())))
+	helpers.GenerateErrorResponse(f, http.StatusTooManyRequests, "rate_limit_error", "rate_limit_exceeded",
+		"Rate limit reached for "+limitName+" on llm_proxy, please try again in "+strconv.Itoa(seconds)+"s.")
+	f.Response.Header.Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// prune removes the full buckets from memory, until the addon is closed
+func (r *RateLimiter) prune() {
+	ticker := time.NewTicker(rateLimitPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, state := range r.limits {
+				if state.requests != nil {
+					state.requests.Prune()
+				}
+				if state.tokens != nil {
+					state.tokens.Prune()
+				}
+			}
+			r.tracker.Prune()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// upstreamRateLimitKey returns the tracker key of the request. OpenAI limits are per organization
+// and model, and the API key selects the organization.
+func upstreamRateLimitKey(f *px.Flow, model string) string {
+	return utils.APIKeyHash(f.Request.Header) + "/" + model
+}
+
+// rateLimitKeyValue returns the bucket key of the request. Requests without a value for the key,
+// e.g. without a workflow header, share one bucket.
+func (r *RateLimiter) rateLimitKeyValue(key config.RateLimitKey, f *px.Flow, model string) string {
+	switch key {
+	case config.RateLimitKeyIP:
+		if f.ConnContext == nil || f.ConnContext.ClientConn == nil || f.ConnContext.ClientConn.Conn == nil {
+			return ""
+		}
+		return r.clientIP(f.ConnContext.ClientConn.Conn.RemoteAddr().String(), f.Request.Header)
+	case config.RateLimitKeyWorkflow:
+		return f.Request.Header.Get(headers.WorkflowName)
+	case config.RateLimitKeyAPIKey:
+		// clients with virtual keys are limited by their own key, not the real key swapped in
+		if id := f.Request.Header.Get(headers.VirtualKey); id != "" {
+			return id
+		}
+		return utils.APIKeyHash(f.Request.Header)
+	case config.RateLimitKeyModel:
+		return model
+	default:
+		return ""
+	}
+}
+
+// estimateRequestTokens guesses the tokens a request will use: the prompt, from the size of the
+// body, plus the max output tokens when the request sets them
+func estimateRequestTokens(req *px.Request) float64 {
+	if req == nil || len(req.Body) == 0 {
+		return 0
+	}
+
+	body, err := utils.DecodeBody(req.Body, req.Header.Get("Content-Encoding"))
+	if err != nil {
+		body = req.Body
+	}
+
+	var maxTokens struct {
+		MaxTokens           int `json:"max_tokens"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+		MaxOutputTokens     int `json:"max_output_tokens"`
+	}
+	_ = json.Unmarshal(body, &maxTokens)
+
+	output := max(maxTokens.MaxTokens, maxTokens.MaxCompletionTokens, maxTokens.MaxOutputTokens)
+	return math.Ceil(float64(len(body))/charsPerToken) + float64(output)
+}
+
+// clientIP returns the IP of the client connection, or the X-Forwarded-For header when the
+// connection is trusted
+func (r *RateLimiter) clientIP(remoteAddr string, header http.Header) string {
+	if r.forwardedFrom != nil && r.forwardedFrom(remoteAddr) {
+		if forwarded := header.Get("X-Forwarded-For"); forwarded != "" {
+			return forwarded
+		}
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// TrustForwardedFor makes the ip key read the client IP from the X-Forwarded-For header, on the
+// client connections for which isTrusted returns true, like the reverse proxy listener's. Other
+// clients could set any address, so their header is ignored. Call this before the proxy starts.
+func (r *RateLimiter) TrustForwardedFor(isTrusted func(remoteAddr string) bool) {
+	r.forwardedFrom = isTrusted
+}
+
+func (r *RateLimiter) String() string {
+	return rateLimiterName
+}
+
+// Close releases the queued requests with a 503, and stops pruning the buckets
+func (r *RateLimiter) Close() error {
+	if !r.closed.Swap(true) {
+		r.logger.Debug("Closing RateLimiter...")
+		close(r.done)
+	}
+	return nil
+}
+
+// NewRateLimiter creates a new RateLimiter addon with the limits
+func NewRateLimiter(logger *slog.Logger, limits *config.RateLimits) *RateLimiter {
+	r := &RateLimiter{
+		limits:   make([]*rateLimitState, 0, len(limits.Limits)),
+		upstream: limits.Upstream,
+		tracker:  ratelimit.NewUpstreamTracker(),
+		done:     make(chan struct{}),
+		logger:   logger.WithGroup("addons.RateLimiter"),
+	}
+
+	for i := range limits.Limits {
+		limit := &limits.Limits[i]
+		state := &rateLimitState{limit: limit}
+		if limit.RequestsPerMinute > 0 {
+			state.requests = ratelimit.NewLimiter(limit.RequestsPerMinute)
+		}
+		if limit.TokensPerMinute > 0 {
+			state.tokens = ratelimit.NewLimiter(limit.TokensPerMinute)
+		}
+		r.limits = append(r.limits, state)
+	}
+
+	go r.prune()
+	return r
+}
@@ -0,0 +1,154 @@
+package addons
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+func newTestRateLimiter(t *testing.T, limitsJSON string) *RateLimiter {
+	t.Helper()
+	limits, err := config.NewRateLimitsFromJSON([]byte(limitsJSON))
+	require.NoError(t, err)
+	r := NewRateLimiter(slog.Default(), limits)
+	t.Cleanup(func() { _ = r.Close() })
+	return r
+}
+
+func TestRateLimiter_Reject(t *testing.T) {
+	r := newTestRateLimiter(t, `{"limits": [{"name": "wf", "key": "workflow", "requests_per_minute": 2}]}`)
+
+	for range 2 {
+		f := newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o"}`, "")
+		f.Request.Header.Set(headers.WorkflowName, "indexer")
+		r.Request(f)
+		assert.Nil(t, f.Response)
+	}
+
+	f := newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o"}`, "")
+	f.Request.Header.Set(headers.WorkflowName, "indexer")
+	r.Request(f)
+	require.NotNil(t, f.Response)
+	assert.Equal(t, http.StatusTooManyRequests, f.Response.StatusCode)
+	assert.Equal(t, "30", f.Response.Header.Get("Retry-After"))
+	assert.Contains(t, string(f.Response.Body), "rate_limit_exceeded")
+
+	f = newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o"}`, "")
+	f.Request.Header.Set(headers.WorkflowName, "chat")
+	r.Request(f)
+	assert.Nil(t, f.Response, "other workflows have their own bucket")
+}
+
+func TestRateLimiter_Tokens(t *testing.T) {
+	r := newTestRateLimiter(t, `{"limits": [{"key": "model", "models": ["gpt-4o"], "tokens_per_minute": 1000}]}`)
+
+	f := newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o", "max_tokens": 900}`, "")
+	r.Request(f)
+	assert.Nil(t, f.Response)
+
+	f = newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o", "max_tokens": 900}`, "")
+	r.Request(f)
+	require.NotNil(t, f.Response)
+	assert.Equal(t, http.StatusTooManyRequests, f.Response.StatusCode)
+
+	f = newTestFlow(t, testChatCompletionsURL, `{"model": "o1", "max_tokens": 900}`, "")
+	r.Request(f)
+	assert.Nil(t, f.Response, "the limit doesn't apply to other models")
+}
+
+func TestRateLimiter_Queue(t *testing.T) {
+	// 600 per minute refills one request every 100ms
+	r := newTestRateLimiter(t, `{"limits": [{"key": "global", "requests_per_minute": 600, "action": "queue", "max_wait": "1s"}]}`)
+
+	for range 600 {
+		r.Request(newTestFlow(t, testChatCompletionsURL, `{}`, ""))
+	}
+
+	start := time.Now()
+	f := newTestFlow(t, testChatCompletionsURL, `{}`, "")
+	r.Request(f)
+	assert.Nil(t, f.Response)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, "the request was queued")
+}
+
+func TestEstimateRequestTokens(t *testing.T) {
+	assert.Zero(t, estimateRequestTokens(nil))
+	f := newTestFlow(t, testChatCompletionsURL, `{"max_completion_tokens": 100}`, "")
+	assert.InDelta(t, 108, estimateRequestTokens(f.Request), 0.001)
+}
+
+func TestRateLimiter_ClientIP(t *testing.T) {
+	r := newTestRateLimiter(t, `{"limits": [{"key": "ip", "requests_per_minute": 1}]}`)
+	forwarded := http.Header{}
+	forwarded.Set("X-Forwarded-For", "192.0.2.1")
+
+	assert.Equal(t, "198.51.100.1", r.clientIP("198.51.100.1:4000", forwarded), "nothing is trusted by default")
+
+	r.TrustForwardedFor(func(remoteAddr string) bool { return remoteAddr == "127.0.0.1:5000" })
+	assert.Equal(t, "192.0.2.1", r.clientIP("127.0.0.1:5000", forwarded))
+	assert.Equal(t, "127.0.0.1", r.clientIP("127.0.0.1:5000", http.Header{}), "the header is missing")
+	assert.Equal(t, "127.0.0.1", r.clientIP("127.0.0.1:5001", forwarded), "the header of other clients is ignored")
+}
+
+func TestRateLimiter_UpstreamMetrics(t *testing.T) {
+	r := newTestRateLimiter(t, `{"limits": [], "upstream": {"enabled": true}}`)
+	newFlow := func(host string) *px.Flow {
+		f := newTestFlow(t, testChatCompletionsURL, `{"model": "made-up-model-1234"}`, "")
+		f.Request.URL.Host = host
+		f.Request.Header.Set("Authorization", "Bearer sk-metrics-test")
+		f.Response = &px.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+		f.Response.Header.Set("X-Ratelimit-Remaining-Requests", "7")
+		return f
+	}
+	apiKey := utils.APIKeyHash(newFlow("api.openai.com").Request.Header)
+
+	series := testutil.CollectAndCount(metrics.UpstreamRateLimitRemaining)
+	r.Responseheaders(newFlow("metrics-test.example.com"))
+	assert.Equal(t, series, testutil.CollectAndCount(metrics.UpstreamRateLimitRemaining), "other hosts are not recorded")
+
+	r.Responseheaders(newFlow("api.openai.com"))
+	gauge := metrics.UpstreamRateLimitRemaining.WithLabelValues(apiKey, metricsOtherLabel, "requests")
+	assert.Equal(t, 7.0, testutil.ToFloat64(gauge), "unknown models are recorded as other")
+}
+
+func TestRateLimiter_Upstream(t *testing.T) {
+	r := newTestRateLimiter(t, `{"limits": [], "upstream": {"enabled": true, "action": "reject"}}`)
+
+	f := newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o"}`, "")
+	f.Request.Header.Set("Authorization", "Bearer sk-test")
+	r.Request(f)
+	require.Nil(t, f.Response, "no limits were reported yet")
+
+	f.Response = &px.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	f.Response.Header.Set("X-Ratelimit-Remaining-Requests", "1")
+	f.Response.Header.Set("X-Ratelimit-Reset-Requests", "29.5s")
+	r.Responseheaders(f)
+
+	f = newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o"}`, "")
+	f.Request.Header.Set("Authorization", "Bearer sk-test")
+	r.Request(f)
+	assert.Nil(t, f.Response, "one request was remaining")
+
+	f = newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o"}`, "")
+	f.Request.Header.Set("Authorization", "Bearer sk-test")
+	r.Request(f)
+	require.NotNil(t, f.Response)
+	assert.Equal(t, http.StatusTooManyRequests, f.Response.StatusCode)
+	assert.Equal(t, "30", f.Response.Header.Get("Retry-After"))
+
+	f = newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o"}`, "")
+	f.Request.Header.Set("Authorization", "Bearer sk-other")
+	r.Request(f)
+	assert.Nil(t, f.Response, "other API keys are tracked separately")
+}
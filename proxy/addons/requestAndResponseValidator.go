@@ -6,10 +6,13 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
 	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
 	px "github.com/proxati/mitmproxy/proxy"
 )
 
+const requestAndResponseValidatorName = "RequestAndResponseValidator"
+
 type RequestAndResponseValidator struct {
 	px.BaseAddon
 	logger *slog.Logger
@@ -29,7 +32,9 @@ func (c *RequestAndResponseValidator) Request(f *px.Flow) {
 		return
 	} else {
 		c.wg.Add(1)
+		metrics.InFlightFlows.WithLabelValues(requestAndResponseValidatorName).Inc()
 		defer c.wg.Done()
+		defer metrics.InFlightFlows.WithLabelValues(requestAndResponseValidatorName).Dec()
 	}
 
 	if f.Request != nil {
@@ -71,7 +76,9 @@ func (c *RequestAndResponseValidator) Response(f *px.Flow) {
 	if !c.closed.Load() {
 		// if the addon is NOT closed, then add to the wait group
 		c.wg.Add(1)
+		metrics.InFlightFlows.WithLabelValues(requestAndResponseValidatorName).Inc()
 		defer c.wg.Done()
+		defer metrics.InFlightFlows.WithLabelValues(requestAndResponseValidatorName).Dec()
 	}
 
 	if f.Response != nil {
@@ -102,5 +109,5 @@ func (d *RequestAndResponseValidator) Close() error {
 }
 
 func (d *RequestAndResponseValidator) String() string {
-	return "RequestAndResponseValidator"
+	return requestAndResponseValidatorName
 }
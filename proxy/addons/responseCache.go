@@ -12,6 +12,7 @@ import (
 	px "github.com/proxati/mitmproxy/proxy"
 
 	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
 	"github.com/proxati/llm_proxy/v2/proxy/addons/cache"
 	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
 	"github.com/proxati/llm_proxy/v2/proxy/addons/megadumper/formatters"
@@ -23,6 +24,7 @@ import (
 
 const (
 	DefaultMemoryCacheSize = 1000 // number of records to cache per URL
+	responseCacheAddonName = "ResponseCacheAddon"
 )
 
 var cacheOnlyMethods = map[string]struct{}{
@@ -40,12 +42,23 @@ var cacheOnlyResponseCodes = map[int]struct{}{
 	http.StatusNoContent: {},
 }
 
+// CacheStats is a point-in-time summary of the cache lookups handled by a ResponseCacheAddon
+type CacheStats struct {
+	Storage string `json:"storage"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Skips   uint64 `json:"skips"`
+}
+
 type ResponseCacheAddon struct {
 	px.BaseAddon
 	filterReqHeaders  *config.HeaderFilterGroup
 	filterRespHeaders *config.HeaderFilterGroup
 	formatter         formatters.MegaDumpFormatter
 	cache             cache.DB
+	hits              atomic.Uint64
+	misses            atomic.Uint64
+	skips             atomic.Uint64
 	wg                sync.WaitGroup
 	closed            atomic.Bool
 	logger            *slog.Logger
@@ -63,6 +76,8 @@ func (c *ResponseCacheAddon) requestOpen(logger *slog.Logger, f *px.Flow) {
 		}
 
 		logger.Info("Cache", "status", cacheStatusHeaderValue)
+		metrics.CacheLookupsTotal.WithLabelValues(cacheStatusHeaderValue).Inc()
+		c.countCacheStatus(cacheStatusHeaderValue)
 		f.Request.Header.Set(headers.CacheStatusHeader, cacheStatusHeaderValue)
 	}()
 
@@ -137,6 +152,28 @@ func (c *ResponseCacheAddon) requestOpen(logger *slog.Logger, f *px.Flow) {
 	f.Response = encodedCachedResponse
 }
 
+// countCacheStatus increments the internal counter for the HIT/MISS/SKIP cache status
+func (c *ResponseCacheAddon) countCacheStatus(cacheStatus string) {
+	switch cacheStatus {
+	case headers.CacheStatusValueHit:
+		c.hits.Add(1)
+	case headers.CacheStatusValueMiss:
+		c.misses.Add(1)
+	case headers.CacheStatusValueSkip:
+		c.skips.Add(1)
+	}
+}
+
+// Stats returns the cache lookup counters, since this addon was created
+func (c *ResponseCacheAddon) Stats() CacheStats {
+	return CacheStats{
+		Storage: fmt.Sprintf("%s", c.cache),
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Skips:   c.skips.Load(),
+	}
+}
+
 func (c *ResponseCacheAddon) Request(f *px.Flow) {
 	logger := configLoggerFieldsWithFlow(c.logger, f).WithGroup("Request")
 
@@ -145,7 +182,9 @@ func (c *ResponseCacheAddon) Request(f *px.Flow) {
 		return
 	} else {
 		c.wg.Add(1) // for blocking this addon during shutdown in .Close()
+		metrics.InFlightFlows.WithLabelValues(responseCacheAddonName).Inc()
 		defer c.wg.Done()
+		defer metrics.InFlightFlows.WithLabelValues(responseCacheAddonName).Dec()
 	}
 
 	c.requestOpen(logger, f)
@@ -216,12 +255,14 @@ func (c *ResponseCacheAddon) Response(f *px.Flow) {
 		return
 	} else {
 		c.wg.Add(1) // for blocking this addon during shutdown in .Close()
+		metrics.InFlightFlows.WithLabelValues(responseCacheAddonName).Inc()
 		// any returns after this need a .Done() call!
 	}
 
 	earlyReturnErr := c.responseCommon(f)
 	if earlyReturnErr != nil {
 		logger.Debug("Skipping cache storage", "reason", earlyReturnErr.Error())
+		metrics.InFlightFlows.WithLabelValues(responseCacheAddonName).Dec()
 		c.wg.Done()
 		return
 	}
@@ -229,7 +270,8 @@ func (c *ResponseCacheAddon) Response(f *px.Flow) {
 	go func() {
 		logger.Debug("Response cache storage starting...")
 		defer c.wg.Done() // .Done() must be inside the goroutine, so that .Close() waits for the storage to finish
-		<-f.Done()        // block until the response from upstream is fully read into proxy memory, and other addons have run
+		defer metrics.InFlightFlows.WithLabelValues(responseCacheAddonName).Dec()
+		<-f.Done() // block until the response from upstream is fully read into proxy memory, and other addons have run
 
 		err := c.responseStorage(f)
 		if err != nil {
@@ -241,7 +283,7 @@ func (c *ResponseCacheAddon) Response(f *px.Flow) {
 }
 
 func (d *ResponseCacheAddon) String() string {
-	return fmt.Sprintf("ResponseCacheAddon (%s)", d.cache)
+	return fmt.Sprintf("%s (%s)", responseCacheAddonName, d.cache)
 }
 
 func (d *ResponseCacheAddon) Close() error {
@@ -95,6 +95,24 @@ func TestNewCacheAddonErr(t *testing.T) {
 	})
 }
 
+func TestCacheStats(t *testing.T) {
+	t.Parallel()
+	emptyHeaderFilterGroup := config.NewHeaderFilterGroup(t.Name(), []string{}, []string{})
+	cache, err := NewCacheAddon(slog.Default(), "memory", t.TempDir(), emptyHeaderFilterGroup, emptyHeaderFilterGroup)
+	require.NoError(t, err)
+
+	cache.countCacheStatus(headers.CacheStatusValueHit)
+	cache.countCacheStatus(headers.CacheStatusValueHit)
+	cache.countCacheStatus(headers.CacheStatusValueMiss)
+	cache.countCacheStatus(headers.CacheStatusValueSkip)
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Skips)
+	assert.NotEmpty(t, stats.Storage)
+}
+
 func TestRequest(t *testing.T) {
 	t.Parallel()
 	testLogger := slog.Default()
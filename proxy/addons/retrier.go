@@ -0,0 +1,253 @@
+package addons
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/providers"
+)
+
+const retrierName = "Retrier"
+
+// retryablePaths are the API paths of the requests that are retried, the completions and embeddings
+var retryablePaths = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/responses":        true,
+}
+
+// retryableStatusCodes are the upstream responses that are worth another try
+var retryableStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// Retrier sends requests upstream, and retries them after a 429, a 500/502/503/504, or a connection
+// error. The backoff between attempts is exponential with jitter, and is at least the upstream
+// Retry-After. Each request has a retry budget, so a client isn't held for longer than that. When a
+// request is retried, each attempt is saved in the connection stats of the traffic log.
+//
+// Only the completion and embedding requests to the known API hosts are retried. Requests routed by
+// the UpstreamRouter use its failover instead.
+type Retrier struct {
+	px.BaseAddon
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	budget      time.Duration
+	client      *http.Client
+	attempts    sync.Map // flow ID -> JSON list of attempts, until Responseheaders
+	done        chan struct{}
+	closed      atomic.Bool
+	logger      *slog.Logger
+}
+
+// Handles returns true for the completion and embedding requests to the known API hosts that don't
+// stream the response, until the addon is closed
+func (r *Retrier) Handles(f *px.Flow) bool {
+	if r.closed.Load() || f.Request == nil || f.Request.URL == nil {
+		return false
+	}
+	if _, ok := providers.APIHostnames[f.Request.URL.Hostname()]; !ok {
+		return false
+	}
+	if f.Request.Method != http.MethodPost && f.Request.Method != http.MethodGet {
+		return false
+	}
+	return retryablePaths[f.Request.URL.Path] && !isStreamingRequest(f.Request)
+}
+
+// SendUpstream sends the request to the requested URL, and retries it until the response isn't
+// retryable, the max attempts are used, or the next backoff would go over the retry budget. The
+// last response is set on the flow, even when it's a 429 or 5xx. An error is returned when the
+// last attempt got no response.
+func (r *Retrier) SendUpstream(f *px.Flow, body []byte) error {
+	logger := configLoggerFieldsWithFlow(r.logger, f)
+	host := f.Request.URL.Hostname()
+	start := time.Now()
+
+	attempts := make([]schema.UpstreamAttempt, 0, 1)
+	var resp *px.Response
+	var err error
+	var delay time.Duration
+	for n := 1; ; n++ {
+		attemptStart := time.Now()
+		resp, err = r.send(f, body)
+
+		attempt := schema.UpstreamAttempt{
+			Attempt:  n,
+			Duration: time.Since(attemptStart).Milliseconds(),
+			Delay:    delay.Milliseconds(),
+		}
+		result := "error"
+		if err != nil {
+			attempt.Error = err.Error()
+		} else {
+			attempt.StatusCode = resp.StatusCode
+			result = strconv.Itoa(resp.StatusCode)
+		}
+		attempts = append(attempts, attempt)
+		metrics.RetryAttemptsTotal.WithLabelValues(host, result).Inc()
+
+		if err == nil && !retryableStatusCodes[resp.StatusCode] {
+			break
+		}
+		if n >= r.maxAttempts {
+			logger.Warn("Upstream request failed, no attempts left", "attempt", n, "result", result)
+			break
+		}
+
+		delay = r.backoff(n, resp)
+		if time.Since(start)+delay > r.budget {
+			logger.Warn("Upstream request failed, the retry budget is used up",
+				"attempt", n, "result", result, "nextDelay", delay)
+			break
+		}
+
+		logger.Info("Retrying upstream request", "attempt", n, "result", result, "error", err, "delay", delay)
+		if !r.sleep(delay) {
+			break
+		}
+	}
+
+	encoded, _ := json.Marshal(attempts)
+	if err != nil {
+		if len(attempts) > 1 {
+			// no Responseheaders for a failed request, so the header is set here
+			f.Request.Header.Set(headers.Attempts, string(encoded))
+		}
+		return err
+	}
+
+	if len(attempts) > 1 {
+		r.attempts.Store(f.Id, string(encoded))
+	}
+	f.Response = resp
+	return nil
+}
+
+// Responseheaders records the attempts in the request headers, for the connection stats
+func (r *Retrier) Responseheaders(f *px.Flow) {
+	if attempts, ok := r.attempts.LoadAndDelete(f.Id); ok {
+		f.Request.Header.Set(headers.Attempts, attempts.(string))
+	}
+}
+
+// send makes one request to the requested URL, and reads the full response
+func (r *Retrier) send(f *px.Flow, body []byte) (*px.Response, error) {
+	req, err := newUpstreamRequest(f, f.Request.URL, f.Request.Header.Clone(), body)
+	if err != nil {
+		return nil, err
+	}
+	return doUpstream(r.client, req)
+}
+
+// backoff returns the delay before the next attempt: an exponential backoff with jitter, or the
+// upstream Retry-After when it's longer
+func (r *Retrier) backoff(attempt int, resp *px.Response) time.Duration {
+	delay := r.baseDelay << (attempt - 1)
+	if delay > r.maxDelay || delay <= 0 {
+		delay = r.maxDelay
+	}
+	// equal jitter: half of the delay, plus a random part of the other half
+	delay = delay/2 + rand.N(delay/2+1)
+
+	if resp != nil {
+		delay = max(delay, parseRetryAfter(resp.Header, time.Now()))
+	}
+	return delay
+}
+
+// sleep waits for the delay, and returns false when the addon is closed first
+func (r *Retrier) sleep(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// parseRetryAfter returns the delay from the retry-after-ms header sent by OpenAI, or from the
+// standard Retry-After header, in seconds or as an HTTP date. Zero when there is neither.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+func (r *Retrier) String() string {
+	return retrierName
+}
+
+// Close wakes up the requests waiting for a retry, and stops retrying
+func (r *Retrier) Close() error {
+	if !r.closed.Swap(true) {
+		r.logger.Debug("Closing Retrier...")
+		close(r.done)
+	}
+	return nil
+}
+
+// NewRetrier creates a new Retrier addon. The maxAttempts include the first attempt, so it must be
+// at least 2. When insecureSkipVerifyTLS is true, the TLS certificates of the upstream hosts are
+// not verified. The upstreamTLS config is optional.
+func NewRetrier(
+	logger *slog.Logger,
+	maxAttempts int,
+	baseDelay, maxDelay, budget time.Duration,
+	insecureSkipVerifyTLS bool,
+	upstreamTLS *config.UpstreamTLS,
+	upstreamProxy *config.UpstreamProxy,
+) (*Retrier, error) {
+	if maxAttempts < 2 {
+		return nil, errors.New("retry max attempts must be at least 2")
+	}
+	if baseDelay <= 0 || maxDelay < baseDelay {
+		return nil, errors.New("retry base delay must be positive, and not longer than the max delay")
+	}
+	if budget <= 0 {
+		return nil, errors.New("retry budget must be positive")
+	}
+
+	return &Retrier{
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		maxDelay:    maxDelay,
+		budget:      budget,
+		client:      newUpstreamClient(insecureSkipVerifyTLS, upstreamTLS, upstreamProxy),
+		done:        make(chan struct{}),
+		logger:      logger.WithGroup("addons.Retrier"),
+	}, nil
+}
@@ -0,0 +1,160 @@
+package addons
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+func getTestAttempts(t *testing.T, f *px.Flow) []schema.UpstreamAttempt {
+	t.Helper()
+	var attempts []schema.UpstreamAttempt
+	if value := f.Request.Header.Get(headers.Attempts); value != "" {
+		require.NoError(t, json.Unmarshal([]byte(value), &attempts))
+	}
+	return attempts
+}
+
+func TestRetrier(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	r, err := NewRetrier(slog.Default(), 3, time.Millisecond, 10*time.Millisecond, time.Second, false, nil, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = r.Close() })
+	assert.Equal(t, retrierName, r.String())
+
+	f := newTestFlow(t, srv.URL+"/v1/chat/completions", `{"model":"gpt-4o"}`, "")
+	require.NoError(t, r.SendUpstream(f, f.Request.Body))
+	r.Responseheaders(f)
+
+	require.NotNil(t, f.Response)
+	assert.Equal(t, http.StatusOK, f.Response.StatusCode)
+	attempts := getTestAttempts(t, f)
+	require.Len(t, attempts, 3)
+	assert.Equal(t, http.StatusServiceUnavailable, attempts[0].StatusCode)
+	assert.Zero(t, attempts[0].Delay)
+	assert.Equal(t, 3, attempts[2].Attempt)
+	assert.Equal(t, http.StatusOK, attempts[2].StatusCode)
+
+	t.Run("no retry for a success", func(t *testing.T) {
+		f := newTestFlow(t, srv.URL, `{"model":"gpt-4o"}`, "")
+		require.NoError(t, r.SendUpstream(f, f.Request.Body))
+		r.Responseheaders(f)
+		assert.Empty(t, getTestAttempts(t, f))
+	})
+}
+
+func TestRetrier_Handles(t *testing.T) {
+	r, err := NewRetrier(slog.Default(), 3, time.Millisecond, 10*time.Millisecond, time.Second, false, nil, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		method  string
+		rawURL  string
+		body    string
+		accept  string
+		handles bool
+	}{
+		{"chat completion", http.MethodPost, "https://api.openai.com/v1/chat/completions", `{"model": "gpt-4o"}`, "", true},
+		{"embedding", http.MethodPost, "https://api.openai.com/v1/embeddings", `{"input": "hi"}`, "", true},
+		{"not streamed", http.MethodPost, "https://api.openai.com/v1/responses", `{"stream": false}`, "", true},
+		{"streamed", http.MethodPost, "https://api.openai.com/v1/chat/completions", `{"model": "gpt-4o", "stream": true}`, "", false},
+		{"event stream", http.MethodPost, "https://api.openai.com/v1/chat/completions", `{}`, "text/event-stream", false},
+		{"other path", http.MethodPost, "https://api.openai.com/v1/files", `{}`, "", false},
+		{"other method", http.MethodDelete, "https://api.openai.com/v1/chat/completions", "", "", false},
+		{"other host", http.MethodPost, "https://example.com/v1/chat/completions", `{}`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFlow(t, tt.rawURL, tt.body, "")
+			f.Request.Method = tt.method
+			if tt.accept != "" {
+				f.Request.Header.Set("Accept", tt.accept)
+			}
+			assert.Equal(t, tt.handles, r.Handles(f))
+		})
+	}
+
+	require.NoError(t, r.Close())
+	assert.False(t, r.Handles(newTestFlow(t, "https://api.openai.com/v1/chat/completions", `{"model":"gpt-4o"}`, "")), "closed")
+}
+
+func TestRetrier_Budget(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(srv.Close)
+
+	r, err := NewRetrier(slog.Default(), 5, time.Millisecond, 10*time.Millisecond, time.Second, false, nil, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = r.Close() })
+
+	f := newTestFlow(t, srv.URL, `{"model":"gpt-4o"}`, "")
+	require.NoError(t, r.SendUpstream(f, f.Request.Body))
+	assert.Equal(t, http.StatusTooManyRequests, f.Response.StatusCode, "the Retry-After is over the budget")
+	assert.Equal(t, int32(1), hits.Load())
+}
+
+func TestRetrier_ConnectionError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	r, err := NewRetrier(slog.Default(), 2, time.Millisecond, time.Millisecond, time.Second, false, nil, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = r.Close() })
+
+	f := newTestFlow(t, srv.URL, `{"model":"gpt-4o"}`, "")
+	assert.Error(t, r.SendUpstream(f, f.Request.Body))
+	assert.Nil(t, f.Response)
+
+	attempts := getTestAttempts(t, f)
+	require.Len(t, attempts, 2)
+	assert.NotEmpty(t, attempts[1].Error)
+}
+
+func TestNewRetrier_Invalid(t *testing.T) {
+	_, err := NewRetrier(slog.Default(), 1, time.Second, time.Second, time.Minute, false, nil, nil)
+	assert.Error(t, err)
+	_, err = NewRetrier(slog.Default(), 3, time.Minute, time.Second, time.Minute, false, nil, nil)
+	assert.Error(t, err)
+	_, err = NewRetrier(slog.Default(), 3, time.Second, time.Second, 0, false, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	header := http.Header{}
+	assert.Zero(t, parseRetryAfter(header, now))
+
+	header.Set("Retry-After", "2")
+	assert.Equal(t, 2*time.Second, parseRetryAfter(header, now))
+
+	header.Set("Retry-After", now.Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.Equal(t, time.Minute, parseRetryAfter(header, now))
+
+	header.Set("Retry-After-Ms", "250")
+	assert.Equal(t, 250*time.Millisecond, parseRetryAfter(header, now))
+}
@@ -0,0 +1,255 @@
+package addons
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/jsonschema"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
+	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const schemaValidatorName = "SchemaValidator"
+
+// schemaValidatorAnyObject is the schema of the tool arguments, for the tools without parameters
+const schemaValidatorAnyObject = `{"type": "object"}`
+
+// SchemaValidator validates the assistant content and the tool calls of the responses against the
+// JSON schemas in the request: the json_schema response_format, or the tool parameters. The result
+// is saved in the internal SchemaValidationResult request header for the traffic logs, and the
+// SchemaValidation response header is "valid" or "invalid". When a response is invalid, the request
+// is sent upstream again, up to the max retries. In enforce mode, a response that's still invalid
+// is replaced with a 502.
+//
+// The retries are sent to the requested URL, so the requests routed by the UpstreamRouter aren't
+// retried. Streamed responses aren't validated.
+type SchemaValidator struct {
+	px.BaseAddon
+	mode              config.GuardrailMode
+	maxRetries        int
+	requestToUpstream *config.HeaderFilterGroup
+	client            *http.Client
+	logger            *slog.Logger
+}
+
+// outputValidators are the compiled schemas of a request
+type outputValidators struct {
+	content    *jsonschema.Schema // nil when the content has no schema
+	jsonObject bool
+	tools      map[string]*jsonschema.Schema
+}
+
+// Response validates the response, retries the invalid ones, and blocks them in enforce mode. This
+// runs before the other Response hooks, so they only see the final response.
+func (sv *SchemaValidator) Response(f *px.Flow) {
+	if f.Request == nil || f.Response == nil || f.Response.StatusCode != http.StatusOK || len(f.Request.Body) == 0 {
+		return
+	}
+	logger := configLoggerFieldsWithFlow(sv.logger, f).WithGroup("Response")
+
+	reqBody, err := utils.DecodeBody(f.Request.Body, f.Request.Header.Get("Content-Encoding"))
+	if err != nil {
+		logger.Debug("Unable to decode request body, skipping schema validation", "error", err)
+		return
+	}
+	schemas := utils.RequestOutputSchemas(reqBody)
+	if schemas.IsEmpty() {
+		return
+	}
+	validators, err := compileOutputValidators(schemas)
+	if err != nil {
+		// the upstream API rejects invalid schemas too
+		logger.Debug("Unable to compile the requested schemas, skipping schema validation", "error", err)
+		return
+	}
+
+	errs, checked := sv.validate(logger, validators, f.Response)
+	if !checked {
+		return
+	}
+	result := schema.SchemaValidationResult{Mode: string(sv.mode), Attempts: 1}
+	for len(errs) > 0 && result.Attempts <= sv.maxRetries {
+		if f.Request.Header.Get(headers.Upstream) != "" {
+			logger.Debug("Not retrying a request that was routed to an upstream pool")
+			break
+		}
+		logger.Info("Response doesn't match the requested schema, retrying",
+			"attempt", result.Attempts, "errors", len(errs), "firstError", errs[0])
+
+		resp, err := sv.resend(f)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			logger.Warn("Schema validation retry failed, keeping the last response", "error", err, "status", statusOf(resp))
+			break
+		}
+		result.Attempts++
+		replaceResponse(f, resp)
+		errs, _ = sv.validate(logger, validators, f.Response)
+	}
+
+	result.Valid = len(errs) == 0
+	result.Errors = errs
+	result.Blocked = !result.Valid && sv.mode == config.GuardrailModeEnforce
+	saveResultHeader(logger, f, headers.SchemaValidationResult, result)
+
+	outcome := "valid"
+	if !result.Valid {
+		outcome = "invalid"
+		logger.Warn("Response doesn't match the requested schema", "attempts", result.Attempts, "errors", errs)
+	}
+	metrics.SchemaValidationsTotal.WithLabelValues(outcome, strconv.FormatBool(result.Attempts > 1)).Inc()
+
+	if result.Blocked {
+		helpers.ReplaceWithErrorResponse(f, http.StatusBadGateway, "server_error", "schema_validation_failed",
+			schemaValidationMessage(errs))
+	}
+	if f.Response.Header == nil {
+		f.Response.Header = make(http.Header)
+	}
+	f.Response.Header.Set(headers.SchemaValidation, outcome)
+}
+
+// validate returns the validation errors of the response outputs that have a schema, and false when
+// no output was checked, e.g. the response isn't JSON
+func (sv *SchemaValidator) validate(logger *slog.Logger, validators *outputValidators, resp *px.Response) ([]string, bool) {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") || len(resp.Body) == 0 {
+		return nil, false
+	}
+	body, err := utils.DecodeBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		logger.Debug("Unable to decode response body, skipping schema validation", "error", err)
+		return nil, false
+	}
+
+	var errs []string
+	checked := false
+	for _, output := range utils.ResponseOutputs(body) {
+		var s *jsonschema.Schema
+		if output.Tool == "" {
+			if validators.content == nil && !validators.jsonObject {
+				continue
+			}
+			s = validators.content
+		} else {
+			var ok bool
+			if s, ok = validators.tools[output.Tool]; !ok {
+				checked = true
+				errs = append(errs, fmt.Sprintf("%s: unknown tool %q", output.Path, output.Tool))
+				continue
+			}
+		}
+
+		checked = true
+		var outputErrs []string
+		if s != nil {
+			outputErrs = s.Validate(output.JSON)
+		} else if !json.Valid(output.JSON) || !strings.HasPrefix(strings.TrimSpace(string(output.JSON)), "{") {
+			outputErrs = []string{"expected a JSON object"}
+		}
+		for _, e := range outputErrs {
+			errs = append(errs, output.Path+": "+e)
+		}
+	}
+	return errs, checked
+}
+
+// resend sends the request upstream again, with the headers that the proxy sent
+func (sv *SchemaValidator) resend(f *px.Flow) (*px.Response, error) {
+	header := sv.requestToUpstream.FilterHeaders(f.Request.Header)
+	req, err := newUpstreamRequest(f, f.Request.URL, header, f.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	return doUpstream(sv.client, req)
+}
+
+func (sv *SchemaValidator) String() string {
+	return schemaValidatorName
+}
+
+// compileOutputValidators compiles the content and tool schemas of a request
+func compileOutputValidators(schemas utils.OutputSchemas) (*outputValidators, error) {
+	validators := &outputValidators{
+		jsonObject: schemas.JSONObject,
+		tools:      make(map[string]*jsonschema.Schema, len(schemas.Tools)),
+	}
+	if schemas.Content != nil {
+		s, err := jsonschema.Compile(schemas.Content)
+		if err != nil {
+			return nil, fmt.Errorf("response format: %w", err)
+		}
+		validators.content = s
+	}
+	for name, parameters := range schemas.Tools {
+		if parameters == nil {
+			parameters = json.RawMessage(schemaValidatorAnyObject)
+		}
+		s, err := jsonschema.Compile(parameters)
+		if err != nil {
+			return nil, fmt.Errorf("tool %s: %w", name, err)
+		}
+		validators.tools[name] = s
+	}
+	return validators, nil
+}
+
+// replaceResponse replaces the response of the flow with a new upstream response. The response is
+// changed in place, so the log addons that keep a reference to the response header map see the new
+// headers.
+func replaceResponse(f *px.Flow, resp *px.Response) {
+	for name := range f.Response.Header {
+		delete(f.Response.Header, name)
+	}
+	for name, values := range resp.Header {
+		f.Response.Header[name] = values
+	}
+	f.Response.StatusCode = resp.StatusCode
+	f.Response.Body = resp.Body
+}
+
+// schemaValidationMessage returns the error message for a blocked response, with the first errors
+func schemaValidationMessage(errs []string) string {
+	if len(errs) > 3 {
+		errs = append(errs[:3:3], fmt.Sprintf("and %d more", len(errs)-3))
+	}
+	return "The model output doesn't match the requested schema: " + strings.Join(errs, "; ")
+}
+
+// statusOf returns the status code of a response, or 0 when it's nil
+func statusOf(resp *px.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// NewSchemaValidator creates a new SchemaValidator addon, the mode must be monitor or enforce. The
+// retries are sent with the headers that aren't in requestToUpstream, like the proxy library does.
+// When insecureSkipVerifyTLS is true, the TLS certificates of the upstream hosts are not verified.
+// The upstreamTLS config is optional.
+func NewSchemaValidator(
+	logger *slog.Logger,
+	mode config.GuardrailMode,
+	maxRetries int,
+	requestToUpstream *config.HeaderFilterGroup,
+	insecureSkipVerifyTLS bool,
+	upstreamTLS *config.UpstreamTLS,
+	upstreamProxy *config.UpstreamProxy,
+) *SchemaValidator {
+	return &SchemaValidator{
+		mode:              mode,
+		maxRetries:        maxRetries,
+		requestToUpstream: requestToUpstream,
+		client:            newUpstreamClient(insecureSkipVerifyTLS, upstreamTLS, upstreamProxy),
+		logger:            logger.WithGroup("addons.SchemaValidator"),
+	}
+}
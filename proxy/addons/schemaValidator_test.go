@@ -0,0 +1,136 @@
+package addons
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+const structuredOutputRequest = `{"model": "gpt-4o", "messages": [{"role": "user", "content": "a person"}],
+	"response_format": {"type": "json_schema", "json_schema": {"name": "person", "strict": true, "schema": {
+		"type": "object",
+		"properties": {"name": {"type": "string"}, "age": {"type": "integer"}},
+		"required": ["name", "age"],
+		"additionalProperties": false
+	}}},
+	"tools": [{"type": "function", "function": {"name": "lookup", "parameters": {"type": "object", "required": ["id"]}}}]
+}`
+
+// newChatCompletion returns a chat completion response body with the content
+func newChatCompletion(content string) string {
+	encoded, _ := json.Marshal(content)
+	return `{"choices": [{"message": {"role": "assistant", "content": ` + string(encoded) + `}}]}`
+}
+
+func getSchemaValidationResult(t *testing.T, f *px.Flow) schema.SchemaValidationResult {
+	t.Helper()
+	var result schema.SchemaValidationResult
+	require.NoError(t, json.Unmarshal([]byte(f.Request.Header.Get(headers.SchemaValidationResult)), &result))
+	return result
+}
+
+func TestSchemaValidator(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		sv := NewSchemaValidator(slog.Default(), config.GuardrailModeMonitor, 0, config.NewHeaderFiltersContainer().RequestToUpstream, false, nil, nil)
+		assert.Equal(t, schemaValidatorName, sv.String())
+
+		f := newTestFlow(t, testChatCompletionsURL, structuredOutputRequest, newChatCompletion(`{"name": "Ada", "age": 36}`))
+		sv.Response(f)
+		assert.Equal(t, schema.SchemaValidationResult{Mode: "monitor", Valid: true, Attempts: 1}, getSchemaValidationResult(t, f))
+		assert.Equal(t, "valid", f.Response.Header.Get(headers.SchemaValidation))
+	})
+
+	t.Run("invalid in monitor mode", func(t *testing.T) {
+		sv := NewSchemaValidator(slog.Default(), config.GuardrailModeMonitor, 0, config.NewHeaderFiltersContainer().RequestToUpstream, false, nil, nil)
+
+		body := `{"choices": [{"message": {"role": "assistant", "content": "{\"name\": \"Ada\"}", "tool_calls": [
+			{"type": "function", "function": {"name": "lookup", "arguments": "{}"}},
+			{"type": "function", "function": {"name": "delete_all", "arguments": "{}"}}
+		]}}]}`
+		f := newTestFlow(t, testChatCompletionsURL, structuredOutputRequest, body)
+		sv.Response(f)
+		assert.Equal(t, schema.SchemaValidationResult{Mode: "monitor", Attempts: 1, Errors: []string{
+			`choices[0].message.content: $: missing required property "age"`,
+			`choices[0].message.tool_calls[0]: $: missing required property "id"`,
+			`choices[0].message.tool_calls[1]: unknown tool "delete_all"`,
+		}}, getSchemaValidationResult(t, f))
+		assert.Equal(t, http.StatusOK, f.Response.StatusCode)
+		assert.Equal(t, body, string(f.Response.Body), "the response is sent as-is")
+		assert.Equal(t, "invalid", f.Response.Header.Get(headers.SchemaValidation))
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		var hits atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Empty(t, r.Header.Get(headers.SchemaValidationResult), "internal headers aren't sent upstream")
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Attempt", "retry")
+			if hits.Add(1) < 2 {
+				_, _ = w.Write([]byte(newChatCompletion(`{"name": "Ada", "age": "36"}`)))
+				return
+			}
+			_, _ = w.Write([]byte(newChatCompletion(`{"name": "Ada", "age": 36}`)))
+		}))
+		t.Cleanup(srv.Close)
+
+		sv := NewSchemaValidator(slog.Default(), config.GuardrailModeEnforce, 3, config.NewHeaderFiltersContainer().RequestToUpstream, false, nil, nil)
+		f := newTestFlow(t, srv.URL+"/v1/chat/completions", `{"model":"gpt-4o"}`, "")
+		f.Request.Body = []byte(structuredOutputRequest)
+		f.Request.Header.Set(headers.PromptGuardResult, "{}")
+		f.Response = &px.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}, "X-Attempt": {"first"}},
+			Body:       []byte(newChatCompletion(`not json`)),
+		}
+		header := f.Response.Header
+
+		sv.Response(f)
+		assert.Equal(t, int32(2), hits.Load())
+		assert.Equal(t, schema.SchemaValidationResult{Mode: "enforce", Valid: true, Attempts: 3}, getSchemaValidationResult(t, f))
+		assert.Equal(t, newChatCompletion(`{"name": "Ada", "age": 36}`), string(f.Response.Body))
+		assert.Equal(t, "retry", header.Get("X-Attempt"), "the header map is changed in place")
+	})
+
+	t.Run("enforce", func(t *testing.T) {
+		sv := NewSchemaValidator(slog.Default(), config.GuardrailModeEnforce, 0, config.NewHeaderFiltersContainer().RequestToUpstream, false, nil, nil)
+
+		f := newTestFlow(t, testChatCompletionsURL, structuredOutputRequest, newChatCompletion(`{"name": "Ada", "age": 36.5}`))
+		sv.Response(f)
+		assert.Equal(t, http.StatusBadGateway, f.Response.StatusCode)
+		assert.JSONEq(t, `{"error": {
+			"message": "The model output doesn't match the requested schema: choices[0].message.content: $.age: expected integer, got number",
+			"type": "server_error",
+			"code": "schema_validation_failed"
+		}}`, string(f.Response.Body))
+		assert.True(t, getSchemaValidationResult(t, f).Blocked)
+		assert.Equal(t, "invalid", f.Response.Header.Get(headers.SchemaValidation))
+	})
+
+	t.Run("skipped", func(t *testing.T) {
+		sv := NewSchemaValidator(slog.Default(), config.GuardrailModeEnforce, 0, config.NewHeaderFiltersContainer().RequestToUpstream, false, nil, nil)
+
+		for name, f := range map[string]*px.Flow{
+			"no schema":  newTestFlow(t, testChatCompletionsURL, `{"model": "gpt-4o"}`, newChatCompletion("hi")),
+			"not json":   newTestFlow(t, testChatCompletionsURL, structuredOutputRequest, "data: {}"),
+			"bad schema": newTestFlow(t, testChatCompletionsURL, `{"response_format": {"type": "json_schema", "json_schema": {"schema": {"type": "date"}}}}`, newChatCompletion("hi")),
+		} {
+			t.Run(name, func(t *testing.T) {
+				sv.Response(f)
+				assert.Equal(t, http.StatusOK, f.Response.StatusCode)
+				assert.Empty(t, f.Request.Header.Get(headers.SchemaValidationResult))
+				assert.Empty(t, f.Response.Header.Get(headers.SchemaValidation))
+			})
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package addons
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
+)
+
+const (
+	tlsPassthroughName = "TLSPassthrough"
+
+	// tlsPassthroughDialTimeout is how long to wait for the upstream TCP connection of a tunnel
+	tlsPassthroughDialTimeout = 30 * time.Second
+)
+
+// TLSPassthrough tunnels the CONNECT requests for hosts that aren't in the intercept list as raw
+// TCP, without decrypting the TLS connection, and without calling the other addons. So the proxy
+// can be the system-wide proxy of a dev machine, and only the LLM API traffic is intercepted,
+// logged, and modified.
+//
+// The proxy library handles CONNECT requests without calling the addons, so the first request of
+// every client connection is checked in ClientConnected, by wrapping the connection before the
+// proxy reads from it. Plain HTTP requests aren't tunneled, because they aren't encrypted.
+type TLSPassthrough struct {
+	px.BaseAddon
+	hosts  config.InterceptHosts
+	dial   func(ctx context.Context, network, addr string) (net.Conn, error)
+	logger *slog.Logger
+}
+
+// ClientConnected wraps the client connection, so a CONNECT request for a host that isn't
+// intercepted is tunneled before the proxy reads it
+func (tp *TLSPassthrough) ClientConnected(client *px.ClientConn) {
+	if client == nil || client.Conn == nil || client.Conn.Conn == nil {
+		return
+	}
+	client.Conn.Conn = newFirstRequestConn(client.Conn.Conn, tp.handleFirstRequest)
+}
+
+// handleFirstRequest tunnels a CONNECT request for a host that isn't intercepted, and returns
+// io.EOF when the tunnel is closed. Every other request is read by the proxy.
+func (tp *TLSPassthrough) handleFirstRequest(c *firstRequestConn, req *http.Request, err error) error {
+	if err != nil || req.Method != http.MethodConnect || tp.hosts.Intercepts(req.Host) {
+		// the proxy responds to the requests that can't be parsed
+		return nil
+	}
+	return tp.tunnel(c, req.Host)
+}
+
+// tunnel copies the bytes between the client and the upstream host, until one of them closes the
+// connection
+func (tp *TLSPassthrough) tunnel(c *firstRequestConn, host string) error {
+	logger := tp.logger.With("host", host, "client.address", c.RemoteAddr().String())
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, "443")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tlsPassthroughDialTimeout)
+	upstream, err := tp.dial(ctx, "tcp", addr)
+	cancel()
+	if err != nil {
+		logger.Warn("Unable to connect to the upstream host of a tunnel", "error", err)
+		body := helpers.NewErrorBody("upstream_error", "upstream_connection_failed", "Unable to connect to "+addr)
+		if err := c.writeResponse(http.StatusBadGateway, nil, body); err != nil {
+			logger.Debug("Unable to write the response", "error", err)
+		}
+		return io.EOF
+	}
+	defer upstream.Close()
+
+	if _, err := io.WriteString(c.Conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		logger.Debug("Unable to write the CONNECT response", "error", err)
+		return io.EOF
+	}
+	logger.Debug("Tunneling a connection without TLS interception")
+
+	// the client may have sent the TLS handshake already, which is buffered by the reader
+	pipeConns(logger, c.Conn, c.hijack(), upstream)
+	return io.EOF
+}
+
+func (tp *TLSPassthrough) String() string {
+	return tlsPassthroughName
+}
+
+// NewTLSPassthrough creates the addon that tunnels the TLS connections to the hosts that aren't in
+// the intercept list. The tunnels are opened through the upstream proxy, when it's not nil.
+func NewTLSPassthrough(logger *slog.Logger, hosts config.InterceptHosts, upstreamProxy *UpstreamProxy) *TLSPassthrough {
+	tp := &TLSPassthrough{
+		hosts:  hosts,
+		dial:   (&net.Dialer{}).DialContext,
+		logger: logger.WithGroup("addons.TLSPassthrough"),
+	}
+	if upstreamProxy != nil {
+		tp.dial = upstreamProxy.DialContext
+	}
+	return tp
+}
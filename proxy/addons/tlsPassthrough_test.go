@@ -0,0 +1,122 @@
+package addons
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+)
+
+// newEchoServer starts a TCP server that echoes every byte back, as the upstream host of a tunnel
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestTLSPassthroughFirstRequest(t *testing.T) {
+	hosts, err := config.ParseInterceptHosts([]string{"api.openai.com"})
+	require.NoError(t, err)
+	tp := NewTLSPassthrough(slog.Default(), hosts, nil)
+	assert.Equal(t, tlsPassthroughName, tp.String())
+
+	t.Run("tunnel", func(t *testing.T) {
+		upstream := newEchoServer(t)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+		proxyErr := make(chan error, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				proxyErr <- err
+				return
+			}
+			defer conn.Close()
+			_, err = newFirstRequestConn(conn, tp.handleFirstRequest).Read(make([]byte, 1))
+			proxyErr <- err
+		}()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// the first bytes of the tunnel are sent with the CONNECT request, like a TLS client hello
+		_, err = io.WriteString(conn, "CONNECT "+upstream+" HTTP/1.1\r\nHost: "+upstream+"\r\n\r\nhello")
+		require.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		echo := make([]byte, 5)
+		_, err = io.ReadFull(reader, echo)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(echo))
+
+		_, err = io.WriteString(conn, " world")
+		require.NoError(t, err)
+		echo = make([]byte, 6)
+		_, err = io.ReadFull(reader, echo)
+		require.NoError(t, err)
+		assert.Equal(t, " world", string(echo))
+
+		conn.Close()
+		assert.Equal(t, io.EOF, <-proxyErr, "the proxy doesn't read the tunneled connection")
+	})
+
+	t.Run("intercepted host", func(t *testing.T) {
+		req, resp := sendThroughFirstRequestConn(t, tp.handleFirstRequest,
+			"CONNECT api.openai.com:443 HTTP/1.1\r\nHost: api.openai.com:443\r\n\r\n")
+		require.Nil(t, resp)
+		require.NotNil(t, req)
+		assert.Equal(t, http.MethodConnect, req.Method)
+	})
+
+	t.Run("plain http", func(t *testing.T) {
+		req, resp := sendThroughFirstRequestConn(t, tp.handleFirstRequest,
+			"GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		require.Nil(t, resp)
+		require.NotNil(t, req)
+		assert.Equal(t, http.MethodGet, req.Method)
+	})
+
+	t.Run("upstream unreachable", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		closedAddr := ln.Addr().String()
+		ln.Close()
+
+		_, resp := sendThroughFirstRequestConn(t, tp.handleFirstRequest,
+			"CONNECT "+closedAddr+" HTTP/1.1\r\nHost: "+closedAddr+"\r\n\r\n")
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "upstream_connection_failed")
+	})
+}
@@ -0,0 +1,218 @@
+package addons
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/internal/redact"
+	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/providers"
+	"github.com/proxati/llm_proxy/v2/schema/proxyadapters/mitm"
+)
+
+const (
+	trafficFeedName = "TrafficFeed"
+
+	// trafficFeedBufferSize is the number of completed flows that can be queued for the consumer,
+	// before new events are dropped
+	trafficFeedBufferSize = 256
+)
+
+// TrafficEvent is a summary of a single completed flow, with the decoded request and response
+type TrafficEvent struct {
+	ID           string
+	Timestamp    time.Time
+	Method       string
+	Host         string
+	Path         string
+	Workflow     string
+	StatusCode   int
+	Model        string
+	InputTokens  int
+	OutputTokens int
+	Latency      time.Duration
+	CacheStatus  string
+	Cost         string // cost of this request, empty when the API is not supported by the CostCounter
+	SessionTotal string // total cost of all requests seen by this feed
+	Log          *schema.LogDumpContainer
+}
+
+// TrafficFeed sends a TrafficEvent for each completed flow to a channel, for live displays such
+// as the TUI. Events are dropped when the consumer is not keeping up, so this never blocks a flow.
+type TrafficFeed struct {
+	px.BaseAddon
+	events            chan TrafficEvent
+	costCounter       *schema.CostCounter
+	filterReqHeaders  *config.HeaderFilterGroup
+	filterRespHeaders *config.HeaderFilterGroup
+	redactor          *redact.Redactor
+	respHeaders       unfilteredResponseHeaders
+	dropped           atomic.Uint64
+	wg                sync.WaitGroup
+	closed            atomic.Bool
+	logger            *slog.Logger
+}
+
+func (t *TrafficFeed) Requestheaders(f *px.Flow) {
+	logger := configLoggerFieldsWithFlow(t.logger, f)
+
+	if t.closed.Load() {
+		logger.Warn("TrafficFeed is being closed, not recording request")
+		return
+	} else {
+		t.wg.Add(1) // for blocking this addon during shutdown in .Close()
+		metrics.InFlightFlows.WithLabelValues(trafficFeedName).Inc()
+	}
+
+	// store a copy of the request in a FlowAdapter right away, before other addons modify it
+	fa := &mitm.FlowAdapter{}
+	fa.SetRequest(f.Request)
+
+	go func() {
+		defer t.wg.Done()
+		defer metrics.InFlightFlows.WithLabelValues(trafficFeedName).Dec()
+		start := time.Now()
+		<-f.Done() // block this goroutine until the entire flow is done
+		latency := time.Since(start)
+		t.respHeaders.setResponse(f, fa)
+		fa.SetFlow(f)
+
+		event := t.newTrafficEvent(logger, f, fa, latency)
+		select {
+		case t.events <- event:
+		default:
+			t.dropped.Add(1)
+			logger.Debug("TrafficFeed consumer is not keeping up, dropping event")
+		}
+	}()
+}
+
+// Responseheaders keeps a reference to the response headers, to show them before they're filtered
+func (t *TrafficFeed) Responseheaders(f *px.Flow) {
+	t.respHeaders.store(f)
+}
+
+// LocalResponse keeps a reference to the headers of a response that was set in the Request hooks,
+// like a cache hit, to log them before they're filtered
+func (t *TrafficFeed) LocalResponse(f *px.Flow) {
+	t.respHeaders.store(f)
+}
+
+// newTrafficEvent builds the event summary from a completed flow
+func (t *TrafficFeed) newTrafficEvent(
+	logger *slog.Logger,
+	f *px.Flow,
+	fa *mitm.FlowAdapter,
+	latency time.Duration,
+) TrafficEvent {
+	event := TrafficEvent{
+		ID:        f.Id.String(),
+		Timestamp: time.Now().Add(-latency),
+		Latency:   latency,
+	}
+
+	if f.Request != nil {
+		event.Method = f.Request.Method
+		event.Workflow = f.Request.Header.Get(headers.WorkflowName)
+		if f.Request.URL != nil {
+			event.Host = f.Request.URL.Hostname()
+			event.Path = f.Request.URL.Path
+		}
+		event.Model = getRequestModel(f.Request)
+	}
+
+	if f.Response != nil {
+		event.StatusCode = f.Response.StatusCode
+		event.CacheStatus = f.Response.Header.Get(headers.CacheStatusHeader)
+	}
+
+	ldc, err := schema.NewLogDumpContainerFromFlowAdapter(
+		fa, config.LogSourceConfigAllTrue, latency.Milliseconds(),
+		t.filterReqHeaders, t.filterRespHeaders, t.redactor,
+	)
+	if err != nil {
+		logger.Debug("Could not create LogDumpContainer for TrafficFeed", "error", err)
+	}
+	event.Log = ldc
+
+	t.addCost(logger, &event)
+	return event
+}
+
+// addCost adds the token and cost details to the event, when the API is supported by the CostCounter
+func (t *TrafficFeed) addCost(logger *slog.Logger, event *TrafficEvent) {
+	if event.Log == nil || event.Log.Request == nil || event.Log.Response == nil {
+		return
+	}
+
+	if _, ok := providers.APIHostnames[event.Host]; !ok {
+		return
+	}
+	if _, ok := cacheOnlyResponseCodes[event.StatusCode]; !ok {
+		return
+	}
+
+	auditOutput, err := t.costCounter.Add(*event.Log.Request, *event.Log.Response)
+	if err != nil {
+		logger.Debug("Unable to calculate cost for TrafficFeed", "error", err)
+		return
+	}
+
+	if auditOutput.Model != "" {
+		event.Model = auditOutput.Model
+	}
+	event.InputTokens = auditOutput.InputTokens
+	event.OutputTokens = auditOutput.OutputTokens
+	event.Cost = auditOutput.TotalReqCost
+	event.SessionTotal = auditOutput.GrandTotal
+}
+
+// Events returns the channel of completed flows
+func (t *TrafficFeed) Events() <-chan TrafficEvent {
+	return t.events
+}
+
+// Dropped returns the number of events that were dropped because the consumer was not keeping up
+func (t *TrafficFeed) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+func (t *TrafficFeed) String() string {
+	return trafficFeedName
+}
+
+func (t *TrafficFeed) Close() error {
+	if !t.closed.Swap(true) {
+		t.logger.Debug("Closing...")
+		t.wg.Wait()
+	}
+
+	return nil
+}
+
+// NewTrafficFeed creates a new TrafficFeed addon. The header filters and the optional redactor
+// are applied to each event, the same as the traffic log.
+func NewTrafficFeed(
+	logger *slog.Logger,
+	filterReqHeaders *config.HeaderFilterGroup,
+	filterRespHeaders *config.HeaderFilterGroup,
+	redactor *redact.Redactor,
+) *TrafficFeed {
+	t := &TrafficFeed{
+		events:            make(chan TrafficEvent, trafficFeedBufferSize),
+		costCounter:       schema.NewCostCounterDefaults(),
+		filterReqHeaders:  filterReqHeaders,
+		filterRespHeaders: filterRespHeaders,
+		redactor:          redactor,
+		logger:            logger.WithGroup("addons.TrafficFeed"),
+	}
+	t.closed.Store(false) // initialize as open
+	return t
+}
@@ -0,0 +1,91 @@
+package addons
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/proxyadapters/mitm"
+)
+
+func TestNewTrafficFeed(t *testing.T) {
+	feed := NewTrafficFeed(slog.Default(), nil, nil, nil)
+	assert.NotNil(t, feed)
+	assert.NotNil(t, feed.Events())
+	assert.Equal(t, "TrafficFeed", feed.String())
+	assert.NoError(t, feed.Close())
+}
+
+func TestTrafficFeed_newTrafficEvent(t *testing.T) {
+	filterReqHeaders := config.NewHeaderFilterGroup(t.Name(), []string{}, []string{"Authorization"})
+	feed := NewTrafficFeed(slog.Default(), filterReqHeaders, nil, nil)
+
+	newFlow := func(host string, status int) *px.Flow {
+		f := &px.Flow{
+			Request: &px.Request{
+				Method: http.MethodPost,
+				URL:    &url.URL{Scheme: "https", Host: host, Path: "/v1/chat/completions"},
+				Header: http.Header{
+					"Authorization": []string{"Bearer sk-secret"},
+				},
+				Body: []byte(`{"model": "gpt-3.5-turbo", "messages": []}`),
+			},
+			Response: &px.Response{
+				StatusCode: status,
+				Header:     http.Header{},
+				Body:       []byte(`{"model": "gpt-3.5-turbo", "usage": {"prompt_tokens": 10, "completion_tokens": 20, "total_tokens": 30}}`),
+			},
+		}
+		f.Request.Header.Set(headers.WorkflowName, "summarize")
+		f.Response.Header.Set(headers.CacheStatusHeader, headers.CacheStatusValueMiss)
+		return f
+	}
+
+	t.Run("supported API", func(t *testing.T) {
+		f := newFlow("api.openai.com", http.StatusOK)
+		event := feed.newTrafficEvent(feed.logger, f, mitm.NewFlowAdapter(f), 2*time.Second)
+
+		assert.Equal(t, f.Id.String(), event.ID)
+		assert.Equal(t, http.MethodPost, event.Method)
+		assert.Equal(t, "api.openai.com", event.Host)
+		assert.Equal(t, "/v1/chat/completions", event.Path)
+		assert.Equal(t, "summarize", event.Workflow)
+		assert.Equal(t, http.StatusOK, event.StatusCode)
+		assert.Equal(t, "gpt-3.5-turbo", event.Model)
+		assert.Equal(t, 10, event.InputTokens)
+		assert.Equal(t, 20, event.OutputTokens)
+		assert.Equal(t, 2*time.Second, event.Latency)
+		assert.Equal(t, headers.CacheStatusValueMiss, event.CacheStatus)
+		assert.NotEmpty(t, event.Cost)
+		assert.NotEmpty(t, event.SessionTotal)
+
+		require.NotNil(t, event.Log)
+		assert.Contains(t, event.Log.Request.Body, "gpt-3.5-turbo")
+		assert.Empty(t, event.Log.Request.Header.Get("Authorization"), "headers are filtered")
+	})
+
+	t.Run("unsupported API", func(t *testing.T) {
+		f := newFlow("example.com", http.StatusOK)
+		event := feed.newTrafficEvent(feed.logger, f, mitm.NewFlowAdapter(f), time.Second)
+
+		assert.Equal(t, "gpt-3.5-turbo", event.Model, "model is still read from the request")
+		assert.Zero(t, event.InputTokens)
+		assert.Empty(t, event.Cost)
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		f := newFlow("api.openai.com", http.StatusTooManyRequests)
+		event := feed.newTrafficEvent(feed.logger, f, mitm.NewFlowAdapter(f), time.Second)
+
+		assert.Equal(t, http.StatusTooManyRequests, event.StatusCode)
+		assert.Empty(t, event.Cost)
+	})
+}
@@ -0,0 +1,143 @@
+package addons
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+// newUpstreamClient creates the HTTP client for the addons that send requests upstream themselves,
+// instead of the proxy library. Redirects and compressed responses are passed to the client as-is.
+// The requests for the hosts in upstreamTLS are sent with their client certificate and root CAs, and
+// the connections are chained through the upstreamProxy, when it's not nil.
+func newUpstreamClient(
+	insecureSkipVerifyTLS bool,
+	upstreamTLS *config.UpstreamTLS,
+	upstreamProxy *config.UpstreamProxy,
+) *http.Client {
+	var transport http.RoundTripper = newUpstreamTransport(&tls.Config{InsecureSkipVerify: insecureSkipVerifyTLS}, upstreamProxy)
+	if upstreamTLS != nil && len(upstreamTLS.Hosts) > 0 {
+		hosts := make(map[*config.UpstreamTLSHost]*http.Transport, len(upstreamTLS.Hosts))
+		for i := range upstreamTLS.Hosts {
+			host := &upstreamTLS.Hosts[i]
+			hosts[host] = newUpstreamTransport(host.TLSConfig(insecureSkipVerifyTLS), upstreamProxy)
+		}
+		transport = &upstreamTLSTransport{
+			base:        transport,
+			hosts:       hosts,
+			upstreamTLS: upstreamTLS,
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// newUpstreamTransport creates a transport with the TLS config. The proxy environment variables are
+// only used without an upstreamProxy.
+func newUpstreamTransport(tlsConfig *tls.Config, upstreamProxy *config.UpstreamProxy) *http.Transport {
+	proxy := http.ProxyFromEnvironment
+	if upstreamProxy != nil {
+		proxy = upstreamProxy.Proxy
+	}
+	return &http.Transport{
+		Proxy:              proxy,
+		ForceAttemptHTTP2:  false,
+		DisableCompression: true, // send the original response body to the client
+		TLSClientConfig:    tlsConfig,
+	}
+}
+
+// upstreamTLSTransport sends each request with the transport for the TLS config of its host, so
+// the connections with different client certificates aren't shared
+type upstreamTLSTransport struct {
+	base        http.RoundTripper
+	hosts       map[*config.UpstreamTLSHost]*http.Transport
+	upstreamTLS *config.UpstreamTLS
+}
+
+func (t *upstreamTLSTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if host := t.upstreamTLS.Match(req.URL.Hostname()); host != nil {
+		return t.hosts[host].RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newUpstreamRequest copies the client request, for sending the body to the URL. The header is
+// changed in place, so pass a copy of the request headers.
+func newUpstreamRequest(f *px.Flow, u *url.URL, header http.Header, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(f.Request.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	header.Del("Proxy-Connection")
+	header.Del("Proxy-Authorization")
+	header.Del("Content-Length") // set by the transport, from the body
+	req.Header = header
+	return req, nil
+}
+
+// isStreamingRequest returns true when the request asks for a streamed response, with "stream": true
+// in the body or an event stream in the Accept header
+func isStreamingRequest(req *px.Request) bool {
+	if strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+		return true
+	}
+	if len(req.Body) == 0 {
+		return false
+	}
+
+	body, err := utils.DecodeBody(req.Body, req.Header.Get("Content-Encoding"))
+	if err != nil {
+		body = req.Body
+	}
+	var stream struct {
+		Stream bool `json:"stream"`
+	}
+	if err := json.Unmarshal(body, &stream); err != nil {
+		return false
+	}
+	return stream.Stream
+}
+
+// MaxUpstreamResponseSize is the largest response body, in bytes, that the addons read when they
+// send a request upstream. It's also the proxy library's stream threshold, so the addons don't
+// buffer a body that the proxy library would have streamed.
+const MaxUpstreamResponseSize = 1024 * 1024 * 100
+
+// doUpstream sends the request, and reads the full response, up to MaxUpstreamResponseSize
+func doUpstream(client *http.Client, req *http.Request) (*px.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, MaxUpstreamResponseSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response body: %w", err)
+	}
+	if len(respBody) > MaxUpstreamResponseSize {
+		return nil, fmt.Errorf("unable to read response body: %w", errBodyTooLarge)
+	}
+
+	return &px.Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       respBody,
+	}, nil
+}
@@ -0,0 +1,327 @@
+package addons
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+const (
+	upstreamProxyName = "UpstreamProxy"
+
+	// upstreamProxyDialTimeout is how long to wait for the upstream proxy to open a tunnel
+	upstreamProxyDialTimeout = 30 * time.Second
+)
+
+// UpstreamProxy chains the upstream connections through a corporate HTTP(S) proxy, except for the
+// hosts in the NO_PROXY list, and records the upstream proxy of each flow in the connection stats.
+// The HTTP clients of the addons get the proxy from Proxy.
+//
+// The proxy library has no option for its upstream proxy, it only reads the HTTP_PROXY, HTTPS_PROXY,
+// and NO_PROXY environment variables with http.ProxyFromEnvironment, which caches them on its first
+// call. So SetProxyLibraryEnvironment sets them for that call only. The proxy library doesn't send
+// the proxy credentials in its CONNECT requests, so when the upstream proxy has credentials,
+// HTTPS_PROXY is a local relay that adds the Proxy-Authorization header.
+//
+// Any local process can connect to the relay, so it only opens the tunnels for the connections of
+// the proxy library, which are registered in the ServerConnected hook by their local address.
+type UpstreamProxy struct {
+	px.BaseAddon
+	proxy        *config.UpstreamProxy
+	dialer       *net.Dialer
+	relay        net.Listener  // nil when the upstream proxy has no credentials
+	relayClients sync.Map      // proxy library connection address -> chan closed once it's registered
+	relayWait    time.Duration // how long the relay waits for a connection to be registered
+	logger       *slog.Logger
+}
+
+// ServerConnected registers the proxy library's connections to the relay, so the relay opens their
+// tunnels
+func (up *UpstreamProxy) ServerConnected(ctx *px.ConnContext) {
+	if up.relay == nil || ctx == nil || ctx.ServerConn == nil || ctx.ServerConn.Conn == nil {
+		return
+	}
+	conn := ctx.ServerConn.Conn
+	if conn.RemoteAddr().String() != up.relay.Addr().String() {
+		return
+	}
+	registered := up.relayClient(conn.LocalAddr().String())
+	select {
+	case <-registered:
+	default:
+		close(registered)
+	}
+}
+
+// relayClient returns the channel that's closed when the relay connection from the address is
+// registered by ServerConnected
+func (up *UpstreamProxy) relayClient(addr string) chan struct{} {
+	registered, _ := up.relayClients.LoadOrStore(addr, make(chan struct{}))
+	return registered.(chan struct{})
+}
+
+// Responseheaders records the upstream proxy in the request headers, for the connection stats
+func (up *UpstreamProxy) Responseheaders(f *px.Flow) {
+	if f.Request == nil || f.Request.URL == nil {
+		return
+	}
+	if proxyURL := up.proxy.ProxyURL(f.Request.URL); proxyURL != nil {
+		f.Request.Header.Set(headers.UpstreamProxy, proxyURL.Host)
+	}
+}
+
+// Proxy returns the upstream proxy config, for the HTTP clients of the addons
+func (up *UpstreamProxy) Proxy() *config.UpstreamProxy {
+	return up.proxy
+}
+
+// Environment returns the proxy environment variables for the proxy library
+func (up *UpstreamProxy) Environment() map[string]string {
+	httpsProxy := up.proxy.URL.String()
+	if up.relay != nil {
+		httpsProxy = "http://" + up.relay.Addr().String()
+	}
+	return map[string]string{
+		"HTTP_PROXY":  up.proxy.URL.String(),
+		"HTTPS_PROXY": httpsProxy,
+		"NO_PROXY":    up.proxy.NoProxy(),
+	}
+}
+
+// SetProxyLibraryEnvironment sets the proxy environment variables from Environment, makes
+// http.ProxyFromEnvironment read and cache them for the proxy library, and restores the previous
+// values, so the rest of the process and its child processes don't see them. This must be called
+// before anything else sends an HTTP request with http.ProxyFromEnvironment, an error is returned
+// when the environment was already read.
+func (up *UpstreamProxy) SetProxyLibraryEnvironment() error {
+	env := up.Environment()
+	previous := make(map[string]*string, len(env))
+	var errs []error
+	for key, value := range env {
+		if v, ok := os.LookupEnv(key); ok {
+			previous[key] = &v
+		} else {
+			previous[key] = nil
+		}
+		if err := os.Setenv(key, value); err != nil {
+			errs = append(errs, fmt.Errorf("unable to set %s: %w", key, err))
+		}
+	}
+
+	probe := &http.Request{URL: &url.URL{Scheme: "https", Host: "llm-proxy.invalid"}}
+	if len(errs) == 0 {
+		_, _ = http.ProxyFromEnvironment(probe)
+	}
+
+	for key, value := range previous {
+		var err error
+		if value == nil {
+			err = os.Unsetenv(key)
+		} else {
+			err = os.Setenv(key, *value)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to restore %s: %w", key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	// check that the proxy library gets the cached values, not the restored ones
+	want := ""
+	if up.proxy.ProxyURL(probe.URL) != nil {
+		httpsProxy, _ := url.Parse(env["HTTPS_PROXY"])
+		want = httpsProxy.Host
+	}
+	got, _ := http.ProxyFromEnvironment(probe)
+	if (got == nil && want == "") || (got != nil && got.Host == want) {
+		return nil
+	}
+	return errors.New("the proxy environment variables were read before the upstream proxy was set")
+}
+
+// DialContext connects to the address, through a tunnel of the upstream proxy unless the host is
+// in the NO_PROXY list. It has the signature of net.Dialer.DialContext.
+func (up *UpstreamProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyURL := up.proxy.ProxyURL(&url.URL{Scheme: "https", Host: addr})
+	if proxyURL == nil {
+		return up.dialer.DialContext(ctx, network, addr)
+	}
+	return up.dialTunnel(ctx, proxyURL, addr)
+}
+
+// dialTunnel sends a CONNECT request for the address to the upstream proxy, with its credentials,
+// and returns the connection when the tunnel is open
+func (up *UpstreamProxy) dialTunnel(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	conn, err := up.dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the upstream proxy: %w", err)
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to connect to the upstream proxy: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if auth := up.proxy.ProxyAuthorization(); auth != "" {
+		req.Header.Set("Proxy-Authorization", auth)
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(upstreamProxyDialTimeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to send the CONNECT request to the upstream proxy: %w", err)
+	}
+	// the upstream host doesn't send anything before the client, so nothing else is buffered
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to read the CONNECT response of the upstream proxy: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("the upstream proxy refused the tunnel to %s: %s", addr, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// serveRelay accepts the CONNECT requests of the proxy library, and opens the tunnels through the
+// upstream proxy with its credentials
+func (up *UpstreamProxy) serveRelay() {
+	for {
+		conn, err := up.relay.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				up.logger.Error("Upstream proxy relay stopped", "error", err)
+			}
+			return
+		}
+		go up.handleRelayConn(conn)
+	}
+}
+
+// handleRelayConn opens the tunnel for a CONNECT request of the proxy library. Its HTTP transport
+// registers the connection before sending the request, but its CONNECT dialer only calls the
+// ServerConnected hook after the response. So when the connection isn't registered yet, the relay
+// responds first, and waits for the registration before it sends the credentials upstream.
+func (up *UpstreamProxy) handleRelayConn(conn net.Conn) {
+	defer conn.Close()
+	addr := conn.RemoteAddr().String()
+	defer up.relayClients.Delete(addr)
+
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+	if req.Method != http.MethodConnect {
+		_, _ = io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\nConnection: close\r\n\r\n")
+		return
+	}
+
+	established := false
+	registered := up.relayClient(addr)
+	select {
+	case <-registered:
+	default:
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+			return
+		}
+		established = true
+		select {
+		case <-registered:
+		case <-time.After(up.relayWait):
+			up.logger.Warn("Closed a relay connection that isn't from the proxy", "client", addr, "host", req.Host)
+			return
+		}
+	}
+
+	upstream, err := up.dialTunnel(context.Background(), up.proxy.URL, req.Host)
+	if err != nil {
+		up.logger.Warn("Unable to open a tunnel through the upstream proxy", "host", req.Host, "error", err)
+		if !established {
+			_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n")
+		}
+		return
+	}
+	if !established {
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+			upstream.Close()
+			return
+		}
+	}
+	pipeConns(up.logger, conn, reader, upstream)
+}
+
+func (up *UpstreamProxy) String() string {
+	return upstreamProxyName
+}
+
+// Close stops the relay
+func (up *UpstreamProxy) Close() error {
+	if up.relay == nil {
+		return nil
+	}
+	up.logger.Debug("Closing UpstreamProxy relay...")
+	if err := up.relay.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// NewUpstreamProxy creates the addon that chains the upstream connections through the proxy, and
+// starts the relay when the proxy has credentials
+func NewUpstreamProxy(logger *slog.Logger, proxy *config.UpstreamProxy) (*UpstreamProxy, error) {
+	up := &UpstreamProxy{
+		proxy:     proxy,
+		dialer:    &net.Dialer{Timeout: upstreamProxyDialTimeout},
+		relayWait: upstreamProxyDialTimeout,
+		logger:    logger.WithGroup("addons.UpstreamProxy"),
+	}
+
+	if proxy.ProxyAuthorization() != "" {
+		relay, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("unable to start the upstream proxy relay: %w", err)
+		}
+		up.relay = relay
+		go up.serveRelay()
+	}
+	return up, nil
+}
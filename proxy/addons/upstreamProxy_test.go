@@ -0,0 +1,222 @@
+package addons
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+// newCorporateTestProxy starts a proxy that requires bob:secret, and tunnels every CONNECT request
+// to the upstream address, whatever the requested host
+func newCorporateTestProxy(t *testing.T, upstream string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != basicAuth("bob", "secret") {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		upstreamConn, err := net.Dial("tcp", upstream)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstreamConn.Close()
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		pipeConns(slog.Default(), conn, buf, upstreamConn)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// assertEcho writes to the connection, and reads the echo
+func assertEcho(t *testing.T, conn io.ReadWriter, reader io.Reader) {
+	t.Helper()
+	_, err := io.WriteString(conn, "hello")
+	require.NoError(t, err)
+	echo := make([]byte, 5)
+	_, err = io.ReadFull(reader, echo)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(echo))
+}
+
+func newTestUpstreamProxy(t *testing.T, proxyURL string, noProxy ...string) *UpstreamProxy {
+	t.Helper()
+	proxy, err := config.ParseUpstreamProxy(proxyURL, noProxy)
+	require.NoError(t, err)
+	up, err := NewUpstreamProxy(slog.Default(), proxy)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, up.Close()) })
+	assert.Equal(t, upstreamProxyName, up.String())
+	return up
+}
+
+func TestUpstreamProxyDialContext(t *testing.T) {
+	corporate := newCorporateTestProxy(t, newEchoServer(t))
+	corporateURL, err := url.Parse(corporate.URL)
+	require.NoError(t, err)
+
+	t.Run("chained", func(t *testing.T) {
+		up := newTestUpstreamProxy(t, "http://bob:secret@"+corporateURL.Host)
+		conn, err := up.DialContext(context.Background(), "tcp", "api.example.com:443")
+		require.NoError(t, err)
+		defer conn.Close()
+		assertEcho(t, conn, conn)
+	})
+
+	t.Run("wrong credentials", func(t *testing.T) {
+		up := newTestUpstreamProxy(t, "http://bob:wrong@"+corporateURL.Host)
+		_, err := up.DialContext(context.Background(), "tcp", "api.example.com:443")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "407")
+	})
+
+	t.Run("no proxy", func(t *testing.T) {
+		up := newTestUpstreamProxy(t, "http://bob:secret@"+corporateURL.Host, "example.com")
+		_, err := up.DialContext(context.Background(), "tcp", "invalid.example.com:443")
+		require.Error(t, err, "dialed directly")
+		assert.NotContains(t, err.Error(), "upstream proxy")
+	})
+}
+
+func TestUpstreamProxyRelay(t *testing.T) {
+	corporate := newCorporateTestProxy(t, newEchoServer(t))
+	corporateURL, err := url.Parse(corporate.URL)
+	require.NoError(t, err)
+
+	up := newTestUpstreamProxy(t, "http://bob:secret@"+corporateURL.Host, "internal.example.com")
+	up.relayWait = 100 * time.Millisecond
+	env := up.Environment()
+	assert.Equal(t, "http://bob:secret@"+corporateURL.Host, env["HTTP_PROXY"])
+	assert.Equal(t, "http://"+up.relay.Addr().String(), env["HTTPS_PROXY"], "the relay adds the credentials")
+	assert.Equal(t, "internal.example.com", env["NO_PROXY"])
+
+	// a CONNECT request without credentials, like the proxy library sends
+	connect := func(t *testing.T) (net.Conn, *bufio.Reader) {
+		t.Helper()
+		conn, err := net.Dial("tcp", up.relay.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		_, err = io.WriteString(conn, "CONNECT api.example.com:443 HTTP/1.1\r\nHost: api.example.com:443\r\n\r\n")
+		require.NoError(t, err)
+		return conn, bufio.NewReader(conn)
+	}
+	register := func(conn net.Conn) {
+		up.ServerConnected(&px.ConnContext{ServerConn: &px.ServerConn{Conn: conn}})
+	}
+
+	t.Run("registered after the response", func(t *testing.T) {
+		conn, reader := connect(t)
+		resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		register(conn)
+		assertEcho(t, conn, reader)
+	})
+
+	t.Run("registered before the request", func(t *testing.T) {
+		conn, err := net.Dial("tcp", up.relay.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		register(conn)
+		_, err = io.WriteString(conn, "CONNECT api.example.com:443 HTTP/1.1\r\nHost: api.example.com:443\r\n\r\n")
+		require.NoError(t, err)
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assertEcho(t, conn, reader)
+	})
+
+	t.Run("not from the proxy", func(t *testing.T) {
+		conn, reader := connect(t)
+		resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		_, err = io.WriteString(conn, "hello")
+		require.NoError(t, err)
+		_, err = reader.ReadByte()
+		assert.Error(t, err, "closed without a tunnel")
+	})
+
+	t.Run("without credentials there's no relay", func(t *testing.T) {
+		up := newTestUpstreamProxy(t, "http://"+corporateURL.Host)
+		assert.Nil(t, up.relay)
+		assert.Equal(t, "http://"+corporateURL.Host, up.Environment()["HTTPS_PROXY"])
+	})
+}
+
+func TestUpstreamProxyEnvironment(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://previous:3128")
+	t.Setenv("HTTPS_PROXY", "")
+	require.NoError(t, os.Unsetenv("HTTPS_PROXY"))
+	t.Setenv("NO_PROXY", "")
+
+	up := newTestUpstreamProxy(t, "http://proxy.corp:3128", "internal.example.com")
+	// http.ProxyFromEnvironment may have been called by another test, which is an error, but either
+	// way the environment is restored
+	_ = up.SetProxyLibraryEnvironment()
+
+	assert.Equal(t, "http://previous:3128", os.Getenv("HTTP_PROXY"))
+	_, set := os.LookupEnv("HTTPS_PROXY")
+	assert.False(t, set, "unset again")
+	noProxy, set := os.LookupEnv("NO_PROXY")
+	assert.True(t, set)
+	assert.Empty(t, noProxy)
+}
+
+func TestUpstreamProxyClient(t *testing.T) {
+	corporate := newCorporateTestProxy(t, newEchoServer(t))
+	corporateURL, err := url.Parse(corporate.URL)
+	require.NoError(t, err)
+	up := newTestUpstreamProxy(t, "http://bob:secret@"+corporateURL.Host)
+
+	// the addon clients send the credentials to the upstream proxy themselves, without the relay
+	transport := newUpstreamTransport(&tls.Config{}, up.Proxy())
+	proxyURL, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "api.example.com"}})
+	require.NoError(t, err)
+	assert.Equal(t, "http://bob:secret@"+corporateURL.Host, proxyURL.String())
+}
+
+func TestUpstreamProxyResponseheaders(t *testing.T) {
+	up := newTestUpstreamProxy(t, "http://proxy.corp:3128", "internal.example.com")
+
+	newFlow := func(host string) *px.Flow {
+		return &px.Flow{Request: &px.Request{
+			Method: http.MethodGet,
+			URL:    &url.URL{Scheme: "https", Host: host, Path: "/"},
+			Header: http.Header{},
+		}}
+	}
+
+	f := newFlow("api.openai.com")
+	up.Responseheaders(f)
+	assert.Equal(t, "proxy.corp:3128", f.Request.Header.Get(headers.UpstreamProxy))
+
+	f = newFlow("internal.example.com")
+	up.Responseheaders(f)
+	assert.Empty(t, f.Request.Header.Get(headers.UpstreamProxy))
+}
@@ -0,0 +1,258 @@
+package addons
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/jsonpatch"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const upstreamRouterName = "UpstreamRouter"
+
+// UpstreamRouter sends the requests for a logical model to a pool of upstream targets, e.g.
+// several Azure OpenAI deployments plus api.openai.com. Targets are picked with a smooth weighted
+// round-robin, and the next target is tried on a 429, a 5xx, or a connection error. Targets that
+// keep failing are skipped for a cooldown period.
+type UpstreamRouter struct {
+	px.BaseAddon
+	pools  *config.UpstreamPools
+	states map[*config.UpstreamPool]*upstreamPoolState
+	client *http.Client
+	chosen sync.Map // flow ID -> name of the target that answered, until Responseheaders
+	now    func() time.Time
+	logger *slog.Logger
+}
+
+// upstreamPoolState is the round-robin and health state of the targets in a pool
+type upstreamPoolState struct {
+	mu      sync.Mutex
+	targets []upstreamTargetState
+}
+
+type upstreamTargetState struct {
+	currentWeight  int
+	failures       int // consecutive failures
+	unhealthyUntil time.Time
+}
+
+// Handles returns true when the requested model matches a pool, and the response isn't streamed
+func (r *UpstreamRouter) Handles(f *px.Flow) bool {
+	return r.match(f) != nil && !isStreamingRequest(f.Request)
+}
+
+// SendUpstream sends the request to the targets of the matching pool, until one of them answers
+// with a response that isn't retryable, or the pool's max attempts are used. The last response is
+// set on the flow, even when it's a 429 or 5xx. An error is returned when no target answered.
+func (r *UpstreamRouter) SendUpstream(f *px.Flow, body []byte) error {
+	pool := r.match(f)
+	if pool == nil {
+		return errors.New("no upstream pool for this request")
+	}
+	logger := configLoggerFieldsWithFlow(r.logger, f).With("pool", pool.Name)
+
+	order := r.attemptOrder(pool)
+	if len(order) > pool.MaxAttempts {
+		order = order[:pool.MaxAttempts]
+	}
+
+	var lastErr error
+	for attempt, i := range order {
+		target := &pool.Targets[i]
+		isLast := attempt == len(order)-1
+
+		resp, err := r.send(f, target, body)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", target.Name, err)
+			r.report(pool, i, false)
+			metrics.UpstreamAttemptsTotal.WithLabelValues(pool.Name, target.Name, "error").Inc()
+			logger.Warn("Upstream target failed", "target", target.Name, "attempt", attempt+1, "error", err)
+			continue
+		}
+
+		retryable := isRetryableStatus(resp.StatusCode)
+		r.report(pool, i, !retryable)
+		metrics.UpstreamAttemptsTotal.WithLabelValues(pool.Name, target.Name, strconv.Itoa(resp.StatusCode)).Inc()
+		if retryable && !isLast {
+			logger.Warn("Upstream target returned a retryable status",
+				"target", target.Name, "attempt", attempt+1, "status", resp.StatusCode)
+			continue
+		}
+
+		logger.Debug("Upstream target answered", "target", target.Name, "attempt", attempt+1, "status", resp.StatusCode)
+		f.Response = resp
+		r.chosen.Store(f.Id, target.Name)
+		return nil
+	}
+
+	return fmt.Errorf("all upstream targets failed, last error: %w", lastErr)
+}
+
+// Responseheaders records the chosen target in the request headers, for the connection stats
+func (r *UpstreamRouter) Responseheaders(f *px.Flow) {
+	if name, ok := r.chosen.LoadAndDelete(f.Id); ok {
+		f.Request.Header.Set(headers.Upstream, name.(string))
+	}
+}
+
+// match returns the pool for the model in the request body, or nil
+func (r *UpstreamRouter) match(f *px.Flow) *config.UpstreamPool {
+	if f.Request == nil || f.Request.URL == nil {
+		return nil
+	}
+	return r.pools.Match(getRequestModel(f.Request), f.Request.URL.Hostname())
+}
+
+// send makes one request to the target, and reads the full response
+func (r *UpstreamRouter) send(f *px.Flow, target *config.UpstreamTarget, body []byte) (*px.Response, error) {
+	req, err := r.newTargetRequest(f, target, body)
+	if err != nil {
+		return nil, err
+	}
+	return doUpstream(r.client, req)
+}
+
+// newTargetRequest copies the client request for a target, with the target URL, model, and headers
+func (r *UpstreamRouter) newTargetRequest(f *px.Flow, target *config.UpstreamTarget, body []byte) (*http.Request, error) {
+	header := f.Request.Header.Clone()
+
+	if target.Model != "" {
+		decoded, err := utils.DecodeBody(body, header.Get("Content-Encoding"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode request body: %w", err)
+		}
+		value, err := json.Marshal(target.Model)
+		if err != nil {
+			return nil, err
+		}
+		patch := jsonpatch.Patch{{Op: jsonpatch.OpReplace, Path: "/model", Value: value}}
+		body, err = patch.Apply(decoded)
+		if err != nil {
+			return nil, fmt.Errorf("unable to set the target model: %w", err)
+		}
+		// the patched body is sent without compression
+		header.Del("Content-Encoding")
+	}
+
+	target.Headers.Apply(header)
+	return newUpstreamRequest(f, target.Endpoint(f.Request.URL), header, body)
+}
+
+// attemptOrder returns the target indexes to try: the next healthy target from the weighted
+// round-robin, then the other healthy targets by weight. When every target is unhealthy, they
+// are all tried anyway, because that's better than failing the request.
+func (r *UpstreamRouter) attemptOrder(pool *config.UpstreamPool) []int {
+	state := r.states[pool]
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	now := r.now()
+	healthy := make([]int, 0, len(pool.Targets))
+	unhealthy := make([]int, 0)
+	for i := range pool.Targets {
+		if now.Before(state.targets[i].unhealthyUntil) {
+			unhealthy = append(unhealthy, i)
+			continue
+		}
+		healthy = append(healthy, i)
+	}
+	if len(healthy) == 0 {
+		healthy, unhealthy = unhealthy, nil
+	}
+
+	// smooth weighted round-robin, the same algorithm as nginx
+	total, best := 0, -1
+	for _, i := range healthy {
+		state.targets[i].currentWeight += pool.Targets[i].Weight
+		total += pool.Targets[i].Weight
+		if best == -1 || state.targets[i].currentWeight > state.targets[best].currentWeight {
+			best = i
+		}
+	}
+	state.targets[best].currentWeight -= total
+
+	order := []int{best}
+	rest := make([]int, 0, len(healthy)-1)
+	for _, i := range healthy {
+		if i != best {
+			rest = append(rest, i)
+		}
+	}
+	sort.SliceStable(rest, func(a, b int) bool {
+		return pool.Targets[rest[a]].Weight > pool.Targets[rest[b]].Weight
+	})
+	order = append(order, rest...)
+	return append(order, unhealthy...)
+}
+
+// report updates the health of a target after a request
+func (r *UpstreamRouter) report(pool *config.UpstreamPool, i int, ok bool) {
+	state := r.states[pool]
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	target := &state.targets[i]
+	if ok {
+		target.failures = 0
+		target.unhealthyUntil = time.Time{}
+		metrics.UpstreamHealthy.WithLabelValues(pool.Name, pool.Targets[i].Name).Set(1)
+		return
+	}
+
+	target.failures++
+	if target.failures >= r.pools.Health.MaxFailures {
+		target.unhealthyUntil = r.now().Add(r.pools.Health.GetCooldown())
+		metrics.UpstreamHealthy.WithLabelValues(pool.Name, pool.Targets[i].Name).Set(0)
+		r.logger.Warn("Upstream target is unhealthy, skipping it",
+			"pool", pool.Name, "target", pool.Targets[i].Name,
+			"failures", target.failures, "until", target.unhealthyUntil)
+	}
+}
+
+// isRetryableStatus returns true for rate limits and server errors
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+func (r *UpstreamRouter) String() string {
+	return upstreamRouterName
+}
+
+// NewUpstreamRouter creates a new UpstreamRouter addon with the pools. When insecureSkipVerifyTLS
+// is true, the TLS certificates of the targets are not verified. The upstreamTLS config is optional.
+func NewUpstreamRouter(
+	logger *slog.Logger,
+	pools *config.UpstreamPools,
+	insecureSkipVerifyTLS bool,
+	upstreamTLS *config.UpstreamTLS,
+	upstreamProxy *config.UpstreamProxy,
+) *UpstreamRouter {
+	states := make(map[*config.UpstreamPool]*upstreamPoolState, len(pools.Pools))
+	for i := range pools.Pools {
+		pool := &pools.Pools[i]
+		states[pool] = &upstreamPoolState{targets: make([]upstreamTargetState, len(pool.Targets))}
+		for _, target := range pool.Targets {
+			metrics.UpstreamHealthy.WithLabelValues(pool.Name, target.Name).Set(1)
+		}
+	}
+
+	return &UpstreamRouter{
+		pools:  pools,
+		states: states,
+		client: newUpstreamClient(insecureSkipVerifyTLS, upstreamTLS, upstreamProxy),
+		now:    time.Now,
+		logger: logger.WithGroup("addons.UpstreamRouter"),
+	}
+}
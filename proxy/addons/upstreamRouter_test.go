@@ -0,0 +1,132 @@
+package addons
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+// newUpstreamTestServer returns a server that answers with the status, and counts the requests
+func newUpstreamTestServer(t *testing.T, name string, status *atomic.Int32, hits *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Target", name)
+		w.Header().Set("X-Api-Key", r.Header.Get("api-key"))
+		w.WriteHeader(int(status.Load()))
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestUpstreamRouter(t *testing.T) {
+	var statusA, statusB, hitsA, hitsB atomic.Int32
+	statusA.Store(http.StatusOK)
+	statusB.Store(http.StatusOK)
+	srvA := newUpstreamTestServer(t, "a", &statusA, &hitsA)
+	srvB := newUpstreamTestServer(t, "b", &statusB, &hitsB)
+
+	pools, err := config.NewUpstreamPoolsFromJSON([]byte(`{
+		"pools": [{
+			"name": "chat",
+			"models": ["gpt-4o"],
+			"targets": [
+				{"name": "a", "url": "` + srvA.URL + `", "weight": 2},
+				{"name": "b", "url": "` + srvB.URL + `", "model": "gpt-4o-mini", "headers": {"set": {"api-key": "key-b"}}}
+			]
+		}],
+		"health": {"max_failures": 1, "cooldown": "1m"}
+	}`))
+	require.NoError(t, err)
+	r := NewUpstreamRouter(slog.Default(), pools, false, nil, nil)
+	assert.Equal(t, upstreamRouterName, r.String())
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	send := func(t *testing.T) *px.Flow {
+		t.Helper()
+		f := newTestFlow(t, testChatCompletionsURL, `{"model":"gpt-4o"}`, "")
+		require.True(t, r.Handles(f))
+		require.NoError(t, r.SendUpstream(f, f.Request.Body))
+		r.Responseheaders(f)
+		return f
+	}
+
+	t.Run("not handled", func(t *testing.T) {
+		assert.False(t, r.Handles(newTestFlow(t, testChatCompletionsURL, `{"model":"gpt-3.5-turbo"}`, "")))
+		assert.False(t, r.Handles(&px.Flow{}))
+	})
+
+	t.Run("streaming request", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, `{"model":"gpt-4o"}`, "")
+		f.Request.Body = []byte(`{"model": "gpt-4o", "stream": true}`)
+		assert.False(t, r.Handles(f), "the proxy library streams the response to the client")
+	})
+
+	t.Run("weighted round-robin", func(t *testing.T) {
+		targets := []string{}
+		for range 3 {
+			f := send(t)
+			assert.Equal(t, http.StatusOK, f.Response.StatusCode)
+			targets = append(targets, f.Request.Header.Get(headers.Upstream))
+		}
+		assert.Equal(t, []string{"a", "b", "a"}, targets)
+	})
+
+	t.Run("target model and headers", func(t *testing.T) {
+		assert.Equal(t, "a", send(t).Response.Header.Get("X-Target"))
+		f := send(t)
+		assert.Equal(t, "b", f.Response.Header.Get("X-Target"))
+		assert.Equal(t, "key-b", f.Response.Header.Get("X-Api-Key"))
+		assert.JSONEq(t, `{"model": "gpt-4o-mini"}`, string(f.Response.Body))
+	})
+
+	t.Run("failover and health", func(t *testing.T) {
+		statusA.Store(http.StatusServiceUnavailable)
+		hitsA.Store(0)
+
+		// a is tried once, and then skipped until the cooldown ends
+		for range 4 {
+			f := send(t)
+			assert.Equal(t, http.StatusOK, f.Response.StatusCode)
+			assert.Equal(t, "b", f.Request.Header.Get(headers.Upstream))
+		}
+		assert.Equal(t, int32(1), hitsA.Load())
+
+		statusA.Store(http.StatusOK)
+		now = now.Add(2 * time.Minute)
+		targets := map[string]int{}
+		for range 3 {
+			targets[send(t).Request.Header.Get(headers.Upstream)]++
+		}
+		assert.Equal(t, map[string]int{"a": 2, "b": 1}, targets, "a is healthy again after the cooldown")
+	})
+
+	t.Run("last response is returned when every target fails", func(t *testing.T) {
+		statusA.Store(http.StatusTooManyRequests)
+		statusB.Store(http.StatusInternalServerError)
+		f := send(t)
+		assert.Contains(t, []int{http.StatusTooManyRequests, http.StatusInternalServerError}, f.Response.StatusCode)
+	})
+
+	t.Run("connection errors", func(t *testing.T) {
+		srvA.Close()
+		srvB.Close()
+		f := newTestFlow(t, testChatCompletionsURL, `{"model":"gpt-4o"}`, "")
+		assert.Error(t, r.SendUpstream(f, f.Request.Body))
+		assert.Nil(t, f.Response)
+	})
+}
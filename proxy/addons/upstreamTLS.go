@@ -0,0 +1,70 @@
+package addons
+
+import (
+	"log/slog"
+	"net/http"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+)
+
+const upstreamTLSName = "UpstreamTLS"
+
+// UpstreamTLS sends the requests for the hosts in the upstream TLS config with the host's client
+// certificate and root CAs, e.g. to a private inference gateway that requires mTLS and is signed by
+// an internal CA. The proxy library only has the global skip-verify option for its upstream
+// connections.
+//
+// The UpstreamRouter and the Retrier use the same TLS config, so they can send the requests for these
+// hosts too.
+type UpstreamTLS struct {
+	px.BaseAddon
+	upstreamTLS *config.UpstreamTLS
+	client      *http.Client
+	logger      *slog.Logger
+}
+
+// Handles returns true when the requested host has a TLS config, and the response isn't streamed
+func (ut *UpstreamTLS) Handles(f *px.Flow) bool {
+	if f.Request == nil || f.Request.URL == nil {
+		return false
+	}
+	return ut.upstreamTLS.Match(f.Request.URL.Hostname()) != nil && !isStreamingRequest(f.Request)
+}
+
+// SendUpstream sends the request to the requested URL, with the TLS config of the host
+func (ut *UpstreamTLS) SendUpstream(f *px.Flow, body []byte) error {
+	req, err := newUpstreamRequest(f, f.Request.URL, f.Request.Header.Clone(), body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := doUpstream(ut.client, req)
+	if err != nil {
+		return err
+	}
+	configLoggerFieldsWithFlow(ut.logger, f).Debug("Sent the request with the upstream TLS config",
+		"host", ut.upstreamTLS.Match(f.Request.URL.Hostname()).Host, "status", resp.StatusCode)
+	f.Response = resp
+	return nil
+}
+
+func (ut *UpstreamTLS) String() string {
+	return upstreamTLSName
+}
+
+// NewUpstreamTLS creates a new UpstreamTLS addon. When insecureSkipVerifyTLS is true, the TLS
+// certificates of the upstream hosts are not verified. The upstreamProxy is optional.
+func NewUpstreamTLS(
+	logger *slog.Logger,
+	upstreamTLS *config.UpstreamTLS,
+	insecureSkipVerifyTLS bool,
+	upstreamProxy *config.UpstreamProxy,
+) *UpstreamTLS {
+	return &UpstreamTLS{
+		upstreamTLS: upstreamTLS,
+		client:      newUpstreamClient(insecureSkipVerifyTLS, upstreamTLS, upstreamProxy),
+		logger:      logger.WithGroup("addons.UpstreamTLS"),
+	}
+}
@@ -0,0 +1,124 @@
+package addons
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+)
+
+// newMTLSTestServer starts a TLS server that requires a client certificate, and writes its root CA,
+// and a client certificate and key, to the dir
+func newMTLSTestServer(t *testing.T, dir string) *httptest.Server {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	clientCert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "client.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "client.key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client-Cert", r.TLS.PeerCertificates[0].Subject.CommonName)
+		w.WriteHeader(http.StatusOK)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "server-ca.pem"), serverCA, 0o600))
+	return srv
+}
+
+func TestUpstreamTLS(t *testing.T) {
+	dir := t.TempDir()
+	srv := newMTLSTestServer(t, dir)
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	newFlow := func(host string) *px.Flow {
+		return &px.Flow{
+			Request: &px.Request{
+				Method: http.MethodGet,
+				URL:    &url.URL{Scheme: "https", Host: host, Path: "/v1/models"},
+				Header: http.Header{},
+			},
+		}
+	}
+
+	upstreamTLS, err := config.NewUpstreamTLSFromJSON([]byte(`{"hosts": [{
+		"host": "127.0.0.1",
+		"client_cert": "` + filepath.Join(dir, "client.crt") + `",
+		"client_key": "` + filepath.Join(dir, "client.key") + `",
+		"root_cas": ["` + filepath.Join(dir, "server-ca.pem") + `"]
+	}]}`))
+	require.NoError(t, err)
+	ut := NewUpstreamTLS(slog.Default(), upstreamTLS, false, nil)
+	assert.Equal(t, upstreamTLSName, ut.String())
+
+	f := newFlow(srvURL.Host)
+	require.True(t, ut.Handles(f))
+	require.NoError(t, ut.SendUpstream(f, nil))
+	assert.Equal(t, http.StatusOK, f.Response.StatusCode)
+	assert.Equal(t, "test client", f.Response.Header.Get("X-Client-Cert"))
+
+	assert.False(t, ut.Handles(newFlow("api.openai.com")))
+
+	streaming := newFlow(srvURL.Host)
+	streaming.Request.Header.Set("Accept", "text/event-stream")
+	assert.False(t, ut.Handles(streaming), "the streamed responses are sent by the proxy library")
+
+	t.Run("without the client certificate", func(t *testing.T) {
+		upstreamTLS, err := config.NewUpstreamTLSFromJSON([]byte(`{"hosts": [{
+			"host": "127.0.0.1",
+			"root_cas": ["` + filepath.Join(dir, "server-ca.pem") + `"]
+		}]}`))
+		require.NoError(t, err)
+		assert.Error(t, NewUpstreamTLS(slog.Default(), upstreamTLS, false, nil).SendUpstream(newFlow(srvURL.Host), nil))
+	})
+
+	t.Run("without the root CA", func(t *testing.T) {
+		upstreamTLS, err := config.NewUpstreamTLSFromJSON([]byte(`{"hosts": [{
+			"host": "127.0.0.1",
+			"client_cert": "` + filepath.Join(dir, "client.crt") + `",
+			"client_key": "` + filepath.Join(dir, "client.key") + `"
+		}]}`))
+		require.NoError(t, err)
+		assert.Error(t, NewUpstreamTLS(slog.Default(), upstreamTLS, false, nil).SendUpstream(newFlow(srvURL.Host), nil))
+	})
+}
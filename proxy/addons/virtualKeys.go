@@ -0,0 +1,262 @@
+package addons
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/internal/virtualkeys"
+	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
+	"github.com/proxati/llm_proxy/v2/schema"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/utils"
+)
+
+const (
+	virtualKeysName = "VirtualKeys"
+
+	// virtualKeysSyncInterval is how often the usage is saved, and the keys are reloaded, so a
+	// revoked key stops working within this time
+	virtualKeysSyncInterval = 10 * time.Second
+)
+
+// VirtualKeys checks the proxy-issued API keys sent by clients, and swaps in the real provider key
+// from the secret store before the request is sent upstream. The usage and cost of each virtual
+// key is saved in the keys database. Requests with a provider key are passed through, unless
+// virtual keys are required.
+//
+// The keys database is opened for each sync, instead of being held open, so the keys command can
+// create and revoke keys while the proxy is running.
+type VirtualKeys struct {
+	px.BaseAddon
+	dbFile      string
+	secrets     *config.SecretStore
+	required    bool
+	keys        map[string]virtualkeys.Key   // key hash -> key, reloaded on each sync
+	usage       map[string]virtualkeys.Usage // key ID -> usage since the last sync
+	flowKeys    sync.Map                     // flow ID -> key ID, until the response or the end of the flow
+	mu          sync.Mutex
+	costCounter *schema.CostCounter
+	wg          sync.WaitGroup
+	done        chan struct{}
+	stopped     chan struct{}
+	closed      atomic.Bool
+	logger      *slog.Logger
+}
+
+// Requestheaders checks the virtual key, and replaces it with the real provider key
+func (v *VirtualKeys) Requestheaders(f *px.Flow) {
+	if f.Request == nil {
+		return
+	}
+	logger := configLoggerFieldsWithFlow(v.logger, f)
+
+	headerName, apiKey := utils.GetAPIKey(f.Request.Header)
+	if !virtualkeys.IsVirtualKey(apiKey) {
+		if v.required {
+			logger.Warn("Request rejected, no virtual API key")
+			helpers.GenerateErrorResponse(f, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key",
+				"A virtual API key issued by llm_proxy is required.")
+		}
+		return
+	}
+
+	v.mu.Lock()
+	key, ok := v.keys[virtualkeys.HashKey(apiKey)]
+	v.mu.Unlock()
+	if !ok || key.IsRevoked() {
+		logger.Warn("Request rejected, unknown or revoked virtual API key", "found", ok)
+		helpers.GenerateErrorResponse(f, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key",
+			"Incorrect API key provided, the virtual API key is unknown or revoked.")
+		return
+	}
+
+	secret, ok := v.secrets.Get(key.Secret)
+	if !ok {
+		logger.Error("Virtual API key refers to a missing secret", "keyID", key.ID, "secret", key.Secret)
+		helpers.GenerateErrorResponse(f, http.StatusInternalServerError, "server_error", "",
+			"The virtual API key is not configured correctly on llm_proxy.")
+		return
+	}
+
+	if headerName == "Authorization" {
+		secret = "Bearer " + secret
+	}
+	f.Request.Header.Set(headerName, secret)
+	f.Request.Header.Set(headers.VirtualKey, key.ID)
+	v.flowKeys.Store(f.Id, key.ID)
+	go func() {
+		// cleanup for the flows that skip the Response hook, like upstream errors and streamed bodies
+		<-f.Done()
+		v.flowKeys.Delete(f.Id)
+	}()
+	logger.Debug("Swapped in the provider key for a virtual API key", "keyID", key.ID, "keyName", key.Name)
+}
+
+// Response counts the request, and its tokens and cost, for the virtual key. The key is the one
+// checked in Requestheaders, not the request header, which is only for the other addons and the logs.
+func (v *VirtualKeys) Response(f *px.Flow) {
+	value, ok := v.flowKeys.LoadAndDelete(f.Id)
+	if !ok {
+		return
+	}
+	id := value.(string)
+
+	if v.closed.Load() {
+		v.logger.Warn("VirtualKeys is being closed, not counting usage", "keyID", id)
+		return
+	}
+	v.wg.Add(1) // for blocking this addon during shutdown in .Close()
+	metrics.InFlightFlows.WithLabelValues(virtualKeysName).Inc()
+
+	go func() {
+		defer v.wg.Done()
+		defer metrics.InFlightFlows.WithLabelValues(virtualKeysName).Dec()
+		<-f.Done()
+
+		now := time.Now().UTC()
+		usage := virtualkeys.Usage{Requests: 1, LastUsedAt: &now}
+		if audit := v.audit(f); audit != nil {
+			usage.InputTokens = int64(audit.InputTokens)
+			usage.OutputTokens = int64(audit.OutputTokens)
+			usage.Cost = audit.TotalReqCostValue
+		}
+		v.addUsage(map[string]virtualkeys.Usage{id: usage})
+	}()
+}
+
+// LocalResponse forgets the virtual key of a request that got a response from the proxy, like a cache
+// hit or a rejection, which isn't counted
+func (v *VirtualKeys) LocalResponse(f *px.Flow) {
+	v.flowKeys.Delete(f.Id)
+}
+
+// audit returns the tokens and cost of the request, or nil when they're unknown
+func (v *VirtualKeys) audit(f *px.Flow) *schema.AuditOutput {
+	audit, err := auditFlowCost(v.costCounter, f)
+	if err != nil {
+		v.logger.Debug("Unable to count the cost for a virtual API key", "error", err)
+		return nil
+	}
+	return audit
+}
+
+// addUsage adds to the usage that will be saved on the next sync
+func (v *VirtualKeys) addUsage(usage map[string]virtualkeys.Usage) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for id, u := range usage {
+		current := v.usage[id]
+		current.Add(u)
+		v.usage[id] = current
+	}
+}
+
+// sync saves the usage since the last sync, and reloads the keys
+func (v *VirtualKeys) sync() error {
+	store, err := virtualkeys.Open(v.dbFile, virtualkeys.DefaultOpenTimeout)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	v.mu.Lock()
+	pending := v.usage
+	v.usage = make(map[string]virtualkeys.Usage)
+	v.mu.Unlock()
+
+	if err := store.AddUsage(pending); err != nil {
+		v.addUsage(pending) // try again on the next sync
+		return err
+	}
+
+	keys, err := store.List()
+	if err != nil {
+		return err
+	}
+	byHash := make(map[string]virtualkeys.Key, len(keys))
+	for _, key := range keys {
+		byHash[key.Hash] = key
+	}
+
+	v.mu.Lock()
+	v.keys = byHash
+	v.mu.Unlock()
+	return nil
+}
+
+// syncLoop syncs on an interval, and once more after the addon is closed
+func (v *VirtualKeys) syncLoop() {
+	defer close(v.stopped)
+	ticker := time.NewTicker(virtualKeysSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := v.sync(); err != nil {
+				v.logger.Error("Unable to sync the virtual API keys", "error", err)
+			}
+		case <-v.done:
+			if err := v.sync(); err != nil {
+				v.logger.Error("Unable to save the virtual API key usage", "error", err)
+			}
+			return
+		}
+	}
+}
+
+func (v *VirtualKeys) String() string {
+	return virtualKeysName
+}
+
+// Close waits for the usage of the in-flight requests, and saves it
+func (v *VirtualKeys) Close() error {
+	if !v.closed.Swap(true) {
+		v.logger.Debug("Closing VirtualKeys...")
+		v.wg.Wait()
+		close(v.done)
+		<-v.stopped
+	}
+	return nil
+}
+
+// NewVirtualKeys creates a new VirtualKeys addon, and loads the keys from the database file. The
+// real provider keys are read from the secret store. When required is true, requests without a
+// virtual key are rejected.
+func NewVirtualKeys(logger *slog.Logger, dbFile string, secrets *config.SecretStore, required bool) (*VirtualKeys, error) {
+	if secrets == nil {
+		return nil, errors.New("a secret store is required for virtual API keys")
+	}
+
+	v := &VirtualKeys{
+		dbFile:      dbFile,
+		secrets:     secrets,
+		required:    required,
+		keys:        make(map[string]virtualkeys.Key),
+		usage:       make(map[string]virtualkeys.Usage),
+		costCounter: schema.NewCostCounterDefaults(),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+		logger:      logger.WithGroup("addons.VirtualKeys"),
+	}
+	if err := v.sync(); err != nil {
+		return nil, err
+	}
+
+	for _, key := range v.keys {
+		if _, ok := secrets.Get(key.Secret); !ok && !key.IsRevoked() {
+			v.logger.Warn("Virtual API key refers to a missing secret", "keyID", key.ID, "keyName", key.Name, "secret", key.Secret)
+		}
+	}
+
+	go v.syncLoop()
+	return v, nil
+}
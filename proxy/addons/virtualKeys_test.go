@@ -0,0 +1,120 @@
+package addons
+
+import (
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	px "github.com/proxati/mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/internal/virtualkeys"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+func TestVirtualKeys(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "keys.db")
+	store, err := virtualkeys.Open(dbFile, time.Second)
+	require.NoError(t, err)
+	teamA, keyA, err := store.Create("team-a", "openai-prod", time.Now())
+	require.NoError(t, err)
+	teamB, _, err := store.Create("team-b", "openai-prod", time.Now())
+	require.NoError(t, err)
+	_, err = store.Revoke("team-b", time.Now())
+	require.NoError(t, err)
+	teamC, _, err := store.Create("team-c", "missing", time.Now())
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	secrets, err := config.NewSecretStoreFromJSON([]byte(`{"secrets": {"openai-prod": "sk-real"}}`))
+	require.NoError(t, err)
+	v, err := NewVirtualKeys(slog.Default(), dbFile, secrets, false)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = v.Close() })
+	assert.Equal(t, virtualKeysName, v.String())
+
+	t.Run("swap", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, `{"model":"gpt-4o"}`, "")
+		f.Request.Header.Set("Authorization", "Bearer "+teamA)
+		v.Requestheaders(f)
+		assert.Nil(t, f.Response)
+		assert.Equal(t, "Bearer sk-real", f.Request.Header.Get("Authorization"))
+		assert.Equal(t, keyA.ID, f.Request.Header.Get(headers.VirtualKey))
+		id, ok := v.flowKeys.Load(f.Id)
+		require.True(t, ok)
+		assert.Equal(t, keyA.ID, id)
+		v.LocalResponse(f)
+		_, ok = v.flowKeys.Load(f.Id)
+		assert.False(t, ok, "not counted when the proxy responds")
+
+		f = newTestFlow(t, testChatCompletionsURL, `{"model":"gpt-4o"}`, "")
+		f.Request.Header.Set("api-key", teamA)
+		v.Requestheaders(f)
+		assert.Equal(t, "sk-real", f.Request.Header.Get("api-key"), "the key is swapped in the same header")
+		v.LocalResponse(f)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		for name, key := range map[string]string{"revoked": teamB, "unknown": virtualkeys.KeyPrefix + "nope"} {
+			f := newTestFlow(t, testChatCompletionsURL, `{"model":"gpt-4o"}`, "")
+			f.Request.Header.Set("Authorization", "Bearer "+key)
+			v.Requestheaders(f)
+			require.NotNil(t, f.Response, name)
+			assert.Equal(t, http.StatusUnauthorized, f.Response.StatusCode, name)
+			assert.Contains(t, string(f.Response.Body), "invalid_api_key", name)
+		}
+
+		f := newTestFlow(t, testChatCompletionsURL, `{"model":"gpt-4o"}`, "")
+		f.Request.Header.Set("Authorization", "Bearer "+teamC)
+		v.Requestheaders(f)
+		require.NotNil(t, f.Response)
+		assert.Equal(t, http.StatusInternalServerError, f.Response.StatusCode)
+	})
+
+	t.Run("provider keys", func(t *testing.T) {
+		f := newTestFlow(t, testChatCompletionsURL, `{"model":"gpt-4o"}`, "")
+		f.Request.Header.Set("Authorization", "Bearer sk-own")
+		v.Requestheaders(f)
+		assert.Nil(t, f.Response, "passed through when virtual keys are optional")
+
+		v.required = true
+		defer func() { v.required = false }()
+		v.Requestheaders(f)
+		require.NotNil(t, f.Response)
+		assert.Equal(t, http.StatusUnauthorized, f.Response.StatusCode)
+	})
+
+	t.Run("forged header", func(t *testing.T) {
+		inFlight := metrics.InFlightFlows.WithLabelValues(virtualKeysName)
+		before := testutil.ToFloat64(inFlight)
+
+		f := newTestFlow(t, testChatCompletionsURL, `{"model":"gpt-4o"}`, "")
+		f.Id = uuid.New()
+		f.Request.Header.Set("Authorization", "Bearer sk-own")
+		f.Request.Header.Set(headers.VirtualKey, keyA.ID)
+		v.Requestheaders(f)
+		f.Response = &px.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+		v.Response(f)
+		assert.Equal(t, before, testutil.ToFloat64(inFlight), "the usage isn't counted for the header")
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		v.addUsage(map[string]virtualkeys.Usage{keyA.ID: {Requests: 1, InputTokens: 10, Cost: 0.25}})
+		v.addUsage(map[string]virtualkeys.Usage{keyA.ID: {Requests: 1, OutputTokens: 5}})
+		require.NoError(t, v.sync())
+
+		store, err := virtualkeys.Open(dbFile, time.Second)
+		require.NoError(t, err)
+		defer store.Close()
+		keys, err := store.List()
+		require.NoError(t, err)
+		assert.Equal(t, virtualkeys.Usage{Requests: 2, InputTokens: 10, OutputTokens: 5, Cost: 0.25}, keys[0].Usage)
+	})
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/proxy/addons"
+)
+
+// adminServer is an optional HTTP server for operational endpoints (metrics, health, etc). It
+// listens on a separate address from the proxy, so it's never reachable through the proxy itself.
+type adminServer struct {
+	listenOn string
+	server   *http.Server
+	listener net.Listener
+	logger   *slog.Logger
+	cfg      *config.Config
+	meta     *metaAddon
+	shutdown chan os.Signal
+	draining atomic.Bool
+}
+
+// newAdminServer creates the admin server and registers the HTTP routes, but does not start it.
+// The meta addon is used to inspect the loaded addons, and the shutdown channel is the same one
+// that's watched by startProxy, so a drain request follows the same path as a SIGTERM.
+func newAdminServer(
+	logger *slog.Logger,
+	listenOn string,
+	cfg *config.Config,
+	meta *metaAddon,
+	shutdown chan os.Signal,
+) *adminServer {
+	a := &adminServer{
+		listenOn: listenOn,
+		logger:   logger.WithGroup("adminServer"),
+		cfg:      cfg,
+		meta:     meta,
+		shutdown: shutdown,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.HandleFunc("GET /healthz", a.handleHealth)
+	mux.HandleFunc("GET /readyz", a.handleReady)
+	mux.HandleFunc("GET /config", a.handleConfig)
+	mux.HandleFunc("GET /addons", a.handleAddons)
+	mux.HandleFunc("GET /cache/stats", a.handleCacheStats)
+	mux.HandleFunc("GET /spend", a.handleSpend)
+	mux.HandleFunc("POST /drain", a.handleDrain)
+
+	a.server = &http.Server{
+		Addr:              listenOn,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return a
+}
+
+// writeJSON sends the object as a JSON response body with the given status code
+func (a *adminServer) writeJSON(w http.ResponseWriter, status int, obj any) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		a.logger.Error("Unable to marshal admin response", "error", err)
+		http.Error(w, "unable to marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		a.logger.Warn("Unable to write admin response", "error", err)
+	}
+}
+
+// isReady returns false when the proxy is shutting down, or has been asked to drain
+func (a *adminServer) isReady() bool {
+	if a.draining.Load() {
+		return false
+	}
+	if a.meta != nil && a.meta.closed.Load() {
+		return false
+	}
+	return true
+}
+
+// handleHealth always returns 200 while the process is able to serve HTTP
+func (a *adminServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReady returns 503 when the proxy is draining, so load balancers stop sending new traffic
+func (a *adminServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !a.isReady() {
+		a.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+	a.writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// handleConfig returns the running config, with secrets removed
+func (a *adminServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if a.cfg == nil {
+		http.Error(w, "config not available", http.StatusNotFound)
+		return
+	}
+
+	body, err := a.cfg.MarshalRedactedJSON()
+	if err != nil {
+		a.logger.Error("Unable to marshal config", "error", err)
+		http.Error(w, "unable to marshal config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(body); err != nil {
+		a.logger.Warn("Unable to write admin response", "error", err)
+	}
+}
+
+// handleAddons lists the names of the addons loaded in the metaAddon, in processing order
+func (a *adminServer) handleAddons(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	if a.meta != nil {
+		for _, addon := range a.meta.mitmAddons {
+			if s, ok := addon.(fmt.Stringer); ok {
+				names = append(names, s.String())
+				continue
+			}
+			// addons without a String method are named by their type, e.g. "AddIDToHeaders"
+			t := reflect.TypeOf(addon)
+			if t.Kind() == reflect.Pointer {
+				t = t.Elem()
+			}
+			names = append(names, t.Name())
+		}
+	}
+	a.writeJSON(w, http.StatusOK, map[string][]string{"addons": names})
+}
+
+// handleCacheStats returns the hit/miss counters from the cache addon, only in cache mode
+func (a *adminServer) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	if a.meta != nil {
+		for _, addon := range a.meta.mitmAddons {
+			if cacheAddon, ok := addon.(*addons.ResponseCacheAddon); ok {
+				a.writeJSON(w, http.StatusOK, cacheAddon.Stats())
+				return
+			}
+		}
+	}
+	http.Error(w, "cache is not enabled", http.StatusNotFound)
+}
+
+// handleSpend returns the running cost total from the API auditor, only in api-auditor mode
+func (a *adminServer) handleSpend(w http.ResponseWriter, r *http.Request) {
+	if a.meta != nil {
+		for _, addon := range a.meta.mitmAddons {
+			if auditor, ok := addon.(*addons.APIAuditorAddon); ok {
+				a.writeJSON(w, http.StatusOK, map[string]string{
+					"grandTotal": auditor.GetCostCounter().GetGrandTotal(),
+				})
+				return
+			}
+		}
+	}
+	http.Error(w, "api auditor is not enabled", http.StatusNotFound)
+}
+
+// handleDrain marks the proxy as not ready, and sends a SIGTERM to the shutdown channel, which
+// closes all ClosableAddons and then the proxy, the same as a signal from the OS.
+func (a *adminServer) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if a.draining.Swap(true) {
+		a.writeJSON(w, http.StatusAccepted, map[string]string{"status": "already draining"})
+		return
+	}
+
+	a.logger.Info("Drain requested from the admin API")
+	if a.shutdown != nil {
+		select {
+		case a.shutdown <- syscall.SIGTERM:
+		default:
+			a.logger.Debug("Shutdown signal already pending")
+		}
+	}
+	a.writeJSON(w, http.StatusAccepted, map[string]string{"status": "draining"})
+}
+
+// Start binds the listen address, and serves the admin endpoints in a background goroutine
+func (a *adminServer) Start() error {
+	ln, err := net.Listen("tcp", a.listenOn)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", a.listenOn, err)
+	}
+	a.listener = ln
+
+	go func() {
+		a.logger.Info("Admin server starting", "listenAddress", ln.Addr().String())
+		if err := a.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.logger.Error("Admin server error", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the bound address of the admin server, useful when listening on port 0
+func (a *adminServer) Addr() string {
+	if a.listener == nil {
+		return a.listenOn
+	}
+	return a.listener.Addr().String()
+}
+
+// Shutdown gracefully stops the admin server
+func (a *adminServer) Shutdown(ctx context.Context) error {
+	a.logger.Debug("Closing admin server...")
+	return a.server.Shutdown(ctx)
+}
+
+// getMetaAddon finds the metaAddon loaded in the proxy, returns nil if not found
+func getMetaAddon(p *px.Proxy) *metaAddon {
+	for _, addon := range p.Addons {
+		if meta, ok := addon.(*metaAddon); ok {
+			return meta
+		}
+	}
+	return nil
+}
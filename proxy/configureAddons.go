@@ -1,22 +1,50 @@
 package proxy
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"strings"
 
 	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/dlp"
+	"github.com/proxati/llm_proxy/v2/internal/moderation"
+	"github.com/proxati/llm_proxy/v2/internal/promptguard"
+	"github.com/proxati/llm_proxy/v2/internal/redact"
 	"github.com/proxati/llm_proxy/v2/proxy/addons"
 )
 
 // configureDumper creates and configure MegaDirDumper addon object, but bypass traffic logs when
 // no output target is requested (or when verbose is disabled)
-func configureDumper(logger *slog.Logger, cfg *config.Config, logSources config.LogSourceConfig) (*addons.MegaTrafficDumper, error) {
+func configureDumper(
+	logger *slog.Logger,
+	cfg *config.Config,
+	logSources config.LogSourceConfig,
+	redactor *redact.Redactor,
+) (*addons.MegaTrafficDumper, error) {
 	// create and configure MegaDirDumper addon object, but bypass traffic logs when no output is requested
 	if cfg.TrafficLogger.Output == "" && !cfg.IsVerboseOrHigher() {
 		// no output dir specified and verbose is disabled
 		return nil, nil
 	}
 
+	if cfg.TrafficLogger.Output == "" && cfg.AppMode == config.TUIMode {
+		// the TUI owns the terminal, so don't write the traffic log to stdout
+		return nil, nil
+	}
+
+	var logRules *config.LogRules
+	if cfg.TrafficLogger.RulesFile != "" {
+		var err error
+		logRules, err = config.LoadLogRules(cfg.TrafficLogger.RulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load traffic log rules: %w", err)
+		}
+		logger.Debug("Loaded traffic log rules", "rulesFile", cfg.TrafficLogger.RulesFile, "ruleCount", len(logRules.Rules))
+	}
+
 	dumperAddon, err := addons.NewMegaTrafficDumperAddon(
 		logger,
 		cfg.TrafficLogger.Output,
@@ -24,6 +52,8 @@ func configureDumper(logger *slog.Logger, cfg *config.Config, logSources config.
 		logSources,
 		cfg.HeaderFilters.RequestToLogs,
 		cfg.HeaderFilters.ResponseToLogs,
+		logRules,
+		redactor,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create traffic log dumper: %v", err)
@@ -32,6 +62,385 @@ func configureDumper(logger *slog.Logger, cfg *config.Config, logSources config.
 	return dumperAddon, nil
 }
 
+// configureRedactor creates the body redactor for the traffic logs, or returns nil when
+// redaction is disabled
+func configureRedactor(logger *slog.Logger, cfg *config.Config) (*redact.Redactor, error) {
+	if cfg.TrafficLogger.RedactRulesFile != "" {
+		redactor, err := redact.LoadFile(cfg.TrafficLogger.RedactRulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redaction rules: %w", err)
+		}
+		logger.Debug("Loaded redaction rules", "rulesFile", cfg.TrafficLogger.RedactRulesFile)
+		return redactor, nil
+	}
+
+	if cfg.TrafficLogger.RedactBodies {
+		logger.Debug("Using the default redaction rules")
+		return redact.NewDefault(), nil
+	}
+
+	return nil, nil
+}
+
+// configureModifier loads the modification rules, and creates the Modifier addon, or returns nil
+// when no rules file is configured
+func configureModifier(logger *slog.Logger, cfg *config.Config) (*addons.Modifier, error) {
+	if cfg.HTTPBehavior.ModifyRulesFile == "" {
+		return nil, nil
+	}
+
+	rules, err := config.LoadModifyRules(cfg.HTTPBehavior.ModifyRulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load modification rules: %w", err)
+	}
+	logger.Debug("Loaded modification rules", "rulesFile", cfg.HTTPBehavior.ModifyRulesFile, "ruleCount", len(rules.Rules))
+
+	return addons.NewModifier(logger, rules), nil
+}
+
+// configureModelAlias loads the model alias table, and creates the ModelAlias addon, or returns nil
+// when no aliases file is configured
+func configureModelAlias(logger *slog.Logger, cfg *config.Config) (*addons.ModelAlias, error) {
+	if cfg.HTTPBehavior.ModelAliasesFile == "" {
+		return nil, nil
+	}
+
+	aliases, err := config.LoadModelAliases(cfg.HTTPBehavior.ModelAliasesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load model aliases: %w", err)
+	}
+	logger.Debug("Loaded model aliases", "aliasesFile", cfg.HTTPBehavior.ModelAliasesFile, "aliasCount", len(aliases.Aliases))
+
+	return addons.NewModelAlias(logger, aliases), nil
+}
+
+// configureLimits creates the Limits addon with the request and response size limits, or returns
+// nil when there are no limits
+func configureLimits(logger *slog.Logger, cfg *config.Config) (*addons.Limits, error) {
+	hb := cfg.HTTPBehavior
+	limits, err := config.NewRequestLimits(hb.MaxRequestBodySize, hb.MaxResponseBodySize, hb.MaxMessages, hb.MaxRequestTokens)
+	if err != nil {
+		return nil, fmt.Errorf("invalid limits: %w", err)
+	}
+	if limits == nil {
+		return nil, nil
+	}
+	logger.Debug("Loaded limits",
+		"maxRequestBodySize", limits.MaxRequestBodySize, "maxResponseBodySize", limits.MaxResponseBodySize,
+		"maxMessages", limits.MaxMessages, "maxRequestTokens", limits.MaxRequestTokens)
+
+	return addons.NewLimits(logger, limits), nil
+}
+
+// configurePolicy loads the policy file, and creates the Policy addon, or returns nil when no policy
+// file is configured
+func configurePolicy(logger *slog.Logger, cfg *config.Config) (*addons.Policy, error) {
+	if cfg.HTTPBehavior.PolicyFile == "" {
+		return nil, nil
+	}
+
+	policy, err := config.LoadPolicy(cfg.HTTPBehavior.PolicyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy: %w", err)
+	}
+	logger.Debug("Loaded policy", "policyFile", cfg.HTTPBehavior.PolicyFile,
+		"default", policy.Default, "ruleCount", len(policy.Rules))
+
+	return addons.NewPolicy(logger, policy), nil
+}
+
+// configureDLP creates the DLP addon with the built-in detectors, or the rules file, or returns nil
+// when DLP is disabled
+func configureDLP(logger *slog.Logger, cfg *config.Config) (*addons.DLP, error) {
+	hb := cfg.HTTPBehavior
+	action, err := config.ParseDLPAction(hb.DLP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --dlp: %w", err)
+	}
+	if action == config.DLPActionOff {
+		if hb.DLPRulesFile != "" {
+			return nil, errors.New("--dlp-rules requires --dlp block or redact")
+		}
+		return nil, nil
+	}
+
+	scanner := dlp.NewDefault()
+	if hb.DLPRulesFile != "" {
+		scanner, err = dlp.LoadFile(hb.DLPRulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load DLP rules: %w", err)
+		}
+	}
+	logger.Debug("Loaded DLP scanner", "action", action, "rulesFile", hb.DLPRulesFile)
+
+	return addons.NewDLP(logger, scanner, action), nil
+}
+
+// configurePromptGuard creates the PromptGuard addon with the built-in heuristics, or the rules
+// file, or returns nil when the prompt guard is off
+func configurePromptGuard(logger *slog.Logger, cfg *config.Config) (*addons.PromptGuard, error) {
+	hb := cfg.HTTPBehavior
+	mode, err := config.ParseGuardrailMode(hb.PromptGuard)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --prompt-guard: %w", err)
+	}
+	if mode == config.GuardrailModeOff {
+		if hb.PromptGuardRulesFile != "" {
+			return nil, errors.New("--prompt-guard-rules requires --prompt-guard monitor or enforce")
+		}
+		return nil, nil
+	}
+
+	guard := promptguard.NewDefault()
+	if hb.PromptGuardRulesFile != "" {
+		guard, err = promptguard.LoadFile(hb.PromptGuardRulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load prompt guard rules: %w", err)
+		}
+	}
+	logger.Debug("Loaded prompt guard", "mode", mode, "rulesFile", hb.PromptGuardRulesFile, "classifier", guard.HasClassifier())
+
+	return addons.NewPromptGuard(logger, guard, mode), nil
+}
+
+// configureSchemaValidator creates the SchemaValidator addon, or returns nil when the response
+// schema validation is disabled
+func configureSchemaValidator(
+	logger *slog.Logger,
+	cfg *config.Config,
+	upstreamTLS *config.UpstreamTLS,
+	upstreamProxy *config.UpstreamProxy,
+) (*addons.SchemaValidator, error) {
+	hb := cfg.HTTPBehavior
+	mode, err := config.ParseGuardrailMode(hb.SchemaValidation)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --schema-validation: %w", err)
+	}
+	if hb.SchemaRetries < 0 {
+		return nil, errors.New("--schema-retries can't be negative")
+	}
+	if mode == config.GuardrailModeOff {
+		if hb.SchemaRetries > 0 {
+			return nil, errors.New("--schema-retries requires --schema-validation monitor or enforce")
+		}
+		return nil, nil
+	}
+	logger.Debug("Enabled response schema validation", "mode", mode, "retries", hb.SchemaRetries)
+
+	return addons.NewSchemaValidator(
+		logger, mode, hb.SchemaRetries, cfg.HeaderFilters.RequestToUpstream, hb.InsecureSkipVerifyTLS, upstreamTLS, upstreamProxy), nil
+}
+
+// configureModeration loads the moderation config, and creates the Moderation addon, or returns nil
+// when no moderation file is configured
+func configureModeration(logger *slog.Logger, cfg *config.Config) (*addons.Moderation, error) {
+	if cfg.HTTPBehavior.ModerationFile == "" {
+		return nil, nil
+	}
+
+	moderator, err := moderation.LoadFile(cfg.HTTPBehavior.ModerationFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load moderation config: %w", err)
+	}
+	logger.Debug("Loaded moderation config", "moderationFile", cfg.HTTPBehavior.ModerationFile,
+		"backend", moderator.Backend(), "responses", moderator.Responses())
+
+	return addons.NewModeration(logger, moderator), nil
+}
+
+// configureUpstreamRouter loads the upstream pools, and creates the UpstreamRouter addon, or returns
+// nil when no pools file is configured
+func configureUpstreamRouter(
+	logger *slog.Logger,
+	cfg *config.Config,
+	upstreamTLS *config.UpstreamTLS,
+	upstreamProxy *config.UpstreamProxy,
+) (*addons.UpstreamRouter, error) {
+	if cfg.HTTPBehavior.UpstreamPoolsFile == "" {
+		return nil, nil
+	}
+
+	pools, err := config.LoadUpstreamPools(cfg.HTTPBehavior.UpstreamPoolsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upstream pools: %w", err)
+	}
+	logger.Debug("Loaded upstream pools", "poolsFile", cfg.HTTPBehavior.UpstreamPoolsFile, "poolCount", len(pools.Pools))
+
+	return addons.NewUpstreamRouter(logger, pools, cfg.HTTPBehavior.InsecureSkipVerifyTLS, upstreamTLS, upstreamProxy), nil
+}
+
+// configureUpstreamTLS loads the per-host upstream TLS config, or returns nil when no upstream TLS
+// file is configured
+func configureUpstreamTLS(logger *slog.Logger, cfg *config.Config) (*config.UpstreamTLS, error) {
+	if cfg.HTTPBehavior.UpstreamTLSFile == "" {
+		return nil, nil
+	}
+
+	upstreamTLS, err := config.LoadUpstreamTLS(cfg.HTTPBehavior.UpstreamTLSFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upstream TLS config: %w", err)
+	}
+	logger.Debug("Loaded upstream TLS config", "upstreamTLSFile", cfg.HTTPBehavior.UpstreamTLSFile, "hostCount", len(upstreamTLS.Hosts))
+	return upstreamTLS, nil
+}
+
+// configureRateLimiter loads the rate limits, and creates the RateLimiter addon, or returns nil when
+// no rate limits file is configured
+func configureRateLimiter(logger *slog.Logger, cfg *config.Config) (*addons.RateLimiter, error) {
+	if cfg.HTTPBehavior.RateLimitsFile == "" {
+		return nil, nil
+	}
+
+	limits, err := config.LoadRateLimits(cfg.HTTPBehavior.RateLimitsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rate limits: %w", err)
+	}
+	logger.Debug("Loaded rate limits", "rateLimitsFile", cfg.HTTPBehavior.RateLimitsFile, "limitCount", len(limits.Limits))
+
+	return addons.NewRateLimiter(logger, limits), nil
+}
+
+// configureRetrier creates the Retrier addon, or returns nil when retries are disabled
+func configureRetrier(
+	logger *slog.Logger,
+	cfg *config.Config,
+	upstreamTLS *config.UpstreamTLS,
+	upstreamProxy *config.UpstreamProxy,
+) (*addons.Retrier, error) {
+	hb := cfg.HTTPBehavior
+	if hb.RetryMaxAttempts < 2 {
+		return nil, nil
+	}
+
+	retrier, err := addons.NewRetrier(
+		logger, hb.RetryMaxAttempts, hb.RetryBaseDelay, hb.RetryMaxDelay, hb.RetryBudget,
+		hb.InsecureSkipVerifyTLS, upstreamTLS, upstreamProxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retry config: %w", err)
+	}
+	return retrier, nil
+}
+
+// configureVirtualKeys loads the secret store and the virtual API keys, and creates the VirtualKeys
+// addon, or returns nil when no virtual keys database is configured
+func configureVirtualKeys(logger *slog.Logger, cfg *config.Config) (*addons.VirtualKeys, error) {
+	hb := cfg.HTTPBehavior
+	if hb.VirtualKeysDB == "" {
+		if hb.RequireVirtualKeys {
+			return nil, errors.New("--require-virtual-keys needs a --virtual-keys-db")
+		}
+		return nil, nil
+	}
+	if hb.SecretStoreFile == "" {
+		return nil, errors.New("virtual API keys need a --secret-store file with the provider keys")
+	}
+
+	secrets, err := config.LoadSecretStore(hb.SecretStoreFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secret store: %w", err)
+	}
+
+	virtualKeys, err := addons.NewVirtualKeys(logger, hb.VirtualKeysDB, secrets, hb.RequireVirtualKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load virtual API keys: %w", err)
+	}
+	logger.Debug("Loaded virtual API keys", "virtualKeysDB", hb.VirtualKeysDB, "secretCount", len(secrets.Secrets))
+	return virtualKeys, nil
+}
+
+// configureProxyAuth loads the htpasswd file and the client allowlist, and creates the ProxyAuth
+// addon, or returns nil when neither is configured
+func configureProxyAuth(logger *slog.Logger, cfg *config.Config) (*addons.ProxyAuth, error) {
+	hb := cfg.HTTPBehavior
+	allowlist, err := config.ParseClientAllowlist(hb.AllowedClients)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --allow-clients: %w", err)
+	}
+	if hb.ProxyAuthFile == "" && len(allowlist) == 0 {
+		return nil, nil
+	}
+
+	var htpasswd *config.Htpasswd
+	if hb.ProxyAuthFile != "" {
+		htpasswd, err = config.LoadHtpasswd(hb.ProxyAuthFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load proxy auth file: %w", err)
+		}
+	}
+
+	proxyAuth, err := addons.NewProxyAuth(logger, htpasswd, allowlist)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Loaded proxy auth", "proxyAuthFile", hb.ProxyAuthFile, "users", htpasswd.Len(), "allowedClients", len(allowlist))
+	return proxyAuth, nil
+}
+
+// configureUpstreamProxy creates the UpstreamProxy addon, and sets the upstream proxy of the proxy
+// library, or returns nil when no upstream proxy is configured
+func configureUpstreamProxy(logger *slog.Logger, cfg *config.Config) (*addons.UpstreamProxy, error) {
+	hb := cfg.HTTPBehavior
+	noProxy := hb.UpstreamNoProxy
+	if len(noProxy) == 0 {
+		noProxy = strings.Split(cmp.Or(os.Getenv("NO_PROXY"), os.Getenv("no_proxy")), ",")
+	}
+
+	proxy, err := config.ParseUpstreamProxy(hb.UpstreamProxy, noProxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --upstream-proxy: %w", err)
+	}
+	if proxy == nil {
+		return nil, nil
+	}
+
+	upstreamProxy, err := addons.NewUpstreamProxy(logger, proxy)
+	if err != nil {
+		return nil, err
+	}
+	// the proxy library only reads its upstream proxy from the environment
+	if err := upstreamProxy.SetProxyLibraryEnvironment(); err != nil {
+		_ = upstreamProxy.Close()
+		return nil, err
+	}
+	logger.Debug("Chaining through the upstream proxy", "upstreamProxy", proxy.String(), "noProxy", proxy.NoProxy())
+	return upstreamProxy, nil
+}
+
+// configureTLSPassthrough creates the addon that tunnels the TLS connections to the hosts that
+// aren't intercepted, or returns nil when every host is intercepted. The tunnels are opened through
+// the upstream proxy, when it's not nil.
+func configureTLSPassthrough(
+	logger *slog.Logger,
+	cfg *config.Config,
+	upstreamProxy *addons.UpstreamProxy,
+) (*addons.TLSPassthrough, error) {
+	hb := cfg.HTTPBehavior
+	hosts, err := config.ParseInterceptHosts(hb.InterceptHosts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --intercept-hosts: %w", err)
+	}
+	if hosts.All() {
+		return nil, nil
+	}
+
+	if hb.ReverseProxyListen != "" {
+		// the reverse proxy listener only trusts this proxy's CA, so its upstream hosts must be
+		// intercepted
+		routes, err := config.ParseReverseProxyRoutes(hb.ReverseProxyRoutes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --reverse-proxy-route: %w", err)
+		}
+		for _, route := range routes {
+			if !hosts.Intercepts(route.Upstream.Host) {
+				hosts = append(hosts, strings.ToLower(route.Upstream.Hostname()))
+			}
+		}
+	}
+
+	logger.Debug("Loaded intercept hosts", "interceptHosts", []string(hosts))
+	return addons.NewTLSPassthrough(logger, hosts, upstreamProxy), nil
+}
+
 func configureCacheAddon(logger *slog.Logger, cfg *config.Config) (*addons.ResponseCacheAddon, error) {
 	cacheConfig, err := cfg.Cache.GetCacheStorageConfig(logger)
 	if err != nil {
@@ -1,14 +1,18 @@
 package proxy
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
+	"strings"
 	"sync/atomic"
 
 	"github.com/proxati/llm_proxy/v2/config"
 	"github.com/proxati/llm_proxy/v2/proxy/addons"
 	"github.com/proxati/llm_proxy/v2/proxy/addons/helpers"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
 	px "github.com/proxati/mitmproxy/proxy"
 )
 
@@ -20,6 +24,8 @@ type metaAddon struct {
 	cfg            *config.Config
 	mitmAddons     []px.Addon
 	closableAddons []addons.ClosableAddon
+	senders        []addons.UpstreamSender
+	localResponses []addons.LocalResponseHandler
 	logger         *slog.Logger
 	closed         atomic.Bool
 }
@@ -76,6 +82,16 @@ func (ma *metaAddon) addAddon(a any) error {
 		ma.logger.Debug("Loaded closable addon", "addonName", myAddon.String())
 	}
 
+	sender, ok := a.(addons.UpstreamSender)
+	if ok {
+		ma.senders = append(ma.senders, sender) // for sending requests upstream, instead of the proxy library
+	}
+
+	localResponse, ok := a.(addons.LocalResponseHandler)
+	if ok {
+		ma.localResponses = append(ma.localResponses, localResponse) // for the responses set in the Request hooks
+	}
+
 	mitmAddon, ok := a.(px.Addon)
 	if ok {
 		// the addon is a valid mitmproxy addon, but it lacks a .String() method so we can't log it
@@ -137,9 +153,14 @@ func (addon *metaAddon) TlsEstablishedServer(ctx *px.ConnContext) {
 }
 
 func (addon *metaAddon) Requestheaders(flow *px.Flow) {
+	// the addons pass results between the hooks in the internal request headers, so a client can't
+	// be allowed to set them
+	removeInternalHeaders(flow)
+
 	if addon.closed.Load() {
 		addon.logger.Warn("skipping addons for Requestheaders, metaAddon is being closed")
 		helpers.GenerateClosedResponse(addon.logger, flow)
+		addon.localResponse(flow)
 		return
 	}
 
@@ -147,6 +168,7 @@ func (addon *metaAddon) Requestheaders(flow *px.Flow) {
 		a.Requestheaders(flow)
 		if flow.Response != nil {
 			// the response has been set, stop processing addons
+			addon.localResponse(flow)
 			break
 		}
 	}
@@ -159,19 +181,86 @@ func (addon *metaAddon) Request(flow *px.Flow) {
 		a.Request(flow)
 		if flow.Response != nil {
 			// the response has been set, stop processing addons
-			break
+			addon.localResponse(flow)
+			return
+		}
+	}
+
+	for _, sender := range addon.senders {
+		if sender.Handles(flow) {
+			addon.sendUpstream(sender, flow)
+			return
 		}
 	}
 
 	// TODO: add a logger here
 }
 
+// removeInternalHeaders removes the internal headers from an incoming request, before any addon runs
+func removeInternalHeaders(flow *px.Flow) {
+	if flow.Request == nil {
+		return
+	}
+	for name := range flow.Request.Header {
+		if len(name) >= len(headers.InternalPrefix) && strings.EqualFold(name[:len(headers.InternalPrefix)], headers.InternalPrefix) {
+			delete(flow.Request.Header, name)
+		}
+	}
+}
+
+// localResponse runs the LocalResponseHandlers on a response that was set in the Requestheaders,
+// Request, or Responseheaders hooks, which the proxy library sends to the client without calling
+// the rest of the response hooks
+func (addon *metaAddon) localResponse(flow *px.Flow) {
+	for _, a := range addon.localResponses {
+		a.LocalResponse(flow)
+	}
+}
+
+// sendUpstream sends the request with an UpstreamSender, instead of the proxy library. The proxy
+// library replies with the response as soon as it's set in the Request hook, so the steps that the
+// proxy library would run after sending the request are run here: the stream request modifiers,
+// and then the response hooks of all addons.
+func (addon *metaAddon) sendUpstream(sender addons.UpstreamSender, flow *px.Flow) {
+	logger := addon.logger.With("sender", sender.String(), "ProxyID", flow.Id.String())
+
+	body, err := io.ReadAll(addon.StreamRequestModifier(flow, bytes.NewReader(flow.Request.Body)))
+	if err != nil {
+		logger.Error("Unable to read the request body", "error", err)
+		helpers.GenerateErrorResponse(flow, http.StatusBadGateway, "upstream_error", "", "unable to read the request body")
+		addon.localResponse(flow)
+		return
+	}
+
+	if err := sender.SendUpstream(flow, body); err != nil {
+		logger.Error("Unable to send the request upstream", "error", err)
+		helpers.GenerateErrorResponse(flow, http.StatusBadGateway, "upstream_error", "", "unable to reach the upstream API")
+		addon.localResponse(flow)
+		return
+	}
+
+	// not addon.Responseheaders, because it stops when the response body is set
+	for _, a := range addon.mitmAddons {
+		a.Responseheaders(flow)
+	}
+	addon.Response(flow)
+
+	respBody, err := io.ReadAll(addon.StreamResponseModifier(flow, bytes.NewReader(flow.Response.Body)))
+	if err != nil {
+		logger.Error("Unable to read the response body", "error", err)
+		helpers.GenerateErrorResponse(flow, http.StatusBadGateway, "upstream_error", "", "unable to read the response body")
+		return
+	}
+	flow.Response.Body = respBody
+}
+
 func (addon *metaAddon) Responseheaders(flow *px.Flow) {
 	for _, a := range addon.mitmAddons {
 		a.Responseheaders(flow)
 
 		if flow.Response != nil && flow.Response.Body != nil {
 			// the response body has been set, stop processing addons
+			addon.localResponse(flow)
 			break
 		}
 	}
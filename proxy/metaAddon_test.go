@@ -1,13 +1,19 @@
 package proxy
 
 import (
+	"errors"
 	"io"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"strings"
 	"sync/atomic"
 	"testing"
 
 	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/proxy/addons"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+	"github.com/proxati/llm_proxy/v2/schema/proxyadapters/mitm"
 	px "github.com/proxati/mitmproxy/proxy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -132,3 +138,219 @@ func TestAllMethods(t *testing.T) {
 		proxyShutdown()
 	})
 }
+
+// mockSender implements the addons.UpstreamSender interface for testing purposes
+type mockSender struct {
+	px.BaseAddon
+	err  error
+	body []byte
+}
+
+func (m *mockSender) String() string          { return "mockSender" }
+func (m *mockSender) Handles(f *px.Flow) bool { return f.Request.URL.Host == "routed.example.com" }
+func (m *mockSender) SendUpstream(f *px.Flow, body []byte) error {
+	m.body = body
+	if m.err != nil {
+		return m.err
+	}
+	f.Response = &px.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: []byte("routed")}
+	return nil
+}
+
+func TestMetaAddonSendUpstream(t *testing.T) {
+	newFlow := func(host string) *px.Flow {
+		return &px.Flow{Request: &px.Request{
+			Method: http.MethodPost,
+			URL:    &url.URL{Scheme: "https", Host: host, Path: "/v1/chat/completions"},
+			Header: http.Header{},
+			Body:   []byte("hello"),
+		}}
+	}
+
+	t.Run("routed", func(t *testing.T) {
+		mock := &mockAddon{}
+		sender := &mockSender{}
+		meta := newMetaAddon(slog.Default(), &config.Config{}, mock, sender)
+		require.Len(t, meta.senders, 1)
+
+		f := newFlow("routed.example.com")
+		meta.Request(f)
+		require.NotNil(t, f.Response)
+		assert.Equal(t, http.StatusOK, f.Response.StatusCode)
+		assert.Equal(t, []byte("routed"), f.Response.Body)
+		assert.Equal(t, []byte("hello"), sender.body)
+
+		// the hooks that would run after the proxy library sends the request
+		assert.True(t, mock.streamRequestModifierCalled.Load())
+		assert.True(t, mock.responseHeadersCalled.Load())
+		assert.True(t, mock.responseCalled.Load())
+		assert.True(t, mock.streamResponseModifierCalled.Load())
+	})
+
+	t.Run("not routed", func(t *testing.T) {
+		mock := &mockAddon{}
+		meta := newMetaAddon(slog.Default(), &config.Config{}, mock, &mockSender{})
+
+		f := newFlow("api.openai.com")
+		meta.Request(f)
+		assert.Nil(t, f.Response, "sent by the proxy library")
+		assert.False(t, mock.responseCalled.Load())
+	})
+
+	t.Run("send error", func(t *testing.T) {
+		mock := &mockAddon{}
+		meta := newMetaAddon(slog.Default(), &config.Config{}, mock, &mockSender{err: errors.New("no route")})
+
+		f := newFlow("routed.example.com")
+		meta.Request(f)
+		require.NotNil(t, f.Response)
+		assert.Equal(t, http.StatusBadGateway, f.Response.StatusCode)
+		assert.False(t, mock.responseCalled.Load())
+	})
+}
+
+// mockLocalResponder sets a response in the Request hook, like a cache hit, and counts the
+// LocalResponse calls
+type mockLocalResponder struct {
+	px.BaseAddon
+	respond bool
+	calls   atomic.Int32
+}
+
+func (m *mockLocalResponder) String() string { return "mockLocalResponder" }
+func (m *mockLocalResponder) Request(f *px.Flow) {
+	if m.respond {
+		f.Response = &px.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: []byte("cached")}
+	}
+}
+func (m *mockLocalResponder) LocalResponse(f *px.Flow) { m.calls.Add(1) }
+
+func TestMetaAddonLocalResponse(t *testing.T) {
+	newFlow := func() *px.Flow {
+		return &px.Flow{Request: &px.Request{
+			Method: http.MethodPost,
+			URL:    &url.URL{Scheme: "https", Host: "api.openai.com", Path: "/v1/chat/completions"},
+			Header: http.Header{},
+		}}
+	}
+
+	t.Run("response set in Request", func(t *testing.T) {
+		first := &mockLocalResponder{respond: true}
+		second := &mockLocalResponder{}
+		meta := newMetaAddon(slog.Default(), &config.Config{}, first, second)
+		require.Len(t, meta.localResponses, 2)
+
+		f := newFlow()
+		meta.Request(f)
+		require.NotNil(t, f.Response)
+		assert.Equal(t, int32(1), first.calls.Load())
+		assert.Equal(t, int32(1), second.calls.Load(), "all handlers run, not only the ones before the response")
+	})
+
+	t.Run("send error", func(t *testing.T) {
+		handler := &mockLocalResponder{}
+		meta := newMetaAddon(slog.Default(), &config.Config{}, handler, &mockSender{err: errors.New("no route")})
+
+		f := newFlow()
+		f.Request.URL.Host = "routed.example.com"
+		meta.Request(f)
+		require.NotNil(t, f.Response)
+		assert.Equal(t, http.StatusBadGateway, f.Response.StatusCode)
+		assert.Equal(t, int32(1), handler.calls.Load())
+	})
+
+	t.Run("response set in Responseheaders", func(t *testing.T) {
+		handler := &mockLocalResponder{}
+		limits := addons.NewLimits(slog.Default(), &config.RequestLimits{MaxResponseBodySize: 10})
+		meta := newMetaAddon(slog.Default(), &config.Config{}, limits, handler)
+
+		f := newFlow()
+		f.Response = &px.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Length": {"100"}}}
+		meta.Responseheaders(f)
+		require.NotNil(t, f.Response.Body)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, f.Response.StatusCode)
+		assert.Equal(t, int32(1), handler.calls.Load())
+	})
+
+	t.Run("sent upstream", func(t *testing.T) {
+		handler := &mockLocalResponder{}
+		meta := newMetaAddon(slog.Default(), &config.Config{}, handler)
+
+		f := newFlow()
+		meta.Request(f)
+		assert.Nil(t, f.Response)
+		assert.Zero(t, handler.calls.Load())
+	})
+}
+
+func TestMetaAddonRemovesInternalHeaders(t *testing.T) {
+	limits, err := config.NewRateLimitsFromJSON([]byte(`{"limits": [{"key": "api_key", "requests_per_minute": 1}]}`))
+	require.NoError(t, err)
+	limiter := addons.NewRateLimiter(slog.Default(), limits)
+	t.Cleanup(func() { _ = limiter.Close() })
+	meta := newMetaAddon(slog.Default(), &config.Config{}, limiter)
+
+	newFlow := func(spoofedKey string) *px.Flow {
+		f := &px.Flow{Request: &px.Request{
+			Method: http.MethodPost,
+			URL:    &url.URL{Scheme: "https", Host: "api.openai.com", Path: "/v1/chat/completions"},
+			Header: http.Header{},
+			Body:   []byte(`{"model": "gpt-4o"}`),
+		}}
+		f.Request.Header.Set("Authorization", "Bearer sk-test")
+		f.Request.Header.Set(headers.VirtualKey, spoofedKey)
+		f.Request.Header["x-llm_proxy-attempts"] = []string{`[{"status": 200}]`} // not canonical
+		f.Request.Header.Set(headers.WorkflowName, "chat")
+		return f
+	}
+
+	f := newFlow("vk-victim")
+	meta.Requestheaders(f)
+	assert.Empty(t, f.Request.Header.Get(headers.VirtualKey))
+	assert.NotContains(t, f.Request.Header, "x-llm_proxy-attempts")
+	assert.Equal(t, "chat", f.Request.Header.Get(headers.WorkflowName), "the workflow header is set by clients")
+	meta.Request(f)
+	assert.Nil(t, f.Response)
+
+	// a different spoofed virtual key doesn't get a new bucket for the same API key
+	f = newFlow("vk-other")
+	meta.Requestheaders(f)
+	meta.Request(f)
+	require.NotNil(t, f.Response)
+	assert.Equal(t, http.StatusTooManyRequests, f.Response.StatusCode)
+}
+
+func TestMetaAddonRemovesForgedResults(t *testing.T) {
+	meta := newMetaAddon(slog.Default(), &config.Config{}, &mockAddon{})
+
+	// the results that the addons save in the request headers, for the traffic log and connection stats
+	forged := map[string]string{
+		headers.Upstream:               "secondary",
+		headers.UpstreamProxy:          "proxy.example.com:3128",
+		headers.Attempts:               `[{"upstream": "primary", "status": 200}]`,
+		headers.OriginalModel:          "gpt-4",
+		headers.PolicyDecision:         `{"action": "allow", "rule": "forged"}`,
+		headers.DLPResult:              `{"action": "allow"}`,
+		headers.PromptGuardResult:      `{"action": "allow"}`,
+		headers.SchemaValidationResult: `{"outcome": "valid"}`,
+		headers.ModerationResult:       `{"request": {"action": "allow"}}`,
+	}
+	f := &px.Flow{Request: &px.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: "https", Host: "api.openai.com", Path: "/v1/chat/completions"},
+		Header: http.Header{},
+	}}
+	for name, value := range forged {
+		f.Request.Header.Set(name, value)
+	}
+
+	meta.Requestheaders(f)
+	for name := range forged {
+		assert.Empty(t, f.Request.Header.Get(name), name)
+	}
+
+	stats := mitm.NewProxyConnectionStatsAdapter(f)
+	assert.Empty(t, stats.GetUpstream())
+	assert.Empty(t, stats.GetUpstreamProxy())
+	assert.Empty(t, stats.GetUpstreamAttempts())
+}
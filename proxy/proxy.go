@@ -14,10 +14,14 @@ import (
 	px "github.com/proxati/mitmproxy/proxy"
 
 	"github.com/proxati/llm_proxy/v2/config"
+	llmca "github.com/proxati/llm_proxy/v2/internal/ca"
 	"github.com/proxati/llm_proxy/v2/proxy/addons"
 	"github.com/proxati/llm_proxy/v2/version"
 )
 
+// caExpiryWarning is when the proxy starts warning that its CA expires soon
+const caExpiryWarning = 30 * 24 * time.Hour
+
 func newCA(logger *slog.Logger, certDir string) (*cert.CA, error) {
 	if certDir == "" {
 		logger.Debug("No cert dir specified, defaulting to ~/.mitmproxy/")
@@ -35,16 +39,30 @@ func newCA(logger *slog.Logger, certDir string) (*cert.CA, error) {
 		return nil, fmt.Errorf("problem with CA config: %w", err)
 	}
 
+	logger.Debug("Loaded CA", "fingerprint", llmca.Fingerprint(&ca.RootCert), "notAfter", ca.RootCert.NotAfter)
+	if time.Until(ca.RootCert.NotAfter) < caExpiryWarning {
+		logger.Warn(
+			"The CA expires soon, rotate it with: llm_proxy ca rotate",
+			"notAfter", ca.RootCert.NotAfter,
+			"certDir", l.StorePath,
+		)
+	}
+
 	return ca, nil
 }
 
+// streamLargeBodies is the body size, in bytes, over which the requests and responses are streamed
+// without calling the Request and Response hooks. The Limits addon cuts off the streamed bodies at
+// the configured max sizes.
+const streamLargeBodies = addons.MaxUpstreamResponseSize
+
 // newProxy returns a new proxy object with some basic configuration
 func newProxy(listenOn string, skipVerifyTLS bool, ca *cert.CA) (*px.Proxy, error) {
 	opts := &px.Options{
 		Addr:                  listenOn,
 		InsecureSkipVerifyTLS: skipVerifyTLS,
 		CA:                    ca,
-		StreamLargeBodies:     1024 * 1024 * 100,                     // responses larger than 100MB will be streamed
+		StreamLargeBodies:     streamLargeBodies,
 		Logger:                slog.Default().WithGroup("mitmproxy"), // don't use the logger from slog.go in this package!
 	}
 
@@ -57,7 +75,7 @@ func newProxy(listenOn string, skipVerifyTLS bool, ca *cert.CA) (*px.Proxy, erro
 
 // configProxy returns a configured proxy object w/ addons. This proxy still needs to be "started"
 // with a blocking call to .Start() (which is handled elsewhere)
-func configProxy(logger *slog.Logger, cfg *config.Config) (*px.Proxy, error) {
+func configProxy(logger *slog.Logger, cfg *config.Config) (p *px.Proxy, err error) {
 	metaAdd := newMetaAddon(logger, cfg)
 
 	ca, err := newCA(logger, cfg.HTTPBehavior.CertDir)
@@ -65,14 +83,57 @@ func configProxy(logger *slog.Logger, cfg *config.Config) (*px.Proxy, error) {
 		return nil, fmt.Errorf("setupCA error: %w", err)
 	}
 
-	p, err := newProxy(cfg.HTTPBehavior.Listen, cfg.HTTPBehavior.InsecureSkipVerifyTLS, ca)
+	p, err = newProxy(cfg.HTTPBehavior.Listen, cfg.HTTPBehavior.InsecureSkipVerifyTLS, ca)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create proxy: %w", err)
 	}
 
+	var metricsAddon *addons.MetricsAddon
+	if cfg.HTTPBehavior.AdminListen != "" {
+		// count requests, latency, and tokens for the admin server's /metrics endpoint
+		metricsAddon = addons.NewMetricsAddon(logger, cfg.HTTPBehavior.InterceptHosts)
+		metaAdd.addAddon(metricsAddon)
+	}
+
 	// always validate the request and response objects
 	metaAdd.addAddon(addons.NewRequestAndResponseValidator(logger))
 
+	// reject clients outside the allowlist or without valid proxy credentials, before the request
+	// is logged, and remove the Proxy-Authorization header
+	proxyAuthAddon, err := configureProxyAuth(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if proxyAuthAddon != nil {
+		metaAdd.addAddon(proxyAuthAddon)
+	}
+
+	// chain the upstream connections through the corporate proxy, the addon is added last
+	upstreamProxyAddon, err := configureUpstreamProxy(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var upstreamProxy *config.UpstreamProxy // for the addons that send requests upstream themselves
+	if upstreamProxyAddon != nil {
+		upstreamProxy = upstreamProxyAddon.Proxy()
+		// the relay is already listening, stop it when a later step fails
+		defer func() {
+			if err != nil {
+				_ = upstreamProxyAddon.Close()
+			}
+		}()
+	}
+
+	// tunnel the TLS connections to the hosts that aren't intercepted, after the proxy auth so its
+	// connection wrapper checks the CONNECT request first
+	tlsPassthroughAddon, err := configureTLSPassthrough(logger, cfg, upstreamProxyAddon)
+	if err != nil {
+		return nil, err
+	}
+	if tlsPassthroughAddon != nil {
+		metaAdd.addAddon(tlsPassthroughAddon)
+	}
+
 	if cfg.IsVerboseOrHigher() {
 		// add the verbose logger to the proxy
 		metaAdd.addAddon(addons.NewStdOutLogger(logger))
@@ -81,8 +142,14 @@ func configProxy(logger *slog.Logger, cfg *config.Config) (*px.Proxy, error) {
 	// struct of bools to toggle the various traffic log outputs
 	logSources := cfg.TrafficLogger.GetLogSourceConfig()
 
+	// optional PII and secret masking for the logged bodies
+	redactor, err := configureRedactor(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	// create the mega traffic dumper addon
-	dumperAddon, err := configureDumper(logger, cfg, logSources)
+	dumperAddon, err := configureDumper(logger, cfg, logSources, redactor)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create traffic log dumper: %w", err)
 	}
@@ -98,11 +165,53 @@ func configProxy(logger *slog.Logger, cfg *config.Config) (*px.Proxy, error) {
 
 		// add the traffic log dumper to the metaAddon
 		metaAdd.addAddon(dumperAddon)
+
+		if metricsAddon != nil {
+			// measure the latency with the same timing as the traffic logs
+			metricsAddon.UseDumperTiming(dumperAddon)
+		}
+	}
+
+	if cfg.AppMode == config.TUIMode {
+		// send each completed flow to the TUI, before the request is modified by other addons
+		metaAdd.addAddon(addons.NewTrafficFeed(
+			logger, cfg.HeaderFilters.RequestToLogs, cfg.HeaderFilters.ResponseToLogs, redactor,
+		))
+	}
+
+	// reject the requests and responses over the size limits, before the other addons read the bodies
+	limitsAddon, err := configureLimits(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if limitsAddon != nil {
+		metaAdd.addAddon(limitsAddon)
+	}
+
+	// the client certificates and root CAs of the upstream hosts, for the addons that send requests
+	upstreamTLS, err := configureUpstreamTLS(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// validate, and retry, the responses before the other Response hooks, so they only see the final
+	// response
+	schemaValidatorAddon, err := configureSchemaValidator(logger, cfg, upstreamTLS, upstreamProxy)
+	if err != nil {
+		return nil, err
+	}
+	if schemaValidatorAddon != nil {
+		metaAdd.addAddon(schemaValidatorAddon)
 	}
 
 	// Always add the request ID to the response headers
 	metaAdd.addAddon(addons.NewAddIDToHeaders())
 
+	// remove internal headers from the upstream request, and filtered headers from the client response
+	metaAdd.addAddon(addons.NewHeaderFilter(
+		logger, cfg.HeaderFilters.RequestToUpstream, cfg.HeaderFilters.ResponseToClient,
+	))
+
 	logger.Debug("http to https upgrade", "enabled", !cfg.HTTPBehavior.NoHTTPUpgrader)
 	// upgrade the request _after_ it's logged
 	if !cfg.HTTPBehavior.NoHTTPUpgrader {
@@ -110,6 +219,70 @@ func configProxy(logger *slog.Logger, cfg *config.Config) (*px.Proxy, error) {
 		metaAdd.addAddon(addons.NewSchemeUpgrader(logger))
 	}
 
+	// check and swap the virtual API keys before anything else can answer the request
+	virtualKeysAddon, err := configureVirtualKeys(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if virtualKeysAddon != nil {
+		metaAdd.addAddon(virtualKeysAddon)
+	}
+
+	// modify the request before it's cached or sent upstream, and the response before it's cached
+	modifierAddon, err := configureModifier(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if modifierAddon != nil {
+		metaAdd.addAddon(modifierAddon)
+	}
+
+	// rewrite the model after the modification rules, so the cache key and cost use the new model
+	modelAliasAddon, err := configureModelAlias(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if modelAliasAddon != nil {
+		metaAdd.addAddon(modelAliasAddon)
+	}
+
+	// check the policy with the aliased model, before the cache, so denied requests are never answered
+	policyAddon, err := configurePolicy(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if policyAddon != nil {
+		metaAdd.addAddon(policyAddon)
+	}
+
+	// find the secrets before the guardrails, so they're never sent to a classifier or moderation API
+	dlpAddon, err := configureDLP(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if dlpAddon != nil {
+		metaAdd.addAddon(dlpAddon)
+	}
+
+	// check the messages for prompt injections before a cached response is sent, so blocked requests
+	// are never answered
+	promptGuardAddon, err := configurePromptGuard(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if promptGuardAddon != nil {
+		metaAdd.addAddon(promptGuardAddon)
+	}
+
+	// moderate the request before the cache, and the response before it's cached
+	moderationAddon, err := configureModeration(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if moderationAddon != nil {
+		metaAdd.addAddon(moderationAddon)
+	}
+
 	logger.Debug("Building proxy config", "AppMode", cfg.AppMode.String())
 	switch cfg.AppMode {
 	case config.CacheMode:
@@ -122,12 +295,49 @@ func configProxy(logger *slog.Logger, cfg *config.Config) (*px.Proxy, error) {
 	case config.APIAuditMode:
 		metaAdd.addAddon(addons.NewAPIAuditor(logger))
 		logger.Debug("APIAuditor mode enabled")
-	case config.ProxyRunMode:
+	case config.ProxyRunMode, config.TUIMode:
 		// log.Debugf("No addons enabled for the basic proxy mode")
 	default:
 		return nil, fmt.Errorf("unknown app mode: %v", cfg.AppMode)
 	}
 
+	// rate limit after the cache, so cache hits don't use the upstream quota
+	rateLimiterAddon, err := configureRateLimiter(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if rateLimiterAddon != nil {
+		metaAdd.addAddon(rateLimiterAddon)
+	}
+
+	// route requests to the upstream pools last, so cache hits are answered before routing
+	routerAddon, err := configureUpstreamRouter(logger, cfg, upstreamTLS, upstreamProxy)
+	if err != nil {
+		return nil, err
+	}
+	if routerAddon != nil {
+		metaAdd.addAddon(routerAddon)
+	}
+
+	// the retrier sends the requests that weren't routed to an upstream pool
+	retrierAddon, err := configureRetrier(logger, cfg, upstreamTLS, upstreamProxy)
+	if err != nil {
+		return nil, err
+	}
+	if retrierAddon != nil {
+		metaAdd.addAddon(retrierAddon)
+	}
+
+	// send the other requests for the hosts with an upstream TLS config, instead of the proxy library
+	if upstreamTLS != nil {
+		metaAdd.addAddon(addons.NewUpstreamTLS(logger, upstreamTLS, cfg.HTTPBehavior.InsecureSkipVerifyTLS, upstreamProxy))
+	}
+
+	// record the upstream proxy of each flow, after the HeaderFilter restores the request headers
+	if upstreamProxyAddon != nil {
+		metaAdd.addAddon(upstreamProxyAddon)
+	}
+
 	// add our single metaAddon abstraction to the proxy
 	p.AddAddon(metaAdd)
 
@@ -191,7 +401,42 @@ func Run(cfg *config.Config) error {
 		return fmt.Errorf("failed to configure proxy: %w", err)
 	}
 
-	if err := startProxy(logger, p, shutdown); err != nil {
+	if cfg.HTTPBehavior.AdminListen != "" {
+		admin := newAdminServer(logger, cfg.HTTPBehavior.AdminListen, cfg, getMetaAddon(p), shutdown)
+		if err := admin.Start(); err != nil {
+			return fmt.Errorf("failed to start admin server: %w", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := admin.Shutdown(ctx); err != nil {
+				logger.Error("Unexpected error shutting down admin server", "error", err)
+			}
+		}()
+	}
+
+	if cfg.HTTPBehavior.ReverseProxyListen != "" {
+		rp, err := configReverseProxy(logger, cfg, p)
+		if err != nil {
+			return fmt.Errorf("failed to configure reverse proxy: %w", err)
+		}
+		if err := rp.Start(); err != nil {
+			return fmt.Errorf("failed to start reverse proxy: %w", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := rp.Shutdown(ctx); err != nil {
+				logger.Error("Unexpected error shutting down reverse proxy", "error", err)
+			}
+		}()
+	}
+
+	if cfg.AppMode == config.TUIMode {
+		if err := runWithTUI(logger, p, shutdown); err != nil {
+			return fmt.Errorf("failed to run proxy with TUI: %w", err)
+		}
+	} else if err := startProxy(logger, p, shutdown); err != nil {
 		return fmt.Errorf("failed to start proxy: %w", err)
 	}
 	logger.Info("LLM_Proxy shutdown complete")
@@ -715,8 +715,8 @@ func TestConfigProxy(t *testing.T) {
 		metaAddon := p.Addons[0].(*metaAddon)
 		assert.Equal(t, cfg, metaAddon.cfg)
 
-		// Assert that the MetaAddon has two addons (the traffic logger, and the ID header addon)
-		assert.Equal(t, 3, len(metaAddon.mitmAddons))
+		// Assert that the MetaAddon has two addons (the traffic logger, the ID header addon, the header filter, and the TLS passthrough)
+		assert.Equal(t, 5, len(metaAddon.mitmAddons))
 	})
 
 	t.Run("TestConfigProxy verbose mode", func(t *testing.T) {
@@ -745,8 +745,8 @@ func TestConfigProxy(t *testing.T) {
 		metaAddon := p.Addons[0].(*metaAddon)
 		assert.Equal(t, cfg, metaAddon.cfg)
 
-		// Assert that the MetaAddon has two addons (the traffic logger, stdout logger, the ID header addon, and the base addon)
-		assert.Equal(t, 5, len(metaAddon.mitmAddons))
+		// Assert that the MetaAddon has two addons (the traffic logger, stdout logger, the ID header addon, the header filter, the TLS passthrough, and the base addon)
+		assert.Equal(t, 7, len(metaAddon.mitmAddons))
 	})
 
 	t.Run("TestConfigProxy output mode", func(t *testing.T) {
@@ -775,7 +775,7 @@ func TestConfigProxy(t *testing.T) {
 		metaAddon := p.Addons[0].(*metaAddon)
 		assert.Equal(t, cfg, metaAddon.cfg)
 
-		// Assert that the MetaAddon has two addons, the logger, the ID header addon, and the base addon
-		assert.Equal(t, 4, len(metaAddon.mitmAddons))
+		// Assert that the MetaAddon has two addons, the logger, the ID header addon, the header filter, the TLS passthrough, and the base addon
+		assert.Equal(t, 6, len(metaAddon.mitmAddons))
 	})
 }
@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/proxati/mitmproxy/cert"
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/metrics"
+	"github.com/proxati/llm_proxy/v2/proxy/addons"
+)
+
+// reverseProxyServer is an optional listener for clients that set a base URL instead of a proxy,
+// e.g. OPENAI_BASE_URL=http://127.0.0.1:8081/openai/v1. Each request is mapped to an upstream URL
+// by path prefix, and is then sent through the MITM proxy like any other client, so the same addon
+// chain (caching, logging, auditing, etc) handles it.
+//
+// The MITM proxy sees this listener as the client of every request, so the client IP is sent in
+// the X-Forwarded-For header, which the RateLimiter trusts only on this listener's connections.
+type reverseProxyServer struct {
+	listenOn  string
+	routes    config.ReverseProxyRoutes
+	proxyAuth *addons.ProxyAuth // optional, for the client allowlist and credentials
+	dialer    *net.Dialer
+	conns     sync.Map // local address -> struct{}, of the open connections to the MITM proxy
+	server    *http.Server
+	listener  net.Listener
+	logger    *slog.Logger
+}
+
+// newReverseProxyServer creates the reverse proxy listener, but does not start it. The proxyAddr is
+// the listen address of the MITM proxy, and the CA is trusted for the TLS connections through it.
+// When the proxy auth is enabled, the listener checks its client allowlist and credentials, and
+// sends the internal credentials to the MITM proxy.
+func newReverseProxyServer(
+	logger *slog.Logger,
+	listenOn string,
+	routes config.ReverseProxyRoutes,
+	proxyAddr string,
+	ca *cert.CA,
+	proxyAuth *addons.ProxyAuth,
+) (*reverseProxyServer, error) {
+	proxyURL, err := url.Parse("http://" + proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy address %q: %w", proxyAddr, err)
+	}
+	if proxyAuth != nil {
+		proxyURL.User = proxyAuth.InternalUser()
+	}
+
+	rootCAs := x509.NewCertPool()
+	if ca != nil {
+		rootCAs.AddCert(&ca.RootCert)
+	}
+
+	rp := &reverseProxyServer{
+		listenOn:  listenOn,
+		routes:    routes,
+		proxyAuth: proxyAuth,
+		dialer:    &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		logger:    logger.WithGroup("reverseProxy"),
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	transport.DialContext = rp.dialProxy
+	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+
+	handler := &httputil.ReverseProxy{
+		Rewrite:       rp.rewrite,
+		Transport:     transport,
+		FlushInterval: -1, // flush immediately, for streaming responses
+		ErrorHandler:  rp.handleError,
+	}
+
+	rp.server = &http.Server{
+		Addr:              listenOn,
+		Handler:           rp.authorizedClientsOnly(rp.routeOrNotFound(handler)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return rp, nil
+}
+
+// configReverseProxy parses the routes, and creates the reverse proxy listener that sends requests
+// through the configured MITM proxy
+func configReverseProxy(logger *slog.Logger, cfg *config.Config, p *px.Proxy) (*reverseProxyServer, error) {
+	routes, err := config.ParseReverseProxyRoutes(cfg.HTTPBehavior.ReverseProxyRoutes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reverse proxy routes: %w", err)
+	}
+	if len(routes) == 0 {
+		return nil, errors.New("at least one reverse proxy route is required")
+	}
+
+	ca, ok := p.Opts.CA.(*cert.CA)
+	if !ok {
+		return nil, errors.New("the proxy CA is not available")
+	}
+
+	rp, err := newReverseProxyServer(
+		logger, cfg.HTTPBehavior.ReverseProxyListen, routes, cfg.HTTPBehavior.Listen, ca, getMITMAddon[*addons.ProxyAuth](p),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if rateLimiter := getMITMAddon[*addons.RateLimiter](p); rateLimiter != nil {
+		rateLimiter.TrustForwardedFor(rp.isProxyConn)
+	}
+	return rp, nil
+}
+
+// getMITMAddon returns the addon of type T of the proxy, or nil when it's disabled
+func getMITMAddon[T px.Addon](p *px.Proxy) T {
+	var zero T
+	meta := getMetaAddon(p)
+	if meta == nil {
+		return zero
+	}
+	for _, addon := range meta.mitmAddons {
+		if a, ok := addon.(T); ok {
+			return a
+		}
+	}
+	return zero
+}
+
+// authorizedClientsOnly returns a 403 for clients that aren't in the proxy auth's client allowlist,
+// and a 401 for clients without valid credentials when there is an htpasswd file. The credentials
+// are sent in the Proxy-Authorization header, or in a Basic Authorization header.
+func (rp *reverseProxyServer) authorizedClientsOnly(next http.Handler) http.Handler {
+	if rp.proxyAuth == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, message := rp.proxyAuth.CheckListenerRequest(r)
+		if status == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rp.logger.Warn("Rejected reverse proxy client", "client", r.RemoteAddr, "status", status, "reason", message)
+		metrics.ProxyAuthRejectedTotal.WithLabelValues(strconv.Itoa(status)).Inc()
+		if status == http.StatusProxyAuthRequired {
+			// this listener isn't a proxy for its clients
+			w.Header().Set("WWW-Authenticate", `Basic realm="llm_proxy"`)
+			writeOpenAIError(w, http.StatusUnauthorized, "authentication_error", message)
+			return
+		}
+		writeOpenAIError(w, status, "permission_error", message)
+	})
+}
+
+// routeOrNotFound returns a 404 for requests that don't match any route, before they're proxied
+func (rp *reverseProxyServer) routeOrNotFound(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := rp.routes.Match(r.URL.Path); !ok {
+			rp.logger.Debug("No reverse proxy route for request", "path", r.URL.Path)
+			writeOpenAIError(w, http.StatusNotFound, "invalid_request_error",
+				fmt.Sprintf("no upstream route for path: %s", r.URL.Path))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rewrite sets the upstream URL of the outbound request, from the matching route
+func (rp *reverseProxyServer) rewrite(pr *httputil.ProxyRequest) {
+	upstream, _ := rp.routes.Match(pr.In.URL.Path)
+	upstream.RawQuery = pr.In.URL.RawQuery
+
+	pr.Out.URL = upstream
+	pr.Out.Host = "" // use the upstream host
+	if host, _, err := net.SplitHostPort(pr.In.RemoteAddr); err == nil {
+		// the X-Forwarded-For header of the client was already removed by the ReverseProxy
+		pr.Out.Header.Set("X-Forwarded-For", host)
+	}
+	rp.logger.Debug(
+		"Reverse proxy request",
+		"client", pr.In.RemoteAddr,
+		"path", pr.In.URL.Path,
+		"upstream", upstream.String(),
+	)
+}
+
+// dialProxy opens a connection to the MITM proxy, and keeps its local address until it's closed,
+// for isProxyConn
+func (rp *reverseProxyServer) dialProxy(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := rp.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	localAddr := conn.LocalAddr().String()
+	rp.conns.Store(localAddr, struct{}{})
+	return &trackedConn{Conn: conn, onClose: func() { rp.conns.Delete(localAddr) }}, nil
+}
+
+// isProxyConn returns true when the remote address of a MITM proxy client is one of this
+// listener's connections, so the X-Forwarded-For header was set by this listener
+func (rp *reverseProxyServer) isProxyConn(remoteAddr string) bool {
+	_, ok := rp.conns.Load(remoteAddr)
+	return ok
+}
+
+// trackedConn calls onClose once, when the connection is closed
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.onClose)
+	return c.Conn.Close()
+}
+
+// handleError returns a 502 when the request couldn't be sent through the proxy
+func (rp *reverseProxyServer) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	rp.logger.Error("Reverse proxy request failed", "path", r.URL.Path, "error", err)
+	writeOpenAIError(w, http.StatusBadGateway, "upstream_error", "unable to reach the upstream API")
+}
+
+// Start binds the listen address, and serves the reverse proxy in a background goroutine
+func (rp *reverseProxyServer) Start() error {
+	ln, err := net.Listen("tcp", rp.listenOn)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", rp.listenOn, err)
+	}
+	rp.listener = ln
+
+	go func() {
+		rp.logger.Info("Reverse proxy listener starting", "listenAddress", ln.Addr().String())
+		if err := rp.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			rp.logger.Error("Reverse proxy listener error", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the bound address of the reverse proxy, useful when listening on port 0
+func (rp *reverseProxyServer) Addr() string {
+	if rp.listener == nil {
+		return rp.listenOn
+	}
+	return rp.listener.Addr().String()
+}
+
+// Shutdown gracefully stops the reverse proxy listener
+func (rp *reverseProxyServer) Shutdown(ctx context.Context) error {
+	rp.logger.Debug("Closing reverse proxy listener...")
+	return rp.server.Shutdown(ctx)
+}
+
+// writeOpenAIError sends an error response body in the same format as the OpenAI API, so SDKs
+// can parse it
+func writeOpenAIError(w http.ResponseWriter, status int, errType, message string) {
+	body, _ := json.Marshal(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    errType,
+		},
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
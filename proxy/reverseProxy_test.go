@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/proxy/addons"
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
+
+func TestReverseProxy(t *testing.T) {
+	logger := slog.Default()
+
+	proxyPort, err := getFreePort(t)
+	require.NoError(t, err)
+	tmpDir := t.TempDir()
+	proxyShutdown, err := runProxy(t, proxyPort, tmpDir, config.CacheMode, config.CacheEngineMemory)
+	require.NoError(t, err)
+
+	hitCounter := new(atomic.Int32)
+	testServerPort, err := getFreePort(t)
+	require.NoError(t, err)
+	_, srvShutdown := runWebServer(t, hitCounter, testServerPort)
+
+	routes, err := config.ParseReverseProxyRoutes([]string{"/test=http://" + testServerPort})
+	require.NoError(t, err)
+	ca, err := newCA(logger, tmpDir+"/"+certSubdir)
+	require.NoError(t, err)
+
+	rp, err := newReverseProxyServer(logger, "127.0.0.1:0", routes, proxyPort, ca, nil)
+	require.NoError(t, err)
+	require.NoError(t, rp.Start())
+	t.Cleanup(func() {
+		require.NoError(t, rp.Shutdown(context.Background()))
+		srvShutdown()
+		proxyShutdown()
+	})
+	baseURL := "http://" + rp.Addr()
+
+	t.Run("routed through the proxy addons", func(t *testing.T) {
+		for i, expectedCache := range []string{headers.CacheStatusValueMiss, headers.CacheStatusValueHit} {
+			resp, err := http.Post(baseURL+"/test/v1/chat/completions", "application/json", strings.NewReader("hello"))
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, respBuilder(t, 1, strings.NewReader("hello")), body, "request %d", i)
+			assert.NotEmpty(t, resp.Header.Get(headers.ProxyID))
+			assert.Equal(t, expectedCache, resp.Header.Get(headers.CacheStatusHeader))
+		}
+		assert.Equal(t, int32(1), hitCounter.Load(), "the second request is a cache hit")
+	})
+
+	t.Run("unknown route", func(t *testing.T) {
+		resp, err := http.Get(baseURL + "/unknown/v1/models")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		var obj map[string]map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&obj))
+		assert.Equal(t, "invalid_request_error", obj["error"]["type"])
+	})
+}
+
+func TestReverseProxyForwardedFor(t *testing.T) {
+	routes, err := config.ParseReverseProxyRoutes([]string{"/test=http://127.0.0.1:1"})
+	require.NoError(t, err)
+	rp, err := newReverseProxyServer(slog.Default(), "127.0.0.1:0", routes, "127.0.0.1:1", nil, nil)
+	require.NoError(t, err)
+
+	t.Run("client IP", func(t *testing.T) {
+		in := httptest.NewRequest(http.MethodPost, "/test/v1/chat/completions", nil)
+		in.RemoteAddr = "192.0.2.1:4000"
+		out := in.Clone(context.Background())
+		rp.rewrite(&httputil.ProxyRequest{In: in, Out: out})
+		assert.Equal(t, "192.0.2.1", out.Header.Get("X-Forwarded-For"))
+	})
+
+	t.Run("connections to the proxy", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+
+		conn, err := rp.dialProxy(context.Background(), "tcp", ln.Addr().String())
+		require.NoError(t, err)
+		assert.True(t, rp.isProxyConn(conn.LocalAddr().String()))
+		assert.False(t, rp.isProxyConn(ln.Addr().String()))
+
+		require.NoError(t, conn.Close())
+		assert.False(t, rp.isProxyConn(conn.LocalAddr().String()), "closed connections are forgotten")
+	})
+}
+
+func TestReverseProxyAuth(t *testing.T) {
+	logger := slog.Default()
+	// bob:secret
+	htpasswd, err := config.NewHtpasswd([]byte("bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"))
+	require.NoError(t, err)
+	proxyAuth, err := addons.NewProxyAuth(logger, htpasswd, nil)
+	require.NoError(t, err)
+
+	routes, err := config.ParseReverseProxyRoutes([]string{"/test=http://127.0.0.1:1"})
+	require.NoError(t, err)
+	rp, err := newReverseProxyServer(logger, "127.0.0.1:0", routes, "127.0.0.1:1", nil, proxyAuth)
+	require.NoError(t, err)
+
+	var forwarded http.Header
+	handler := rp.authorizedClientsOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Run("no credentials", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/test/v1/chat/completions", nil)
+		r.Header.Set("Authorization", "Bearer sk-test")
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "the allowlist is empty, but the credentials are required")
+		assert.Equal(t, `Basic realm="llm_proxy"`, w.Header().Get("WWW-Authenticate"))
+		assert.Contains(t, w.Body.String(), "authentication_error")
+	})
+
+	t.Run("credentials", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/test/v1/chat/completions", nil)
+		r.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("bob:secret")))
+		r.Header.Set("Authorization", "Bearer sk-test")
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, forwarded.Get("Proxy-Authorization"))
+		assert.Equal(t, "Bearer sk-test", forwarded.Get("Authorization"))
+	})
+}
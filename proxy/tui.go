@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/internal/tui"
+	"github.com/proxati/llm_proxy/v2/proxy/addons"
+)
+
+// getTrafficFeed finds the TrafficFeed addon loaded in the metaAddon, returns nil if not found
+func getTrafficFeed(p *px.Proxy) *addons.TrafficFeed {
+	meta := getMetaAddon(p)
+	if meta == nil {
+		return nil
+	}
+
+	for _, addon := range meta.mitmAddons {
+		if feed, ok := addon.(*addons.TrafficFeed); ok {
+			return feed
+		}
+	}
+	return nil
+}
+
+// runWithTUI runs the proxy in the background and the TUI in the foreground. Closing the TUI
+// sends a shutdown signal to the proxy, and the TUI is closed if the proxy stops on its own.
+func runWithTUI(logger *slog.Logger, p *px.Proxy, shutdown chan os.Signal) error {
+	feed := getTrafficFeed(p)
+	if feed == nil {
+		return errors.New("traffic feed addon is not loaded")
+	}
+
+	program := tui.NewProgram(feed.Events())
+
+	proxyErr := make(chan error, 1)
+	go func() {
+		proxyErr <- startProxy(logger, p, shutdown)
+		program.Quit()
+	}()
+
+	_, tuiErr := program.Run()
+	logger.Debug("TUI closed, shutting down proxy")
+
+	select {
+	case shutdown <- syscall.SIGTERM:
+	default:
+		// a shutdown signal is already pending
+	}
+
+	if err := <-proxyErr; err != nil {
+		return err
+	}
+	if tuiErr != nil {
+		return fmt.Errorf("tui error: %w", tuiErr)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package schema
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -22,6 +23,12 @@ type AuditOutput struct {
 	OutputCost   string `JSON:"outputCost"`
 	TotalReqCost string `JSON:"totalReqCost"`
 	GrandTotal   string `JSON:"grandTotal"`
+
+	// numeric values, for metrics
+	InputTokens       int     `JSON:"inputTokens"`
+	OutputTokens      int     `JSON:"outputTokens"`
+	Currency          string  `JSON:"currency"`
+	TotalReqCostValue float64 `JSON:"totalReqCostValue"`
 }
 
 func (output *AuditOutput) String() string {
@@ -99,6 +106,13 @@ func (cc *CostCounter) String() string {
 	return cc.grandTotal.String()
 }
 
+// GetGrandTotal returns the formatted total cost of all transactions added to this counter
+func (cc *CostCounter) GetGrandTotal() string {
+	cc.rwMutex.RLock()
+	defer cc.rwMutex.RUnlock()
+	return cc.formatter.Format(cc.grandTotal)
+}
+
 func (cc *CostCounter) providerCacheLookup(cacheKey string) *APIProvider {
 	cc.rwMutex.RLock()
 	defer cc.rwMutex.RUnlock()
@@ -178,6 +192,7 @@ func (cc *CostCounter) Add(req ProxyRequest, resp ProxyResponse) (*AuditOutput,
 		return nil, fmt.Errorf("failed to add outputCost to the grand total: %v", err)
 	}
 	totalReqCost, _ := inputCost.Add(outputCost)
+	totalReqCostValue, _ := strconv.ParseFloat(totalReqCost.Number(), 64)
 
 	// return the output object with the formatted cost data w/ currency symbol added
 	return &AuditOutput{
@@ -187,5 +202,10 @@ func (cc *CostCounter) Add(req ProxyRequest, resp ProxyResponse) (*AuditOutput,
 		OutputCost:   cc.formatter.Format(outputCost),
 		TotalReqCost: cc.formatter.Format(totalReqCost),
 		GrandTotal:   cc.formatter.Format(cc.grandTotal),
+
+		InputTokens:       chatCompResp.Usage.PromptTokens,
+		OutputTokens:      chatCompResp.Usage.CompletionTokens,
+		Currency:          totalReqCost.CurrencyCode(),
+		TotalReqCostValue: totalReqCostValue,
 	}, nil
 }
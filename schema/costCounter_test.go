@@ -29,6 +29,14 @@ func TestCostCounterString(t *testing.T) {
 	assert.Equal(t, "100 USD", cc.String())
 }
 
+func TestGetGrandTotal(t *testing.T) {
+	cc := NewCostCounterDefaults()
+	mon, err := currency.NewAmount("1.5", "USD")
+	require.NoError(t, err)
+	cc.grandTotal = mon
+	assert.Equal(t, "$1.50", cc.GetGrandTotal())
+}
+
 func TestProviderLookup(t *testing.T) {
 	cc := NewCostCounterDefaults()
 	// Assuming there's a provider with URL "http://example.com" and product "testModel" for testing
@@ -78,6 +86,7 @@ func TestAdd(t *testing.T) {
 		OutputCost:   "$0.00",
 		TotalReqCost: "$0.00",
 		GrandTotal:   "$0.00",
+		Currency:     "USD",
 	}
 
 	out, err := cc.Add(req, resp)
@@ -0,0 +1,10 @@
+package schema
+
+import "github.com/proxati/llm_proxy/v2/internal/redact"
+
+// DLPResult is the result of the DLP scan for a request that contained secrets
+type DLPResult struct {
+	Action   string           `json:"action"`            // "block" or "redact"
+	Blocked  bool             `json:"blocked,omitempty"` // the request was rejected
+	Findings []redact.Finding `json:"findings"`
+}
@@ -1,5 +1,9 @@
 package headers
 
+// InternalPrefix is the prefix of the headers set by this proxy. Incoming requests with these headers
+// have them removed, because the addons use them to pass results between the hooks.
+const InternalPrefix = "X-Llm_proxy-"
+
 const (
 	// ProxyID is a response header with a request ID assigned by this proxy to track the request
 	ProxyID = "X-Llm_proxy-id"
@@ -10,6 +14,58 @@ const (
 	// SchemeUpgraded is a response header that indicates that the scheme was upgraded (http->https)
 	SchemeUpgraded = "X-Llm_proxy-scheme-upgraded"
 
+	// OriginalModel is an internal request header with the requested model, when it was rewritten by
+	// the model alias table
+	OriginalModel = "X-Llm_proxy-original-model"
+
+	// Upstream is an internal request header with the name of the upstream target that was chosen
+	// by the upstream router
+	Upstream = "X-Llm_proxy-upstream"
+
+	// UpstreamProxy is an internal request header with the host of the upstream proxy that the
+	// request was chained through
+	UpstreamProxy = "X-Llm_proxy-upstream-proxy"
+
+	// Attempts is an internal request header with a JSON list of the upstream attempts made by the
+	// retry addon, for the connection stats
+	Attempts = "X-Llm_proxy-attempts"
+
+	// VirtualKey is an internal request header with the ID of the virtual API key used by the client
+	VirtualKey = "X-Llm_proxy-virtual-key"
+
+	// PolicyDecision is an internal request header with the JSON policy decision, for the traffic logs
+	PolicyDecision = "X-Llm_proxy-policy-decision"
+
+	// DLPResult is an internal request header with the JSON result of the DLP scan, for the traffic
+	// logs
+	DLPResult = "X-Llm_proxy-dlp-result"
+
+	// DLP is a response header with the detectors that found the secrets redacted from the request
+	DLP = "X-Llm_proxy-dlp"
+
+	// SchemaValidationResult is an internal request header with the JSON result of the response
+	// schema validation, for the traffic logs
+	SchemaValidationResult = "X-Llm_proxy-schema-validation-result"
+
+	// SchemaValidation is a response header with "valid" or "invalid", when the response was
+	// validated against the schemas in the request
+	SchemaValidation = "X-Llm_proxy-schema-validation"
+
+	// PromptGuardResult is an internal request header with the JSON result of the prompt guard, for
+	// the traffic logs
+	PromptGuardResult = "X-Llm_proxy-prompt-guard-result"
+
+	// PromptGuard is a response header with the categories that the prompt guard flagged in the
+	// request, in monitor mode
+	PromptGuard = "X-Llm_proxy-prompt-guard"
+
+	// ModerationResult is an internal request header with the JSON moderation result of the request
+	// and response, for the traffic logs
+	ModerationResult = "X-Llm_proxy-moderation-result"
+
+	// Moderation is a response header with the categories that the moderation annotated
+	Moderation = "X-Llm_proxy-moderation"
+
 	// WorkflowName is an optional request header that can be used to specify the name of the workflow
 	WorkflowName = "X-Llm_workflow-name"
 
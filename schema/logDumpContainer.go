@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/redact"
 	"github.com/proxati/llm_proxy/v2/schema/proxyadapters"
 )
 
@@ -24,13 +25,19 @@ const ObjectTypeDefault string = "llm_proxy_traffic_log"
 
 // LogDumpContainer holds the request and response data for a given flow
 type LogDumpContainer struct {
-	ObjectType      string                `json:"object_type,omitempty"`
-	SchemaVersion   string                `json:"schema,omitempty"`
-	Timestamp       time.Time             `json:"timestamp,omitempty"`
-	ConnectionStats *ProxyConnectionStats `json:"connection_stats,omitempty"`
-	Request         *ProxyRequest         `json:"request,omitempty"`
-	Response        *ProxyResponse        `json:"response,omitempty"`
-	logConfig       config.LogSourceConfig
+	ObjectType       string                  `json:"object_type,omitempty"`
+	SchemaVersion    string                  `json:"schema,omitempty"`
+	Timestamp        time.Time               `json:"timestamp,omitempty"`
+	ConnectionStats  *ProxyConnectionStats   `json:"connection_stats,omitempty"`
+	Request          *ProxyRequest           `json:"request,omitempty"`
+	Response         *ProxyResponse          `json:"response,omitempty"`
+	OriginalModel    string                  `json:"original_model,omitempty"`    // requested model, when it was rewritten by an alias
+	Policy           *config.PolicyDecision  `json:"policy,omitempty"`            // set when the policy evaluated the request
+	DLP              *DLPResult              `json:"dlp,omitempty"`               // set when secrets were found in the request
+	PromptGuard      *PromptGuardResult      `json:"prompt_guard,omitempty"`      // set when the prompt guard flagged the request
+	SchemaValidation *SchemaValidationResult `json:"schema_validation,omitempty"` // set when the response was validated
+	Moderation       *ModerationResult       `json:"moderation,omitempty"`        // set when the request or response was moderated
+	logConfig        config.LogSourceConfig
 }
 
 func NewLogDumpContainerEmpty() *LogDumpContainer {
@@ -43,12 +50,14 @@ func NewLogDumpContainerEmpty() *LogDumpContainer {
 	}
 }
 
-// NewLogDumpContainerFromFlowAdapter returns a LogDumpContainer with *only* the fields requested in logSources populated
+// NewLogDumpContainerFromFlowAdapter returns a LogDumpContainer with *only* the fields requested in logSources populated.
+// When redactor is not nil, sensitive data in the request and response bodies is masked.
 func NewLogDumpContainerFromFlowAdapter(
 	f proxyadapters.FlowReaderAdapter,
 	logSources config.LogSourceConfig,
 	doneAt int64,
 	filterReqHeaders, filterRespHeaders *config.HeaderFilterGroup,
+	redactor *redact.Redactor,
 ) (*LogDumpContainer, error) {
 	if f == nil {
 		return nil, errors.New("flow is nil")
@@ -84,6 +93,16 @@ func NewLogDumpContainerFromFlowAdapter(
 		ldc.ConnectionStats = NewProxyConnectionStatsWithDuration(f.GetConnectionStats(), doneAt)
 	}
 
+	// mask the bodies here, before any formatter sees them
+	if redactor != nil {
+		if ldc.Request != nil {
+			ldc.Request.Body = redactor.Redact(ldc.Request.Body)
+		}
+		if ldc.Response != nil {
+			ldc.Response.Body = redactor.Redact(ldc.Response.Body)
+		}
+	}
+
 	if len(errs) > 0 {
 		for _, err := range errs {
 			if err != nil {
@@ -11,6 +11,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/proxati/llm_proxy/v2/config"
+	"github.com/proxati/llm_proxy/v2/internal/redact"
 	"github.com/proxati/llm_proxy/v2/schema"
 	"github.com/proxati/llm_proxy/v2/schema/proxyadapters"
 )
@@ -259,7 +260,7 @@ func TestNewLogDumpDiskContainer_JSON(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			container, err := schema.NewLogDumpContainerFromFlowAdapter(tc.flow, tc.logSources, 0, tc.filterReqHeaders, tc.filterRespHeaders)
+			container, err := schema.NewLogDumpContainerFromFlowAdapter(tc.flow, tc.logSources, 0, tc.filterReqHeaders, tc.filterRespHeaders, nil)
 			require.Nil(t, err)
 			assert.Equal(t, tc.expectedConnectionStats, container.ConnectionStats)
 			assert.Equal(t, tc.expectedRequestMethod, container.Request.Method)
@@ -274,6 +275,19 @@ func TestNewLogDumpDiskContainer_JSON(t *testing.T) {
 	}
 }
 
+func TestNewLogDumpContainerFromFlowAdapter_Redacted(t *testing.T) {
+	t.Parallel()
+	flow := getDefaultFlow(t).(MockFlow)
+	flow.Request.Body = []byte(`{"messages": [{"content": "email me at bob@example.com"}]}`)
+	flow.Response.Body = []byte(`{"key": "sk-abcdefghijklmnopqrstuvwxyz"}`)
+
+	container, err := schema.NewLogDumpContainerFromFlowAdapter(
+		flow, config.LogSourceConfigAllTrue, 0, nil, nil, redact.NewDefault())
+	require.NoError(t, err)
+	assert.Equal(t, `{"messages": [{"content": "email me at [REDACTED:email]"}]}`, container.Request.Body)
+	assert.Equal(t, `{"key": "[REDACTED:api_key]"}`, container.Response.Body)
+}
+
 func TestUnmarshalLogDumpContainer(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,11 @@
+package schema
+
+import "github.com/proxati/llm_proxy/v2/internal/moderation"
+
+// ModerationResult is the content moderation verdict for a request, and its response when the
+// responses are moderated
+type ModerationResult struct {
+	Backend  string              `json:"backend"` // openai, webhook, or keywords
+	Request  *moderation.Verdict `json:"request,omitempty"`
+	Response *moderation.Verdict `json:"response,omitempty"`
+}
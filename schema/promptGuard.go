@@ -0,0 +1,11 @@
+package schema
+
+import "github.com/proxati/llm_proxy/v2/internal/promptguard"
+
+// PromptGuardResult is the result of the prompt injection and jailbreak guardrail for a request
+type PromptGuardResult struct {
+	Mode       string                  `json:"mode"`              // "monitor" or "enforce"
+	Blocked    bool                    `json:"blocked,omitempty"` // the request was rejected, in enforce mode
+	Detections []promptguard.Detection `json:"detections,omitempty"`
+	Error      string                  `json:"error,omitempty"` // the classifier failed, only the heuristics ran
+}
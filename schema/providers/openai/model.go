@@ -0,0 +1,25 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// requestModel is a minimal view of any OpenAI-style request body, used when only the model
+// field is needed (chat completions, embeddings, images, etc.)
+type requestModel struct {
+	Model string `json:"model"`
+}
+
+// GetModelFromRequestBody parses only the "model" field from a JSON request body
+func GetModelFromRequestBody(body []byte) (string, error) {
+	if len(body) == 0 {
+		return "", nil
+	}
+
+	var rm requestModel
+	if err := json.Unmarshal(body, &rm); err != nil {
+		return "", fmt.Errorf("could not unmarshal model from request body: %w", err)
+	}
+	return rm.Model, nil
+}
@@ -0,0 +1,34 @@
+package openai
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetModelFromRequestBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		expectedModel string
+		expectError   bool
+	}{
+		{"chat completion", `{"model": "gpt-4o", "messages": []}`, "gpt-4o", false},
+		{"embeddings", `{"model": "text-embedding-3-small", "input": "hello"}`, "text-embedding-3-small", false},
+		{"no model field", `{"input": "hello"}`, "", false},
+		{"empty body", ``, "", false},
+		{"invalid JSON", `{"model": `, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := GetModelFromRequestBody([]byte(tt.body))
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedModel, model)
+		})
+	}
+}
@@ -12,6 +12,23 @@ type ProxyConnectionStats struct {
 	URL           string `json:"url"`
 	Duration      int64  `json:"duration_ms"`
 	ProxyID       string `json:"proxy_id,omitempty"`
+	Upstream      string `json:"upstream,omitempty"` // upstream target chosen by the router, if any
+
+	// Chained is true when the request was sent through the upstream (corporate) proxy
+	Chained       bool   `json:"chained,omitempty"`
+	UpstreamProxy string `json:"upstream_proxy,omitempty"` // host of the upstream proxy
+
+	// Attempts is each request sent upstream by the retry addon, when it was retried
+	Attempts []UpstreamAttempt `json:"attempts,omitempty"`
+}
+
+// UpstreamAttempt is one try at sending a request upstream
+type UpstreamAttempt struct {
+	Attempt    int    `json:"attempt"`               // starts at 1
+	StatusCode int    `json:"status_code,omitempty"` // zero when the request failed without a response
+	Error      string `json:"error,omitempty"`
+	Duration   int64  `json:"duration_ms"`
+	Delay      int64  `json:"delay_ms,omitempty"` // backoff before this attempt
 }
 
 // ToJSON converts the ProxyConnectionStats object to a JSON byte slice
@@ -34,6 +51,15 @@ func newConnectionStats(cs proxyadapters.ConnectionStatsReaderAdapter) *ProxyCon
 		ClientAddress: cs.GetClientIP(),
 		ProxyID:       cs.GetProxyID(),
 		URL:           cs.GetRequestURL(),
+		Upstream:      cs.GetUpstream(),
+		UpstreamProxy: cs.GetUpstreamProxy(),
+	}
+	logOutput.Chained = logOutput.UpstreamProxy != ""
+
+	if attempts := cs.GetUpstreamAttempts(); attempts != "" {
+		if err := json.Unmarshal([]byte(attempts), &logOutput.Attempts); err != nil {
+			getLogger().Error("Could not parse the upstream attempts", "error", err)
+		}
 	}
 
 	return logOutput
@@ -8,7 +8,10 @@ import (
 )
 
 // MockConnectionStatsReaderAdapter is a mock implementation of the proxyAdapters.ConnectionStatsReaderAdapter interface
-type MockConnectionStatsReaderAdapter struct{}
+type MockConnectionStatsReaderAdapter struct {
+	attempts      string
+	upstreamProxy string
+}
 
 func (m *MockConnectionStatsReaderAdapter) GetClientIP() string {
 	return "192.168.1.1"
@@ -22,6 +25,18 @@ func (m *MockConnectionStatsReaderAdapter) GetRequestURL() string {
 	return "http://mockurl.com"
 }
 
+func (m *MockConnectionStatsReaderAdapter) GetUpstream() string {
+	return ""
+}
+
+func (m *MockConnectionStatsReaderAdapter) GetUpstreamAttempts() string {
+	return m.attempts
+}
+
+func (m *MockConnectionStatsReaderAdapter) GetUpstreamProxy() string {
+	return m.upstreamProxy
+}
+
 func TestNewProxyConnectionStatsWithDuration(t *testing.T) {
 	mockAdapter := &MockConnectionStatsReaderAdapter{}
 	duration := int64(150)
@@ -33,6 +48,20 @@ func TestNewProxyConnectionStatsWithDuration(t *testing.T) {
 	assert.Equal(t, "mock-proxy-id", stats.ProxyID)
 	assert.Equal(t, "http://mockurl.com", stats.URL)
 	assert.Equal(t, duration, stats.Duration)
+	assert.Nil(t, stats.Attempts)
+	assert.False(t, stats.Chained)
+
+	mockAdapter.attempts = `[{"attempt":1,"status_code":503,"duration_ms":20},{"attempt":2,"status_code":200,"duration_ms":30,"delay_ms":500}]`
+	stats = schema.NewProxyConnectionStatsWithDuration(mockAdapter, duration)
+	assert.Equal(t, []schema.UpstreamAttempt{
+		{Attempt: 1, StatusCode: 503, Duration: 20},
+		{Attempt: 2, StatusCode: 200, Duration: 30, Delay: 500},
+	}, stats.Attempts)
+
+	mockAdapter.upstreamProxy = "proxy.corp:3128"
+	stats = schema.NewProxyConnectionStatsWithDuration(mockAdapter, duration)
+	assert.True(t, stats.Chained)
+	assert.Equal(t, "proxy.corp:3128", stats.UpstreamProxy)
 }
 
 func TestLogStdOutLine_toJSONstr(t *testing.T) {
@@ -44,4 +73,8 @@ func TestLogStdOutLine_toJSONstr(t *testing.T) {
 
 	expected := `{"client_address":"127.0.0.1","url":"http://example.com","duration_ms":100}`
 	assert.Equal(t, expected, line.ToJSONstr())
+
+	line.Upstream = "azure-eastus"
+	expected = `{"client_address":"127.0.0.1","url":"http://example.com","duration_ms":100,"upstream":"azure-eastus"}`
+	assert.Equal(t, expected, line.ToJSONstr())
 }
@@ -30,6 +30,9 @@ type ConnectionStatsReaderAdapter interface {
 	GetClientIP() string
 	GetProxyID() string
 	GetRequestURL() string
+	GetUpstream() string
+	GetUpstreamAttempts() string
+	GetUpstreamProxy() string
 }
 
 // FlowReaderAdapter is an interface for reading flow data from any proxy flow object that has
@@ -1,6 +1,10 @@
 package mitm
 
-import px "github.com/proxati/mitmproxy/proxy"
+import (
+	px "github.com/proxati/mitmproxy/proxy"
+
+	"github.com/proxati/llm_proxy/v2/schema/headers"
+)
 
 const unknownAddr = "unknown"
 
@@ -46,3 +50,30 @@ func (cs *ConnectionStatsAdapter) GetRequestURL() string {
 	}
 	return cs.f.Request.URL.String()
 }
+
+// GetUpstream returns the upstream target chosen by the upstream router, or an empty string when the
+// request was sent to the requested host, to implement the ConnectionStatsReaderAdapter interface
+func (cs *ConnectionStatsAdapter) GetUpstream() string {
+	if cs.f == nil || cs.f.Request == nil || cs.f.Request.Header == nil {
+		return ""
+	}
+	return cs.f.Request.Header.Get(headers.Upstream)
+}
+
+// GetUpstreamAttempts returns the JSON list of upstream attempts made by the retry addon, or an
+// empty string when the request was sent once, to implement the ConnectionStatsReaderAdapter interface
+func (cs *ConnectionStatsAdapter) GetUpstreamAttempts() string {
+	if cs.f == nil || cs.f.Request == nil || cs.f.Request.Header == nil {
+		return ""
+	}
+	return cs.f.Request.Header.Get(headers.Attempts)
+}
+
+// GetUpstreamProxy returns the host of the upstream proxy that the request was chained through, or
+// an empty string when it was sent directly, to implement the ConnectionStatsReaderAdapter interface
+func (cs *ConnectionStatsAdapter) GetUpstreamProxy() string {
+	if cs.f == nil || cs.f.Request == nil || cs.f.Request.Header == nil {
+		return ""
+	}
+	return cs.f.Request.Header.Get(headers.UpstreamProxy)
+}
@@ -1,12 +1,15 @@
 package mitm
 
 import (
+	"net/http"
 	"net/url"
 	"testing"
 
 	"github.com/google/uuid"
 	px "github.com/proxati/mitmproxy/proxy"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/proxati/llm_proxy/v2/schema/headers"
 )
 
 func TestConnectionStatsAdapter(t *testing.T) {
@@ -33,4 +36,17 @@ func TestConnectionStatsAdapter(t *testing.T) {
 	assert.Equal(t, unknownAddr, statsAdapter.GetClientIP())
 	assert.Equal(t, pxFlow.Id.String(), statsAdapter.GetProxyID())
 	assert.Equal(t, "http://example.com", statsAdapter.GetRequestURL())
+	assert.Empty(t, statsAdapter.GetUpstream())
+
+	pxFlow.Request.Header = http.Header{}
+	pxFlow.Request.Header.Set(headers.Upstream, "azure-eastus")
+	assert.Equal(t, "azure-eastus", statsAdapter.GetUpstream())
+
+	assert.Empty(t, statsAdapter.GetUpstreamAttempts())
+	pxFlow.Request.Header.Set(headers.Attempts, `[{"attempt":1,"status_code":503}]`)
+	assert.Equal(t, `[{"attempt":1,"status_code":503}]`, statsAdapter.GetUpstreamAttempts())
+
+	assert.Empty(t, statsAdapter.GetUpstreamProxy())
+	pxFlow.Request.Header.Set(headers.UpstreamProxy, "proxy.corp:3128")
+	assert.Equal(t, "proxy.corp:3128", statsAdapter.GetUpstreamProxy())
 }
@@ -0,0 +1,11 @@
+package schema
+
+// SchemaValidationResult is the result of validating the structured outputs and tool calls of a
+// response against the schemas in the request
+type SchemaValidationResult struct {
+	Mode     string   `json:"mode"`              // "monitor" or "enforce"
+	Valid    bool     `json:"valid"`             // the last response matches the schemas
+	Attempts int      `json:"attempts"`          // upstream responses that were validated, including the retries
+	Blocked  bool     `json:"blocked,omitempty"` // the response was replaced with an error, in enforce mode
+	Errors   []string `json:"errors,omitempty"`  // validation errors of the last response
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// apiKeyHeaders are the request headers that carry an API key, for the providers this proxy knows
+var apiKeyHeaders = []string{"Authorization", "Api-Key", "X-Api-Key", "X-Goog-Api-Key"}
+
+// GetAPIKey returns the API key in the request headers, and the name of the header it's in. The
+// "Bearer " prefix of the Authorization header is removed. Both are empty when there is no key.
+func GetAPIKey(header http.Header) (name, apiKey string) {
+	for _, name := range apiKeyHeaders {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+		if name == "Authorization" {
+			if after, ok := strings.CutPrefix(value, "Bearer "); ok {
+				value = after
+			}
+		}
+		return name, strings.TrimSpace(value)
+	}
+	return "", ""
+}
+
+// APIKeyHash returns a short SHA-256 hash of the API key in the request headers, or an empty
+// string when there is none. The hash identifies a key in logs and metrics, without leaking it.
+func APIKeyHash(header http.Header) string {
+	_, apiKey := GetAPIKey(header)
+	if apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyHash(t *testing.T) {
+	bearer := http.Header{}
+	bearer.Set("Authorization", "Bearer sk-test")
+	azure := http.Header{}
+	azure.Set("api-key", "sk-test")
+	other := http.Header{}
+	other.Set("Authorization", "Bearer sk-other")
+
+	hash := APIKeyHash(bearer)
+	assert.Len(t, hash, 16)
+	assert.NotContains(t, hash, "sk-test")
+	assert.Equal(t, hash, APIKeyHash(azure), "the same key in a different header")
+	assert.NotEqual(t, hash, APIKeyHash(other))
+	assert.Empty(t, APIKeyHash(http.Header{}))
+}
+
+func TestGetAPIKey(t *testing.T) {
+	header := http.Header{}
+	name, apiKey := GetAPIKey(header)
+	assert.Empty(t, name)
+	assert.Empty(t, apiKey)
+
+	header.Set("X-Api-Key", "sk-ant")
+	name, apiKey = GetAPIKey(header)
+	assert.Equal(t, "X-Api-Key", name)
+	assert.Equal(t, "sk-ant", apiKey)
+
+	header.Set("Authorization", "Bearer sk-test")
+	name, apiKey = GetAPIKey(header)
+	assert.Equal(t, "Authorization", name, "the Authorization header is checked first")
+	assert.Equal(t, "sk-test", apiKey)
+}
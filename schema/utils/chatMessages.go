@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ChatMessage is the text of one message in the messages list of a chat request body
+type ChatMessage struct {
+	Index int    // position in the messages list
+	Role  string // "system", "user", "assistant", "tool", etc
+	Text  string // the text parts of the content, joined with newlines
+}
+
+// ChatMessages returns the text of each message in a chat request body, in the OpenAI or Anthropic
+// format. The content is either a string, or a list of parts, where the text parts and the content
+// of tool results are included. Returns nil when the body isn't JSON with a messages list.
+func ChatMessages(body []byte) []ChatMessage {
+	var req struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+
+	messages := make([]ChatMessage, 0, len(req.Messages))
+	for i, msg := range req.Messages {
+		messages = append(messages, ChatMessage{
+			Index: i,
+			Role:  msg.Role,
+			Text:  strings.Join(contentText(msg.Content, nil), "\n"),
+		})
+	}
+	return messages
+}
+
+// contentText appends the text in a message content to texts: a string, or a list of parts with a
+// "text" field, or a nested "content" (Anthropic tool results)
+func contentText(content json.RawMessage, texts []string) []string {
+	if len(content) == 0 {
+		return texts
+	}
+
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		if text != "" {
+			texts = append(texts, text)
+		}
+		return texts
+	}
+
+	var parts []struct {
+		Text    string          `json:"text"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return texts
+	}
+	for _, part := range parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+		texts = contentText(part.Content, texts)
+	}
+	return texts
+}
+
+// CompletionText returns the generated text in a response body: the OpenAI chat completion choices,
+// legacy completion choices, and Responses API output, or the Anthropic content blocks. Returns nil
+// when the body isn't JSON, or has no generated text.
+func CompletionText(body []byte) []string {
+	var resp struct {
+		Choices []struct {
+			Text    string `json:"text"`
+			Message struct {
+				Content json.RawMessage `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Content json.RawMessage `json:"content"`
+		Output  []struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"output"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil
+	}
+
+	var texts []string
+	for _, choice := range resp.Choices {
+		if choice.Text != "" {
+			texts = append(texts, choice.Text)
+		}
+		texts = contentText(choice.Message.Content, texts)
+	}
+	texts = contentText(resp.Content, texts)
+	for _, output := range resp.Output {
+		texts = contentText(output.Content, texts)
+	}
+	return texts
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []ChatMessage
+	}{
+		{
+			name: "string content",
+			body: `{"model":"gpt-4o","messages":[{"role":"system","content":"be nice"},{"role":"user","content":"hello"}]}`,
+			expected: []ChatMessage{
+				{Index: 0, Role: "system", Text: "be nice"},
+				{Index: 1, Role: "user", Text: "hello"},
+			},
+		},
+		{
+			name: "content parts",
+			body: `{"messages":[{"role":"user","content":[
+				{"type":"text","text":"what is this?"},
+				{"type":"image_url","image_url":{"url":"https://example.com/cat.png"}},
+				{"type":"text","text":"be brief"}
+			]}]}`,
+			expected: []ChatMessage{{Index: 0, Role: "user", Text: "what is this?\nbe brief"}},
+		},
+		{
+			name: "anthropic tool result",
+			body: `{"messages":[{"role":"user","content":[
+				{"type":"tool_result","tool_use_id":"t1","content":[{"type":"text","text":"72F and sunny"}]},
+				{"type":"tool_result","tool_use_id":"t2","content":"rain"}
+			]}]}`,
+			expected: []ChatMessage{{Index: 0, Role: "user", Text: "72F and sunny\nrain"}},
+		},
+		{
+			name:     "assistant tool call without content",
+			body:     `{"messages":[{"role":"assistant","content":null,"tool_calls":[]}]}`,
+			expected: []ChatMessage{{Index: 0, Role: "assistant"}},
+		},
+		{
+			name:     "no messages",
+			body:     `{"prompt":"hello"}`,
+			expected: []ChatMessage{},
+		},
+		{
+			name: "not JSON",
+			body: `hello`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ChatMessages([]byte(tt.body)))
+		})
+	}
+}
+
+func TestCompletionText(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{
+			name:     "openai chat completion",
+			body:     `{"object":"chat.completion","choices":[{"message":{"role":"assistant","content":"hi there"}},{"message":{"content":"hello"}}]}`,
+			expected: []string{"hi there", "hello"},
+		},
+		{
+			name:     "openai tool call",
+			body:     `{"choices":[{"message":{"role":"assistant","content":null,"tool_calls":[{"id":"1"}]}}]}`,
+			expected: nil,
+		},
+		{
+			name:     "legacy completion",
+			body:     `{"object":"text_completion","choices":[{"text":"once upon a time"}]}`,
+			expected: []string{"once upon a time"},
+		},
+		{
+			name:     "anthropic message",
+			body:     `{"type":"message","content":[{"type":"text","text":"bonjour"},{"type":"tool_use","id":"t1","input":{}}]}`,
+			expected: []string{"bonjour"},
+		},
+		{
+			name:     "openai responses",
+			body:     `{"object":"response","output":[{"type":"message","content":[{"type":"output_text","text":"42"}]}]}`,
+			expected: []string{"42"},
+		},
+		{
+			name: "not JSON",
+			body: `data: {"choices":[]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, CompletionText([]byte(tt.body)))
+		})
+	}
+}
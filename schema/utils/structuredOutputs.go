@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// OutputSchemas are the JSON schemas that a request asks the model output to follow
+type OutputSchemas struct {
+	// Content is the schema of the assistant content, from a json_schema response_format (chat
+	// completions) or text.format (Responses API). Nil when the request doesn't have one.
+	Content json.RawMessage
+
+	// JSONObject is true when the content must be a JSON object, with the json_object format
+	JSONObject bool
+
+	// Tools are the parameters schemas of the function tools, by name. The schema is nil when the
+	// tool has no parameters schema, and the arguments can be any JSON object.
+	Tools map[string]json.RawMessage
+}
+
+// IsEmpty returns true when the request doesn't ask for structured outputs, or have tools
+func (o OutputSchemas) IsEmpty() bool {
+	return o.Content == nil && !o.JSONObject && len(o.Tools) == 0
+}
+
+// RequestOutputSchemas returns the output schemas of a request body: the OpenAI response_format,
+// the Responses API text.format, and the function tools in the OpenAI chat completions, Responses
+// API, or Anthropic format
+func RequestOutputSchemas(body []byte) OutputSchemas {
+	var req struct {
+		ResponseFormat json.RawMessage `json:"response_format"`
+		Text           json.RawMessage `json:"text"`
+		Tools          json.RawMessage `json:"tools"`
+	}
+	var schemas OutputSchemas
+	if err := json.Unmarshal(body, &req); err != nil {
+		return schemas
+	}
+
+	var format struct {
+		Type       string          `json:"type"`
+		Schema     json.RawMessage `json:"schema"` // Responses API
+		JSONSchema struct {
+			Schema json.RawMessage `json:"schema"`
+		} `json:"json_schema"` // chat completions
+	}
+	if json.Unmarshal(req.ResponseFormat, &format) != nil {
+		var text struct {
+			Format json.RawMessage `json:"format"`
+		}
+		if json.Unmarshal(req.Text, &text) == nil {
+			_ = json.Unmarshal(text.Format, &format)
+		}
+	}
+	switch format.Type {
+	case "json_schema":
+		schemas.Content = format.Schema
+		if format.JSONSchema.Schema != nil {
+			schemas.Content = format.JSONSchema.Schema
+		}
+	case "json_object":
+		schemas.JSONObject = true
+	}
+
+	var tools []struct {
+		Type        string          `json:"type"`
+		Name        string          `json:"name"`         // Responses API and Anthropic
+		Parameters  json.RawMessage `json:"parameters"`   // Responses API
+		InputSchema json.RawMessage `json:"input_schema"` // Anthropic
+		Function    *struct {
+			Name       string          `json:"name"`
+			Parameters json.RawMessage `json:"parameters"`
+		} `json:"function"` // chat completions
+	}
+	if err := json.Unmarshal(req.Tools, &tools); err != nil {
+		return schemas
+	}
+	for _, tool := range tools {
+		name, parameters := tool.Name, tool.Parameters
+		switch {
+		case tool.Function != nil:
+			name, parameters = tool.Function.Name, tool.Function.Parameters
+		case tool.InputSchema != nil:
+			parameters = tool.InputSchema
+		case tool.Type != "" && tool.Type != "function":
+			// built-in tools, like web_search, have no schema
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		if schemas.Tools == nil {
+			schemas.Tools = make(map[string]json.RawMessage)
+		}
+		schemas.Tools[name] = parameters
+	}
+	return schemas
+}
+
+// ModelOutput is the assistant content or a tool call in a response body
+type ModelOutput struct {
+	Path string // location in the response, like "choices[0].message.content"
+	Tool string // the function name of a tool call, empty for the content
+	JSON []byte // the content text, or the tool call arguments
+}
+
+// ResponseOutputs returns the assistant content and the tool calls in a response body: the OpenAI
+// chat completion choices, the Responses API output, or the Anthropic content blocks. Returns nil
+// when the body isn't JSON.
+func ResponseOutputs(body []byte) []ModelOutput {
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content   *string `json:"content"`
+				ToolCalls []struct {
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+		} `json:"choices"`
+		Output []struct {
+			Type      string `json:"type"`
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+			Content   []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"output"`
+		Content []struct {
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil
+	}
+
+	var outputs []ModelOutput
+	for i, choice := range resp.Choices {
+		if choice.Message.Content != nil {
+			outputs = append(outputs, ModelOutput{
+				Path: fmt.Sprintf("choices[%d].message.content", i),
+				JSON: []byte(*choice.Message.Content),
+			})
+		}
+		for j, call := range choice.Message.ToolCalls {
+			outputs = append(outputs, ModelOutput{
+				Path: fmt.Sprintf("choices[%d].message.tool_calls[%d]", i, j),
+				Tool: call.Function.Name,
+				JSON: []byte(call.Function.Arguments),
+			})
+		}
+	}
+
+	for i, item := range resp.Output {
+		switch item.Type {
+		case "message":
+			for j, part := range item.Content {
+				if part.Type == "output_text" {
+					outputs = append(outputs, ModelOutput{
+						Path: fmt.Sprintf("output[%d].content[%d]", i, j),
+						JSON: []byte(part.Text),
+					})
+				}
+			}
+		case "function_call":
+			outputs = append(outputs, ModelOutput{
+				Path: fmt.Sprintf("output[%d]", i),
+				Tool: item.Name,
+				JSON: []byte(item.Arguments),
+			})
+		}
+	}
+
+	for i, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			outputs = append(outputs, ModelOutput{Path: fmt.Sprintf("content[%d]", i), JSON: []byte(block.Text)})
+		case "tool_use":
+			outputs = append(outputs, ModelOutput{Path: fmt.Sprintf("content[%d]", i), Tool: block.Name, JSON: block.Input})
+		}
+	}
+	return outputs
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestOutputSchemas(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected OutputSchemas
+	}{
+		{
+			name:     "chat completions json_schema",
+			body:     `{"response_format": {"type": "json_schema", "json_schema": {"name": "r", "schema": {"type": "object"}}}}`,
+			expected: OutputSchemas{Content: json.RawMessage(`{"type": "object"}`)},
+		},
+		{
+			name:     "json_object",
+			body:     `{"response_format": {"type": "json_object"}}`,
+			expected: OutputSchemas{JSONObject: true},
+		},
+		{
+			name:     "responses api text format",
+			body:     `{"text": {"format": {"type": "json_schema", "name": "r", "schema": {"type": "array"}}}}`,
+			expected: OutputSchemas{Content: json.RawMessage(`{"type": "array"}`)},
+		},
+		{
+			name: "tools",
+			body: `{"tools": [
+				{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}},
+				{"type": "function", "name": "lookup", "parameters": {"type": "string"}},
+				{"name": "search", "input_schema": {"required": ["q"]}},
+				{"type": "web_search"},
+				{"type": "function", "function": {"name": "now"}}
+			]}`,
+			expected: OutputSchemas{Tools: map[string]json.RawMessage{
+				"get_weather": json.RawMessage(`{"type": "object"}`),
+				"lookup":      json.RawMessage(`{"type": "string"}`),
+				"search":      json.RawMessage(`{"required": ["q"]}`),
+				"now":         nil,
+			}},
+		},
+		{name: "text format", body: `{"response_format": {"type": "text"}, "messages": []}`},
+		{name: "not json", body: `model=gpt-4o`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schemas := RequestOutputSchemas([]byte(tt.body))
+			assert.Equal(t, tt.expected, schemas)
+			assert.Equal(t, tt.expected.IsEmpty(), schemas.IsEmpty())
+		})
+	}
+}
+
+func TestResponseOutputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []ModelOutput
+	}{
+		{
+			name: "chat completion",
+			body: `{"choices": [
+				{"message": {"role": "assistant", "content": "{\"a\": 1}"}},
+				{"message": {"role": "assistant", "content": null, "tool_calls": [
+					{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\": \"Paris\"}"}}
+				]}}
+			]}`,
+			expected: []ModelOutput{
+				{Path: "choices[0].message.content", JSON: []byte(`{"a": 1}`)},
+				{Path: "choices[1].message.tool_calls[0]", Tool: "get_weather", JSON: []byte(`{"city": "Paris"}`)},
+			},
+		},
+		{
+			name: "responses api",
+			body: `{"output": [
+				{"type": "reasoning", "summary": []},
+				{"type": "function_call", "name": "lookup", "arguments": "\"x\""},
+				{"type": "message", "content": [{"type": "refusal", "refusal": "no"}, {"type": "output_text", "text": "[1]"}]}
+			]}`,
+			expected: []ModelOutput{
+				{Path: "output[1]", Tool: "lookup", JSON: []byte(`"x"`)},
+				{Path: "output[2].content[1]", JSON: []byte(`[1]`)},
+			},
+		},
+		{
+			name: "anthropic",
+			body: `{"content": [{"type": "text", "text": "searching"}, {"type": "tool_use", "name": "search", "input": {"q": "go"}}]}`,
+			expected: []ModelOutput{
+				{Path: "content[0]", JSON: []byte("searching")},
+				{Path: "content[1]", Tool: "search", JSON: []byte(`{"q": "go"}`)},
+			},
+		},
+		{name: "not json", body: `data: {}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ResponseOutputs([]byte(tt.body)))
+		})
+	}
+}